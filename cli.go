@@ -0,0 +1,306 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/buildinfo"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/server"
+	"github.com/abdusco/linked/internal/slugs"
+)
+
+const usage = `Usage: linked [command]
+
+Commands:
+  serve                                  Run the HTTP server (default)
+  version [--json]                       Show build information
+  links list [--json]                    List all links
+  links add --url URL [--slug SLUG]      Create a link
+  links delete --slug SLUG [--domain D]  Delete a link
+  export [--format json]                 Export all links with their stats
+  stats [--json] [--domain D] SLUG       Show click stats of a link
+
+Commands other than serve operate directly on the configured database.
+`
+
+// errUsage marks errors caused by invalid invocation rather than a failed operation.
+var errUsage = errors.New("invalid usage")
+
+// runCLI runs an admin subcommand and returns the process exit code.
+func runCLI(ctx context.Context, cfg server.Config, cmd string, args []string) int {
+	var err error
+	switch cmd {
+	case "links":
+		err = withDB(ctx, cfg, func(dbInstance *sql.DB) error {
+			linksRepo := repo.NewLinksRepo(dbInstance, cfg.SlugCaseInsensitive)
+			return runLinksCommand(ctx, linksRepo, slugs.NewGenerator(cfg.SlugCharset, cfg.SlugChecksum, linksRepo), handler.NewLinkValidator(cfg.AllowedURLSchemes, cfg.SlugMinLength, cfg.SlugMaxLength), args)
+		})
+	case "export":
+		err = withDB(ctx, cfg, func(dbInstance *sql.DB) error {
+			return runExportCommand(ctx, dbInstance, args)
+		})
+	case "stats":
+		err = withDB(ctx, cfg, func(dbInstance *sql.DB) error {
+			return runStatsCommand(ctx, dbInstance, repo.NewLinksRepo(dbInstance, cfg.SlugCaseInsensitive), args)
+		})
+	case "version":
+		err = runVersionCommand(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "error: %v\n\n%s", err, usage)
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+}
+
+func withDB(ctx context.Context, cfg server.Config, fn func(dbInstance *sql.DB) error) error {
+	dbInstance, err := db.Init(ctx, cfg.DBDriver, cfg.DBDSN)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer dbInstance.Close()
+
+	if err := db.SetSlugCaseInsensitive(ctx, dbInstance, cfg.SlugCaseInsensitive); err != nil {
+		return err
+	}
+
+	return fn(dbInstance)
+}
+
+func runLinksCommand(ctx context.Context, linksRepo *repo.LinksRepo, slugGen *slugs.Generator, validator *handler.LinkValidator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: links needs a subcommand: list, add or delete", errUsage)
+	}
+
+	sub, args := args[0], args[1:]
+	fs := flag.NewFlagSet("links "+sub, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+
+	switch sub {
+	case "list":
+		if _, err := parseArgs(fs, args); err != nil {
+			return err
+		}
+
+		links, err := linksRepo.ListAll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list links: %w", err)
+		}
+		if *asJSON {
+			return printJSON(os.Stdout, links)
+		}
+		printLinksTable(os.Stdout, links)
+		return nil
+
+	case "add":
+		url := fs.String("url", "", "destination URL")
+		slug := fs.String("slug", "", "custom slug, generated if empty")
+		description := fs.String("description", "", "note on why the link exists")
+		if _, err := parseArgs(fs, args); err != nil {
+			return err
+		}
+
+		req := handler.CreateLinkRequest{URL: *url, Slug: *slug, Description: *description}
+		if err := validator.Validate(&req); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if req.Slug == "" {
+			generated, err := slugGen.Next(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to generate slug: %w", err)
+			}
+			req.Slug = generated
+		}
+
+		link, err := linksRepo.Create(ctx, repo.NewLink{Slug: req.Slug, URL: req.URL, Description: req.Description})
+		if err != nil {
+			return fmt.Errorf("failed to create link: %w", err)
+		}
+		if *asJSON {
+			return printJSON(os.Stdout, link)
+		}
+		printLinksTable(os.Stdout, []*internal.Link{link})
+		return nil
+
+	case "delete":
+		slug := fs.String("slug", "", "slug of the link to delete")
+		domain := fs.String("domain", "", "domain of the link, empty for a link on any domain")
+		if _, err := parseArgs(fs, args); err != nil {
+			return err
+		}
+		if *slug == "" {
+			return fmt.Errorf("%w: --slug is required", errUsage)
+		}
+
+		link, err := linksRepo.GetBySlug(ctx, strings.ToLower(*domain), *slug)
+		if err != nil {
+			return fmt.Errorf("failed to find link %q: %w", *slug, err)
+		}
+		if err := linksRepo.Delete(ctx, link.ID); err != nil {
+			return fmt.Errorf("failed to delete link %q: %w", *slug, err)
+		}
+		fmt.Fprintf(os.Stdout, "deleted %s\n", *slug)
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown links subcommand %q", errUsage, sub)
+}
+
+func runExportCommand(ctx context.Context, dbInstance *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format, only json is supported")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *format != "json" {
+		return fmt.Errorf("%w: unsupported format %q", errUsage, *format)
+	}
+
+	// Slugs aren't looked up, so how they match doesn't matter
+	links, err := repo.NewLinksRepo(dbInstance, false).ListAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	return printJSON(os.Stdout, links)
+}
+
+type linkStatsOutput struct {
+	Link      *internal.Link          `json:"link"`
+	Referrers *internal.ReferrerStats `json:"referrers"`
+}
+
+func runStatsCommand(ctx context.Context, dbInstance *sql.DB, linksRepo *repo.LinksRepo, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	domain := fs.String("domain", "", "domain of the link, empty for a link on any domain")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: stats needs exactly one slug", errUsage)
+	}
+	slug := positional[0]
+
+	clicksRepo := repo.NewClicksRepo(dbInstance)
+
+	link, err := linksRepo.GetBySlug(ctx, strings.ToLower(*domain), slug)
+	if err != nil {
+		return fmt.Errorf("failed to find link %q: %w", slug, err)
+	}
+	link.Stats, err = clicksRepo.GetStatsForLink(ctx, link.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get stats: %w", err)
+	}
+	referrers, err := clicksRepo.GetReferrerStats(ctx, link.ID, time.Time{}, 10, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get referrer stats: %w", err)
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, linkStatsOutput{Link: link, Referrers: referrers})
+	}
+
+	printLinksTable(os.Stdout, []*internal.Link{link})
+	fmt.Fprintln(os.Stdout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REFERRER\tCLICKS")
+	for _, ref := range referrers.Referrers {
+		fmt.Fprintf(w, "%s\t%d\n", ref.Host, ref.Clicks)
+	}
+	fmt.Fprintf(w, "(direct)\t%d\n", referrers.Direct)
+	return w.Flush()
+}
+
+func runVersionCommand(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	build := buildinfo.New(version, buildTime)
+	if *asJSON {
+		return printJSON(os.Stdout, build)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "version\t%s\n", build.Version)
+	fmt.Fprintf(w, "revision\t%s\n", cmp.Or(build.Revision, "-"))
+	fmt.Fprintf(w, "dirty\t%t\n", build.Dirty)
+	fmt.Fprintf(w, "build time\t%s\n", cmp.Or(build.BuildTime, "-"))
+	fmt.Fprintf(w, "commit time\t%s\n", cmp.Or(build.CommitTime, "-"))
+	fmt.Fprintf(w, "go version\t%s\n", build.GoVersion)
+	return w.Flush()
+}
+
+// parseArgs parses flags wherever they appear in args and returns the remaining positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				fs.SetOutput(os.Stdout)
+				fs.PrintDefaults()
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printLinksTable(out io.Writer, links []*internal.Link) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSLUG\tURL\tCLICKS\tLAST CLICKED\tCREATED")
+	for _, link := range links {
+		clicks, lastClicked := int64(0), "-"
+		if link.Stats != nil {
+			clicks = link.Stats.Clicks
+			if link.Stats.LastClickedAt != nil {
+				lastClicked = link.Stats.LastClickedAt.Format(time.DateTime)
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n",
+			link.ID, link.Slug, link.URL, clicks, lastClicked, link.CreatedAt.Format(time.DateTime))
+	}
+	w.Flush()
+}
@@ -0,0 +1,278 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// URLPrefix is where static files are mounted.
+	URLPrefix = "/static/"
+
+	hashLength = 8
+
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	shortCacheControl     = "public, max-age=300"
+	noCacheControl        = "no-cache"
+)
+
+// templates are the HTML files rendered as templates so they can reference hashed asset URLs.
+var templates = []string{"login.html", "index.html", "preview.html", "directory.html", "unfurl.html", "coming-soon.html", "did-you-mean.html"}
+
+// staticPages are templates that don't take any data, so they're rendered once up front.
+var staticPages = []string{"login.html", "index.html"}
+
+// staticFiles are the files the pages load, and favicon.ico, checked to exist along with the templates on startup.
+var staticFiles = []string{"app.js", "style.css", "alpine.min.js", "fonts.css", "favicon.ico"}
+
+type manifest struct {
+	hashed   map[string]string // name -> hashed name
+	original map[string]string // hashed name -> name
+}
+
+// Assets serves files from a filesystem under content-hashed names.
+//
+// In live mode nothing is cached: every request re-reads the filesystem,
+// so edits on disk show up immediately during development.
+type Assets struct {
+	fsys      fs.FS
+	live      bool
+	manifest  *manifest
+	templates map[string]*template.Template
+	// pages caches templates rendered without data
+	pages map[string]*file
+	// files holds every file in memory, keyed by its name
+	files map[string]*file
+}
+
+// New loads the assets of fsys. It fails listing every template and static file missing from fsys,
+// also in live mode, so a renamed file is caught on startup rather than by a visitor.
+func New(fsys fs.FS, live bool) (*Assets, error) {
+	if err := checkRequired(fsys); err != nil {
+		return nil, err
+	}
+
+	a := &Assets{fsys: fsys, live: live}
+	if live {
+		return a, nil
+	}
+
+	m, err := buildManifest(fsys)
+	if err != nil {
+		return nil, err
+	}
+	a.manifest = m
+
+	if a.files, err = loadFiles(fsys, m); err != nil {
+		return nil, err
+	}
+
+	a.templates = make(map[string]*template.Template, len(templates))
+	for _, name := range templates {
+		tmpl, err := parseTemplate(fsys, m, name)
+		if err != nil {
+			return nil, err
+		}
+		a.templates[name] = tmpl
+	}
+
+	a.pages = make(map[string]*file, len(staticPages))
+	for _, name := range staticPages {
+		data, err := execute(a.templates[name], nil)
+		if err != nil {
+			return nil, err
+		}
+		if a.pages[name], err = newFile(data, echo.MIMETextHTMLCharsetUTF8, true); err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+	}
+
+	return a, nil
+}
+
+// ServePage answers with the HTML page with the given name, rendered without data. Pages may change
+// with a new build, so they're revalidated with their ETag instead of being cached.
+func (a *Assets) ServePage(c echo.Context, name string) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, noCacheControl)
+	if a.live {
+		data, err := a.Render(name, nil)
+		if err != nil {
+			return err
+		}
+		return c.HTMLBlob(http.StatusOK, data)
+	}
+
+	page, ok := a.pages[name]
+	if !ok {
+		return fmt.Errorf("unknown page %q", name)
+	}
+	page.serve(c)
+	return nil
+}
+
+// Render renders the HTML page with the given name using data.
+func (a *Assets) Render(name string, data any) ([]byte, error) {
+	tmpl, err := a.template(name)
+	if err != nil {
+		return nil, err
+	}
+	return execute(tmpl, data)
+}
+
+func (a *Assets) template(name string) (*template.Template, error) {
+	if !a.live {
+		tmpl, ok := a.templates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown page %q", name)
+		}
+		return tmpl, nil
+	}
+
+	m, err := a.current()
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(a.fsys, m, name)
+}
+
+// ServeStatic serves GET /static/*. Hashed paths are cached forever,
+// plain paths keep working with a short cache lifetime.
+func (a *Assets) ServeStatic(c echo.Context) error {
+	name, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return echo.ErrNotFound
+	}
+
+	m, err := a.current()
+	if err != nil {
+		return err
+	}
+
+	cacheControl := shortCacheControl
+	if original, ok := m.original[name]; ok {
+		name = original
+		cacheControl = immutableCacheControl
+	}
+	return a.serveFile(c, name, cacheControl)
+}
+
+// ServeFile answers with the static file with the given name, for files browsers ask for at
+// a path of their own like /favicon.ico. They can't be hashed, so they're cached briefly.
+func (a *Assets) ServeFile(c echo.Context, name string) error {
+	return a.serveFile(c, name, shortCacheControl)
+}
+
+func (a *Assets) serveFile(c echo.Context, name, cacheControl string) error {
+	if a.live {
+		c.Response().Header().Set(echo.HeaderCacheControl, noCacheControl)
+		return echo.StaticFileHandler(name, a.fsys)(c)
+	}
+
+	f, ok := a.files[name]
+	if !ok {
+		return echo.ErrNotFound
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, cacheControl)
+	f.serve(c)
+	return nil
+}
+
+// checkRequired fails when any of the templates or static files is missing from fsys, naming all of them.
+func checkRequired(fsys fs.FS) error {
+	var missing []string
+	for _, name := range slices.Concat(templates, staticFiles) {
+		if _, err := fs.Stat(fsys, name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing web assets: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (a *Assets) current() (*manifest, error) {
+	if !a.live {
+		return a.manifest, nil
+	}
+	return buildManifest(a.fsys)
+}
+
+func (m *manifest) url(name string) (string, error) {
+	hashed, ok := m.hashed[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %q", name)
+	}
+	return URLPrefix + hashed, nil
+}
+
+func buildManifest(fsys fs.FS) (*manifest, error) {
+	m := &manifest{
+		hashed:   make(map[string]string),
+		original: make(map[string]string),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		hashed := hashedName(name, data)
+		m.hashed[name] = hashed
+		m.original[hashed] = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash assets: %w", err)
+	}
+
+	return m, nil
+}
+
+// hashedName inserts a short content hash before the extension: app.js -> app.3f9c2a1b.js
+func hashedName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func parseTemplate(fsys fs.FS, m *manifest, name string) (*template.Template, error) {
+	src, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	tmpl, err := template.New(name).
+		Funcs(template.FuncMap{"asset": m.url}).
+		Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func execute(tmpl *template.Template, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,180 @@
+package assets
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testFiles are the smallest set of web assets New accepts.
+func testFiles() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range templates {
+		fsys[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	for _, name := range staticFiles {
+		fsys[name] = &fstest.MapFile{Data: []byte("/* " + name + " */")}
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`<script src="{{ asset "app.js" }}"></script>`)}
+	return fsys
+}
+
+// newTestServer serves the pages and static files of a like the server does.
+func newTestServer(t *testing.T, a *Assets) string {
+	t.Helper()
+
+	e := echo.New()
+	e.GET(URLPrefix+"*", a.ServeStatic)
+	e.GET("/", func(c echo.Context) error { return a.ServePage(c, "index.html") })
+	ts := httptest.NewServer(e)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// get requests url and returns the response with its body read.
+func get(t *testing.T, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	// The default transport would ask for gzip itself and decompress the response
+	res, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+var hashedAppJS = regexp.MustCompile(`/static/app\.[0-9a-f]{8}\.js`)
+
+func TestManifest(t *testing.T) {
+	fsys := testFiles()
+	m, err := buildManifest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashed := m.hashed["app.js"]
+	if !hashedAppJS.MatchString(URLPrefix + hashed) {
+		t.Fatalf("app.js is hashed as %s, want app.<8 hex digits>.js", hashed)
+	}
+	if m.original[hashed] != "app.js" {
+		t.Errorf("%s maps back to %q, want app.js", hashed, m.original[hashed])
+	}
+	if got := hashedName("app.js", fsys["app.js"].Data); got != hashed {
+		t.Errorf("hashing again gives %s, want %s", got, hashed)
+	}
+	if got := hashedName("app.js", []byte("changed")); got == hashed {
+		t.Errorf("other content is hashed as %s too", got)
+	}
+	if got := hashedName("fonts/inter.woff2", []byte("font")); !strings.HasPrefix(got, "fonts/inter.") || !strings.HasSuffix(got, ".woff2") {
+		t.Errorf("a file in a directory is hashed as %s, want fonts/inter.<hash>.woff2", got)
+	}
+	if _, err := m.url("missing.js"); err == nil {
+		t.Error("url of a file that doesn't exist succeeded")
+	}
+
+	// Pages refer to the hashed name
+	a, err := New(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, page := get(t, newTestServer(t, a)+"/")
+	if want := `<script src="/static/` + hashed + `"></script>`; page != want {
+		t.Errorf("got page %s, want %s", page, want)
+	}
+}
+
+func TestServeStaticCacheHeaders(t *testing.T) {
+	fsys := testFiles()
+	a, err := New(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := newTestServer(t, a)
+	hashed := a.manifest.hashed["app.js"]
+
+	tests := []struct {
+		path             string
+		wantStatus       int
+		wantCacheControl string
+	}{
+		{"/static/" + hashed, http.StatusOK, immutableCacheControl},
+		// Plain paths keep working for pages cached before hashing
+		{"/static/app.js", http.StatusOK, shortCacheControl},
+		{"/static/app.00000000.js", http.StatusNotFound, ""},
+		{"/static/missing.js", http.StatusNotFound, ""},
+		{"/", http.StatusOK, noCacheControl},
+	}
+	for _, tt := range tests {
+		res, body := get(t, baseURL+tt.path)
+		if res.StatusCode != tt.wantStatus || res.Header.Get("Cache-Control") != tt.wantCacheControl {
+			t.Errorf("%s: got %d with Cache-Control %q, want %d with %q", tt.path, res.StatusCode, res.Header.Get("Cache-Control"), tt.wantStatus, tt.wantCacheControl)
+		}
+		if tt.wantStatus == http.StatusOK && tt.path != "/" && body != string(fsys["app.js"].Data) {
+			t.Errorf("%s: got %q, want the content of app.js", tt.path, body)
+		}
+	}
+
+	// The ETag revalidates the content
+	res, _ := get(t, baseURL+"/static/app.js")
+	if res, _ := get(t, baseURL+"/static/app.js", "If-None-Match", res.Header.Get("ETag")); res.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating: got %d, want %d", res.StatusCode, http.StatusNotModified)
+	}
+}
+
+func TestLiveEdits(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, testFiles()); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(os.DirFS(dir), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := newTestServer(t, a)
+
+	_, before := get(t, baseURL+"/")
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The page links the new content right away, under its new name and the plain one
+	_, after := get(t, baseURL+"/")
+	hashedURL := hashedAppJS.FindString(after)
+	if hashedURL == "" || hashedURL == hashedAppJS.FindString(before) {
+		t.Fatalf("page after the edit is %s, want it to link the edited app.js under a new name", after)
+	}
+	for _, path := range []string{hashedURL, "/static/app.js"} {
+		res, body := get(t, baseURL+path)
+		if res.StatusCode != http.StatusOK || body != "edited" || res.Header.Get("Cache-Control") != noCacheControl {
+			t.Errorf("%s: got %d %q with Cache-Control %q, want %d %q with %q", path, res.StatusCode, body, res.Header.Get("Cache-Control"), http.StatusOK, "edited", noCacheControl)
+		}
+	}
+
+	// Templates are read again too
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("new page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, page := get(t, baseURL+"/"); page != "new page" {
+		t.Errorf("page after editing its template is %q, want %q", page, "new page")
+	}
+}
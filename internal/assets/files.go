@@ -0,0 +1,172 @@
+package assets
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+// minCompressSize is the size below which compressing saves too little to be worth a variant
+const minCompressSize = 1 << 10
+
+// compressible are the extensions of text files worth compressing, fonts and images are compressed already
+var compressible = []string{".js", ".css", ".html", ".svg", ".json", ".txt"}
+
+// compressors make the variants of files, in the order they're preferred in. Brotli's best level
+// takes too long to run on every startup, its default still beats gzip.
+var compressors = []struct {
+	encoding  string
+	newWriter func(io.Writer) (io.WriteCloser, error)
+}{
+	{"br", func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+	}},
+	{"gzip", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, gzip.BestCompression) }},
+}
+
+// variant is a compressed encoding of a file.
+type variant struct {
+	encoding string
+	data     []byte
+}
+
+// file is a static file loaded in memory, with compressed variants when they're worth it.
+type file struct {
+	data []byte
+	// variants are in the order of compressors
+	variants    []variant
+	contentType string
+	// etag is a hash of the content, each variant gets its own
+	etag string
+}
+
+// loadFiles reads every file of the manifest into memory.
+func loadFiles(fsys fs.FS, m *manifest) (map[string]*file, error) {
+	files := make(map[string]*file, len(m.hashed))
+	for name := range m.hashed {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		ext := strings.ToLower(path.Ext(name))
+		f, err := newFile(data, cmp.Or(mime.TypeByExtension(ext), http.DetectContentType(data)), slices.Contains(compressible, ext))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+		files[name] = f
+	}
+	return files, nil
+}
+
+func newFile(data []byte, contentType string, compress bool) (*file, error) {
+	sum := sha256.Sum256(data)
+	f := &file{
+		data:        data,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+	if !compress || len(data) < minCompressSize {
+		return f, nil
+	}
+
+	for _, c := range compressors {
+		compressed, err := compressBytes(data, c.newWriter)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			f.variants = append(f.variants, variant{encoding: c.encoding, data: compressed})
+		}
+	}
+	return f, nil
+}
+
+// serve writes the file in the encoding the client prefers of those it has. http.ServeContent
+// answers If-None-Match with a 304 and handles ranges.
+func (f *file) serve(c echo.Context) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, f.contentType)
+
+	data, etag := f.data, f.etag
+	if len(f.variants) > 0 {
+		header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+		if v, ok := f.negotiate(c.Request()); ok {
+			header.Set(echo.HeaderContentEncoding, v.encoding)
+			data, etag = v.data, strings.TrimSuffix(f.etag, `"`)+"-"+v.encoding+`"`
+		}
+	}
+	header.Set("ETag", etag)
+
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, bytes.NewReader(data))
+}
+
+// negotiate picks the variant the client accepts with the highest quality, the one preferred by
+// the server on a tie. Without an acceptable variant the file is sent as it is.
+func (f *file) negotiate(r *http.Request) (variant, bool) {
+	qualities := acceptedEncodings(r)
+	var best variant
+	bestQuality := 0.0
+	for _, v := range f.variants {
+		q, ok := qualities[v.encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQuality {
+			best, bestQuality = v, q
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// acceptedEncodings returns the quality of each encoding in the Accept-Encoding of r, 1 when it has none.
+func acceptedEncodings(r *http.Request) map[string]float64 {
+	qualities := map[string]float64{}
+	for encoding := range strings.SplitSeq(r.Header.Get(echo.HeaderAcceptEncoding), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[name] = q
+	}
+	return qualities
+}
+
+func compressBytes(data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,135 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decode undoes the Content-Encoding of a response.
+func decode(t *testing.T, encoding, body string) string {
+	t.Helper()
+
+	var r io.Reader = strings.NewReader(body)
+	switch encoding {
+	case "":
+	case "gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(r)
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestServeCompressed(t *testing.T) {
+	fsys := testFiles()
+	large := strings.Repeat("console.log('compress me');\n", 100)
+	fsys["app.js"] = &fstest.MapFile{Data: []byte(large)}
+	a, err := New(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := newTestServer(t, a)
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"br", "br"},
+		// Brotli is preferred when both are as good to the client
+		{"gzip, br", "br"},
+		{"gzip, deflate, br, zstd", "br"},
+		{"GZIP", "gzip"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"gzip;q=0", ""},
+		{"br; q=0, gzip; q=0", ""},
+		{"*", "br"},
+		{"*, br;q=0", "gzip"},
+		{"identity", ""},
+		{"deflate", ""},
+		{"gzip;q=nonsense", ""},
+	}
+	etags := map[string]string{}
+	for _, tt := range tests {
+		res, body := get(t, baseURL+"/static/app.js", "Accept-Encoding", tt.acceptEncoding)
+		encoding := res.Header.Get("Content-Encoding")
+		if encoding != tt.want {
+			t.Errorf("Accept-Encoding %q: got encoding %q, want %q", tt.acceptEncoding, encoding, tt.want)
+			continue
+		}
+		if got := decode(t, encoding, body); got != large {
+			t.Errorf("Accept-Encoding %q: got %d bytes of other content", tt.acceptEncoding, len(got))
+		}
+		// Caches keep a copy for each encoding
+		if vary := res.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: got Vary %q, want Accept-Encoding", tt.acceptEncoding, vary)
+		}
+		if etag, ok := etags[encoding]; ok && etag != res.Header.Get("ETag") {
+			t.Errorf("Accept-Encoding %q: got ETag %s, want %s like before", tt.acceptEncoding, res.Header.Get("ETag"), etag)
+		}
+		etags[encoding] = res.Header.Get("ETag")
+	}
+	if len(etags) != 3 || etags[""] == etags["gzip"] || etags[""] == etags["br"] || etags["gzip"] == etags["br"] {
+		t.Errorf("got ETags %v, want one of its own for each encoding", etags)
+	}
+
+	// A variant is revalidated with its own ETag
+	res, _ := get(t, baseURL+"/static/app.js", "Accept-Encoding", "br", "If-None-Match", etags["br"])
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating brotli: got %d, want %d", res.StatusCode, http.StatusNotModified)
+	}
+}
+
+func TestServeUncompressed(t *testing.T) {
+	fsys := testFiles()
+	// Too small to be worth compressing, and an icon that's compressed already
+	fsys["app.js"] = &fstest.MapFile{Data: []byte("console.log('small');")}
+	fsys["favicon.ico"] = &fstest.MapFile{Data: bytes.Repeat([]byte{0}, 4<<10)}
+	a, err := New(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := newTestServer(t, a)
+
+	for _, path := range []string{"/static/app.js", "/static/favicon.ico"} {
+		res, body := get(t, baseURL+path, "Accept-Encoding", "gzip, br")
+		if encoding, vary := res.Header.Get("Content-Encoding"), res.Header.Get("Vary"); encoding != "" || vary != "" {
+			t.Errorf("%s: got encoding %q and Vary %q, want neither", path, encoding, vary)
+		}
+		if name := strings.TrimPrefix(path, URLPrefix); body != string(fsys[name].Data) {
+			t.Errorf("%s: got %d bytes of other content", path, len(body))
+		}
+	}
+}
+
+func TestNewMissingAssets(t *testing.T) {
+	fsys := testFiles()
+	delete(fsys, "login.html")
+	delete(fsys, "app.js")
+
+	for _, live := range []bool{false, true} {
+		_, err := New(fsys, live)
+		if want := "missing web assets: login.html, app.js"; err == nil || err.Error() != want {
+			t.Errorf("live %t: got %v, want %s", live, err, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidConfirmation = errors.New("invalid confirmation token")
+	ErrConfirmationExpired = errors.New("confirmation token expired")
+)
+
+// Confirmer issues short-lived tokens that confirm an action on a subject, like deleting a link, was
+// asked for twice. Tokens are stateless: the expiry and an HMAC of it with the subject.
+type Confirmer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewConfirmer signs tokens valid for ttl with secret, which is kept apart from the JWTs signed with it.
+func NewConfirmer(secret string, ttl time.Duration) *Confirmer {
+	return &Confirmer{secret: []byte("confirm:" + secret), ttl: ttl, now: time.Now}
+}
+
+// Token returns a token confirming the action on subject, and when it expires.
+func (c *Confirmer) Token(subject string) (string, time.Time) {
+	expiresAt := c.now().Add(c.ttl).Truncate(time.Second)
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + c.sign(subject, expiry), expiresAt
+}
+
+// Verify checks that token was issued for subject and hasn't expired.
+func (c *Confirmer) Verify(subject, token string) error {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidConfirmation
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrInvalidConfirmation
+	}
+	// The signature is checked first, a forged token shouldn't learn whether its expiry passed
+	if !hmac.Equal([]byte(signature), []byte(c.sign(subject, expiry))) {
+		return ErrInvalidConfirmation
+	}
+	if !c.now().Before(time.Unix(expiresAt, 0)) {
+		return ErrConfirmationExpired
+	}
+	return nil
+}
+
+func (c *Confirmer) sign(subject, expiry string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(subject + "\n" + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
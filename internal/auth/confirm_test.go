@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfirmer returns a Confirmer whose clock is set by moving the returned time.
+func newTestConfirmer(secret string, ttl time.Duration) (*Confirmer, *time.Time) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	c := NewConfirmer(secret, ttl)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestConfirmerExpiry(t *testing.T) {
+	c, now := newTestConfirmer("secret", time.Minute)
+	issued := *now
+	token, expiresAt := c.Token("delete-link:1")
+	if want := issued.Add(time.Minute); !expiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", expiresAt, want)
+	}
+
+	tests := []struct {
+		at   time.Time
+		want error
+	}{
+		{issued, nil},
+		{expiresAt.Add(-time.Nanosecond), nil},
+		// Valid up to, not including, the moment it expires
+		{expiresAt, ErrConfirmationExpired},
+		{expiresAt.Add(time.Hour), ErrConfirmationExpired},
+	}
+	for _, tt := range tests {
+		*now = tt.at
+		if err := c.Verify("delete-link:1", token); !errors.Is(err, tt.want) {
+			t.Errorf("at %v: got %v, want %v", tt.at, err, tt.want)
+		}
+	}
+}
+
+func TestConfirmerTamperedToken(t *testing.T) {
+	c, _ := newTestConfirmer("secret", time.Minute)
+	token, _ := c.Token("delete-link:1")
+	expiry, signature, _ := strings.Cut(token, ".")
+
+	later, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := []byte(signature)
+	flipped[0] ^= 1
+	other, _ := NewConfirmer("other secret", time.Minute).Token("delete-link:1")
+
+	for name, tampered := range map[string]string{
+		"extended expiry":     strconv.FormatInt(later+3600, 10) + "." + signature,
+		"changed signature":   expiry + "." + string(flipped),
+		"truncated signature": expiry + "." + signature[:len(signature)-2],
+		"no signature":        expiry + ".",
+		"no expiry":           "." + signature,
+		"no separator":        expiry + signature,
+		"invalid expiry":      "soon." + signature,
+		"another secret":      other,
+		"empty":               "",
+	} {
+		if err := c.Verify("delete-link:1", tampered); !errors.Is(err, ErrInvalidConfirmation) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidConfirmation)
+		}
+	}
+}
+
+func TestConfirmerTokenOfAnotherSubject(t *testing.T) {
+	c, now := newTestConfirmer("secret", time.Minute)
+	token, _ := c.Token("delete-link:1")
+
+	for _, subject := range []string{"delete-link:2", "delete-link:10", "delete-link:", "delete-link:1 ", "archive-link:1"} {
+		if err := c.Verify(subject, token); !errors.Is(err, ErrInvalidConfirmation) {
+			t.Errorf("token of delete-link:1 for %q: got %v, want %v", subject, err, ErrInvalidConfirmation)
+		}
+	}
+
+	// Once expired, a token for another link is still invalid rather than expired
+	*now = now.Add(time.Hour)
+	if err := c.Verify("delete-link:2", token); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("expired token for another link: got %v, want %v", err, ErrInvalidConfirmation)
+	}
+}
+
+func TestConfirmerTokensOfTheSameSecret(t *testing.T) {
+	// Instances sharing the secret accept each other's tokens
+	a, _ := newTestConfirmer("secret", time.Minute)
+	b, _ := newTestConfirmer("secret", time.Minute)
+	token, _ := a.Token("delete-link:1")
+	if err := b.Verify("delete-link:1", token); err != nil {
+		t.Errorf("token of another instance: got %v, want it accepted", err)
+	}
+}
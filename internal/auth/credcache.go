@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// credentialsCacheTTL is how long basic auth credentials are trusted without checking them again
+	credentialsCacheTTL = time.Minute
+	// credentialsCacheSize bounds the cache, it's cleared when full
+	credentialsCacheSize = 1024
+)
+
+// credentialsCache remembers the basic auth credentials that logged in recently, so clients
+// sending them with every request don't pay for bcrypt and the lockout lookup each time.
+// Entries are keyed by an HMAC of the credentials and the IP they came from, passwords aren't
+// kept, and another IP checks them in full. They only hold as long as the password hash of
+// the user is the same one, and a failed login from their IP drops them.
+type credentialsCache struct {
+	secret []byte
+
+	mu      sync.Mutex
+	entries map[string]cachedCredentials
+}
+
+type cachedCredentials struct {
+	ipAddress    string
+	passwordHash string
+	expires      time.Time
+}
+
+func newCredentialsCache(secret string) *credentialsCache {
+	return &credentialsCache{secret: []byte(secret), entries: make(map[string]cachedCredentials)}
+}
+
+func (c *credentialsCache) key(ipAddress string, creds Credentials) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(ipAddress + "\x00" + creds.Username + "\x00" + creds.Password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// get returns the password hash creds were checked against from ipAddress, if they were recently.
+func (c *credentialsCache) get(ipAddress string, creds Credentials) (string, bool) {
+	key := c.key(ipAddress, creds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.passwordHash, true
+}
+
+// put remembers that creds matched passwordHash when they came from ipAddress.
+func (c *credentialsCache) put(ipAddress string, creds Credentials, passwordHash string) {
+	key := c.key(ipAddress, creds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= credentialsCacheSize {
+		clear(c.entries)
+	}
+	c.entries[key] = cachedCredentials{
+		ipAddress:    ipAddress,
+		passwordHash: passwordHash,
+		expires:      time.Now().Add(credentialsCacheTTL),
+	}
+}
+
+// forgetIP drops the credentials that came from ipAddress, its next logins are checked in full.
+func (c *credentialsCache) forgetIP(ipAddress string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.ipAddress == ipAddress {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdusco/linked/internal/webhook"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrLockedOut is returned for logins from a client with too many recent failed logins.
+var ErrLockedOut = errors.New("too many failed logins")
+
+// Lockout locks out clients guessing passwords. MaxFailures failed logins from an IP within
+// Window lock it out until the oldest of them is Window old, a successful login resets the count.
+type Lockout struct {
+	// MaxFailures of zero never locks anyone out, failed logins are still recorded
+	MaxFailures int
+	Window      time.Duration
+}
+
+// checkLockout returns the failed logins from ipAddress that count towards a lockout,
+// or ErrLockedOut when there are too many.
+func (a *Authenticator) checkLockout(ctx context.Context, ipAddress string) (int, error) {
+	failures, err := a.attempts.CountFailures(ctx, ipAddress, time.Now().Add(-a.lockout.Window))
+	if err != nil {
+		return 0, err
+	}
+	if a.lockout.MaxFailures > 0 && failures >= a.lockout.MaxFailures {
+		return failures, ErrLockedOut
+	}
+	return failures, nil
+}
+
+// recordFailure stores a failed login, warning when it's the one locking the client out.
+// Basic auth credentials of the client aren't let through without checking them anymore.
+// A failure to store it is logged, the login fails either way.
+func (a *Authenticator) recordFailure(ctx context.Context, username, ipAddress string, failures int) {
+	a.basicAuth.forgetIP(ipAddress)
+	if err := a.attempts.RecordFailure(ctx, username, ipAddress); err != nil {
+		log.Error().Err(err).Str("ip", ipAddress).Msg("failed to record failed login")
+		return
+	}
+	if failures != a.lockout.MaxFailures {
+		return
+	}
+
+	until := time.Now().Add(a.lockout.Window).UTC()
+	log.Warn().
+		Str("ip", ipAddress).
+		Str("username", username).
+		Int("failures", failures).
+		Time("until", until).
+		Msg("client is locked out after too many failed logins")
+	a.webhooks.LoginLockedOut(webhook.LockoutData{
+		IPAddress: ipAddress,
+		Username:  username,
+		Failures:  failures,
+		Until:     until,
+	})
+}
+
+// LockedOut answers a locked out client with 429, telling it when to try again at the latest.
+func (a *Authenticator) LockedOut(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(a.lockout.Window.Seconds())))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed logins, try again later")
+}
@@ -1,23 +1,45 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/webhook"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	cookieName  = "auth_token"
 	tokenExpiry = 30 * 24 * time.Hour // 1 month
+	// userContextKey holds the *internal.User of an authenticated request
+	userContextKey = "user"
+	// MinPasswordLength applies to users created through the API, ADMIN_CREDENTIALS is exempt
+	MinPasswordLength = 8
 )
 
 type authClaims struct {
 	jwt.RegisteredClaims
+	// Version is the token version at signing time. Tokens with an older version have been revoked.
+	Version int64 `json:"ver,omitempty"`
+	// UserID is missing from tokens issued before there were users, their subject is the admin username
+	UserID int64         `json:"uid,omitempty"`
+	Role   internal.Role `json:"role,omitempty"`
 }
 
 var ErrUnauthorized = errors.New("unauthorized")
@@ -27,10 +49,6 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
-func (c Credentials) Check(other Credentials) bool {
-	return c.Username == other.Username && c.Password == other.Password
-}
-
 func NewCredentials(s string) (Credentials, error) {
 	parts := strings.SplitN(s, ":", 2)
 	if len(parts) != 2 {
@@ -43,24 +61,251 @@ func NewCredentials(s string) (Credentials, error) {
 	}, nil
 }
 
+// SecureMode decides when the auth cookie gets the Secure flag.
+type SecureMode string
+
+const (
+	// SecureAuto sets Secure when the request arrived over TLS, directly or through a proxy.
+	SecureAuto   SecureMode = "auto"
+	SecureAlways SecureMode = "true"
+	SecureNever  SecureMode = "false"
+)
+
+func ParseSecureMode(s string) (SecureMode, error) {
+	switch mode := SecureMode(strings.ToLower(s)); mode {
+	case SecureAuto, SecureAlways, SecureNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid cookie secure mode %q, must be one of auto, true, false", s)
+}
+
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("invalid cookie samesite mode %q, must be one of lax, strict, none", s)
+}
+
+type CookieOptions struct {
+	Secure   SecureMode
+	SameSite http.SameSite
+	// TrustedProxies are the proxies whose X-Forwarded-Proto tells SecureAuto the client used HTTPS,
+	// anyone else could claim it over plain HTTP
+	TrustedProxies []*net.IPNet
+}
+
+// HashPassword hashes a password to store it.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// unknownUserHash is compared against for unknown usernames, so they take as long to reject as wrong passwords
+var unknownUserHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	return hash
+})
+
 type Authenticator struct {
 	credentials Credentials
 	jwtSecret   string
+	cookieOpts  CookieOptions
+	settings    *repo.SettingsRepo
+	users       *repo.UsersRepo
+	attempts    *repo.LoginAttemptsRepo
+	lockout     Lockout
+	webhooks    *webhook.Dispatcher
+	basicAuth   *credentialsCache
 }
 
-func NewAuthenticator(credentials Credentials, jwtSecret string) *Authenticator {
-	return &Authenticator{credentials: credentials, jwtSecret: jwtSecret}
+// NewAuthenticator logs users in against the users table. The credentials are those of
+// ADMIN_CREDENTIALS, which Init keeps as an admin user. Failed logins are recorded in attempts,
+// and lock out their client by lockout.
+func NewAuthenticator(credentials Credentials, jwtSecret string, cookieOpts CookieOptions, settings *repo.SettingsRepo, users *repo.UsersRepo, attempts *repo.LoginAttemptsRepo, lockout Lockout, webhooks *webhook.Dispatcher) *Authenticator {
+	return &Authenticator{
+		credentials: credentials,
+		jwtSecret:   jwtSecret,
+		cookieOpts:  cookieOpts,
+		settings:    settings,
+		users:       users,
+		attempts:    attempts,
+		lockout:     lockout,
+		webhooks:    webhooks,
+		basicAuth:   newCredentialsCache(jwtSecret),
+	}
 }
 
-func (a Authenticator) Authenticate(creds Credentials) (*http.Cookie, error) {
-	ok := a.checkCredentials(creds)
-	if !ok {
-		return nil, ErrUnauthorized
+// Init revokes all previously issued tokens if the configured credentials changed since
+// the last start. The configured credentials are then seeded as an admin user, or that
+// user is updated to match them.
+func (a *Authenticator) Init(ctx context.Context) error {
+	// Fail at start rather than rejecting every token later
+	if _, err := a.tokenVersion(ctx); err != nil {
+		return err
+	}
+
+	hash := a.credentialsHash()
+	storedHash, err := a.settings.Get(ctx, repo.SettingCredentialsHash)
+	if err != nil && !errors.Is(err, internal.ErrSettingNotFound) {
+		return err
+	}
+	// Nothing stored means a fresh database or an upgrade, no reason to log anyone out
+	if storedHash != "" && storedHash != hash {
+		log.Warn().Msg("admin credentials changed, revoking all issued tokens")
+		if err := a.RevokeAll(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := a.settings.Set(ctx, repo.SettingCredentialsHash, hash); err != nil {
+		return err
+	}
+	return a.seedAdmin(ctx)
+}
+
+func (a *Authenticator) seedAdmin(ctx context.Context) error {
+	user, hash, err := a.users.GetByUsername(ctx, a.credentials.Username)
+	if errors.Is(err, internal.ErrUserNotFound) {
+		hash, err := HashPassword(a.credentials.Password)
+		if err != nil {
+			return err
+		}
+		_, err = a.users.Create(ctx, repo.UserParams{
+			Username:     a.credentials.Username,
+			PasswordHash: hash,
+			Role:         internal.RoleAdmin,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to seed admin user: %w", err)
+		}
+		log.Info().Str("username", a.credentials.Username).Msg("created admin user from ADMIN_CREDENTIALS")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	params := repo.UserParams{Role: internal.RoleAdmin}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(a.credentials.Password)) != nil {
+		if params.PasswordHash, err = HashPassword(a.credentials.Password); err != nil {
+			return err
+		}
+	} else if user.Role == internal.RoleAdmin {
+		return nil
+	}
+	if _, err := a.users.Update(ctx, user.ID, params); err != nil {
+		return fmt.Errorf("failed to update admin user: %w", err)
+	}
+	return nil
+}
+
+// RevokeAll invalidates every token issued so far, logging out all sessions.
+func (a *Authenticator) RevokeAll(ctx context.Context) error {
+	if _, err := a.settings.Increment(ctx, repo.SettingTokenVersion); err != nil {
+		return fmt.Errorf("failed to bump token version: %w", err)
+	}
+	return nil
+}
+
+// tokenVersion returns the version tokens are signed with now, older ones have been revoked.
+// It's read on every request, so revoking on another instance applies right away.
+func (a *Authenticator) tokenVersion(ctx context.Context) (int64, error) {
+	version, err := a.settings.Get(ctx, repo.SettingTokenVersion)
+	if errors.Is(err, internal.ErrSettingNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token version %q: %w", version, err)
+	}
+	return v, nil
+}
+
+// credentialsHash fingerprints the credentials without storing them in the clear.
+func (a *Authenticator) credentialsHash() string {
+	mac := hmac.New(sha256.New, []byte(a.jwtSecret))
+	mac.Write([]byte(a.credentials.Username + ":" + a.credentials.Password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate checks the credentials and sets the auth cookie on success. A client with too many
+// failed logins gets ErrLockedOut without its credentials being checked.
+func (a *Authenticator) Authenticate(c echo.Context, creds Credentials) error {
+	_, err := a.authenticate(c, creds)
+	return err
+}
+
+// authenticate is Authenticate, returning the password hash the credentials matched.
+func (a *Authenticator) authenticate(c echo.Context, creds Credentials) (string, error) {
+	ctx := c.Request().Context()
+	ipAddress := c.RealIP()
+
+	failures, err := a.checkLockout(ctx, ipAddress)
+	if err != nil {
+		return "", err
+	}
+	user, hash, err := a.checkCredentials(ctx, creds)
+	if errors.Is(err, ErrUnauthorized) {
+		a.recordFailure(ctx, creds.Username, ipAddress, failures+1)
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	if failures > 0 {
+		if err := a.attempts.ClearFailures(ctx, ipAddress); err != nil {
+			log.Error().Err(err).Str("ip", ipAddress).Msg("failed to clear failed logins")
+		}
 	}
-	return a.generateCookie(creds.Username)
+	return hash, a.logIn(c, user)
 }
 
-func (a Authenticator) checkJWT(tokenStr string) (*authClaims, error) {
+// logIn makes user the one of the request and sets the auth cookie.
+func (a *Authenticator) logIn(c echo.Context, user *internal.User) error {
+	version, err := a.tokenVersion(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	c.Set(userContextKey, user)
+	return a.setAuthCookie(c, user, version)
+}
+
+// UserFrom returns the user of an authenticated request, or nil.
+func UserFrom(c echo.Context) *internal.User {
+	user, _ := c.Get(userContextKey).(*internal.User)
+	return user
+}
+
+// RequireRole only lets users with at least the given role through, it goes after the auth middleware.
+func RequireRole(role internal.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := UserFrom(c)
+			if user == nil {
+				return echo.ErrUnauthorized
+			}
+			if !user.Role.Includes(role) {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("requires the %s role", role))
+			}
+			return next(c)
+		}
+	}
+}
+
+// ClearCookie expires the auth cookie.
+func (a *Authenticator) ClearCookie(c echo.Context) {
+	c.SetCookie(a.newCookie(c, "", -1))
+}
+
+func (a *Authenticator) checkJWT(tokenStr string) (*authClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &authClaims{}, func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -75,22 +320,45 @@ func (a Authenticator) checkJWT(tokenStr string) (*authClaims, error) {
 	if !ok {
 		return nil, errors.New("invalid token claims")
 	}
-
 	return claims, nil
 }
 
-func (a Authenticator) checkCredentials(credentials Credentials) bool {
-	return a.credentials.Check(credentials)
+// checkCredentials returns the user of credentials and its password hash they matched.
+func (a *Authenticator) checkCredentials(ctx context.Context, credentials Credentials) (*internal.User, string, error) {
+	user, hash, err := a.users.GetByUsername(ctx, credentials.Username)
+	if errors.Is(err, internal.ErrUserNotFound) {
+		_ = bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(credentials.Password))
+		return nil, "", ErrUnauthorized
+	} else if err != nil {
+		return nil, "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(credentials.Password)) != nil {
+		return nil, "", ErrUnauthorized
+	}
+	return user, hash, nil
+}
+
+// userForClaims loads the user of a token, so deleted users are rejected and role changes apply right away.
+func (a *Authenticator) userForClaims(ctx context.Context, claims *authClaims) (*internal.User, error) {
+	if claims.UserID != 0 {
+		return a.users.Get(ctx, claims.UserID)
+	}
+	user, _, err := a.users.GetByUsername(ctx, claims.Subject)
+	return user, err
 }
 
-func (a Authenticator) signJWT(username string) (string, error) {
+// signJWT signs a token of user with version, the current one of tokenVersion.
+func (a *Authenticator) signJWT(user *internal.User, version int64) (string, error) {
 	now := jwt.NewNumericDate(time.Now())
 	claims := &authClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   username,
+			Subject:   user.Username,
 			IssuedAt:  now,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * 24 * time.Hour)), // 30 days
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExpiry)),
 		},
+		Version: version,
+		UserID:  user.ID,
+		Role:    user.Role,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -101,22 +369,59 @@ func (a Authenticator) signJWT(username string) (string, error) {
 	return signed, nil
 }
 
-func (a Authenticator) generateCookie(username string) (*http.Cookie, error) {
-	token, err := a.signJWT(username)
+func (a *Authenticator) setAuthCookie(c echo.Context, user *internal.User, version int64) error {
+	token, err := a.signJWT(user, version)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	c.SetCookie(a.newCookie(c, token, int(tokenExpiry.Seconds())))
+	return nil
+}
+
+// newCookie is the single place the auth cookie is built. A negative maxAge deletes it.
+func (a *Authenticator) newCookie(c echo.Context, value string, maxAge int) *http.Cookie {
 	cookie := &http.Cookie{
 		Name:     cookieName,
-		Value:    token,
+		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 60 * 60, // 30 days in seconds
+		Secure:   a.isSecure(c),
+		SameSite: a.cookieOpts.SameSite,
+		MaxAge:   maxAge,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	return cookie
+}
+
+func (a *Authenticator) isSecure(c echo.Context) bool {
+	// Browsers drop SameSite=None cookies that aren't Secure
+	if a.cookieOpts.SameSite == http.SameSiteNoneMode {
+		return true
 	}
-	return cookie, nil
+
+	switch a.cookieOpts.Secure {
+	case SecureAlways:
+		return true
+	case SecureNever:
+		return false
+	}
+	if c.IsTLS() {
+		return true
+	}
+	return a.fromTrustedProxy(c.Request()) && strings.EqualFold(c.Request().Header.Get(echo.HeaderXForwardedProto), "https")
+}
+
+// fromTrustedProxy reports whether r came from one of the trusted proxies.
+func (a *Authenticator) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && slices.ContainsFunc(a.cookieOpts.TrustedProxies, func(proxy *net.IPNet) bool { return proxy.Contains(ip) })
 }
 
 func NewAuthMiddleware(auther *Authenticator) echo.MiddlewareFunc {
@@ -130,7 +435,12 @@ func NewAuthMiddleware(auther *Authenticator) echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			for _, strategy := range strategies {
 				ok, err := strategy(c)
-				if err != nil {
+				if errors.Is(err, ErrLockedOut) {
+					return auther.LockedOut(c)
+				} else if errors.Is(err, internal.ErrDatabaseBusy) {
+					// Not a failed login, the client should try again rather than log in again
+					return err
+				} else if err != nil {
 					continue
 				}
 
@@ -143,7 +453,7 @@ func NewAuthMiddleware(auther *Authenticator) echo.MiddlewareFunc {
 	}
 }
 
-func (a Authenticator) authWithCookie(c echo.Context) (bool, error) {
+func (a *Authenticator) authWithCookie(c echo.Context) (bool, error) {
 	cookie, err := c.Cookie(cookieName)
 	if err != nil || cookie == nil || cookie.Value == "" {
 		return false, nil
@@ -153,43 +463,54 @@ func (a Authenticator) authWithCookie(c echo.Context) (bool, error) {
 	if err != nil {
 		return false, nil
 	}
-
-	refreshedCookie, err := a.generateCookie(claims.Subject)
+	version, err := a.tokenVersion(c.Request().Context())
 	if err != nil {
+		return false, fmt.Errorf("failed to read token version: %w", err)
+	}
+	if claims.Version < version {
+		// Revoked
+		return false, nil
+	}
+	user, err := a.userForClaims(c.Request().Context(), claims)
+	if err != nil {
+		return false, fmt.Errorf("failed to load user: %w", err)
+	}
+	c.Set(userContextKey, user)
+
+	if err := a.setAuthCookie(c, user, version); err != nil {
 		return false, fmt.Errorf("failed to generate cookie: %w", err)
 	}
-	c.SetCookie(refreshedCookie)
 
 	return true, nil
 }
 
-func (a Authenticator) authWithBasicAuth(c echo.Context) (bool, error) {
+func (a *Authenticator) authWithBasicAuth(c echo.Context) (bool, error) {
 	username, password, ok := c.Request().BasicAuth()
 	if !ok {
 		return false, nil
 	}
 	creds := Credentials{Username: username, Password: password}
-
-	cookie, err := a.Authenticate(creds)
-	if err != nil {
-		return false, fmt.Errorf("failed to generate cookie: %w", err)
+	ipAddress := c.RealIP()
+
+	// Clients send the same credentials with every request, those that just logged in are let through
+	// while the user still has the password they matched
+	if hash, ok := a.basicAuth.get(ipAddress, creds); ok {
+		user, current, err := a.users.GetByUsername(c.Request().Context(), username)
+		if err != nil && !errors.Is(err, internal.ErrUserNotFound) {
+			return false, fmt.Errorf("failed to load user: %w", err)
+		}
+		if err == nil && current == hash {
+			if err := a.logIn(c, user); err != nil {
+				return false, fmt.Errorf("failed to authenticate: %w", err)
+			}
+			return true, nil
+		}
 	}
-	cookie.Secure = c.IsTLS()
-
-	c.SetCookie(cookie)
 
-	return ok, nil
-}
-
-func ExpireCookie() *http.Cookie {
-	return &http.Cookie{
-		Name:     cookieName,
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Unix(0, 0),
-		MaxAge:   -1,
+	hash, err := a.authenticate(c, creds)
+	if err != nil {
+		return false, fmt.Errorf("failed to authenticate: %w", err)
 	}
+	a.basicAuth.put(ipAddress, creds, hash)
+	return true, nil
 }
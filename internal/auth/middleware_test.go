@@ -0,0 +1,247 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// logInCookie logs in to the server at baseURL and returns the auth cookie it set.
+func logInCookie(t *testing.T, baseURL string) *http.Cookie {
+	t.Helper()
+
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, baseURL)
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range client.Jar.Cookies(u) {
+		if cookie.Name == "auth_token" {
+			return cookie
+		}
+	}
+	t.Fatal("logging in set no auth cookie")
+	return nil
+}
+
+// do sends a request to the server at baseURL with only cookie to authenticate it.
+func do(t *testing.T, method, baseURL, path string, cookie *http.Cookie) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, baseURL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestRevokeAll(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+
+	old := logInCookie(t, ts.URL)
+	other := logInCookie(t, ts.URL)
+	if got := do(t, http.MethodPost, ts.URL, "/api/auth/revoke-all", other); got != http.StatusNoContent {
+		t.Fatalf("revoke all: got %d, want %d", got, http.StatusNoContent)
+	}
+
+	if got := do(t, http.MethodGet, ts.URL, "/api/links", old); got != http.StatusUnauthorized {
+		t.Errorf("token issued before revoking: got %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := do(t, http.MethodGet, ts.URL, "/api/links", logInCookie(t, ts.URL)); got != http.StatusOK {
+		t.Errorf("token issued after revoking: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestRevokeAllOnAnotherInstance(t *testing.T) {
+	sqlDB := testutil.NewDB(t)
+	cfg := testutil.ServerConfig(t)
+	first := testutil.NewServer(t, sqlDB, cfg)
+	second := testutil.NewServer(t, sqlDB, cfg)
+
+	old := logInCookie(t, first.URL)
+	if got := do(t, http.MethodGet, first.URL, "/api/links", old); got != http.StatusOK {
+		t.Fatalf("before revoking: got %d, want %d", got, http.StatusOK)
+	}
+
+	if got := do(t, http.MethodPost, second.URL, "/api/auth/revoke-all", logInCookie(t, second.URL)); got != http.StatusNoContent {
+		t.Fatalf("revoke all: got %d, want %d", got, http.StatusNoContent)
+	}
+
+	// The instance that issued the token learns of the revocation from the database
+	if got := do(t, http.MethodGet, first.URL, "/api/links", old); got != http.StatusUnauthorized {
+		t.Errorf("token issued before revoking: got %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := do(t, http.MethodGet, first.URL, "/api/links", logInCookie(t, first.URL)); got != http.StatusOK {
+		t.Errorf("token issued after revoking: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+// doBasic sends a request to the server at baseURL authenticated with basic auth.
+func doBasic(t *testing.T, baseURL, username, password string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/links", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(username, password)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestBasicAuthChecksCredentialsOnce(t *testing.T) {
+	sqlDB := testutil.NewDB(t)
+	ts := testutil.NewServer(t, sqlDB, testutil.ServerConfig(t))
+
+	start := time.Now()
+	if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusOK {
+		t.Fatalf("first request: got %d, want %d", got, http.StatusOK)
+	}
+	checked := time.Since(start)
+
+	// Without the lockout lookup, the next ones don't need the failed logins
+	if _, err := sqlDB.Exec("DROP TABLE login_attempts"); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	for range 10 {
+		if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusOK {
+			t.Fatalf("repeated request: got %d, want %d", got, http.StatusOK)
+		}
+	}
+	// Nor bcrypt, ten of them take less time than the one that compared the password
+	if elapsed := time.Since(start); elapsed > checked {
+		t.Errorf("10 repeated requests took %v, the first one %v", elapsed, checked)
+	}
+}
+
+func TestBasicAuthAfterPasswordChange(t *testing.T) {
+	sqlDB := testutil.NewDB(t)
+	ts := testutil.NewServer(t, sqlDB, testutil.ServerConfig(t))
+	if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusOK {
+		t.Fatalf("before the change: got %d, want %d", got, http.StatusOK)
+	}
+
+	users := repo.NewUsersRepo(sqlDB)
+	ctx := context.Background()
+	admin, _, err := users.GetByUsername(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := auth.HashPassword("another-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Update(ctx, admin.ID, repo.UserParams{PasswordHash: hash, Role: admin.Role}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusUnauthorized {
+		t.Errorf("old password: got %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := doBasic(t, ts.URL, "admin", "another-password"); got != http.StatusOK {
+		t.Errorf("new password: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestBasicAuthLockout(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.LoginLockout.MaxFailures = 2
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+	if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusOK {
+		t.Fatalf("before failing: got %d, want %d", got, http.StatusOK)
+	}
+
+	doBasic(t, ts.URL, "admin", "wrong")
+	doBasic(t, ts.URL, "admin", "wrong")
+	// The credentials that got in before are checked again, and the client is locked out
+	if got := doBasic(t, ts.URL, "admin", "admin"); got != http.StatusTooManyRequests {
+		t.Errorf("after failing: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+// logInSecure logs in to the server at baseURL with the X-Forwarded-Proto header xfp, and reports
+// whether the auth cookie it set is Secure.
+func logInSecure(t *testing.T, baseURL, xfp string) bool {
+	t.Helper()
+
+	payload, err := json.Marshal(auth.Credentials{Username: "admin", Password: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/login", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if xfp != "" {
+		req.Header.Set("X-Forwarded-Proto", xfp)
+	}
+	res, err := testutil.NewClient(t).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "auth_token" {
+			return cookie.Secure
+		}
+	}
+	t.Fatalf("logging in got %d without an auth cookie", res.StatusCode)
+	return false
+}
+
+func TestSecureCookieBehindProxy(t *testing.T) {
+	// Test servers listen on loopback, so that's where requests come from
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, elsewhere, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name    string
+		mode    auth.SecureMode
+		trusted []*net.IPNet
+		xfp     string
+		want    bool
+	}{
+		{"trusted proxy over https", auth.SecureAuto, []*net.IPNet{loopback}, "https", true},
+		{"trusted proxy in another case", auth.SecureAuto, []*net.IPNet{elsewhere, loopback}, "HTTPS", true},
+		{"trusted proxy over http", auth.SecureAuto, []*net.IPNet{loopback}, "http", false},
+		{"trusted proxy without the header", auth.SecureAuto, []*net.IPNet{loopback}, "", false},
+		// Anyone can send the header over plain HTTP
+		{"no trusted proxies", auth.SecureAuto, nil, "https", false},
+		{"untrusted peer", auth.SecureAuto, []*net.IPNet{elsewhere}, "https", false},
+		{"always", auth.SecureAlways, nil, "", true},
+		{"never", auth.SecureNever, []*net.IPNet{loopback}, "https", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutil.ServerConfig(t)
+			cfg.CookieSecure = tt.mode
+			cfg.TrustedProxies = tt.trusted
+			ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+			if got := logInSecure(t, ts.URL, tt.xfp); got != tt.want {
+				t.Errorf("got Secure %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
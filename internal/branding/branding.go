@@ -0,0 +1,189 @@
+// Package branding holds the look of visitor-facing pages, as configured by the admin.
+package branding
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+)
+
+const (
+	// LogoPath serves the uploaded logo
+	LogoPath = "/branding/logo"
+	// MaxLogoSize is the largest logo that can be uploaded
+	MaxLogoSize = 256 << 10
+
+	logoUploadName = "branding.logo"
+
+	defaultName        = "link·ed"
+	defaultAccentColor = "#667eea"
+
+	maxNameLength    = 60
+	maxFooterLength  = 200
+	maxContactLength = 200
+)
+
+// logoTypes are the image types accepted as logo. SVG is left out, it can carry scripts.
+var logoTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+var colorRegex = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+var ErrInvalidLogo = errors.New("logo must be a PNG, JPEG, GIF or WebP image")
+
+// Branding is shown on every page a visitor sees. Empty fields fall back to defaults.
+type Branding struct {
+	Name           string `json:"name" query:"name"`
+	LogoURL        string `json:"logo_url" query:"logo_url"`
+	AccentColor    string `json:"accent_color" query:"accent_color"`
+	FooterText     string `json:"footer_text" query:"footer_text"`
+	SupportContact string `json:"support_contact" query:"support_contact"`
+}
+
+// WithDefaults fills in the empty fields that have a default.
+func (b Branding) WithDefaults() Branding {
+	if b.Name == "" {
+		b.Name = defaultName
+	}
+	if b.AccentColor == "" {
+		b.AccentColor = defaultAccentColor
+	}
+	return b
+}
+
+// Merge returns b with the non-empty fields of other applied on top.
+func (b Branding) Merge(other Branding) Branding {
+	for _, f := range []struct{ dst, src *string }{
+		{&b.Name, &other.Name},
+		{&b.LogoURL, &other.LogoURL},
+		{&b.AccentColor, &other.AccentColor},
+		{&b.FooterText, &other.FooterText},
+		{&b.SupportContact, &other.SupportContact},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return b
+}
+
+// Validate trims the fields and checks their lengths and formats, reporting the first invalid one as an internal.ValidationError.
+func (b *Branding) Validate() error {
+	b.Name = strings.TrimSpace(b.Name)
+	b.LogoURL = strings.TrimSpace(b.LogoURL)
+	b.AccentColor = strings.ToLower(strings.TrimSpace(b.AccentColor))
+	b.FooterText = strings.TrimSpace(b.FooterText)
+	b.SupportContact = strings.TrimSpace(b.SupportContact)
+
+	if utf8.RuneCountInString(b.Name) > maxNameLength {
+		return internal.NewValidationError("name", fmt.Sprintf("name must be at most %d characters long", maxNameLength))
+	}
+	if utf8.RuneCountInString(b.FooterText) > maxFooterLength {
+		return internal.NewValidationError("footer_text", fmt.Sprintf("footer text must be at most %d characters long", maxFooterLength))
+	}
+	if utf8.RuneCountInString(b.SupportContact) > maxContactLength {
+		return internal.NewValidationError("support_contact", fmt.Sprintf("support contact must be at most %d characters long", maxContactLength))
+	}
+	if b.AccentColor != "" && !colorRegex.MatchString(b.AccentColor) {
+		return internal.NewValidationError("accent_color", "accent color must be a hex color like #667eea")
+	}
+	if b.LogoURL != "" && !strings.HasPrefix(b.LogoURL, LogoPath+"?") {
+		u, err := url.Parse(b.LogoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return internal.NewValidationError("logo_url", "logo url must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// Store keeps the branding in the settings and the uploaded logo with the uploads.
+type Store struct {
+	settings *repo.SettingsRepo
+	uploads  *repo.UploadsRepo
+}
+
+func NewStore(settings *repo.SettingsRepo, uploads *repo.UploadsRepo) *Store {
+	return &Store{settings: settings, uploads: uploads}
+}
+
+// Load returns the saved branding, without defaults applied.
+func (s *Store) Load(ctx context.Context) (Branding, error) {
+	value, err := s.settings.Get(ctx, repo.SettingBranding)
+	if errors.Is(err, internal.ErrSettingNotFound) {
+		return Branding{}, nil
+	} else if err != nil {
+		return Branding{}, err
+	}
+
+	var b Branding
+	if err := json.Unmarshal([]byte(value), &b); err != nil {
+		return Branding{}, fmt.Errorf("failed to decode branding: %w", err)
+	}
+	return b, nil
+}
+
+// Save replaces the branding, b must be validated.
+func (s *Store) Save(ctx context.Context, b Branding) error {
+	value, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to encode branding: %w", err)
+	}
+	return s.settings.Set(ctx, repo.SettingBranding, string(value))
+}
+
+// SaveLogo stores an uploaded logo and points the branding at it.
+// The URL changes with the content, so browsers can cache the logo for long.
+func (s *Store) SaveLogo(ctx context.Context, data []byte) (Branding, error) {
+	if len(data) > MaxLogoSize {
+		return Branding{}, fmt.Errorf("logo must be at most %d KB", MaxLogoSize>>10)
+	}
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(logoTypes, contentType) {
+		return Branding{}, ErrInvalidLogo
+	}
+
+	b, err := s.Load(ctx)
+	if err != nil {
+		return Branding{}, err
+	}
+	if err := s.uploads.Put(ctx, &internal.Upload{Name: logoUploadName, ContentType: contentType, Data: data}); err != nil {
+		return Branding{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	b.LogoURL = LogoPath + "?v=" + hex.EncodeToString(sum[:4])
+	return b, s.Save(ctx, b)
+}
+
+// DeleteLogo removes the uploaded logo, and the branding's reference to it.
+func (s *Store) DeleteLogo(ctx context.Context) (Branding, error) {
+	b, err := s.Load(ctx)
+	if err != nil {
+		return Branding{}, err
+	}
+	if err := s.uploads.Delete(ctx, logoUploadName); err != nil {
+		return Branding{}, err
+	}
+
+	if strings.HasPrefix(b.LogoURL, LogoPath+"?") {
+		b.LogoURL = ""
+		return b, s.Save(ctx, b)
+	}
+	return b, nil
+}
+
+// Logo returns the uploaded logo, or internal.ErrUploadNotFound.
+func (s *Store) Logo(ctx context.Context) (*internal.Upload, error) {
+	return s.uploads.Get(ctx, logoUploadName)
+}
@@ -0,0 +1,60 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision,omitempty"`
+	Dirty      bool   `json:"dirty"`
+	BuildTime  string `json:"build_time,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// New merges the values injected with -ldflags with what the Go toolchain embeds in the binary.
+// ldflags win when set; the embedded module and VCS info fills in the rest, so binaries
+// built with a plain `go build` or `go install` still report something useful.
+func New(version, buildTime string) Info {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		bi = nil
+	}
+	return fromBuildInfo(version, buildTime, bi)
+}
+
+func fromBuildInfo(version, buildTime string, bi *debug.BuildInfo) Info {
+	info := Info{
+		Version:   version,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.BuildTime == "unknown" {
+		info.BuildTime = ""
+	}
+	if bi == nil {
+		return info
+	}
+
+	info.GoVersion = bi.GoVersion
+	// `go install module@version` stamps the module version, local builds report (devel)
+	if (info.Version == "" || info.Version == "dev") && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.modified":
+			info.Dirty = setting.Value == "true"
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		}
+	}
+
+	return info
+}
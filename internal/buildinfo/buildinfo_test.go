@@ -0,0 +1,78 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestFromBuildInfo(t *testing.T) {
+	installed := &debug.BuildInfo{
+		GoVersion: "go1.25.1",
+		Main:      debug.Module{Path: "github.com/abdusco/linked", Version: "v1.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "-ldflags", Value: "-s -w"},
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2024-01-15T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	local := &debug.BuildInfo{
+		GoVersion: "go1.25.1",
+		Main:      debug.Module{Path: "github.com/abdusco/linked", Version: "(devel)"},
+		Settings:  []debug.BuildSetting{{Key: "vcs.revision", Value: "fedcba9876543210"}, {Key: "vcs.modified", Value: "false"}},
+	}
+
+	tests := []struct {
+		name               string
+		version, buildTime string
+		bi                 *debug.BuildInfo
+		want               Info
+	}{
+		{
+			// The defaults of main, as a plain `go install module@version` leaves them
+			"ldflags unset", "dev", "unknown", installed,
+			Info{Version: "v1.4.0", Revision: "0123456789abcdef", Dirty: true, CommitTime: "2024-01-15T12:00:00Z", GoVersion: "go1.25.1"},
+		},
+		{
+			"ldflags empty", "", "", installed,
+			Info{Version: "v1.4.0", Revision: "0123456789abcdef", Dirty: true, CommitTime: "2024-01-15T12:00:00Z", GoVersion: "go1.25.1"},
+		},
+		{
+			"ldflags set", "v1.5.0-rc1", "2024-02-01T08:00:00Z", installed,
+			Info{Version: "v1.5.0-rc1", Revision: "0123456789abcdef", Dirty: true, BuildTime: "2024-02-01T08:00:00Z", CommitTime: "2024-01-15T12:00:00Z", GoVersion: "go1.25.1"},
+		},
+		{
+			// A local build has no module version to fall back to
+			"local build", "dev", "unknown", local,
+			Info{Version: "dev", Revision: "fedcba9876543210", GoVersion: "go1.25.1"},
+		},
+		{
+			"no module version", "dev", "unknown", &debug.BuildInfo{GoVersion: "go1.25.1"},
+			Info{Version: "dev", GoVersion: "go1.25.1"},
+		},
+		{
+			"no build info", "dev", "unknown", nil,
+			Info{Version: "dev", GoVersion: runtime.Version()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fromBuildInfo(tt.version, tt.buildTime, tt.bi); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	// Test binaries carry build info too, without a module version
+	info := New("dev", "unknown")
+	if info.Version != "dev" || info.BuildTime != "" {
+		t.Errorf("got version %q built at %q, want dev at no time", info.Version, info.BuildTime)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("got Go version %q, want %q", info.GoVersion, runtime.Version())
+	}
+}
@@ -0,0 +1,18 @@
+package buildinfo
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderVersion carries the version of the server in every response
+const HeaderVersion = "X-Linked-Version"
+
+// Middleware sets the version header before the handler runs, so it's on errors too.
+func Middleware(info Info) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set(HeaderVersion, info.Version)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package clickfilter
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"time"
+
+	"github.com/abdusco/linked/internal/cache"
+	"github.com/abdusco/linked/internal/useragent"
+)
+
+// maxTrackedClicks bounds memory use, deduplication lapses briefly when it fills up
+const maxTrackedClicks = 100_000
+
+// Filter decides which clicks are counted. It drops repeats of the same visitor within a window,
+// like a browser prefetching a link right before it's opened, and optionally link preview bots and uptime monitors.
+type Filter struct {
+	window      time.Duration
+	excludeBots bool
+	seen        *cache.TTL[[sha256.Size]byte, struct{}]
+}
+
+// New returns a filter that counts a visitor at most once per window, zero disables deduplication.
+func New(window time.Duration, excludeBots bool) *Filter {
+	f := &Filter{window: window, excludeBots: excludeBots}
+	if window > 0 {
+		f.seen = cache.NewTTL[[sha256.Size]byte, struct{}](window, maxTrackedClicks)
+	}
+	return f
+}
+
+// Skip reports whether a click made with method shouldn't be recorded, and why. Methods are
+// deduplicated apart, so a HEAD check right before a visit doesn't take its place.
+// Concurrent duplicates are counted exactly once.
+func (f *Filter) Skip(linkID int64, method, ipAddress, userAgent string) (bool, string) {
+	if f.excludeBots && (useragent.IsUnfurler(userAgent) || useragent.IsMonitor(userAgent)) {
+		return true, "bot"
+	}
+	if f.seen == nil {
+		return false, ""
+	}
+
+	// Hash the key so the cache doesn't keep arbitrarily long user agents around
+	key := sha256.Sum256([]byte(strconv.FormatInt(linkID, 10) + "\x00" + method + "\x00" + ipAddress + "\x00" + userAgent))
+	if !f.seen.SetIfAbsent(key, struct{}{}) {
+		return true, "duplicate"
+	}
+	return false, ""
+}
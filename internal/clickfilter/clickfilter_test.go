@@ -0,0 +1,81 @@
+package clickfilter
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSkipConcurrentDuplicates(t *testing.T) {
+	f := New(time.Minute, false)
+
+	// Visitors each click their link many times at once, like a prefetch racing the click
+	const visitors, clicks = 10, 50
+	var counted [visitors]atomic.Int64
+	var wg sync.WaitGroup
+	for v := range visitors {
+		for range clicks {
+			wg.Go(func() {
+				if skip, reason := f.Skip(1, http.MethodGet, fmt.Sprintf("198.51.100.%d", v), "Mozilla/5.0"); !skip {
+					counted[v].Add(1)
+				} else if reason != "duplicate" {
+					t.Errorf("skipped for %q, want duplicate", reason)
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	for v := range visitors {
+		if n := counted[v].Load(); n != 1 {
+			t.Errorf("visitor %d: counted %d of %d concurrent clicks, want 1", v, n, clicks)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	f := New(50*time.Millisecond, true)
+
+	if skip, _ := f.Skip(1, http.MethodGet, "198.51.100.1", "Mozilla/5.0"); skip {
+		t.Fatal("first click skipped")
+	}
+	for _, click := range []struct {
+		name      string
+		linkID    int64
+		method    string
+		ipAddress string
+		userAgent string
+	}{
+		{"another link", 2, http.MethodGet, "198.51.100.1", "Mozilla/5.0"},
+		{"another IP", 1, http.MethodGet, "198.51.100.2", "Mozilla/5.0"},
+		{"another user agent", 1, http.MethodGet, "198.51.100.1", "curl/8.0"},
+		// A check before a visit doesn't take its place
+		{"another method", 1, http.MethodHead, "198.51.100.1", "Mozilla/5.0"},
+	} {
+		if skip, reason := f.Skip(click.linkID, click.method, click.ipAddress, click.userAgent); skip {
+			t.Errorf("%s: skipped as %s, want it counted", click.name, reason)
+		}
+	}
+
+	if skip, reason := f.Skip(1, http.MethodGet, "198.51.100.3", "Slackbot-LinkExpanding 1.0"); !skip || reason != "bot" {
+		t.Errorf("preview bot: got %t %q, want it skipped as a bot", skip, reason)
+	}
+
+	// Once the window is over, the visitor counts again
+	time.Sleep(60 * time.Millisecond)
+	if skip, _ := f.Skip(1, http.MethodGet, "198.51.100.1", "Mozilla/5.0"); skip {
+		t.Error("click after the window skipped")
+	}
+}
+
+func TestSkipWithoutWindow(t *testing.T) {
+	f := New(0, false)
+	for range 3 {
+		if skip, reason := f.Skip(1, http.MethodGet, "198.51.100.1", "Slackbot-LinkExpanding 1.0"); skip {
+			t.Errorf("skipped as %s without deduplication nor excluding bots", reason)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package clicksink hands the clicks recorded over to analytics pipelines outside of the application.
+package clicksink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// queueSize bounds the clicks waiting to be written, more are dropped
+	queueSize = 10_000
+	// bufferSize is how much is written at once, unless flushInterval passes first
+	bufferSize    = 64 << 10
+	flushInterval = time.Second
+)
+
+// Event is a click as sinks receive it.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Slug      string    `json:"slug"`
+	LinkID    int64     `json:"link_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Referer   string    `json:"referer"`
+	// Method is GET for visits, HEAD for link checkers and monitors
+	Method string `json:"method"`
+}
+
+// Sink receives every click recorded. Send must not block, a sink that can't keep up drops clicks
+// rather than slowing down redirects.
+type Sink interface {
+	Send(Event)
+}
+
+// Nop drops every click, it's the sink when none is configured.
+type Nop struct{}
+
+func (Nop) Send(Event) {}
+
+// NDJSON writes clicks as JSON, one per line, to stdout or a file. Writes are buffered and
+// flushed every second. A file is reopened on SIGHUP, so it can be rotated by moving it away.
+type NDJSON struct {
+	// path is the file written to, empty for stdout
+	path   string
+	out    io.Writer
+	buf    *bufio.Writer
+	events chan Event
+	// failing is set after a write failed, so only the first of a run of failures is logged
+	failing bool
+}
+
+// NewStdout returns a sink writing to stdout, logs go to stderr and don't get mixed in.
+func NewStdout() *NDJSON {
+	return newNDJSON("", os.Stdout)
+}
+
+// OpenFile returns a sink appending to the file at path, which is created if missing.
+func OpenFile(path string) (*NDJSON, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newNDJSON(path, f), nil
+}
+
+func newNDJSON(path string, out io.Writer) *NDJSON {
+	return &NDJSON{
+		path:   path,
+		out:    out,
+		buf:    bufio.NewWriterSize(out, bufferSize),
+		events: make(chan Event, queueSize),
+	}
+}
+
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// Send queues the click to be written, or drops it when the queue is full.
+func (s *NDJSON) Send(e Event) {
+	select {
+	case s.events <- e:
+	default:
+		metrics.ClickSinkDropped.Add(1)
+	}
+}
+
+// Run writes the queued clicks until ctx is done, then writes what's left and closes the file.
+func (s *NDJSON) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	hup := make(chan os.Signal, 1)
+	if s.path != "" {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.drain()
+			return
+		case e := <-s.events:
+			s.write(e)
+		case <-ticker.C:
+			s.flush()
+		case <-hup:
+			s.reopen()
+		}
+	}
+}
+
+func (s *NDJSON) write(e Event) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	line = append(line, '\n')
+	if _, err := s.buf.Write(line); err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *NDJSON) flush() {
+	if s.buf.Buffered() == 0 {
+		return
+	}
+	if err := s.buf.Flush(); err != nil {
+		s.fail(err)
+		return
+	}
+	if s.failing {
+		s.failing = false
+		log.Info().Str("path", s.path).Msg("writing clicks to the sink again")
+	}
+}
+
+// fail drops what's buffered, a failed bufio.Writer refuses every write after
+func (s *NDJSON) fail(err error) {
+	metrics.ClickSinkErrors.Add(1)
+	if !s.failing {
+		s.failing = true
+		log.Error().Err(err).Str("path", s.path).Msg("failed to write clicks to the sink, dropping them")
+	}
+	s.buf.Reset(s.out)
+}
+
+// reopen switches to a new file at path, after the old one was moved away by a log rotation.
+func (s *NDJSON) reopen() {
+	s.flush()
+	f, err := openFile(s.path)
+	if err != nil {
+		log.Error().Err(err).Str("path", s.path).Msg("failed to reopen click sink, writing to the old file")
+		return
+	}
+	s.close()
+	s.out = f
+	s.buf.Reset(f)
+	log.Info().Str("path", s.path).Msg("reopened click sink")
+}
+
+func (s *NDJSON) drain() {
+	for {
+		select {
+		case e := <-s.events:
+			s.write(e)
+		default:
+			s.flush()
+			s.close()
+			return
+		}
+	}
+}
+
+func (s *NDJSON) close() {
+	if f, ok := s.out.(*os.File); ok && s.path != "" {
+		if err := f.Close(); err != nil {
+			log.Error().Err(err).Str("path", s.path).Msg("failed to close click sink")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package clicksink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/metrics"
+)
+
+// event is the nth click sent in a test.
+func event(n int) Event {
+	return Event{
+		Timestamp: time.Date(2024, 1, 15, 12, 0, n, 0, time.UTC),
+		Slug:      "promo",
+		LinkID:    int64(n),
+		IP:        "192.0.2.1",
+		UserAgent: "Mozilla/5.0",
+		Method:    "GET",
+	}
+}
+
+// readEvents reads the clicks written to the file at path.
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %d isn't a click: %v", len(events)+1, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// run runs s until the returned function is called, which returns once s has stopped.
+func run(s *NDJSON) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestFlushOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.ndjson")
+	// A file that's there already is appended to
+	if err := os.WriteFile(path, []byte(`{"slug":"earlier"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := run(s)
+
+	// Far less than a buffer's worth, so only shutting down writes them out
+	const clicks = 100
+	for n := range clicks {
+		s.Send(event(n))
+	}
+	stop()
+
+	events := readEvents(t, path)
+	if len(events) != clicks+1 || events[0].Slug != "earlier" {
+		t.Fatalf("got %d lines, want the earlier one and %d clicks", len(events), clicks)
+	}
+	for n, e := range events[1:] {
+		if e != event(n) {
+			t.Errorf("line %d: got %+v, want %+v", n+2, e, event(n))
+		}
+	}
+}
+
+func TestDropUnderBackpressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clicks.ndjson")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing writes the queue out yet, so it fills up and the rest is dropped without blocking
+	const extra = 50
+	dropped := metrics.ClickSinkDropped.Value()
+	sent := make(chan struct{})
+	go func() {
+		for n := range queueSize + extra {
+			s.Send(event(n))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked on a full queue")
+	}
+	if got := metrics.ClickSinkDropped.Value() - dropped; got != extra {
+		t.Errorf("dropped %d clicks, want %d", got, extra)
+	}
+
+	// What was queued is still written, in order
+	run(s)()
+	events := readEvents(t, path)
+	if len(events) != queueSize {
+		t.Fatalf("wrote %d clicks, want the %d queued", len(events), queueSize)
+	}
+	for n, e := range events {
+		if e.LinkID != int64(n) {
+			t.Fatalf("line %d is click %d, want %d", n+1, e.LinkID, n)
+		}
+	}
+}
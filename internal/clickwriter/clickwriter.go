@@ -0,0 +1,158 @@
+// Package clickwriter records clicks within a budget of database writes per second, so a burst of
+// clicks on a popular link doesn't take the database writer away from the rest of the application.
+package clickwriter
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/clicksink"
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/webhook"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// backlogSize bounds the clicks waiting to be written, more are dropped
+	backlogSize = 100_000
+	// writeTimeout bounds writing a deferred click
+	writeTimeout = 5 * time.Second
+	// flushTimeout bounds writing the backlog on shutdown
+	flushTimeout = 10 * time.Second
+)
+
+type pendingClick struct {
+	link  *internal.Link
+	click repo.NewClick
+}
+
+// Writer writes clicks right away while within budget. Above it, clicks go to a backlog that's
+// drained at the budgeted rate. Clicks keep the time they were made at, and the backlog is
+// drained in order, so a link's last click time never goes backwards.
+type Writer struct {
+	clicks   *repo.ClicksRepo
+	webhooks *webhook.Dispatcher
+	// sink gets every click written, counted or not
+	sink clicksink.Sink
+	// limiter is nil without a budget, then every click is written right away
+	limiter *rate.Limiter
+	backlog chan pendingClick
+	// pending counts clicks in the backlog or being drained from it
+	pending atomic.Int64
+}
+
+// New returns a Writer allowing writesPerSecond click writes, zero means no limit.
+func New(clicks *repo.ClicksRepo, webhooks *webhook.Dispatcher, sink clicksink.Sink, writesPerSecond int) *Writer {
+	w := &Writer{
+		clicks:   clicks,
+		webhooks: webhooks,
+		sink:     sink,
+		backlog:  make(chan pendingClick, backlogSize),
+	}
+	if writesPerSecond > 0 {
+		w.limiter = rate.NewLimiter(rate.Limit(writesPerSecond), writesPerSecond)
+	}
+	metrics.ClickWriteBudget.Set(int64(writesPerSecond))
+	return w
+}
+
+// Record writes the click, or queues it when over budget. Only direct writes can fail,
+// internal.ErrLinkNotFound means the link was deleted in the meantime.
+func (w *Writer) Record(ctx context.Context, link *internal.Link, click repo.NewClick) error {
+	// Once clicks are queued, later ones have to queue up behind them
+	if w.limiter == nil || (w.pending.Load() == 0 && w.limiter.Allow()) {
+		return w.write(ctx, link, click)
+	}
+
+	// Counted before it's queued, so it's never drained and uncounted, which would let the count go
+	// below zero or to zero while a click still waits
+	w.pending.Add(1)
+	select {
+	case w.backlog <- pendingClick{link: link, click: click}:
+		metrics.ClickBacklog.Set(w.pending.Load())
+		metrics.ClicksDeferred.Add(1)
+	default:
+		w.pending.Add(-1)
+		metrics.ClicksDropped.Add(1)
+		log.Warn().Int64("link_id", link.ID).Msg("click backlog is full, dropping click")
+	}
+	return nil
+}
+
+// Run drains the backlog at the budgeted rate until ctx is done, then writes what's left.
+func (w *Writer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			w.flush()
+			return
+		case p := <-w.backlog:
+			if err := w.limiter.Wait(ctx); err != nil {
+				// Shutting down, p is written with the rest of the backlog
+				w.writePending(context.Background(), p)
+				w.flush()
+				return
+			}
+			w.writePending(ctx, p)
+		}
+	}
+}
+
+func (w *Writer) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	for w.pending.Load() > 0 && ctx.Err() == nil {
+		select {
+		case p := <-w.backlog:
+			w.writePending(ctx, p)
+		default:
+			return
+		}
+	}
+	if n := w.pending.Load(); n > 0 {
+		log.Warn().Int64("clicks", n).Msg("shutting down with unwritten clicks")
+	}
+}
+
+func (w *Writer) writePending(ctx context.Context, p pendingClick) {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	err := w.write(ctx, p.link, p.click)
+	if errors.Is(err, internal.ErrLinkNotFound) {
+		log.Debug().Int64("link_id", p.link.ID).Msg("link deleted before deferred click was written")
+	} else if err != nil {
+		log.Error().Err(err).Int64("link_id", p.link.ID).Msg("failed to write deferred click")
+	}
+
+	w.pending.Add(-1)
+	metrics.ClickBacklog.Set(w.pending.Load())
+	metrics.ClicksDrained.Add(1)
+}
+
+func (w *Writer) write(ctx context.Context, link *internal.Link, click repo.NewClick) error {
+	if err := w.clicks.Create(ctx, click); err != nil {
+		return err
+	}
+	w.sink.Send(clicksink.Event{
+		Timestamp: click.ClickedAt.UTC(),
+		Slug:      link.Slug,
+		LinkID:    link.ID,
+		IP:        click.IPAddress,
+		UserAgent: click.UserAgent,
+		Referer:   click.Referer,
+		Method:    cmp.Or(click.Method, http.MethodGet),
+	})
+	if click.Counted() {
+		w.webhooks.LinkClicked(link)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	backupPrefix = "linked-"
+	backupSuffix = ".db"
+	// backupTimeFormat sorts by name in time order
+	backupTimeFormat = "20060102T150405Z"
+)
+
+var ErrInsufficientBackupSpace = errors.New("not enough free disk space for a backup")
+
+// Backup writes a consistent copy of the database to path, which must not exist yet.
+// VACUUM INTO reads the database in a single transaction, so writes can go on meanwhile
+// and the copy never contains half of one, unlike copying the database and WAL files.
+func Backup(ctx context.Context, db *sql.DB, path string) error {
+	stats, err := GetStats(ctx, db)
+	if err != nil {
+		return err
+	}
+	free, err := freeSpace(filepath.Dir(path))
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("failed to check free space in %s: %w", filepath.Dir(path), err)
+	}
+	// The copy leaves out free pages
+	if err == nil && free < stats.Size-stats.FreeSize {
+		return fmt.Errorf("%w: %s has %d bytes free, needs %d", ErrInsufficientBackupSpace, filepath.Dir(path), free, stats.Size-stats.FreeSize)
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// BackupName is the file name of a backup taken at t, like linked-20240131T120000Z.db.
+func BackupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeFormat) + backupSuffix
+}
+
+// WriteBackup backs up the database into dir under a timestamped name, then deletes the
+// oldest backups beyond keep. It returns the path of the new backup.
+func WriteBackup(ctx context.Context, db *sql.DB, dir string, keep int) (string, error) {
+	path := filepath.Join(dir, BackupName(time.Now()))
+	// Written under another name first, so a failed backup is never mistaken for one
+	partial := path + ".partial"
+	_ = os.Remove(partial)
+
+	if err := Backup(ctx, db, partial); err != nil {
+		_ = os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		_ = os.Remove(partial)
+		return "", fmt.Errorf("failed to rename backup: %w", err)
+	}
+
+	if err := pruneBackups(dir, keep); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to delete old backup: %w", err)
+		}
+		log.Info().Str("name", name).Msg("deleted old backup")
+	}
+	return nil
+}
+
+// ScheduleBackups writes a backup into dir every interval until ctx is done, keeping the latest keep ones.
+func ScheduleBackups(ctx context.Context, db *sql.DB, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path, err := WriteBackup(ctx, db, dir, keep)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("failed to write scheduled backup")
+		} else if err == nil {
+			log.Info().Str("path", path).Msg("wrote scheduled backup")
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// countRows counts the rows of table.
+func countRows(t *testing.T, sqlDB *sql.DB, table string) int {
+	t.Helper()
+
+	var n int
+	if err := sqlDB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("failed to count %s: %v", table, err)
+	}
+	return n
+}
+
+// openRestored opens the backup at path like a database to run on, and checks it's intact.
+func openRestored(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	restored, err := sql.Open(db.DriverSQLite, "file:"+path+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { restored.Close() })
+
+	var integrity string
+	if err := restored.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil || integrity != "ok" {
+		t.Fatalf("integrity check of the backup: got %q, %v", integrity, err)
+	}
+	// Already at the latest version, so there's nothing to migrate
+	if err := db.Migrate(context.Background(), restored); err != nil {
+		t.Fatalf("failed to migrate the backup: %v", err)
+	}
+	return restored
+}
+
+func TestBackupRestores(t *testing.T) {
+	sqlDB, _ := testutil.NewFileDB(t, "WAL")
+	f := testutil.NewFixturesIn(sqlDB)
+	for range 20 {
+		link := f.Link(t)
+		f.Click(t, link)
+		// Clicks of another month are in a partition of their own
+		f.Click(t, link, func(c *repo.NewClick) { c.ClickedAt = testutil.Epoch.AddDate(0, 1, 0) })
+	}
+	links, clicksBefore := countRows(t, sqlDB, "links"), countRows(t, sqlDB, "clicks")
+
+	// Clicks keep coming in while the backup is taken
+	link := f.Link(t)
+	links++
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := f.Clicks.Create(context.Background(), repo.NewClick{LinkID: link.ID, ClickedAt: f.Clock.Now(), Method: http.MethodGet}); err != nil {
+				t.Errorf("failed to record a click during the backup: %v", err)
+				return
+			}
+		}
+	})
+	path := filepath.Join(t.TempDir(), "backup.db")
+	err := db.Backup(context.Background(), sqlDB, path)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clicksAfter := countRows(t, sqlDB, "clicks")
+
+	restored := openRestored(t, path)
+	if n := countRows(t, restored, "links"); n != links {
+		t.Errorf("restored %d links, want %d", n, links)
+	}
+	if n := countRows(t, restored, "clicks"); n < clicksBefore || n > clicksAfter {
+		t.Errorf("restored %d clicks, want between the %d before and %d after the backup", n, clicksBefore, clicksAfter)
+	}
+
+	// The restored database works like the original
+	got, err := repo.NewLinksRepo(restored, false).GetByID(context.Background(), link.ID)
+	if err != nil || got.Slug != link.Slug {
+		t.Errorf("link in the backup: got %v, %v, want %s", got, err, link.Slug)
+	}
+}
+
+func TestBackupDownloadRestores(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	for range 5 {
+		f.Click(t, f.Link(t))
+	}
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	res, err := client.Get(ts.URL + "/api/admin/backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	path := filepath.Join(t.TempDir(), "download.db")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(out, res.Body); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openRestored(t, path)
+	for _, table := range []string{"links", "clicks", "users"} {
+		if got, want := countRows(t, restored, table), countRows(t, f.DB, table); got != want {
+			t.Errorf("restored %d %s, want %d", got, table, want)
+		}
+	}
+}
+
+func TestWriteBackupPrunes(t *testing.T) {
+	sqlDB, _ := testutil.NewFileDB(t, "WAL")
+	dir := t.TempDir()
+	now := time.Now()
+	// Older backups, and files that aren't backups and are left alone
+	for _, name := range []string{
+		db.BackupName(now.Add(-3 * time.Hour)),
+		db.BackupName(now.Add(-2 * time.Hour)),
+		db.BackupName(now.Add(-time.Hour)),
+		"notes.txt",
+		db.BackupName(now.Add(-4*time.Hour)) + ".partial",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := db.WriteBackup(context.Background(), sqlDB, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openRestored(t, path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{
+		db.BackupName(now.Add(-4*time.Hour)) + ".partial",
+		db.BackupName(now.Add(-time.Hour)),
+		filepath.Base(path),
+		"notes.txt",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
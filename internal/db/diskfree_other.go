@@ -0,0 +1,13 @@
+//go:build !unix
+
+package db
+
+import "errors"
+
+func freeSpace(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func fileSystemID(string) (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package db
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// fileSystemID identifies the file system dir is on, to tell whether two directories share free space.
+func fileSystemID(dir string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(st.Dev), nil
+}
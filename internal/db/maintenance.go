@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// auto_vacuum modes as reported by PRAGMA auto_vacuum
+const (
+	AutoVacuumNone        = 0
+	AutoVacuumFull        = 1
+	AutoVacuumIncremental = 2
+)
+
+var (
+	ErrInsufficientDiskSpace = errors.New("not enough free disk space to vacuum")
+	ErrNotIncremental        = errors.New("database doesn't use auto_vacuum=INCREMENTAL, run a full vacuum once (POST /api/admin/vacuum) to switch it")
+)
+
+// Stats describes the storage of a sqlite database.
+type Stats struct {
+	Path          string `json:"path"`
+	PageSize      int64  `json:"page_size"`
+	PageCount     int64  `json:"page_count"`
+	FreelistCount int64  `json:"freelist_count"`
+	// Size is the size of the database in bytes, FreeSize the part of it held by unused pages
+	Size       int64  `json:"size"`
+	FreeSize   int64  `json:"free_size"`
+	AutoVacuum string `json:"auto_vacuum"`
+}
+
+func GetStats(ctx context.Context, db *sql.DB) (*Stats, error) {
+	var s Stats
+	var autoVacuum int
+	pragmas := []struct {
+		name string
+		dest any
+	}{
+		{"page_size", &s.PageSize},
+		{"page_count", &s.PageCount},
+		{"freelist_count", &s.FreelistCount},
+		{"auto_vacuum", &autoVacuum},
+	}
+	for _, p := range pragmas {
+		if err := db.QueryRowContext(ctx, "PRAGMA "+p.name).Scan(p.dest); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", p.name, err)
+		}
+	}
+
+	path, err := filePath(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Path = path
+	s.Size = s.PageSize * s.PageCount
+	s.FreeSize = s.PageSize * s.FreelistCount
+	s.AutoVacuum = autoVacuumName(autoVacuum)
+	return &s, nil
+}
+
+// Vacuum rebuilds the database to return free pages to the file system and switches it to
+// incremental auto vacuum. It refuses to run when the disk may not fit the temporary copies.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	stats, err := GetStats(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := checkHeadroom(stats); err != nil {
+		return err
+	}
+
+	// The auto_vacuum mode only changes when set on the connection running the vacuum
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum: %w", err)
+	}
+	// Shrink the WAL, which grew by about the size of the database
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+
+	log.Info().
+		Int64("size_before", stats.Size).
+		Int64("freed", stats.FreeSize).
+		Msg("database vacuumed")
+	return nil
+}
+
+// IncrementalVacuum frees up to pages unused pages, it needs auto_vacuum=INCREMENTAL.
+func IncrementalVacuum(ctx context.Context, db *sql.DB, pages int) error {
+	var autoVacuum int
+	if err := db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+	if autoVacuum != AutoVacuumIncremental {
+		return ErrNotIncremental
+	}
+
+	// The pragma returns a row per step, it only completes once they're all read
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", pages))
+	if err != nil {
+		return fmt.Errorf("failed to vacuum incrementally: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// ScheduleIncrementalVacuum frees up to pages unused pages every interval until ctx is done.
+func ScheduleIncrementalVacuum(ctx context.Context, db *sql.DB, interval time.Duration, pages int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := IncrementalVacuum(ctx, db, pages); err != nil {
+			if errors.Is(err, ErrNotIncremental) {
+				log.Warn().Err(err).Msg("scheduled incremental vacuum disabled")
+				return
+			}
+			log.Error().Err(err).Msg("failed to vacuum incrementally")
+		}
+	}
+}
+
+// checkHeadroom makes sure a vacuum can't fill up the disk. It writes a temporary copy of the
+// database, usually in the temp directory, then about as much again to the WAL next to it.
+func checkHeadroom(stats *Stats) error {
+	needed := map[string]int64{}
+	dir := filepath.Dir(stats.Path)
+	for _, d := range []string{dir, os.TempDir()} {
+		id, err := fileSystemID(d)
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				log.Warn().Msg("can't check free disk space on this platform, vacuuming anyway")
+				return nil
+			}
+			return fmt.Errorf("failed to inspect %s: %w", d, err)
+		}
+		needed[id] += stats.Size
+	}
+
+	for _, d := range []string{dir, os.TempDir()} {
+		id, _ := fileSystemID(d)
+		free, err := freeSpace(d)
+		if err != nil {
+			return fmt.Errorf("failed to check free space in %s: %w", d, err)
+		}
+		if free < needed[id] {
+			return fmt.Errorf("%w: %s has %d bytes free, needs %d", ErrInsufficientDiskSpace, d, free, needed[id])
+		}
+	}
+	return nil
+}
+
+func filePath(ctx context.Context, db *sql.DB) (string, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == "main" {
+			return file, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("main database not found")
+}
+
+func autoVacuumName(mode int) string {
+	switch mode {
+	case AutoVacuumNone:
+		return "none"
+	case AutoVacuumFull:
+		return "full"
+	case AutoVacuumIncremental:
+		return "incremental"
+	}
+	return fmt.Sprintf("unknown (%d)", mode)
+}
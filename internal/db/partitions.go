@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnpartitionedClicks is the table of the clicks recorded before they were split up by month.
+// It's emptied into the partitions in the background and dropped once empty.
+const UnpartitionedClicks = "clicks_unpartitioned"
+
+// clickColumns are the columns of every click table, in the order of the clicks view
+const clickColumns = "id, link_id, clicked_at, user_agent, ip_address, referer, country_code, visitor_hash, method"
+
+// ClickPartition is the table of the clicks made in month, like 2024-01 for clicks_202401.
+func ClickPartition(month string) string {
+	return "clicks_" + strings.ReplaceAll(month, "-", "")
+}
+
+// ClickPartitionSchema creates the click table name for driver, with the columns and indexes the
+// clicks table had. Partitions are created when the first click of their month is recorded. IDs
+// are taken from click_ids rather than the table, so they're unique across partitions.
+func ClickPartitionSchema(driver, name string) string {
+	schema := `
+	CREATE TABLE IF NOT EXISTS %[1]s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		link_id INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+		clicked_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_agent TEXT,
+		ip_address TEXT,
+		referer TEXT,
+		country_code TEXT,
+		visitor_hash TEXT,
+		method TEXT NOT NULL DEFAULT 'GET'
+	);`
+	if driver == DriverPostgres {
+		schema = `
+	CREATE TABLE IF NOT EXISTS %[1]s (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		link_id BIGINT NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+		clicked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		user_agent TEXT,
+		ip_address TEXT,
+		referer TEXT,
+		country_code TEXT,
+		visitor_hash TEXT,
+		method TEXT NOT NULL DEFAULT 'GET'
+	);`
+	}
+	return fmt.Sprintf(schema+`
+	CREATE INDEX IF NOT EXISTS idx_%[1]s_link_id ON %[1]s(link_id);
+	CREATE INDEX IF NOT EXISTS idx_%[1]s_clicked_at ON %[1]s(clicked_at);
+	`, name)
+}
+
+// ClickUnion selects the clicks of all of tables, which must not be empty.
+func ClickUnion(tables []string) string {
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT " + clickColumns + " FROM " + table
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// ClickViewSchema replaces the clicks view with one of tables, which must not be empty. Both
+// dialects take it, CREATE OR REPLACE VIEW is postgres only.
+func ClickViewSchema(tables []string) string {
+	return "DROP VIEW IF EXISTS clicks; CREATE VIEW clicks AS " + ClickUnion(tables) + ";"
+}
@@ -0,0 +1,96 @@
+package destpolicy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrDisallowed is wrapped by the errors of Check, which name the offending host.
+var ErrDisallowed = errors.New("destination is not allowed")
+
+// Policy decides which hosts links may point to. A host matching the blocklist is refused,
+// otherwise with an allowlist it must match the allowlist. Patterns are domains like example.com,
+// or like *.example.com to match its subdomains but not example.com itself.
+type Policy struct {
+	allow []string
+	block []string
+}
+
+// New validates and normalizes the patterns of a policy, empty lists don't restrict anything.
+func New(allow, block []string) (*Policy, error) {
+	p := &Policy{}
+	var err error
+	if p.allow, err = normalizePatterns(allow); err != nil {
+		return nil, err
+	}
+	if p.block, err = normalizePatterns(block); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func normalizePatterns(patterns []string) ([]string, error) {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		p := normalizeHost(pattern)
+		domain := strings.TrimPrefix(p, "*.")
+		if domain == "" || strings.ContainsAny(domain, "*/:@ ") {
+			return nil, fmt.Errorf("invalid domain pattern %q, expected one like example.com or *.example.com", pattern)
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// Allowlist returns the patterns a host must match when there are any.
+func (p *Policy) Allowlist() []string {
+	return slices.Clone(p.allow)
+}
+
+// Blocklist returns the patterns a host must not match.
+func (p *Policy) Blocklist() []string {
+	return slices.Clone(p.block)
+}
+
+// Check returns an error naming the host of rawURL when links may not point to it.
+// URLs without a host, like mailto:, are only refused by an allowlist.
+func (p *Policy) Check(rawURL string) error {
+	if len(p.allow) == 0 && len(p.block) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url", ErrDisallowed)
+	}
+	host := normalizeHost(u.Hostname())
+
+	if host != "" && matchesAny(host, p.block) {
+		return fmt.Errorf("%w: host %s is blocked", ErrDisallowed, host)
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	if host == "" {
+		return fmt.Errorf("%w: url has no host to check against the allowlist", ErrDisallowed)
+	}
+	if !matchesAny(host, p.allow) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", ErrDisallowed, host)
+	}
+	return nil
+}
+
+func matchesAny(host string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			return strings.HasSuffix(host, "."+domain)
+		}
+		return host == pattern
+	})
+}
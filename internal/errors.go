@@ -1,7 +1,68 @@
 package internal
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var ErrSlugExists = errors.New("slug already exists")
 var ErrLinkNotFound = errors.New("link not found")
+var ErrSettingNotFound = errors.New("setting not found")
 
+// ErrDatabaseBusy is wrapped by errors of statements that kept failing because another connection held a lock.
+var ErrDatabaseBusy = errors.New("database is temporarily unavailable")
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrUsernameTaken = errors.New("username already taken")
+var ErrLastAdmin = errors.New("the last admin can't be removed")
+
+var ErrCampaignNotFound = errors.New("campaign not found")
+var ErrCampaignNameTaken = errors.New("campaign name already taken")
+
+// FieldError tells why a field of a request is invalid. Field is its JSON name, with the index
+// for the items of a list like rules[0].
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request.
+type ValidationError []FieldError
+
+// NewValidationError reports a single invalid field.
+func NewValidationError(field, message string) ValidationError {
+	return ValidationError{{Field: field, Message: message}}
+}
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records err as the reason field is invalid, when there's one. A ValidationError of the items
+// of field, like those of a list, is added as it is.
+func (e *ValidationError) Add(field string, err error) {
+	var itemErrs ValidationError
+	if errors.As(err, &itemErrs) {
+		*e = append(*e, itemErrs...)
+	} else if err != nil {
+		*e = append(*e, FieldError{Field: field, Message: err.Error()})
+	}
+}
+
+// Err returns the ValidationError, or nil when no field is invalid.
+func (e ValidationError) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
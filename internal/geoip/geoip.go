@@ -0,0 +1,66 @@
+// Package geoip resolves client IPs to countries.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Resolver looks up the country of an IP address.
+type Resolver interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country ip is located in,
+	// or an empty string when it can't be resolved.
+	Country(ip string) string
+}
+
+// Nop resolves nothing, it's used when no GeoIP database is configured.
+type Nop struct{}
+
+func (Nop) Country(string) string { return "" }
+
+// MaxMind resolves countries from a MaxMind-format database such as GeoLite2 Country.
+type MaxMind struct {
+	reader *maxminddb.Reader
+}
+
+// Open memory-maps the mmdb file at path.
+func Open(path string) (*MaxMind, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geoip database: %w", err)
+	}
+	return &MaxMind{reader: reader}, nil
+}
+
+func (m *MaxMind) Country(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := m.reader.Lookup(parsed, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Check looks up a well-known address, which fails when the database file is unreadable.
+func (m *MaxMind) Check(context.Context) error {
+	var record any
+	if err := m.reader.Lookup(net.IPv4(1, 1, 1, 1), &record); err != nil {
+		return fmt.Errorf("geoip lookup failed: %w", err)
+	}
+	return nil
+}
+
+func (m *MaxMind) Close() error {
+	return m.reader.Close()
+}
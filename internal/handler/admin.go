@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminHandler exposes maintenance of the sqlite database.
+type AdminHandler struct {
+	db *sql.DB
+}
+
+func NewAdminHandler(dbInstance *sql.DB) *AdminHandler {
+	return &AdminHandler{db: dbInstance}
+}
+
+// DBStats handles GET /api/admin/db-stats
+func (h *AdminHandler) DBStats(c echo.Context) error {
+	stats, err := db.GetStats(c.Request().Context(), h.db)
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to get database stats")
+		return err
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// Vacuum handles POST /api/admin/vacuum - rebuilds the database file and returns the new stats
+func (h *AdminHandler) Vacuum(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := db.Vacuum(ctx, h.db); err != nil {
+		if errors.Is(err, db.ErrInsufficientDiskSpace) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to vacuum database")
+		return err
+	}
+
+	return h.DBStats(c)
+}
+
+// Backup handles GET /api/admin/backup - downloads a consistent copy of the database,
+// taken while writes go on
+func (h *AdminHandler) Backup(c echo.Context) error {
+	dir, err := os.MkdirTemp("", "linked-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	if err := db.Backup(c.Request().Context(), h.db, path); err != nil {
+		if errors.Is(err, db.ErrInsufficientBackupSpace) {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to back up database")
+		return err
+	}
+
+	return c.Attachment(path, db.BackupName(time.Now()))
+}
@@ -1,33 +1,44 @@
 package handler
 
 import (
-	"embed"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/assets"
 	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	defaultLoginAttemptsLimit = 100
+	maxLoginAttemptsLimit     = 1000
+)
+
 type AuthHandler struct {
 	auther   *auth.Authenticator
-	staticFS embed.FS
+	attempts *repo.LoginAttemptsRepo
+	assets   *assets.Assets
 }
 
-func NewAuthHandler(auther *auth.Authenticator, staticFS embed.FS) *AuthHandler {
+func NewAuthHandler(auther *auth.Authenticator, attempts *repo.LoginAttemptsRepo, assets *assets.Assets) *AuthHandler {
 	return &AuthHandler{
 		auther:   auther,
-		staticFS: staticFS,
+		attempts: attempts,
+		assets:   assets,
 	}
 }
 
+type ListLoginAttemptsResponse struct {
+	Attempts []*internal.LoginAttempt `json:"attempts"`
+}
+
 func (h *AuthHandler) ServeLoginPage(c echo.Context) error {
-	data, err := h.staticFS.ReadFile("login.html")
-	if err != nil {
-		return fmt.Errorf("failed to read login.html: %w", err)
-	}
-	return c.HTMLBlob(http.StatusOK, data)
+	return h.assets.ServePage(c, "login.html")
 }
 
 func (h *AuthHandler) Login(c echo.Context) error {
@@ -36,23 +47,59 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 	}
 
-	cookie, err := h.auther.Authenticate(creds)
-	if err != nil {
-		if errors.Is(err, auth.ErrUnauthorized) {
+	if err := h.auther.Authenticate(c, creds); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnauthorized):
 			return echo.ErrUnauthorized
+		case errors.Is(err, auth.ErrLockedOut):
+			return h.auther.LockedOut(c)
 		}
 		return err
 	}
-	cookie.Secure = c.IsTLS()
-
-	c.SetCookie(cookie)
 
 	return c.NoContent(http.StatusNoContent)
 }
 
 // Logout handles GET /logout - clears the JWT cookie and redirects to /
 func (h *AuthHandler) Logout(c echo.Context) error {
-	expiredCookie := auth.ExpireCookie()
-	c.SetCookie(expiredCookie)
+	h.auther.ClearCookie(c)
 	return c.Redirect(http.StatusFound, "/")
 }
+
+// RevokeAll handles POST /api/auth/revoke-all - invalidates every issued token, including the caller's
+func (h *AuthHandler) RevokeAll(c echo.Context) error {
+	if err := h.auther.RevokeAll(c.Request().Context()); err != nil {
+		return err
+	}
+	h.auther.ClearCookie(c)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListLoginAttempts handles GET /api/admin/login-attempts?ip=&username=&window=24h&limit=100 - failed logins, newest first
+func (h *AuthHandler) ListLoginAttempts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	since, err := parseWindow(c.QueryParam("window"))
+	if err != nil {
+		return internal.NewValidationError("window", err.Error())
+	}
+	limit := defaultLoginAttemptsLimit
+	if s := c.QueryParam("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxLoginAttemptsLimit {
+			return internal.NewValidationError("limit", fmt.Sprintf("limit must be between 1 and %d", maxLoginAttemptsLimit))
+		}
+	}
+
+	attempts, err := h.attempts.List(ctx, repo.LoginAttemptFilter{
+		IPAddress: c.QueryParam("ip"),
+		Username:  c.QueryParam("username"),
+		Since:     since,
+		Limit:     limit,
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to list login attempts")
+		return err
+	}
+	return c.JSON(http.StatusOK, ListLoginAttemptsResponse{Attempts: attempts})
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/assets"
+	"github.com/abdusco/linked/internal/branding"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// visitorPage is the data of templates shown to visitors: the page itself and the instance branding.
+type visitorPage struct {
+	Brand branding.Branding
+	Page  any
+}
+
+// renderVisitorPage renders a visitor-facing template with the saved branding.
+// The page falls back to the default branding when it can't be loaded.
+func renderVisitorPage(ctx context.Context, a *assets.Assets, store *branding.Store, name string, page any) ([]byte, error) {
+	brand, err := store.Load(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to load branding")
+	}
+	return a.Render(name, visitorPage{Brand: brand.WithDefaults(), Page: page})
+}
+
+// BrandingHandler manages the branding of visitor-facing pages.
+type BrandingHandler struct {
+	store  *branding.Store
+	assets *assets.Assets
+}
+
+func NewBrandingHandler(store *branding.Store, assets *assets.Assets) *BrandingHandler {
+	return &BrandingHandler{store: store, assets: assets}
+}
+
+// GetBranding handles GET /api/admin/branding
+func (h *BrandingHandler) GetBranding(c echo.Context) error {
+	b, err := h.store.Load(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to load branding")
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// UpdateBranding handles PUT /api/admin/branding - replaces all fields, empty ones use the defaults
+func (h *BrandingHandler) UpdateBranding(c echo.Context) error {
+	var b branding.Branding
+	if err := c.Bind(&b); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	if err := h.store.Save(c.Request().Context(), b); err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to save branding")
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// UploadLogo handles PUT /api/admin/branding/logo with the image in the "logo" form field
+func (h *BrandingHandler) UploadLogo(c echo.Context) error {
+	header, err := c.FormFile("logo")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "logo file is required")
+	}
+	if header.Size > branding.MaxLogoSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("logo must be at most %d KB", branding.MaxLogoSize>>10))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read logo")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, branding.MaxLogoSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read logo")
+	}
+
+	b, err := h.store.SaveLogo(c.Request().Context(), data)
+	if err != nil {
+		if errors.Is(err, branding.ErrInvalidLogo) || len(data) > branding.MaxLogoSize {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to save logo")
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// DeleteLogo handles DELETE /api/admin/branding/logo
+func (h *BrandingHandler) DeleteLogo(c echo.Context) error {
+	b, err := h.store.DeleteLogo(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to delete logo")
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// ServeLogo handles GET /branding/logo
+func (h *BrandingHandler) ServeLogo(c echo.Context) error {
+	logo, err := h.store.Logo(c.Request().Context())
+	if err != nil {
+		if errors.Is(err, internal.ErrUploadNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "no logo uploaded")
+		}
+		return err
+	}
+
+	cacheControl := "public, max-age=300"
+	// The versioned URL changes whenever another logo is uploaded
+	if c.QueryParam("v") != "" {
+		cacheControl = "public, max-age=31536000, immutable"
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, cacheControl)
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, logo.ContentType, logo.Data)
+}
+
+// Preview handles GET /api/admin/branding/preview - renders the link preview page with the saved
+// branding and any fields given as query params on top, without saving them
+func (h *BrandingHandler) Preview(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var unsaved branding.Branding
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &unsaved); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid branding")
+	}
+	if err := unsaved.Validate(); err != nil {
+		return err
+	}
+
+	saved, err := h.store.Load(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to load branding")
+		return err
+	}
+
+	data, err := h.assets.Render("preview.html", visitorPage{
+		Brand: saved.Merge(unsaved).WithDefaults(),
+		Page: previewPage{
+			URL:         "https://example.com/some/long/destination",
+			Host:        "example.com",
+			ContinueURL: "#",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTMLBlob(http.StatusOK, data)
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/utm"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultCampaignStatsDays = 30
+	maxCampaignStatsDays     = 365
+)
+
+type CampaignHandler struct {
+	campaignsRepo *repo.CampaignsRepo
+	linksRepo     *repo.LinksRepo
+	clicksRepo    *repo.ClicksRepo
+}
+
+func NewCampaignHandler(campaignsRepo *repo.CampaignsRepo, linksRepo *repo.LinksRepo, clicksRepo *repo.ClicksRepo) *CampaignHandler {
+	return &CampaignHandler{campaignsRepo: campaignsRepo, linksRepo: linksRepo, clicksRepo: clicksRepo}
+}
+
+type CampaignRequest struct {
+	Name string `json:"name"`
+	// UTM are the UTM parameters the destinations of its links should have. Left out on update, they
+	// stay as they are, and an empty object removes them.
+	UTM map[string]string `json:"utm"`
+}
+
+func (r *CampaignRequest) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return internal.NewValidationError("name", "name is required")
+	}
+	const maxNameLength = 100
+	if utf8.RuneCountInString(r.Name) > maxNameLength {
+		return internal.NewValidationError("name", fmt.Sprintf("name must be at most %d characters long", maxNameLength))
+	}
+	if err := utm.ValidateMapping(r.UTM); err != nil {
+		return internal.NewValidationError("utm", err.Error())
+	}
+	return nil
+}
+
+type ListCampaignsResponse struct {
+	Campaigns []*internal.Campaign `json:"campaigns"`
+}
+
+// CreateCampaign handles POST /api/campaigns
+func (h *CampaignHandler) CreateCampaign(c echo.Context) error {
+	var req CampaignRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	campaign, err := h.campaignsRepo.Create(c.Request().Context(), repo.CampaignParams{Name: req.Name, UTM: req.UTM})
+	if err != nil {
+		if errors.Is(err, internal.ErrCampaignNameTaken) {
+			return internal.ErrCampaignNameTaken
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Str("name", req.Name).Msg("failed to create campaign")
+		return err
+	}
+	return c.JSON(http.StatusCreated, campaign)
+}
+
+// ListCampaigns handles GET /api/campaigns
+func (h *CampaignHandler) ListCampaigns(c echo.Context) error {
+	campaigns, err := h.campaignsRepo.List(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to list campaigns")
+		return err
+	}
+	return c.JSON(http.StatusOK, ListCampaignsResponse{Campaigns: campaigns})
+}
+
+// GetCampaign handles GET /api/campaigns/:id
+func (h *CampaignHandler) GetCampaign(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid campaign id")
+	}
+
+	campaign, err := h.campaignsRepo.Get(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, campaign)
+}
+
+// UpdateCampaign handles PUT /api/campaigns/:id - renames the campaign and changes the UTM parameters it expects
+func (h *CampaignHandler) UpdateCampaign(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid campaign id")
+	}
+
+	var req CampaignRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	campaign, err := h.campaignsRepo.Update(c.Request().Context(), id, repo.CampaignParams{Name: req.Name, UTM: req.UTM})
+	if err != nil {
+		if errors.Is(err, internal.ErrCampaignNotFound) || errors.Is(err, internal.ErrCampaignNameTaken) {
+			return err
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to update campaign")
+		return err
+	}
+	return c.JSON(http.StatusOK, campaign)
+}
+
+// DeleteCampaign handles DELETE /api/campaigns/:id - its links stay, without a campaign
+func (h *CampaignHandler) DeleteCampaign(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid campaign id")
+	}
+
+	if err := h.campaignsRepo.Delete(c.Request().Context(), id); err != nil {
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return internal.ErrCampaignNotFound
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to delete campaign")
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CampaignStats handles GET /api/campaigns/:id/stats?days=30 - the clicks of all its links
+func (h *CampaignHandler) CampaignStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid campaign id")
+	}
+
+	days := defaultCampaignStatsDays
+	if s := c.QueryParam("days"); s != "" {
+		days, err = strconv.Atoi(s)
+		if err != nil || days < 1 || days > maxCampaignStatsDays {
+			return internal.NewValidationError("days", fmt.Sprintf("days must be between 1 and %d", maxCampaignStatsDays))
+		}
+	}
+
+	if _, err := h.campaignsRepo.Get(ctx, id); err != nil {
+		return err
+	}
+
+	stats, err := h.clicksRepo.GetCampaignStats(ctx, id, days)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to get campaign stats")
+		return err
+	}
+	return c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+type CloneLinkRequest struct {
+	// Slug of the clone, generated when empty
+	Slug string `json:"slug"`
+	// Tags replace those of the original when given
+	Tags []string `json:"tags"`
+}
+
+// CloneLink handles POST /api/links/:id/clone - creates a link with the destination, tags, description,
+// schedule and the other settings of an existing one. The clone starts without clicks.
+func (h *LinkHandler) CloneLink(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	var req CloneLinkRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if req.Slug != "" {
+		if err := h.validator.ValidateSlug(req.Slug); err != nil {
+			return internal.NewValidationError("slug", err.Error())
+		}
+	}
+
+	source, err := h.linksRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	tags := source.Tags
+	if req.Tags != nil {
+		if tags, err = normalizeTags(req.Tags); err != nil {
+			return internal.NewValidationError("tags", err.Error())
+		}
+	}
+	// The policy may have changed since the original was created
+	for _, dest := range linkDestinations(source.URL, source.Rules) {
+		if err := h.destinations.Check(dest); err != nil {
+			return newAPIError(http.StatusUnprocessableEntity, CodeDestinationDisallowed, err.Error())
+		}
+	}
+	// So may the UTM parameters of the campaign
+	warnings, err := h.checkUTM(ctx, source.CampaignID, source.URL, source.Rules)
+	if err != nil {
+		return err
+	}
+
+	params := repo.NewLink{
+		Slug:        req.Slug,
+		Domain:      source.Domain,
+		URL:         source.URL,
+		Title:       source.Title,
+		Description: source.Description,
+		Snapshot:    source.Snapshot && h.snapshotter != nil,
+		Preview:     source.Preview,
+		Listed:      source.Listed,
+		Tags:        tags,
+		OpenGraph:   source.OpenGraph,
+		ActivatesAt: source.ActivatesAt,
+		ExpiresAt:   source.ExpiresAt,
+		OwnerID:     ownerID(c),
+		Rules:       source.Rules,
+		CampaignID:  source.CampaignID,
+	}
+	var link *internal.Link
+	for attempt := 1; attempt <= regenerateSlugAttempts; attempt++ {
+		if req.Slug == "" {
+			if params.Slug, err = h.slugGen.Next(ctx); err != nil {
+				break
+			}
+		}
+		link, err = h.linksRepo.Create(ctx, params)
+		if req.Slug != "" || !errors.Is(err, internal.ErrSlugExists) || attempt == regenerateSlugAttempts {
+			break
+		}
+		metrics.SlugRetries.Add(1)
+	}
+	if err != nil {
+		if errors.Is(err, internal.ErrSlugExists) {
+			return internal.ErrSlugExists
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to clone link")
+		return err
+	}
+
+	if link.Snapshot {
+		h.snapshotter.TakeAsync(link)
+	}
+	h.webhooks.LinkCreated(link)
+
+	return c.JSON(http.StatusCreated, CreateLinkResponse{Link: newLinkResponseFor(c, link), Warning: strings.Join(warnings, "; ")})
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// maxCSPReportSize bounds a violation report, real ones are a few hundred bytes
+const maxCSPReportSize = 16 << 10
+
+// CollectCSPReport handles POST /csp-report - logs the Content-Security-Policy violations browsers report,
+// to tell what a policy would break
+func CollectCSPReport(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxCSPReportSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid report")
+	}
+	if len(body) > maxCSPReportSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "report too large")
+	}
+	if !json.Valid(body) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid report")
+	}
+
+	logger.FromContext(c.Request().Context()).Warn().
+		RawJSON("report", body).
+		Str("user_agent", c.Request().UserAgent()).
+		Msg("content security policy violation")
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,27 +1,20 @@
 package handler
 
 import (
-	"embed"
-	"fmt"
-	"net/http"
-
+	"github.com/abdusco/linked/internal/assets"
 	"github.com/labstack/echo/v4"
 )
 
 type DashboardHandler struct {
-	staticFS embed.FS
+	assets *assets.Assets
 }
 
-func NewDashboardHandler(staticFS embed.FS) *DashboardHandler {
+func NewDashboardHandler(assets *assets.Assets) *DashboardHandler {
 	return &DashboardHandler{
-		staticFS: staticFS,
+		assets: assets,
 	}
 }
 
 func (h *DashboardHandler) ServeDashboardPage(c echo.Context) error {
-	data, err := h.staticFS.ReadFile("index.html")
-	if err != nil {
-		return fmt.Errorf("failed to read index.html: %w", err)
-	}
-	return c.HTMLBlob(http.StatusOK, data)
+	return h.assets.ServePage(c, "index.html")
 }
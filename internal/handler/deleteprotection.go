@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// DeleteProtection asks for a second DELETE before a link with a lot of clicks goes, so a slip in the
+// dashboard doesn't take its history along.
+type DeleteProtection struct {
+	// MinClicks is the click count a link must exceed to need a confirmation, zero disables the protection
+	MinClicks int64
+	// AllowForce lets ?force=true skip the confirmation, for scripts
+	AllowForce bool
+	Confirmer  *auth.Confirmer
+}
+
+// deleteConfirmationResponse answers the first DELETE of a protected link, which is repeated
+// with ?confirm=<confirm_token> to go through.
+type deleteConfirmationResponse struct {
+	ErrorResponse
+	ConfirmToken string    `json:"confirm_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Clicks       int64     `json:"clicks"`
+}
+
+// confirmDelete reports whether link can be deleted. When it can't, it has answered the request already.
+func (h *LinkHandler) confirmDelete(c echo.Context, link *internal.Link) (bool, error) {
+	p := h.deleteProtection
+	if p.MinClicks == 0 {
+		return true, nil
+	}
+	ctx := c.Request().Context()
+	if force, _ := strconv.ParseBool(c.QueryParam("force")); force && p.AllowForce {
+		logger.FromContext(ctx).Info().Int64("id", link.ID).Msg("forced deletion of link")
+		return true, nil
+	}
+
+	stats, err := h.clicksRepo.GetStatsForLink(ctx, link.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count clicks: %w", err)
+	}
+	if stats.Clicks <= p.MinClicks {
+		return true, nil
+	}
+
+	subject := fmt.Sprintf("delete-link:%d", link.ID)
+	message := fmt.Sprintf("link has %d clicks, repeat the request with confirm_token as ?confirm= to delete it", stats.Clicks)
+	if token := c.QueryParam("confirm"); token != "" {
+		err := p.Confirmer.Verify(subject, token)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, auth.ErrConfirmationExpired):
+			message = "confirmation expired, " + message
+		default:
+			return false, newAPIError(http.StatusBadRequest, CodeInvalidConfirmation, "invalid confirmation token")
+		}
+	}
+
+	token, expiresAt := p.Confirmer.Token(subject)
+	return false, c.JSON(http.StatusConflict, deleteConfirmationResponse{
+		ErrorResponse: ErrorResponse{Code: CodeConfirmationRequired, Error: message},
+		ConfirmToken:  token,
+		ExpiresAt:     expiresAt,
+		Clicks:        stats.Clicks,
+	})
+}
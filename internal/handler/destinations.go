@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type DestinationPolicyResponse struct {
+	// Allowlist is empty when links may point to any host that isn't blocked
+	Allowlist []string `json:"allowlist"`
+	Blocklist []string `json:"blocklist"`
+}
+
+// DestinationPolicy handles GET /api/admin/destination-policy - the hosts links may point to
+func (h *LinkHandler) DestinationPolicy(c echo.Context) error {
+	return c.JSON(http.StatusOK, DestinationPolicyResponse{
+		Allowlist: h.destinations.Allowlist(),
+		Blocklist: h.destinations.Blocklist(),
+	})
+}
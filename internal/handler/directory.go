@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/assets"
+	"github.com/abdusco/linked/internal/branding"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const directoryPageSize = 50
+
+// DirectoryHandler serves the public list of links that opted in with the listed flag.
+type DirectoryHandler struct {
+	linksRepo *repo.LinksRepo
+	assets    *assets.Assets
+	branding  *branding.Store
+}
+
+func NewDirectoryHandler(linksRepo *repo.LinksRepo, assets *assets.Assets, brand *branding.Store) *DirectoryHandler {
+	return &DirectoryHandler{
+		linksRepo: linksRepo,
+		assets:    assets,
+		branding:  brand,
+	}
+}
+
+// DirectoryLink exposes only what's meant to be public about a listed link.
+type DirectoryLink struct {
+	Slug     string `json:"slug"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Host     string `json:"host"`
+	ShortURL string `json:"short_url"`
+}
+
+type DirectoryResponse struct {
+	Links   []DirectoryLink `json:"links"`
+	Page    int             `json:"page"`
+	HasMore bool            `json:"has_more"`
+}
+
+// ListLinks handles GET /api/public/links?page=1
+func (h *DirectoryHandler) ListLinks(c echo.Context) error {
+	resp, err := h.loadPage(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+type directoryPage struct {
+	DirectoryResponse
+	PrevPage int
+	NextPage int
+}
+
+// ServeDirectoryPage handles GET /links?page=1
+func (h *DirectoryHandler) ServeDirectoryPage(c echo.Context) error {
+	resp, err := h.loadPage(c)
+	if err != nil {
+		return err
+	}
+
+	page := directoryPage{DirectoryResponse: *resp}
+	if resp.Page > 1 {
+		page.PrevPage = resp.Page - 1
+	}
+	if resp.HasMore {
+		page.NextPage = resp.Page + 1
+	}
+
+	data, err := renderVisitorPage(c.Request().Context(), h.assets, h.branding, "directory.html", page)
+	if err != nil {
+		return err
+	}
+	return c.HTMLBlob(http.StatusOK, data)
+}
+
+func (h *DirectoryHandler) loadPage(c echo.Context) (*DirectoryResponse, error) {
+	page := 1
+	if s := c.QueryParam("page"); s != "" {
+		var err error
+		page, err = strconv.Atoi(s)
+		if err != nil || page < 1 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid page")
+		}
+	}
+
+	// Fetch one extra row to find out whether there's a next page
+	links, err := h.linksRepo.ListListed(c.Request().Context(), directoryPageSize+1, (page-1)*directoryPageSize)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := len(links) > directoryPageSize
+	links = links[:min(len(links), directoryPageSize)]
+
+	origin := getOrigin(c.Request())
+	return &DirectoryResponse{
+		Links: lo.Map(links, func(link *internal.Link, _ int) DirectoryLink {
+			return newDirectoryLink(origin, link)
+		}),
+		Page:    page,
+		HasMore: hasMore,
+	}, nil
+}
+
+func newDirectoryLink(origin string, link *internal.Link) DirectoryLink {
+	host := link.URL
+	if u, err := url.Parse(link.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return DirectoryLink{
+		Slug:     link.Slug,
+		Title:    cmp.Or(link.Title, link.Slug),
+		URL:      link.URL,
+		Host:     host,
+		ShortURL: shortURL(origin, link),
+	}
+}
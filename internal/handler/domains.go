@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+)
+
+// normalizeDomain lowercases the domain of a link and checks it's one of the domains of the instance,
+// an empty domain is that of links on any domain.
+func normalizeDomain(domain string, domains []string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" || slices.Contains(domains, domain) {
+		return domain, nil
+	}
+	if len(domains) == 0 {
+		return "", fmt.Errorf("domain %q is not allowed, this instance has no domains", domain)
+	}
+	return "", fmt.Errorf("domain %q is not allowed, must be one of %s", domain, strings.Join(domains, ", "))
+}
+
+// requestHost is the host that received r, without the port, which picks the links on its domain.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// linkOrigin is the origin of the short links of link, its domain when it has one or else the origin of r.
+func linkOrigin(origin string, link *internal.Link) string {
+	if link.Domain == "" {
+		return origin
+	}
+	scheme, _, _ := strings.Cut(origin, "://")
+	return scheme + "://" + link.Domain
+}
+
+// shortURL is the short link of link, on its domain when it has one.
+func shortURL(origin string, link *internal.Link) string {
+	return linkOrigin(origin, link) + "/" + url.PathEscape(link.Slug)
+}
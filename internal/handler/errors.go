@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorCode tells errors of the API apart for clients. Unlike messages, codes don't change.
+type ErrorCode string
+
+const (
+	CodeBadRequest            ErrorCode = "bad_request"
+	CodeValidationFailed      ErrorCode = "validation_failed"
+	CodeUnauthorized          ErrorCode = "unauthorized"
+	CodeForbidden             ErrorCode = "forbidden"
+	CodeNotFound              ErrorCode = "not_found"
+	CodeLinkNotFound          ErrorCode = "link_not_found"
+	CodeCampaignNotFound      ErrorCode = "campaign_not_found"
+	CodeUserNotFound          ErrorCode = "user_not_found"
+	CodeWebhookNotFound       ErrorCode = "webhook_not_found"
+	CodeSnapshotNotFound      ErrorCode = "snapshot_not_found"
+	CodeUploadNotFound        ErrorCode = "upload_not_found"
+	CodeMethodNotAllowed      ErrorCode = "method_not_allowed"
+	CodeConflict              ErrorCode = "conflict"
+	CodeSlugConflict          ErrorCode = "slug_conflict"
+	CodeCampaignNameTaken     ErrorCode = "campaign_name_taken"
+	CodeUsernameTaken         ErrorCode = "username_taken"
+	CodeLastAdmin             ErrorCode = "last_admin"
+	CodeConfirmationRequired  ErrorCode = "confirmation_required"
+	CodeInvalidConfirmation   ErrorCode = "invalid_confirmation"
+	CodePayloadTooLarge       ErrorCode = "payload_too_large"
+	CodeUnsupportedMedia      ErrorCode = "unsupported_media_type"
+	CodeUnprocessable         ErrorCode = "unprocessable"
+	CodeVerificationFailed    ErrorCode = "verification_failed"
+	CodeDestinationDisallowed ErrorCode = "destination_disallowed"
+	CodeUTMMismatch           ErrorCode = "utm_mismatch"
+	CodeRateLimited           ErrorCode = "rate_limited"
+	CodeInternal              ErrorCode = "internal_error"
+	CodeUnavailable           ErrorCode = "unavailable"
+	CodeDatabaseBusy          ErrorCode = "database_busy"
+	CodeRedirectLoop          ErrorCode = "redirect_loop"
+)
+
+// statusCodes are the codes of errors that only have a status, like those of echo.NewHTTPError.
+var statusCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMedia,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusLoopDetected:          CodeRedirectLoop,
+}
+
+// sentinelErrors are the errors of the repos handlers return as they are, with the status and code they're answered with.
+var sentinelErrors = []struct {
+	err    error
+	status int
+	code   ErrorCode
+}{
+	{internal.ErrSlugExists, http.StatusConflict, CodeSlugConflict},
+	{internal.ErrLinkNotFound, http.StatusNotFound, CodeLinkNotFound},
+	{internal.ErrCampaignNotFound, http.StatusNotFound, CodeCampaignNotFound},
+	{internal.ErrCampaignNameTaken, http.StatusConflict, CodeCampaignNameTaken},
+	{internal.ErrUserNotFound, http.StatusNotFound, CodeUserNotFound},
+	{internal.ErrUsernameTaken, http.StatusConflict, CodeUsernameTaken},
+	{internal.ErrLastAdmin, http.StatusConflict, CodeLastAdmin},
+	{internal.ErrWebhookNotFound, http.StatusNotFound, CodeWebhookNotFound},
+	{internal.ErrSnapshotNotFound, http.StatusNotFound, CodeSnapshotNotFound},
+	{internal.ErrUploadNotFound, http.StatusNotFound, CodeUploadNotFound},
+	// The lock is usually held for a moment by a burst of writes, clients can try again soon
+	{internal.ErrDatabaseBusy, http.StatusServiceUnavailable, CodeDatabaseBusy},
+}
+
+// ErrorResponse is the body of the errors of the API.
+type ErrorResponse struct {
+	Code  ErrorCode `json:"code"`
+	Error string    `json:"error"`
+	// Details tells which fields of the request are invalid, with validation_failed
+	Details []internal.FieldError `json:"details,omitempty"`
+}
+
+// APIError is an error answered with a code of its own, rather than the one of its status.
+type APIError struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+}
+
+func newAPIError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ResolveError returns the status and body the API answers err with. Errors it doesn't know
+// are internal errors, their message isn't shown.
+func ResolveError(err error) (int, ErrorResponse) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status, ErrorResponse{Code: apiErr.Code, Error: apiErr.Message}
+	}
+	var validationErr internal.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusBadRequest, ErrorResponse{Code: CodeValidationFailed, Error: validationErr.Error(), Details: validationErr}
+	}
+	for _, sentinel := range sentinelErrors {
+		if errors.Is(err, sentinel.err) {
+			// The sentinel rather than the error wrapping it, which may tell more than clients should know
+			return sentinel.status, ErrorResponse{Code: sentinel.code, Error: sentinel.err.Error()}
+		}
+	}
+
+	status, message := http.StatusInternalServerError, "internal server error"
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		if msg, ok := httpErr.Message.(string); ok {
+			message = msg
+		} else {
+			message = strings.ToLower(http.StatusText(status))
+		}
+	}
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+	return status, ErrorResponse{Code: code, Error: message}
+}
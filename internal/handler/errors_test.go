@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/labstack/echo/v4"
+)
+
+func TestResolveError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       ErrorResponse
+	}{
+		{
+			name:       "sentinel",
+			err:        internal.ErrLinkNotFound,
+			wantStatus: http.StatusNotFound,
+			want:       ErrorResponse{Code: CodeLinkNotFound, Error: internal.ErrLinkNotFound.Error()},
+		},
+		{
+			// Only the sentinel is shown, not what the repo wrapped it with
+			name:       "wrapped sentinel",
+			err:        fmt.Errorf("failed to get link 7 from links: %w", internal.ErrLinkNotFound),
+			wantStatus: http.StatusNotFound,
+			want:       ErrorResponse{Code: CodeLinkNotFound, Error: internal.ErrLinkNotFound.Error()},
+		},
+		{
+			name:       "busy database",
+			err:        fmt.Errorf("failed to scan links: %w", internal.ErrDatabaseBusy),
+			wantStatus: http.StatusServiceUnavailable,
+			want:       ErrorResponse{Code: CodeDatabaseBusy, Error: internal.ErrDatabaseBusy.Error()},
+		},
+		{
+			name:       "validation",
+			err:        internal.NewValidationError("url", "url is required"),
+			wantStatus: http.StatusBadRequest,
+			want: ErrorResponse{
+				Code:    CodeValidationFailed,
+				Error:   "url is required",
+				Details: []internal.FieldError{{Field: "url", Message: "url is required"}},
+			},
+		},
+		{
+			name:       "api error",
+			err:        newAPIError(http.StatusUnprocessableEntity, CodeDestinationDisallowed, "destination is not allowed"),
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       ErrorResponse{Code: CodeDestinationDisallowed, Error: "destination is not allowed"},
+		},
+		{
+			name:       "http error",
+			err:        echo.NewHTTPError(http.StatusBadRequest, "invalid link id"),
+			wantStatus: http.StatusBadRequest,
+			want:       ErrorResponse{Code: CodeBadRequest, Error: "invalid link id"},
+		},
+		{
+			name:       "http error without a text",
+			err:        &echo.HTTPError{Code: http.StatusMethodNotAllowed, Message: map[string]string{"allowed": "GET"}},
+			wantStatus: http.StatusMethodNotAllowed,
+			want:       ErrorResponse{Code: CodeMethodNotAllowed, Error: "method not allowed"},
+		},
+		{
+			// Internal errors say what went wrong in the log, not to clients
+			name:       "internal",
+			err:        errors.New("failed to scan links: no such table: links"),
+			wantStatus: http.StatusInternalServerError,
+			want:       ErrorResponse{Code: CodeInternal, Error: "internal server error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, got := ResolveError(tt.err)
+			if status != tt.wantStatus || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %d %+v, want %d %+v", status, got, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/base64"
+	"html"
+	"slices"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/rs/zerolog/log"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	qrImageSize = 256
+	// maxQRDataURILength keeps long URLs, which make dense codes, from bloating responses
+	maxQRDataURILength = 16 << 10
+)
+
+// LinkFormats are ready to paste renderings of a short link.
+type LinkFormats struct {
+	URL      string `json:"url"`
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	// QR is a PNG data URI, only included when asked for with ?include=qr
+	QR string `json:"qr,omitempty"`
+}
+
+// newLinkFormats renders the short URL labeled with the link title, or the slug when it has none.
+func newLinkFormats(shortURL string, link *internal.Link, withQR bool) *LinkFormats {
+	label := cmp.Or(link.Title, link.Slug)
+	f := &LinkFormats{
+		URL:      shortURL,
+		Markdown: markdownLink(label, shortURL),
+		HTML:     `<a href="` + html.EscapeString(shortURL) + `">` + html.EscapeString(label) + `</a>`,
+	}
+	if withQR {
+		f.QR = qrDataURI(shortURL)
+	}
+	return f
+}
+
+// markdownEscaper backslash-escapes the characters that could end the link text early
+// or be read as emphasis, code, inline HTML or an entity.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`[`, `\[`,
+	`]`, `\]`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`~`, `\~`,
+	`<`, `\<`,
+	`>`, `\>`,
+	`&`, `\&`,
+)
+
+// markdownDestinationEscaper percent-encodes what would end the link destination.
+var markdownDestinationEscaper = strings.NewReplacer(
+	` `, `%20`,
+	`(`, `%28`,
+	`)`, `%29`,
+	`<`, `%3C`,
+	`>`, `%3E`,
+)
+
+func markdownLink(label, url string) string {
+	// Line breaks can't be escaped, and would end the link
+	label = strings.Join(strings.Fields(label), " ")
+	return "[" + markdownEscaper.Replace(label) + "](" + markdownDestinationEscaper.Replace(url) + ")"
+}
+
+func qrDataURI(url string) string {
+	png, err := qrcode.Encode(url, qrcode.Medium, qrImageSize)
+	if err != nil {
+		log.Warn().Err(err).Str("url", url).Msg("failed to encode QR code")
+		return ""
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	if len(uri) > maxQRDataURILength {
+		return ""
+	}
+	return uri
+}
+
+// parseInclude reads which optional parts of a link response were asked for with ?include=formats,qr
+func parseInclude(include string) (formats, qr bool) {
+	parts := strings.Split(include, ",")
+	qr = slices.Contains(parts, "qr")
+	formats = qr || slices.Contains(parts, "formats")
+	return formats, qr
+}
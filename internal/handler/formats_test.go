@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/abdusco/linked/internal"
+)
+
+func TestLinkFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		shortURL string
+		markdown string
+		html     string
+	}{
+		{
+			"plain", "Spring promo", "https://s.example/promo",
+			`[Spring promo](https://s.example/promo)`,
+			`<a href="https://s.example/promo">Spring promo</a>`,
+		},
+		{
+			"no title", "", "https://s.example/promo",
+			`[promo](https://s.example/promo)`,
+			`<a href="https://s.example/promo">promo</a>`,
+		},
+		{
+			"brackets", "Docs [beta]", "https://s.example/promo",
+			`[Docs \[beta\]](https://s.example/promo)`,
+			`<a href="https://s.example/promo">Docs [beta]</a>`,
+		},
+		{
+			"brackets closing the link early", "a](https://evil.example) [b", "https://s.example/promo",
+			`[a\](https://evil.example) \[b](https://s.example/promo)`,
+			`<a href="https://s.example/promo">a](https://evil.example) [b</a>`,
+		},
+		{
+			"quotes", `Say "hi" & it's done`, "https://s.example/promo",
+			`[Say "hi" \& it's done](https://s.example/promo)`,
+			`<a href="https://s.example/promo">Say &#34;hi&#34; &amp; it&#39;s done</a>`,
+		},
+		{
+			"entities", "Fish &amp; chips", "https://s.example/promo",
+			`[Fish \&amp; chips](https://s.example/promo)`,
+			`<a href="https://s.example/promo">Fish &amp;amp; chips</a>`,
+		},
+		{
+			"markup", "<b>bold</b> *and* _more_ `code` ~gone~", "https://s.example/promo",
+			"[\\<b\\>bold\\</b\\> \\*and\\* \\_more\\_ \\`code\\` \\~gone\\~](https://s.example/promo)",
+			`<a href="https://s.example/promo">&lt;b&gt;bold&lt;/b&gt; *and* _more_ ` + "`code`" + ` ~gone~</a>`,
+		},
+		{
+			"backslashes", `C:\dir\]`, "https://s.example/promo",
+			`[C:\\dir\\\]](https://s.example/promo)`,
+			`<a href="https://s.example/promo">C:\dir\]</a>`,
+		},
+		{
+			"line breaks", "first line\n\n  second\tline", "https://s.example/promo",
+			`[first line second line](https://s.example/promo)`,
+			"<a href=\"https://s.example/promo\">first line\n\n  second\tline</a>",
+		},
+		{
+			"URL ending the destination early", "Promo", "https://s.example/a (b)?q=<x>",
+			`[Promo](https://s.example/a%20%28b%29?q=%3Cx%3E)`,
+			`<a href="https://s.example/a (b)?q=&lt;x&gt;">Promo</a>`,
+		},
+		{
+			"URL with a query", "Promo", `https://s.example/promo?a=1&b="2"`,
+			`[Promo](https://s.example/promo?a=1&b="2")`,
+			`<a href="https://s.example/promo?a=1&amp;b=&#34;2&#34;">Promo</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLinkFormats(tt.shortURL, &internal.Link{Slug: "promo", Title: tt.title}, false)
+			if f.URL != tt.shortURL {
+				t.Errorf("URL: got %q, want %q", f.URL, tt.shortURL)
+			}
+			if f.Markdown != tt.markdown {
+				t.Errorf("markdown: got %s, want %s", f.Markdown, tt.markdown)
+			}
+			if f.HTML != tt.html {
+				t.Errorf("HTML: got %s, want %s", f.HTML, tt.html)
+			}
+			if f.QR != "" {
+				t.Errorf("got a QR code without asking for one")
+			}
+		})
+	}
+}
+
+func TestLinkFormatsQR(t *testing.T) {
+	f := newLinkFormats("https://s.example/promo", &internal.Link{Slug: "promo"}, true)
+	data, ok := strings.CutPrefix(f.QR, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("got QR %.40q, want a PNG data URI", f.QR)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != qrImageSize || size.Y != qrImageSize {
+		t.Errorf("got a %v image, want %dx%[2]d", size, qrImageSize)
+	}
+
+	// A URL too long for a code is left out, the other formats are still there
+	long := "https://s.example/" + strings.Repeat("x", 3000)
+	if f := newLinkFormats(long, &internal.Link{Slug: "promo"}, true); f.QR != "" || f.URL != long {
+		t.Errorf("got QR of %d bytes for a long URL, want none", len(f.QR))
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	tests := []struct {
+		include     string
+		formats, qr bool
+	}{
+		{"", false, false},
+		{"formats", true, false},
+		// The QR code comes with the formats it's part of
+		{"qr", true, true},
+		{"formats,qr", true, true},
+		{"stats,formats", true, false},
+		{"format", false, false},
+		{"formats ", false, false},
+	}
+	for _, tt := range tests {
+		if formats, qr := parseInclude(tt.include); formats != tt.formats || qr != tt.qr {
+			t.Errorf("parseInclude(%q) = %t, %t, want %t, %t", tt.include, formats, qr, tt.formats, tt.qr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abdusco/linked/internal/health"
+	"github.com/labstack/echo/v4"
+)
+
+// HealthHandler reports the state of the integrations the application depends on.
+type HealthHandler struct {
+	registry *health.Registry
+}
+
+func NewHealthHandler(registry *health.Registry) *HealthHandler {
+	return &HealthHandler{registry: registry}
+}
+
+type ReadyResponse struct {
+	Status health.Status `json:"status"`
+	// Dependencies maps each dependency to its status, details are only shown to the admin
+	Dependencies map[string]health.Status `json:"dependencies"`
+}
+
+// Ready handles GET /ready - answers 503 only when a critical dependency is down
+func (h *HealthHandler) Ready(c echo.Context) error {
+	reports := h.registry.Reports(c.Request().Context())
+
+	resp := ReadyResponse{
+		Status:       health.Overall(reports),
+		Dependencies: make(map[string]health.Status, len(reports)),
+	}
+	for _, r := range reports {
+		resp.Dependencies[r.Name] = r.Status
+	}
+
+	code := http.StatusOK
+	if resp.Status == health.StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(code, resp)
+}
+
+type ListIntegrationsResponse struct {
+	Status       health.Status   `json:"status"`
+	Integrations []health.Report `json:"integrations"`
+}
+
+// ListIntegrations handles GET /api/admin/integrations
+func (h *HealthHandler) ListIntegrations(c echo.Context) error {
+	reports := h.registry.Reports(c.Request().Context())
+	return c.JSON(http.StatusOK, ListIntegrationsResponse{
+		Status:       health.Overall(reports),
+		Integrations: reports,
+	})
+}
+
+// TestIntegration handles POST /api/admin/integrations/:name/test - runs the self-check right away
+func (h *HealthHandler) TestIntegration(c echo.Context) error {
+	report, err := h.registry.Test(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		if errors.Is(err, health.ErrUnknownDependency) {
+			return echo.NewHTTPError(http.StatusNotFound, "integration not found")
+		}
+		if errors.Is(err, health.ErrNoCheck) {
+			return echo.NewHTTPError(http.StatusBadRequest, "integration has no self-check, its status comes from its use")
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,374 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxImportRows bounds the rows read from one file, the results are kept in memory
+	maxImportRows = 100_000
+	// maxSlugSuffix bounds the suffixes tried for a taken slug, like my-link-2 up to my-link-10
+	maxSlugSuffix = 10
+)
+
+// importColumns are the header names a source uses for each field, the first one present wins.
+// Names are compared by their letters and digits only, so "Long URL" matches "long_url".
+type importColumns struct {
+	slug        []string
+	url         []string
+	title       []string
+	description []string
+	createdAt   []string
+}
+
+var importSources = map[string]importColumns{
+	"bitly": {
+		slug:      []string{"bitlink", "link", "shortlink", "shorturl", "id"},
+		url:       []string{"longurl", "destination", "url"},
+		title:     []string{"title"},
+		createdAt: []string{"createdat", "created", "creationdate", "date"},
+	},
+	"shortio": {
+		slug:      []string{"path", "shorturl", "secureshorturl", "slug"},
+		url:       []string{"originalurl", "destinationurl", "url"},
+		title:     []string{"title"},
+		createdAt: []string{"createdat", "created", "date"},
+	},
+	"generic-csv": {
+		slug:        []string{"slug"},
+		url:         []string{"url"},
+		title:       []string{"title"},
+		description: []string{"description"},
+		createdAt:   []string{"createdat"},
+	},
+}
+
+type ImportConflict string
+
+const (
+	ImportConflictSkip      ImportConflict = "skip"
+	ImportConflictOverwrite ImportConflict = "overwrite"
+	ImportConflictSuffix    ImportConflict = "suffix"
+)
+
+type ImportStatus string
+
+const (
+	ImportCreated     ImportStatus = "created"
+	ImportOverwritten ImportStatus = "overwritten"
+	ImportSkipped     ImportStatus = "skipped"
+)
+
+type ImportRowResult struct {
+	// Line is where the row starts in the file, the header is line 1
+	Line   int          `json:"line"`
+	Slug   string       `json:"slug,omitempty"`
+	URL    string       `json:"url,omitempty"`
+	Status ImportStatus `json:"status"`
+	Reason string       `json:"reason,omitempty"`
+	LinkID int64        `json:"link_id,omitempty"`
+}
+
+type ImportResponse struct {
+	Created     int               `json:"created"`
+	Overwritten int               `json:"overwritten"`
+	Skipped     int               `json:"skipped"`
+	Results     []ImportRowResult `json:"results"`
+	// Error says why the import stopped before the end of the file, the rows before it are kept
+	Error string `json:"error,omitempty"`
+}
+
+func (r *ImportResponse) add(result ImportRowResult) {
+	switch result.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportOverwritten:
+		r.Overwritten++
+	case ImportSkipped:
+		r.Skipped++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// ImportLinks handles POST /api/import?source=bitly|shortio|generic-csv
+// The CSV file is the request body, or the "file" field of a multipart form. It's read as it
+// arrives and every row is created on its own, so a failure halfway keeps the earlier rows.
+func (h *LinkHandler) ImportLinks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	columns, ok := importSources[c.QueryParam("source")]
+	if !ok {
+		return internal.NewValidationError("source", "source must be bitly, shortio or generic-csv")
+	}
+	conflict := ImportConflict(c.QueryParam("conflict"))
+	switch conflict {
+	case "":
+		conflict = ImportConflictSkip
+	case ImportConflictSkip, ImportConflictOverwrite, ImportConflictSuffix:
+	default:
+		return internal.NewValidationError("conflict", "conflict must be skip, overwrite or suffix")
+	}
+	preserveDates := c.QueryParam("preserve_dates") == "true"
+
+	body, err := importBody(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read CSV header")
+	}
+	fields := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if key := columnKey(name); key != "" {
+			if _, seen := fields[key]; !seen {
+				fields[key] = i
+			}
+		}
+	}
+	column := func(names []string) int {
+		for _, name := range names {
+			if i, ok := fields[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	slugCol, urlCol, titleCol, createdCol := column(columns.slug), column(columns.url), column(columns.title), column(columns.createdAt)
+	descriptionCol := column(columns.description)
+	if urlCol < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("CSV has no destination column, expected one of %s", strings.Join(columns.url, ", ")))
+	}
+
+	resp := ImportResponse{Results: []ImportRowResult{}}
+	for rows := 0; ; rows++ {
+		if rows == maxImportRows {
+			resp.Error = fmt.Sprintf("only the first %d rows are imported", maxImportRows)
+			break
+		}
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			resp.add(ImportRowResult{Line: parseErr.StartLine, Status: ImportSkipped, Reason: "malformed row"})
+			continue
+		} else if err != nil {
+			resp.Error = "failed to read file: " + err.Error()
+			break
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		result := ImportRowResult{Line: line, Slug: slugFromShortLink(field(slugCol)), URL: field(urlCol)}
+
+		var createdAt time.Time
+		if preserveDates && field(createdCol) != "" {
+			if createdAt, err = parseImportTime(field(createdCol)); err != nil {
+				result.Status, result.Reason = ImportSkipped, "invalid creation date"
+				resp.add(result)
+				continue
+			}
+		}
+
+		req := CreateLinkRequest{URL: result.URL, Slug: result.Slug, Title: field(titleCol), Description: field(descriptionCol)}
+		if err := h.validator.Validate(&req); err != nil {
+			result.Status, result.Reason = ImportSkipped, err.Error()
+			resp.add(result)
+			continue
+		}
+		if err := h.destinations.Check(req.URL); err != nil {
+			result.Status, result.Reason = ImportSkipped, err.Error()
+			resp.add(result)
+			continue
+		}
+		if _, _, ok := h.selfLink(c.Request(), req.URL); ok && h.selfRedirects == SelfRedirectReject {
+			result.Status, result.Reason = ImportSkipped, "url points at a short link of this instance"
+			resp.add(result)
+			continue
+		}
+
+		resp.add(h.importLink(ctx, result, repo.NewLink{
+			Slug:        req.Slug,
+			URL:         req.URL,
+			Title:       req.Title,
+			Description: req.Description,
+			CreatedAt:   createdAt,
+			OwnerID:     ownerID(c),
+		}, conflict, auth.UserFrom(c)))
+		if ctx.Err() != nil {
+			resp.Error = "import was interrupted"
+			break
+		}
+	}
+
+	logger.FromContext(ctx).Info().
+		Int("created", resp.Created).
+		Int("overwritten", resp.Overwritten).
+		Int("skipped", resp.Skipped).
+		Str("error", resp.Error).
+		Msg("imported links")
+	return c.JSON(http.StatusOK, resp)
+}
+
+// importLink creates one imported link, resolving a taken slug by the conflict strategy.
+// Only links the user may change are overwritten.
+func (h *LinkHandler) importLink(ctx context.Context, result ImportRowResult, params repo.NewLink, conflict ImportConflict, user *internal.User) ImportRowResult {
+	generated := params.Slug == ""
+	original := params.Slug
+	for attempt := 1; ; attempt++ {
+		if generated {
+			slug, err := h.slugGen.Next(ctx)
+			if err != nil {
+				logger.FromContext(ctx).Error().Err(err).Msg("failed to generate slug for imported link")
+				result.Status, result.Reason = ImportSkipped, "could not generate a free slug"
+				return result
+			}
+			params.Slug = slug
+		}
+		result.Slug = params.Slug
+
+		link, err := h.linksRepo.Create(ctx, params)
+		if err == nil {
+			h.webhooks.LinkCreated(link)
+			result.Status, result.LinkID = ImportCreated, link.ID
+			return result
+		}
+		if !errors.Is(err, internal.ErrSlugExists) {
+			logger.FromContext(ctx).Error().Err(err).Str("slug", params.Slug).Msg("failed to import link")
+			result.Status, result.Reason = ImportSkipped, "failed to create link"
+			return result
+		}
+
+		switch {
+		case generated && attempt < regenerateSlugAttempts:
+			metrics.SlugRetries.Add(1)
+			continue
+		case generated:
+			result.Status, result.Reason = ImportSkipped, "could not generate a free slug"
+			return result
+		case conflict == ImportConflictOverwrite:
+			if existing, err := h.linksRepo.GetBySlug(ctx, params.Domain, params.Slug); err == nil && h.linksRepo.SameSlug(existing.Slug, params.Slug) && !user.CanModify(existing) {
+				result.Status, result.Reason = ImportSkipped, "slug belongs to another user's link"
+				return result
+			}
+			link, err := h.linksRepo.ReplaceDestination(ctx, params)
+			if errors.Is(err, internal.ErrLinkNotFound) {
+				result.Status, result.Reason = ImportSkipped, "slug is an alias of another link"
+			} else if err != nil {
+				logger.FromContext(ctx).Error().Err(err).Str("slug", params.Slug).Msg("failed to overwrite imported link")
+				result.Status, result.Reason = ImportSkipped, "failed to overwrite link"
+			} else {
+				result.Status, result.LinkID = ImportOverwritten, link.ID
+			}
+			return result
+		case conflict == ImportConflictSuffix && attempt < maxSlugSuffix:
+			params.Slug = fmt.Sprintf("%s-%d", original, attempt+1)
+			// The suffix may take the slug past the longest one allowed
+			if err := h.validator.ValidateSlug(params.Slug); err != nil {
+				result.Status, result.Reason = ImportSkipped, err.Error()
+				return result
+			}
+		default:
+			result.Status, result.Reason = ImportSkipped, "duplicate slug"
+			return result
+		}
+	}
+}
+
+// importBody returns the uploaded file without reading it up front.
+func importBody(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(echo.HeaderContentType))
+	if mediaType != echo.MIMEMultipartForm {
+		return r.Body, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("invalid multipart form")
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("form has no file field")
+		} else if err != nil {
+			return nil, errors.New("invalid multipart form")
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+func columnKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// slugFromShortLink takes the slug out of a short link like bit.ly/abc or https://short.io/abc,
+// and leaves a bare slug as is.
+func slugFromShortLink(s string) string {
+	if i := strings.IndexAny(s, "?#"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSuffix(s, "/")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// importTimeLayouts are the timestamp formats seen in exports, those without a zone are UTC.
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	time.DateOnly,
+}
+
+func parseImportTime(s string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", s)
+}
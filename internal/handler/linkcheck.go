@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/linkcheck"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/timeout"
+	"github.com/labstack/echo/v4"
+)
+
+type LinkCheckHandler struct {
+	linksRepo *repo.LinksRepo
+	checker   *linkcheck.Checker
+}
+
+func NewLinkCheckHandler(linksRepo *repo.LinksRepo, checker *linkcheck.Checker) *LinkCheckHandler {
+	return &LinkCheckHandler{
+		linksRepo: linksRepo,
+		checker:   checker,
+	}
+}
+
+type CheckLinkResponse struct {
+	Health *internal.LinkHealth `json:"health"`
+}
+
+// CheckLink handles POST /api/links/:id/check - checks the destination right away, without
+// waiting for the background checks
+func (h *LinkCheckHandler) CheckLink(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	link, err := h.linksRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, link); err != nil {
+		return err
+	}
+	if !linkcheck.Checkable(link) {
+		return echo.NewHTTPError(http.StatusBadRequest, "only http and https destinations can be checked")
+	}
+
+	timeout.SetPhase(ctx, "check destination")
+	health, err := h.checker.Check(ctx, link)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to check link destination")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, CheckLinkResponse{Health: health})
+}
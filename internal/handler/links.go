@@ -1,30 +1,128 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/assets"
+	"github.com/abdusco/linked/internal/branding"
+	"github.com/abdusco/linked/internal/clickfilter"
+	"github.com/abdusco/linked/internal/clickwriter"
+	"github.com/abdusco/linked/internal/destpolicy"
+	"github.com/abdusco/linked/internal/geoip"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/metrics"
 	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/safehttp"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/abdusco/linked/internal/slugs"
+	"github.com/abdusco/linked/internal/snapshot"
+	"github.com/abdusco/linked/internal/tarpit"
+	"github.com/abdusco/linked/internal/timeout"
+	"github.com/abdusco/linked/internal/useragent"
+	"github.com/abdusco/linked/internal/visitor"
+	"github.com/abdusco/linked/internal/webhook"
 	"github.com/labstack/echo/v4"
-	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 )
 
 type LinkHandler struct {
 	linksRepo  *repo.LinksRepo
 	clicksRepo *repo.ClicksRepo
+	// snapshotter is nil when snapshots are disabled
+	snapshotter *snapshot.Snapshotter
+	webhooks    *webhook.Dispatcher
+	guard       *tarpit.Guard
+	clickFilter *clickfilter.Filter
+	clickWriter *clickwriter.Writer
+	visitors    *visitor.Hasher
+	geo         geoip.Resolver
+	assets      *assets.Assets
+	branding    *branding.Store
+	validator   *LinkValidator
+	// domains are the hosts pointed at this instance that links can be scoped to
+	domains []string
+	// slugGen generates the slugs of links created without one
+	slugGen *slugs.Generator
+	// destinations restricts the hosts links may point to
+	destinations  *destpolicy.Policy
+	selfRedirects SelfRedirectPolicy
+	// campaignsRepo has the UTM parameters the campaigns of links expect, checked by utmCheck
+	campaignsRepo *repo.CampaignsRepo
+	utmCheck      UTMCheckPolicy
+	// slugNormalization decides about slugs that only match once a trailing slash or punctuation is stripped
+	slugNormalization SlugNormalization
+	deleteProtection  DeleteProtection
+	// settings can change while the server runs, they're read for every request
+	settings     *settings.Store
+	verifyClient *http.Client
 }
 
-func NewLinkHandler(linksRepo *repo.LinksRepo, clicksRepo *repo.ClicksRepo) *LinkHandler {
+// LinkHandlerDeps are what NewLinkHandler builds a LinkHandler from.
+type LinkHandlerDeps struct {
+	LinksRepo  *repo.LinksRepo
+	ClicksRepo *repo.ClicksRepo
+	// Snapshotter is nil when snapshots are disabled
+	Snapshotter *snapshot.Snapshotter
+	Webhooks    *webhook.Dispatcher
+	Guard       *tarpit.Guard
+	ClickFilter *clickfilter.Filter
+	ClickWriter *clickwriter.Writer
+	Visitors    *visitor.Hasher
+	Geo         geoip.Resolver
+	Assets      *assets.Assets
+	Branding    *branding.Store
+	Validator   *LinkValidator
+	// Domains are the hosts pointed at this instance that links can be scoped to
+	Domains []string
+	// SlugGen generates the slugs of links created without one
+	SlugGen *slugs.Generator
+	// Destinations restricts the hosts links may point to
+	Destinations  *destpolicy.Policy
+	SelfRedirects SelfRedirectPolicy
+	// CampaignsRepo has the UTM parameters the campaigns of links expect, checked by UTMCheck
+	CampaignsRepo *repo.CampaignsRepo
+	UTMCheck      UTMCheckPolicy
+	// SlugNormalization decides about slugs that only match once a trailing slash or punctuation is stripped
+	SlugNormalization SlugNormalization
+	DeleteProtection  DeleteProtection
+	// Settings can change while the server runs, they're read for every request
+	Settings *settings.Store
+}
+
+func NewLinkHandler(deps LinkHandlerDeps) *LinkHandler {
 	return &LinkHandler{
-		linksRepo:  linksRepo,
-		clicksRepo: clicksRepo,
+		linksRepo:         deps.LinksRepo,
+		clicksRepo:        deps.ClicksRepo,
+		snapshotter:       deps.Snapshotter,
+		webhooks:          deps.Webhooks,
+		guard:             deps.Guard,
+		clickFilter:       deps.ClickFilter,
+		clickWriter:       deps.ClickWriter,
+		visitors:          deps.Visitors,
+		geo:               deps.Geo,
+		assets:            deps.Assets,
+		branding:          deps.Branding,
+		validator:         deps.Validator,
+		domains:           deps.Domains,
+		slugGen:           deps.SlugGen,
+		selfRedirects:     deps.SelfRedirects,
+		slugNormalization: deps.SlugNormalization,
+		deleteProtection:  deps.DeleteProtection,
+		settings:          deps.Settings,
+		destinations:      deps.Destinations,
+		campaignsRepo:     deps.CampaignsRepo,
+		utmCheck:          deps.UTMCheck,
+		verifyClient:      safehttp.NewClient(verifyTimeout, verifyMaxRedirects),
 	}
 }
 
@@ -40,45 +138,158 @@ func getOrigin(r *http.Request) string {
 }
 
 type CreateLinkRequest struct {
-	URL  string `json:"url" validate:"required,url"`
+	URL  string `json:"url"`
 	Slug string `json:"slug"`
+	// Domain scopes the slug to one of the domains of the instance, without it the link works on all of them
+	Domain string `json:"domain"`
+	Title  string `json:"title"`
+	// Description is a private note on why the link exists
+	Description string   `json:"description"`
+	Snapshot    bool     `json:"snapshot"`
+	Preview     bool     `json:"preview"`
+	Listed      bool     `json:"listed"`
+	Tags        []string `json:"tags"`
+	// OpenGraph fields are shown to bots unfurling the short link
+	OGTitle       string `json:"og_title"`
+	OGDescription string `json:"og_description"`
+	OGImage       string `json:"og_image"`
+	// ActivatesAt and ExpiresAt bound when the link redirects, it always does without them
+	ActivatesAt *time.Time `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// Rules send visitors on some devices or with some languages elsewhere than URL, the first matching one wins
+	Rules []internal.DestinationRule `json:"rules"`
+	// CampaignID adds the link to a campaign
+	CampaignID *int64 `json:"campaign_id"`
+	// ReuseExisting returns an existing link with the same normalized URL instead of creating another one
+	ReuseExisting bool `json:"reuse_existing"`
 }
 
 var slugRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
 
-func (r *CreateLinkRequest) Validate() error {
-	if r.URL == "" {
-		return errors.New("url is required")
+const maxDescriptionLength = 500
+
+func normalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "", fmt.Errorf("description must be at most %d characters long", maxDescriptionLength)
 	}
-	const minSlugLength = 5
-	if r.Slug != "" {
-		if len(r.Slug) < minSlugLength {
-			return fmt.Errorf("slug must be at least %d characters long", minSlugLength)
-		}
-		if !slugRegex.MatchString(r.Slug) {
-			return errors.New("slug must contain only letters, numbers, and hyphens or underscores")
+	return description, nil
+}
+
+func (r *CreateLinkRequest) validateOpenGraph(errs *internal.ValidationError) {
+	r.OGTitle = strings.TrimSpace(r.OGTitle)
+	r.OGDescription = strings.TrimSpace(r.OGDescription)
+	r.OGImage = strings.TrimSpace(r.OGImage)
+
+	const maxOGTitleLength, maxOGDescriptionLength = 200, 500
+	if utf8.RuneCountInString(r.OGTitle) > maxOGTitleLength {
+		errs.Add("og_title", fmt.Errorf("og_title must be at most %d characters long", maxOGTitleLength))
+	}
+	if utf8.RuneCountInString(r.OGDescription) > maxOGDescriptionLength {
+		errs.Add("og_description", fmt.Errorf("og_description must be at most %d characters long", maxOGDescriptionLength))
+	}
+	if r.OGImage != "" {
+		u, err := url.Parse(r.OGImage)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.Add("og_image", errors.New("og_image must be an http or https URL"))
 		}
 	}
-	return nil
 }
 
 type LinkResponse struct {
-	ID        int64               `json:"id"`
-	Slug      string              `json:"slug"`
-	URL       string              `json:"url"`
-	ShortURL  string              `json:"short_url"`
-	CreatedAt time.Time           `json:"created_at"`
-	Stats     *internal.LinkStats `json:"stats,omitempty"`
+	ID             int64                      `json:"id"`
+	Slug           string                     `json:"slug"`
+	Domain         string                     `json:"domain,omitempty"`
+	URL            string                     `json:"url"`
+	Title          string                     `json:"title"`
+	Description    string                     `json:"description"`
+	ShortURL       string                     `json:"short_url"`
+	PublicStatsURL string                     `json:"public_stats_url,omitempty"`
+	Snapshot       bool                       `json:"snapshot"`
+	Preview        bool                       `json:"preview"`
+	Listed         bool                       `json:"listed"`
+	Tags           []string                   `json:"tags"`
+	OGTitle        string                     `json:"og_title,omitempty"`
+	OGDescription  string                     `json:"og_description,omitempty"`
+	OGImage        string                     `json:"og_image,omitempty"`
+	ActivatesAt    *time.Time                 `json:"activates_at,omitempty"`
+	ExpiresAt      *time.Time                 `json:"expires_at,omitempty"`
+	Rules          []internal.DestinationRule `json:"rules"`
+	Status         internal.LinkStatus        `json:"status"`
+	OwnerID        *int64                     `json:"owner_id,omitempty"`
+	CampaignID     *int64                     `json:"campaign_id,omitempty"`
+	CreatedAt      time.Time                  `json:"created_at"`
+	Stats          *internal.LinkStats        `json:"stats,omitempty"`
+	Health         *internal.LinkHealth       `json:"health,omitempty"`
+	Formats        *LinkFormats               `json:"formats,omitempty"`
+	// Warning says why the saved link looks wrong, like a destination that disagrees with its campaign
+	Warning string `json:"warning,omitempty"`
+}
+
+func newLinkResponse(origin string, link *internal.Link) LinkResponse {
+	resp := LinkResponse{
+		ID:            link.ID,
+		Slug:          link.Slug,
+		Domain:        link.Domain,
+		URL:           link.URL,
+		Title:         link.Title,
+		Description:   link.Description,
+		ShortURL:      shortURL(origin, link),
+		Snapshot:      link.Snapshot,
+		Preview:       link.Preview,
+		Listed:        link.Listed,
+		Tags:          link.Tags,
+		OGTitle:       link.OpenGraph.Title,
+		OGDescription: link.OpenGraph.Description,
+		OGImage:       link.OpenGraph.Image,
+		ActivatesAt:   link.ActivatesAt,
+		ExpiresAt:     link.ExpiresAt,
+		Rules:         link.Rules,
+		Status:        link.Status(time.Now()),
+		OwnerID:       link.OwnerID,
+		CampaignID:    link.CampaignID,
+		CreatedAt:     link.CreatedAt,
+		Stats:         link.Stats,
+		Health:        link.Health,
+	}
+	if link.StatsToken != "" {
+		resp.PublicStatsURL = publicStatsURL(origin, link.StatsToken)
+	}
+	return resp
+}
+
+// newLinkResponseFor builds the response for a single link, with the formats requested by ?include=
+func newLinkResponseFor(c echo.Context, link *internal.Link) LinkResponse {
+	resp := newLinkResponse(getOrigin(c.Request()), link)
+	if formats, qr := parseInclude(c.QueryParam("include")); formats {
+		resp.Formats = newLinkFormats(resp.ShortURL, link, qr)
+	}
+	return resp
 }
 
 type CreateLinkResponse struct {
 	Link LinkResponse `json:"link"`
+	// Warning says why the destination looks broken, when checked with ?verify=true
+	Warning string `json:"warning,omitempty"`
+	// Verification is how following the new link went, with ?verify=true
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// VerificationErrorResponse is the answer to ?strict=true when the link fails verification and isn't created
+type VerificationErrorResponse struct {
+	ErrorResponse
+	Verification *Verification `json:"verification"`
 }
 
 type ListLinksResponse struct {
 	Links []LinkResponse `json:"links"`
+	// NextCursor continues after the links with ?cursor=, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
+// maxLinksLimit is the most links of a page of ListLinks, without ?limit= all of them are listed
+const maxLinksLimit = 1000
+
 func (h *LinkHandler) CreateLink(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -87,120 +298,669 @@ func (h *LinkHandler) CreateLink(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 	}
 
-	if err := req.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := h.validator.Validate(&req); err != nil {
+		return err
+	}
+	domain, err := normalizeDomain(req.Domain, h.domains)
+	if err != nil {
+		return internal.NewValidationError("domain", err.Error())
+	}
+	req.Domain = domain
+	var warnings []string
+	for i, dest := range linkDestinations(req.URL, req.Rules) {
+		if err := h.destinations.Check(dest); err != nil {
+			return newAPIError(http.StatusUnprocessableEntity, CodeDestinationDisallowed, err.Error())
+		}
+		if _, _, ok := h.selfLink(c.Request(), dest); ok {
+			field, name := "url", "url"
+			if i > 0 {
+				field, name = fmt.Sprintf("url of rule %d", i), fmt.Sprintf("rules[%d].url", i-1)
+			}
+			switch h.selfRedirects {
+			case SelfRedirectReject:
+				return internal.NewValidationError(name, field+" points at a short link of this instance")
+			case SelfRedirectWarn:
+				warnings = append(warnings, field+" points at a short link of this instance")
+			}
+		}
+	}
+	utmWarnings, err := h.checkUTM(ctx, req.CampaignID, req.URL, req.Rules)
+	if err != nil {
+		return err
+	}
+	warnings = append(warnings, utmWarnings...)
+
+	// A broken destination is only reported and the link is created regardless, unless verifying strictly
+	strict := c.QueryParam("strict") == "true"
+	verify := strict || c.QueryParam("verify") == "true"
+	var unreachable string
+	if verify {
+		timeout.SetPhase(ctx, "verify destination")
+		if unreachable = h.checkReachable(ctx, req.URL); unreachable != "" {
+			warnings = append(warnings, unreachable)
+		}
+	}
+	warning := strings.Join(warnings, "; ")
+
+	if req.Snapshot && h.snapshotter == nil {
+		return internal.NewValidationError("snapshot", "snapshots are disabled on this instance")
 	}
 
-	if req.Slug == "" {
-		req.Slug = repo.GenerateSlug()
+	if req.ReuseExisting {
+		existing, err := h.linksRepo.FindByURL(ctx, req.URL)
+		if err != nil {
+			logger.FromContext(ctx).Error().Err(err).Str("url", req.URL).Msg("failed to look up existing links")
+			return err
+		}
+		// Only a link on the same domain is the link asked for
+		existing = lo.Filter(existing, func(link *internal.Link, _ int) bool { return link.Domain == req.Domain })
+		if len(existing) > 0 {
+			return c.JSON(http.StatusOK, CreateLinkResponse{Link: newLinkResponseFor(c, existing[0]), Warning: warning})
+		}
 	}
 
-	link, err := h.linksRepo.Create(ctx, req.Slug, req.URL)
+	params := repo.NewLink{
+		Slug:        req.Slug,
+		Domain:      req.Domain,
+		URL:         req.URL,
+		Title:       req.Title,
+		Description: req.Description,
+		Snapshot:    req.Snapshot,
+		Preview:     req.Preview,
+		Listed:      req.Listed,
+		Tags:        req.Tags,
+		OpenGraph: internal.OpenGraph{
+			Title:       req.OGTitle,
+			Description: req.OGDescription,
+			Image:       req.OGImage,
+		},
+		ActivatesAt: req.ActivatesAt,
+		ExpiresAt:   req.ExpiresAt,
+		OwnerID:     ownerID(c),
+		Rules:       req.Rules,
+		CampaignID:  req.CampaignID,
+	}
+
+	var link *internal.Link
+	var created bool
+	var verification *Verification
+	if strict {
+		// Verified before it's committed, so a link failing verification is never seen
+		err = h.linksRepo.WithTx(ctx, func(tx *repo.Tx) error {
+			link, created, err = h.createOrGet(ctx, params, func(params repo.NewLink) (*internal.Link, bool, error) {
+				return h.linksRepo.CreateOrGetTx(ctx, tx, params)
+			})
+			if err != nil || !created {
+				return err
+			}
+			verification = h.verifyLink(ctx, c.Request(), tx, link, unreachable)
+			if !verification.Passed {
+				return errVerificationFailed
+			}
+			return nil
+		})
+	} else {
+		link, created, err = h.createOrGet(ctx, params, func(params repo.NewLink) (*internal.Link, bool, error) {
+			return h.linksRepo.CreateOrGet(ctx, params)
+		})
+		if err == nil && created && verify {
+			verification = h.verifyLink(ctx, c.Request(), nil, link, unreachable)
+		}
+	}
 	if err != nil {
-		log.Error().Err(err).Str("slug", req.Slug).Msg("failed to create link")
+		if errors.Is(err, errVerificationFailed) {
+			return c.JSON(http.StatusUnprocessableEntity, VerificationErrorResponse{
+				ErrorResponse: ErrorResponse{Code: CodeVerificationFailed, Error: err.Error()},
+				Verification:  verification,
+			})
+		}
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return internal.NewValidationError("campaign_id", "campaign not found")
+		}
 		if errors.Is(err, internal.ErrSlugExists) {
-			return echo.NewHTTPError(http.StatusConflict, "slug already exists")
+			// The slug is an alias of another link
+			logger.FromContext(ctx).Debug().Str("slug", req.Slug).Msg("slug already exists")
+			return internal.ErrSlugExists
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		logger.FromContext(ctx).Error().Err(err).Str("slug", req.Slug).Msg("failed to create link")
+		return err
+	}
+	if !created {
+		// A retried request, or a concurrent one for the same link, gets the link that won
+		if link.URL != req.URL {
+			logger.FromContext(ctx).Debug().Str("slug", req.Slug).Msg("slug already exists")
+			return internal.ErrSlugExists
+		}
+		return c.JSON(http.StatusOK, CreateLinkResponse{Link: newLinkResponseFor(c, link), Warning: warning})
 	}
 
-	origin := getOrigin(c.Request())
-	resp := LinkResponse{
-		ID:        link.ID,
-		Slug:      link.Slug,
-		URL:       link.URL,
-		ShortURL:  origin + "/" + link.Slug,
-		CreatedAt: link.CreatedAt,
-		Stats:     link.Stats,
+	if link.Snapshot {
+		h.snapshotter.TakeAsync(link)
+	}
+	h.webhooks.LinkCreated(link)
+
+	return c.JSON(http.StatusCreated, CreateLinkResponse{Link: newLinkResponseFor(c, link), Warning: warning, Verification: verification})
+}
+
+// createOrGet creates the link of params with create, CreateOrGet or CreateOrGetTx. A link without a slug
+// gets a generated one, which is retried with the next one when it turns out to be taken: the link
+// found with it isn't the one asked for.
+func (h *LinkHandler) createOrGet(ctx context.Context, params repo.NewLink, create func(repo.NewLink) (*internal.Link, bool, error)) (*internal.Link, bool, error) {
+	if params.Slug != "" {
+		return create(params)
+	}
+	for attempt := 1; ; attempt++ {
+		slug, err := h.slugGen.Next(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		params.Slug = slug
+		link, created, err := create(params)
+		if taken := errors.Is(err, internal.ErrSlugExists) || (err == nil && !created); !taken {
+			return link, created, err
+		} else if attempt == regenerateSlugAttempts {
+			return nil, false, internal.ErrSlugExists
+		}
+		metrics.SlugRetries.Add(1)
+	}
+}
+
+// GetLink handles GET /api/links/:id
+func (h *LinkHandler) GetLink(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	link, err := h.linksRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	link.Stats, err = h.clicksRepo.GetStatsForLink(ctx, link.ID)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to get link stats")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newLinkResponseFor(c, link))
+}
+
+// Visit handles GET /api/links/:id/visit - follows the link without recording a click, so
+// testing a link doesn't count towards its stats. Scheduled and expired links are followed too.
+func (h *LinkHandler) Visit(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	link, err := h.linksRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// The rules apply to the device and language of the user, like they would to a visitor
+	device, language := useragent.Device(c.Request().UserAgent()), preferredLanguage(c.Request())
+	destination := link.Destination(device, language)
+	if h.selfRedirects != SelfRedirectAllow {
+		destination, err = h.resolveSelfRedirects(ctx, c.Request(), link, device, language)
+		if errors.Is(err, errRedirectLoop) {
+			return echo.NewHTTPError(http.StatusLoopDetected, "link redirects in a loop")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Not permanent like the short link, the destination may change
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.Redirect(http.StatusFound, destination)
+}
+
+type RegenerateSlugRequest struct {
+	// KeepOld keeps the previous slug redirecting to the link
+	KeepOld bool `json:"keep_old"`
+}
+
+// regenerateSlugAttempts bounds the retries when a generated slug is already taken
+const regenerateSlugAttempts = 3
+
+// RegenerateSlug handles POST /api/links/:id/regenerate-slug
+func (h *LinkHandler) RegenerateSlug(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	var req RegenerateSlugRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 	}
 
-	return c.JSON(http.StatusCreated, CreateLinkResponse{Link: resp})
+	link, err := h.linkToChange(c, id)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; attempt <= regenerateSlugAttempts; attempt++ {
+		var slug string
+		if slug, err = h.slugGen.Next(ctx); err != nil {
+			break
+		}
+		link, err = h.linksRepo.ChangeSlug(ctx, id, slug, req.KeepOld)
+		if !errors.Is(err, internal.ErrSlugExists) || attempt == regenerateSlugAttempts {
+			break
+		}
+		metrics.SlugRetries.Add(1)
+	}
+	if err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return internal.ErrLinkNotFound
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to regenerate slug")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newLinkResponseFor(c, link))
 }
 
+// ListLinks handles GET /api/links, or only the links pointing at the same destination with ?url=.
+// Repeated ?tag= params only keep links that have all of the tags, and ?q= those with the text
+// in their slug, URL, title or description. ?domain= keeps the links on a domain, an empty one those on any.
+// ?health=broken|ok|unchecked keeps links by the outcome of the last check of their destination.
+// ?sort=created_at|clicks|last_click and ?order=asc|desc order them, newest first by default.
+// ?limit= pages them, and ?cursor= with the next_cursor of a page continues after it.
 func (h *LinkHandler) ListLinks(c echo.Context) error {
 	ctx := c.Request().Context()
-	links, err := h.linksRepo.ListAll(ctx)
+
+	tags, err := normalizeTags(c.QueryParams()["tag"])
+	if err != nil {
+		return internal.NewValidationError("tag", err.Error())
+	}
+	order, err := parseLinkOrder(c.QueryParam("sort"), c.QueryParam("order"))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to list links")
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
+	}
+	health, err := repo.ParseHealthFilter(c.QueryParam("health"))
+	if err != nil {
+		return internal.NewValidationError("health", err.Error())
+	}
+	var page repo.LinkPage
+	if s := c.QueryParam("limit"); s != "" {
+		page.Limit, err = strconv.Atoi(s)
+		if err != nil || page.Limit < 1 || page.Limit > maxLinksLimit {
+			return internal.NewValidationError("limit", fmt.Sprintf("limit must be between 1 and %d", maxLinksLimit))
+		}
+		// Fetch one extra link to find out whether there's a next page
+		page.Limit++
+	}
+	if s := c.QueryParam("cursor"); s != "" {
+		cursor, err := repo.ParseLinkCursor(s)
+		if err != nil {
+			return internal.NewValidationError("cursor", err.Error())
+		}
+		page.After = &cursor
+	}
+
+	token, err := h.linksRepo.ChangeToken(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to get links change token")
+		return err
+	}
+	if notModified(c, token) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	filter := repo.LinkFilter{URL: c.QueryParam("url"), Tags: tags, Search: strings.TrimSpace(c.QueryParam("q")), Health: health}
+	if s := c.QueryParam("campaign_id"); s != "" {
+		campaignID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return internal.NewValidationError("campaign_id", "invalid campaign id")
+		}
+		filter.CampaignID = &campaignID
+	}
+	if c.QueryParams().Has("domain") {
+		domain := strings.ToLower(c.QueryParam("domain"))
+		filter.Domain = &domain
+	}
+
+	links, err := h.linksRepo.List(ctx, filter, order, page)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to list links")
+		return err
+	}
+
+	var nextCursor string
+	if page.Limit > 0 && len(links) == page.Limit {
+		links = links[:len(links)-1]
+		nextCursor = repo.NewLinkCursor(links[len(links)-1]).String()
 	}
 
 	origin := getOrigin(c.Request())
 	linksResponses := lo.Map(links, func(link *internal.Link, _ int) LinkResponse {
-		return LinkResponse{
-			ID:        link.ID,
-			Slug:      link.Slug,
-			URL:       link.URL,
-			ShortURL:  origin + "/" + link.Slug,
-			CreatedAt: link.CreatedAt,
-			Stats:     link.Stats,
-		}
+		return newLinkResponse(origin, link)
 	})
 
-	return c.JSON(http.StatusOK, ListLinksResponse{Links: linksResponses})
+	return c.JSON(http.StatusOK, ListLinksResponse{Links: linksResponses, NextCursor: nextCursor})
 }
 
+// parseLinkOrder parses the ?sort= and ?order= of ListLinks, both optional.
+func parseLinkOrder(sort, order string) (repo.LinkOrder, error) {
+	var linkOrder repo.LinkOrder
+	switch by := repo.LinkSort(sort); by {
+	case "", repo.SortByCreatedAt:
+		linkOrder.By = repo.SortByCreatedAt
+	case repo.SortByClicks, repo.SortByLastClick:
+		linkOrder.By = by
+	default:
+		return repo.LinkOrder{}, internal.NewValidationError("sort", fmt.Sprintf("invalid sort %q, must be one of created_at, clicks, last_click", sort))
+	}
+	switch order {
+	case "", "desc":
+	case "asc":
+		linkOrder.Ascending = true
+	default:
+		return repo.LinkOrder{}, internal.NewValidationError("order", fmt.Sprintf("invalid order %q, must be asc or desc", order))
+	}
+	return linkOrder, nil
+}
+
+// Redirect handles GET and HEAD /:slug and /:slug/. HEAD requests get the same response, they're recorded
+// with their method to audit them but don't count as clicks.
 func (h *LinkHandler) Redirect(c echo.Context) error {
+	ctx := c.Request().Context()
+	slug := requestedSlug(c)
+
+	logger.FromContext(ctx).Debug().Str("slug", slug).Msg("redirect request")
+
+	// Destinations shouldn't learn the short link the visitor came through
+	c.Response().Header().Set(echo.HeaderReferrerPolicy, "no-referrer")
+
+	timeout.SetPhase(ctx, "lookup link")
+	link, err := h.linksRepo.Resolve(ctx, requestHost(c.Request()), slug)
+	// Only a miss is normalized, so a slug that matches as requested never costs a second lookup
+	if normalized := normalizeSlug(slug); errors.Is(err, internal.ErrLinkNotFound) && h.slugNormalization != SlugNormalizationOff && normalized != slug {
+		link, err = h.linksRepo.Resolve(ctx, requestHost(c.Request()), normalized)
+		if err == nil {
+			logger.FromContext(ctx).Debug().Str("slug", slug).Str("normalized", link.Slug).Msg("matched normalized slug")
+			if h.slugNormalization == SlugNormalizationCanonical {
+				return redirectToCanonical(c, link.Slug)
+			}
+			slug = link.Slug
+		}
+	}
+	if errors.Is(err, internal.ErrLinkNotFound) {
+		logger.FromContext(ctx).Warn().Str("slug", slug).Msg("link not found")
+		return h.linkNotFound(c, slug)
+	} else if err != nil {
+		// A locked database isn't a missing link, it's answered with a 503 to try again
+		return fmt.Errorf("failed to look up link: %w", err)
+	}
+	if inactive, err := h.serveInactive(c, link); inactive {
+		return err
+	}
+
+	// Clicks are only recorded once the visitor proceeds past the interstitial
+	if link.Preview && c.QueryParam("continue") == "" {
+		return h.renderPreview(c, link)
+	}
+
+	userAgent := c.Request().UserAgent()
+	ipAddress := c.RealIP()
+	referer := c.Request().Referer()
+
+	device, language := useragent.Device(userAgent), preferredLanguage(c.Request())
+	destination := link.Destination(device, language)
+	if len(link.Rules) > 0 {
+		// Caches must not hand the destination of one visitor to another
+		c.Response().Header().Add(echo.HeaderVary, "User-Agent, Accept-Language")
+	}
+	if h.selfRedirects != SelfRedirectAllow {
+		destination, err = h.resolveSelfRedirects(ctx, c.Request(), link, device, language)
+		if errors.Is(err, errRedirectLoop) {
+			logger.FromContext(ctx).Warn().Str("slug", slug).Msg("refusing to redirect in a loop")
+			return echo.NewHTTPError(http.StatusLoopDetected, "link redirects in a loop")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Unfurling bots get the link's own preview metadata, everyone else the destination
+	respond := func() error {
+		if !link.OpenGraph.IsZero() && useragent.IsUnfurler(userAgent) {
+			return h.renderUnfurl(c, link, destination)
+		}
+		return c.Redirect(h.settings.Current(ctx).RedirectStatus, destination)
+	}
+
+	// Bots and clients checking a link send HEAD, which isn't a visit. Checks are only recorded to
+	// audit them, so a monitor polling a link is filtered like any other repeated click.
+	method := c.Request().Method
+	logger.FromContext(ctx).Info().Str("slug", slug).Str("ip", ipAddress).Str("method", method).Msg("redirecting link")
+	if skip, reason := h.clickFilter.Skip(link.ID, method, ipAddress, userAgent); skip {
+		logger.FromContext(ctx).Debug().Str("slug", slug).Str("reason", reason).Msg("click not counted")
+		return respond()
+	}
+
+	timeout.SetPhase(ctx, "record click")
+	// An unresolved country or visitor is recorded as unknown, neither holds up the redirect
+	click := repo.NewClick{
+		LinkID:      link.ID,
+		ClickedAt:   h.clicksRepo.Now(),
+		UserAgent:   userAgent,
+		IPAddress:   ipAddress,
+		Referer:     referer,
+		CountryCode: h.geo.Country(ipAddress),
+		VisitorHash: h.visitors.Hash(ctx, ipAddress, userAgent),
+		Method:      method,
+	}
+	if err := h.clickWriter.Record(ctx, link, click); err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			logger.FromContext(ctx).Warn().Str("slug", slug).Msg("link deleted before click was recorded")
+		} else {
+			logger.FromContext(ctx).Error().Err(err).Str("slug", slug).Msg("failed to record click")
+		}
+	}
+
+	return respond()
+}
+
+// Preview handles GET /p/:slug - shows the interstitial for any link, whether or not it has preview enabled
+func (h *LinkHandler) Preview(c echo.Context) error {
 	ctx := c.Request().Context()
 	slug := c.Param("slug")
 
-	log.Debug().Str("slug", slug).Msg("redirect request")
+	c.Response().Header().Set(echo.HeaderReferrerPolicy, "no-referrer")
+
+	link, err := h.linksRepo.Resolve(ctx, requestHost(c.Request()), slug)
+	if errors.Is(err, internal.ErrLinkNotFound) {
+		return h.linkNotFound(c, slug)
+	} else if err != nil {
+		return fmt.Errorf("failed to look up link: %w", err)
+	}
+	if inactive, err := h.serveInactive(c, link); inactive {
+		return err
+	}
+
+	return h.renderPreview(c, link)
+}
+
+// maxSuggestions bounds the slugs offered for a mistyped one, the checksum leaves few candidates already
+const maxSuggestions = 3
+
+// linkNotFound answers a visitor's request for a missing slug, holding the response
+// back once the client has missed too often so scanning the slug space gets slow.
+// A mistyped checksummed slug gets a page suggesting the existing links one typo away.
+// With a not found URL in the settings the visitor is sent there instead of getting an error.
+func (h *LinkHandler) linkNotFound(c echo.Context, slug string) error {
+	delay := h.guard.Miss(c.RealIP())
+	tarpit.Wait(c.Request().Context(), delay)
+	// A client held back is likely scanning, suggestions would hand it the neighbours of every guess
+	if delay == 0 {
+		if suggested, err := h.suggestSlugs(c, slug); err != nil {
+			logger.FromContext(c.Request().Context()).Error().Err(err).Str("slug", slug).Msg("failed to suggest slugs")
+		} else if len(suggested) > 0 {
+			return h.renderDidYouMean(c, slug, suggested)
+		}
+	}
+	if notFoundURL := h.settings.Current(c.Request().Context()).NotFoundURL; notFoundURL != "" {
+		// Temporary, the slug may be taken later
+		return c.Redirect(http.StatusFound, notFoundURL)
+	}
+	return internal.ErrLinkNotFound
+}
 
-	link, err := h.linksRepo.GetBySlug(ctx, slug)
+// suggestSlugs returns the existing slugs one typo away from slug, none unless its check character is wrong.
+func (h *LinkHandler) suggestSlugs(c echo.Context, slug string) ([]string, error) {
+	candidates := h.slugGen.NearMisses(slug)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	return h.linksRepo.ExistingSlugs(c.Request().Context(), requestHost(c.Request()), candidates, maxSuggestions)
+}
+
+type didYouMeanPage struct {
+	Slug string
+	// Suggestions are the short links of the slugs the visitor may have meant
+	Suggestions []string
+}
+
+func (h *LinkHandler) renderDidYouMean(c echo.Context, slug string, suggested []string) error {
+	page := didYouMeanPage{Slug: slug}
+	for _, s := range suggested {
+		page.Suggestions = append(page.Suggestions, getOrigin(c.Request())+"/"+url.PathEscape(s))
+	}
+	data, err := renderVisitorPage(c.Request().Context(), h.assets, h.branding, "did-you-mean.html", page)
 	if err != nil {
-		log.Warn().Str("slug", slug).Msg("link not found")
-		return echo.NewHTTPError(http.StatusNotFound, "link not found")
+		return err
 	}
 
-	userAgent := c.Request().UserAgent()
-	ipAddress := getClientIP(c.Request())
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTMLBlob(http.StatusNotFound, data)
+}
 
-	log.Info().Str("slug", slug).Str("ip", ipAddress).Msg("redirecting link")
+type previewPage struct {
+	URL         string
+	Host        string
+	ContinueURL string
+	// RefreshSeconds is when the page continues on its own, zero stays on the page
+	RefreshSeconds int
+}
 
-	if err := h.clicksRepo.Create(ctx, link.ID, userAgent, ipAddress); err != nil {
-		log.Error().Err(err).Str("slug", slug).Msg("failed to record click")
+func (h *LinkHandler) renderPreview(c echo.Context, link *internal.Link) error {
+	// Continuing applies the rules of the link, so the page shows where this visitor would go
+	destination := link.Destination(useragent.Device(c.Request().UserAgent()), preferredLanguage(c.Request()))
+	page := previewPage{
+		URL:            destination,
+		Host:           destination,
+		ContinueURL:    "/" + url.PathEscape(link.Slug) + "?continue=1",
+		RefreshSeconds: 10,
+	}
+	if u, err := url.Parse(destination); err == nil && u.Host != "" {
+		page.Host = u.Host
+	}
+
+	data, err := renderVisitorPage(c.Request().Context(), h.assets, h.branding, "preview.html", page)
+	if err != nil {
+		return err
 	}
 
-	return c.Redirect(http.StatusPermanentRedirect, link.URL)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTMLBlob(http.StatusOK, data)
 }
 
-func (h *LinkHandler) DeleteLink(c echo.Context) error {
-	ctx := c.Request().Context()
+type unfurlPage struct {
+	OpenGraph   internal.OpenGraph
+	ShortURL    string
+	Destination string
+}
+
+// renderUnfurl answers a bot with the link's preview metadata, and a refresh to the destination
+// in case it follows it like a browser would.
+func (h *LinkHandler) renderUnfurl(c echo.Context, link *internal.Link, destination string) error {
+	page := unfurlPage{
+		OpenGraph:   link.OpenGraph,
+		ShortURL:    shortURL(getOrigin(c.Request()), link),
+		Destination: destination,
+	}
+	data, err := renderVisitorPage(c.Request().Context(), h.assets, h.branding, "unfurl.html", page)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTMLBlob(http.StatusOK, data)
+}
 
+// DeleteLink handles DELETE /api/links/:id
+func (h *LinkHandler) DeleteLink(c echo.Context) error {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
 	}
 
-	err = h.linksRepo.Delete(ctx, id)
+	link, err := h.linkToChange(c, id)
 	if err != nil {
-		log.Error().Err(err).Int64("id", id).Msg("failed to delete link")
-		if errors.Is(err, internal.ErrLinkNotFound) {
-			return echo.NewHTTPError(http.StatusNotFound, "link not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
-
-	return c.NoContent(http.StatusNoContent)
+	return h.deleteLink(c, link)
 }
 
-func getClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if ips := net.ParseIP(xff); ips != nil {
-			return xff
-		}
+// DeleteLinkBySlug handles DELETE /api/links/slug/:slug?domain= - for clients that only know the slug.
+// Slugs can be all digits, so they get their own route instead of sharing the one of ids.
+// Without a domain it deletes the link on any domain.
+func (h *LinkHandler) DeleteLinkBySlug(c echo.Context) error {
+	slug := c.Param("slug")
+	domain := strings.ToLower(c.QueryParam("domain"))
+
+	link, err := h.linksRepo.GetBySlug(c.Request().Context(), domain, slug)
+	if err != nil {
+		return err
+	}
+	// Old slugs lead to the link too, but deleting it takes its current one
+	if !h.linksRepo.SameSlug(link.Slug, slug) {
+		return internal.ErrLinkNotFound
 	}
+	if err := authorizeChange(c, link); err != nil {
+		return err
+	}
+	return h.deleteLink(c, link)
+}
+
+// deleteLink deletes a link the user may change, its clicks go with it. Links with more clicks
+// than the delete protection allows need to be confirmed first.
+func (h *LinkHandler) deleteLink(c echo.Context, link *internal.Link) error {
+	ctx := c.Request().Context()
 
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		if ip := net.ParseIP(xri); ip != nil {
-			return xri
+	if ok, err := h.confirmDelete(c, link); !ok {
+		return err
+	}
+	if err := h.linksRepo.Delete(ctx, link.ID); err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", link.ID).Msg("failed to delete link")
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return internal.ErrLinkNotFound
 		}
+		return err
 	}
 
-	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		return host
-	}
+	h.webhooks.LinkDeleted(link)
 
-	return r.RemoteAddr
+	return c.NoContent(http.StatusNoContent)
+}
+
+// linkToChange loads a link the user of the request wants to modify, checking they may.
+// It returns HTTP errors, ready to be returned by the handler.
+func (h *LinkHandler) linkToChange(c echo.Context, id int64) (*internal.Link, error) {
+	link, err := h.linksRepo.GetByID(c.Request().Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeChange(c, link); err != nil {
+		return nil, err
+	}
+	return link, nil
 }
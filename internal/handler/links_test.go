@@ -0,0 +1,544 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/server"
+	"github.com/abdusco/linked/internal/testutil"
+	"github.com/samber/lo"
+)
+
+// getWithForwarding requests url claiming to be forwarded for xff, returning how long it took.
+func getWithForwarding(t *testing.T, url, xff string) (int, time.Duration) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", xff)
+	req.Header.Set("X-Real-IP", xff)
+	start := time.Now()
+	res, err := testutil.NewClient(t).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode, time.Since(start)
+}
+
+func TestScanBudgetIgnoresForwardingHeaders(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.ScanBudget = 2
+	cfg.ScanTarpitDelay = 500 * time.Millisecond
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+
+	// Claiming to be someone else with every guess doesn't get a budget of its own
+	for i, xff := range []string{"198.51.100.1", "198.51.100.2"} {
+		if status, took := getWithForwarding(t, ts.URL+"/missing-slug", xff); status != http.StatusNotFound || took >= cfg.ScanTarpitDelay {
+			t.Fatalf("miss %d within the budget: got %d after %s", i+1, status, took)
+		}
+	}
+	if status, took := getWithForwarding(t, ts.URL+"/missing-slug", "198.51.100.3"); status != http.StatusNotFound || took < cfg.ScanTarpitDelay {
+		t.Errorf("miss over the budget: got %d after %s, want it held back %s", status, took, cfg.ScanTarpitDelay)
+	}
+}
+
+func TestClickRecordsConnectingIP(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t, func(l *repo.NewLink) { l.Slug = "tracked" })
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+
+	if status, _ := getWithForwarding(t, ts.URL+"/tracked", "198.51.100.1"); status != http.StatusPermanentRedirect {
+		t.Fatalf("got %d, want %d", status, http.StatusPermanentRedirect)
+	}
+
+	// Clicks are written in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var ip string
+		err := f.DB.QueryRow("SELECT ip_address FROM clicks WHERE link_id = ?", link.ID).Scan(&ip)
+		if err == nil {
+			if ip != "127.0.0.1" {
+				t.Errorf("recorded IP %s, want the connecting 127.0.0.1", ip)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("click wasn't recorded: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// request sends a request with a JSON body, unless body is empty, and returns the status and body of the response.
+func request(t *testing.T, client *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, strings.TrimSpace(string(got))
+}
+
+func TestLinkErrorBodies(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t, func(l *repo.NewLink) { l.Slug = "taken" })
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "invalid id",
+			method:     http.MethodGet,
+			path:       "/api/links/abc",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"bad_request","error":"invalid link id"}`,
+		},
+		{
+			name:       "missing link",
+			method:     http.MethodGet,
+			path:       "/api/links/999",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"link_not_found","error":"link not found"}`,
+		},
+		{
+			name:       "invalid url",
+			method:     http.MethodPost,
+			path:       "/api/links",
+			body:       `{"url":"not-a-url"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"validation_failed","error":"url must be an absolute URL","details":[{"field":"url","message":"url must be an absolute URL"}]}`,
+		},
+		{
+			name:       "taken slug",
+			method:     http.MethodPost,
+			path:       "/api/links",
+			body:       `{"url":"https://example.com/other","slug":"taken"}`,
+			wantStatus: http.StatusConflict,
+			wantBody:   `{"code":"slug_conflict","error":"slug already exists"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := request(t, client, tt.method, ts.URL+tt.path, tt.body)
+			if status != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("got %d %s\nwant %d %s", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("internal error", func(t *testing.T) {
+		// Counting the clicks of the link fails once the rollups are gone
+		if _, err := f.DB.Exec("DROP TABLE click_rollups"); err != nil {
+			t.Fatal(err)
+		}
+		status, body := request(t, client, http.MethodGet, fmt.Sprintf("%s/api/links/%d", ts.URL, link.ID), "")
+		if want := `{"code":"internal_error","error":"internal server error"}`; status != http.StatusInternalServerError || body != want {
+			t.Errorf("got %d %s\nwant %d %s", status, body, http.StatusInternalServerError, want)
+		}
+	})
+}
+
+// listLinks lists the links with If-None-Match set to etag, returning the status and the ETag of the response.
+func listLinks(t *testing.T, client *http.Client, baseURL, etag string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/links", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("ETag")
+}
+
+func TestListLinksNotModified(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t, func(l *repo.NewLink) { l.Tags = []string{"docs"} })
+	scheduled := f.Link(t, func(l *repo.NewLink) { l.ActivatesAt = lo.ToPtr(f.Clock.Now().Add(time.Hour)) })
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t), server.WithLinksRepo(f.Links), server.WithClicksRepo(f.Clicks))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+	ctx := context.Background()
+
+	status, etag := listLinks(t, client, ts.URL, "")
+	if status != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want %d with one", status, etag, http.StatusOK)
+	}
+
+	changes := []struct {
+		name   string
+		change func(t *testing.T)
+	}{
+		{
+			name: "create",
+			change: func(t *testing.T) {
+				if status, body := request(t, client, http.MethodPost, ts.URL+"/api/links", `{"url":"https://example.com/new"}`); status != http.StatusCreated {
+					t.Fatalf("got %d %s", status, body)
+				}
+			},
+		},
+		{
+			name: "update",
+			change: func(t *testing.T) {
+				if status, body := request(t, client, http.MethodPatch, fmt.Sprintf("%s/api/links/%d", ts.URL, link.ID), `{"description":"Docs"}`); status != http.StatusOK {
+					t.Fatalf("got %d %s", status, body)
+				}
+			},
+		},
+		{
+			// Tags only change along with their link, the trigger of link_tags sees them all the same
+			name: "tags",
+			change: func(t *testing.T) {
+				if _, err := f.DB.Exec("DELETE FROM link_tags WHERE link_id = ?", link.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "click",
+			change: func(t *testing.T) {
+				if err := f.Clicks.Create(ctx, repo.NewClick{LinkID: link.ID, ClickedAt: f.Clock.Now()}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "check",
+			change: func(t *testing.T) {
+				health := &internal.LinkHealth{Result: internal.CheckOK, StatusCode: http.StatusOK, CheckedAt: f.Clock.Now()}
+				if err := f.Links.SaveHealth(ctx, link.ID, health); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// Nothing is written, the status of the scheduled link changes with time
+			name:   "activation",
+			change: func(t *testing.T) { f.Clock.Advance(2 * time.Hour) },
+		},
+		{
+			name: "delete",
+			change: func(t *testing.T) {
+				if status, body := request(t, client, http.MethodDelete, fmt.Sprintf("%s/api/links/%d", ts.URL, scheduled.ID), ""); status != http.StatusNoContent {
+					t.Fatalf("got %d %s", status, body)
+				}
+			},
+		},
+	}
+	for _, tt := range changes {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := listLinks(t, client, ts.URL, etag); status != http.StatusNotModified {
+				t.Fatalf("before the change: got %d, want %d", status, http.StatusNotModified)
+			}
+
+			tt.change(t)
+
+			status, changed := listLinks(t, client, ts.URL, etag)
+			if status != http.StatusOK || changed == etag {
+				t.Fatalf("after the change: got %d with ETag %s, want %d with another than %s", status, changed, http.StatusOK, etag)
+			}
+			etag = changed
+		})
+	}
+}
+
+func TestListLinksPages(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	var want []int64
+	for range 3 {
+		f.Clock.Advance(time.Minute)
+		want = append([]int64{f.Link(t).ID}, want...)
+	}
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t), server.WithLinksRepo(f.Links), server.WithClicksRepo(f.Clicks))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	var got []int64
+	next := ts.URL + "/api/links?limit=2"
+	for next != "" {
+		status, body := request(t, client, http.MethodGet, next, "")
+		if status != http.StatusOK {
+			t.Fatalf("got %d %s", status, body)
+		}
+		var page handler.ListLinksResponse
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range page.Links {
+			got = append(got, link.ID)
+		}
+		next = ""
+		if page.NextCursor != "" {
+			next = ts.URL + "/api/links?limit=2&cursor=" + page.NextCursor
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got pages of %v, want %v", got, want)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=two", "cursor=nope"} {
+		if status, body := request(t, client, http.MethodGet, ts.URL+"/api/links?"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want %d", query, status, body, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRedirectNormalizedSlug(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	for _, slug := range []string{"promo1", "promo_", "promo-"} {
+		f.Link(t, func(l *repo.NewLink) { l.Slug = slug; l.URL = "https://example.com/" + slug })
+	}
+
+	tests := []struct {
+		path          string
+		normalization handler.SlugNormalization
+		wantStatus    int
+		wantLocation  string
+	}{
+		{"/promo1/", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "/promo1"},
+		{"/promo1.?utm_source=chat", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "/promo1?utm_source=chat"},
+		{"/promo1%2E", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "/promo1"},
+		{"/promo1!", handler.SlugNormalizationDestination, http.StatusPermanentRedirect, "https://example.com/promo1"},
+		{"/promo1/", handler.SlugNormalizationOff, http.StatusNotFound, ""},
+		// Hyphens and underscores are part of the slug, not cleaned up
+		{"/promo_", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "https://example.com/promo_"},
+		{"/promo-", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "https://example.com/promo-"},
+		{"/promo_.", handler.SlugNormalizationCanonical, http.StatusPermanentRedirect, "/promo_"},
+		{"/promo-/", handler.SlugNormalizationDestination, http.StatusPermanentRedirect, "https://example.com/promo-"},
+		{"/promo1_", handler.SlugNormalizationCanonical, http.StatusNotFound, ""},
+		{"/promo1-", handler.SlugNormalizationCanonical, http.StatusNotFound, ""},
+	}
+	servers := map[handler.SlugNormalization]string{}
+	for _, normalization := range []handler.SlugNormalization{handler.SlugNormalizationCanonical, handler.SlugNormalizationDestination, handler.SlugNormalizationOff} {
+		cfg := testutil.ServerConfig(t)
+		cfg.SlugNormalization = normalization
+		servers[normalization] = testutil.NewServer(t, f.DB, cfg).URL
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.normalization, tt.path), func(t *testing.T) {
+			baseURL := servers[tt.normalization]
+			res, err := testutil.NewClient(t).Get(baseURL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			location := strings.TrimPrefix(res.Header.Get("Location"), baseURL)
+			if res.StatusCode != tt.wantStatus || location != tt.wantLocation {
+				t.Errorf("got %d to %q, want %d to %q", res.StatusCode, location, tt.wantStatus, tt.wantLocation)
+			}
+		})
+	}
+}
+
+// clicksByMethod counts the clicks stored for link by their method.
+func clicksByMethod(t *testing.T, f *testutil.Fixtures, link *internal.Link) map[string]int {
+	t.Helper()
+
+	rows, err := f.DB.Query("SELECT method, COUNT(*) FROM clicks WHERE link_id = ? GROUP BY method", link.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var method string
+		var n int
+		if err := rows.Scan(&method, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[method] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+// countedClicks is what the API says link was clicked.
+func countedClicks(t *testing.T, client *http.Client, baseURL string, link *internal.Link) int64 {
+	t.Helper()
+
+	res, err := client.Get(fmt.Sprintf("%s/api/links/%d", baseURL, link.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got handler.LinkResponse
+	testutil.DecodeJSON(t, res, &got)
+	return got.Stats.Clicks
+}
+
+func TestRedirectMethods(t *testing.T) {
+	const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	const monitor = "Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)"
+
+	type visit struct{ method, userAgent string }
+	tests := []struct {
+		name        string
+		excludeBots bool
+		dedup       time.Duration
+		visits      []visit
+		wantStored  map[string]int
+		wantCounted int64
+	}{
+		{"GET", false, 0, []visit{{http.MethodGet, browser}}, map[string]int{http.MethodGet: 1}, 1},
+		// Recorded to audit it, but not a visit
+		{"HEAD", false, 0, []visit{{http.MethodHead, browser}}, map[string]int{http.MethodHead: 1}, 0},
+		{"monitor doing GET", false, 0, []visit{{http.MethodGet, monitor}}, map[string]int{http.MethodGet: 1}, 1},
+		{"monitor doing GET without bots", true, 0, []visit{{http.MethodGet, monitor}}, map[string]int{}, 0},
+		{"monitor doing HEAD without bots", true, 0, []visit{{http.MethodHead, monitor}}, map[string]int{}, 0},
+		// A monitor polling is recorded once per window, and its checks don't hide the visit that follows
+		{
+			"repeated HEAD then GET", false, time.Minute,
+			[]visit{{http.MethodHead, browser}, {http.MethodHead, browser}, {http.MethodHead, browser}, {http.MethodGet, browser}},
+			map[string]int{http.MethodHead: 1, http.MethodGet: 1}, 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testutil.NewFixtures(t)
+			link := f.Link(t, func(l *repo.NewLink) { l.Slug = "methods" })
+			cfg := testutil.ServerConfig(t)
+			cfg.ExcludeBotClicks = tt.excludeBots
+			cfg.ClickDedupWindow = tt.dedup
+			ts := testutil.NewServer(t, f.DB, cfg)
+
+			visitor := testutil.NewClient(t)
+			for _, v := range tt.visits {
+				req, err := http.NewRequest(v.method, ts.URL+"/methods", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("User-Agent", v.userAgent)
+				res, err := visitor.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				if res.StatusCode != http.StatusPermanentRedirect || res.Header.Get("Location") != link.URL {
+					t.Errorf("%s: got %d to %q, want %d to %s", v.method, res.StatusCode, res.Header.Get("Location"), http.StatusPermanentRedirect, link.URL)
+				}
+			}
+
+			if got := clicksByMethod(t, f, link); !maps.Equal(got, tt.wantStored) {
+				t.Errorf("stored clicks %v, want %v", got, tt.wantStored)
+			}
+			admin := testutil.NewClient(t)
+			testutil.LogIn(t, admin, ts.URL)
+			if got := countedClicks(t, admin, ts.URL, link); got != tt.wantCounted {
+				t.Errorf("counted %d clicks, want %d", got, tt.wantCounted)
+			}
+		})
+	}
+}
+
+func TestRedirectOptionsIsNoClick(t *testing.T) {
+	for _, cors := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cors %t", cors), func(t *testing.T) {
+			f := testutil.NewFixtures(t)
+			link := f.Link(t, func(l *repo.NewLink) { l.Slug = "preflight" })
+			cfg := testutil.ServerConfig(t)
+			if cors {
+				cfg.CORSAllowedOrigins = []string{"https://app.example.com"}
+			}
+			ts := testutil.NewServer(t, f.DB, cfg)
+
+			req, err := http.NewRequest(http.MethodOptions, ts.URL+"/preflight", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			res, err := testutil.NewClient(t).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			// Answered by the router or the CORS middleware, before the redirect handler
+			if res.StatusCode != http.StatusNoContent || res.Header.Get("Location") != "" {
+				t.Errorf("got %d to %q, want %d without a redirect", res.StatusCode, res.Header.Get("Location"), http.StatusNoContent)
+			}
+			if got := clicksByMethod(t, f, link); len(got) != 0 {
+				t.Errorf("stored clicks %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestLinkFormatsOnlyWhenAsked(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t, func(l *repo.NewLink) { l.Slug = "promo"; l.Title = `Docs [beta] & "more"` })
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	for _, tt := range []struct {
+		include     string
+		wantFormats bool
+		wantQR      bool
+	}{
+		{"", false, false},
+		{"formats", true, false},
+		{"formats,qr", true, true},
+	} {
+		res, err := client.Get(fmt.Sprintf("%s/api/links/%d?include=%s", ts.URL, link.ID, tt.include))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got handler.LinkResponse
+		testutil.DecodeJSON(t, res, &got)
+		if (got.Formats != nil) != tt.wantFormats {
+			t.Errorf("include %q: got formats %+v, want them %t", tt.include, got.Formats, tt.wantFormats)
+			continue
+		}
+		if got.Formats == nil {
+			continue
+		}
+		if want := "[Docs \\[beta\\] \\& \"more\"](" + got.ShortURL + ")"; got.Formats.Markdown != want {
+			t.Errorf("include %q: got markdown %s, want %s", tt.include, got.Formats.Markdown, want)
+		}
+		if (got.Formats.QR != "") != tt.wantQR {
+			t.Errorf("include %q: got QR of %d bytes, want one %t", tt.include, len(got.Formats.QR), tt.wantQR)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abdusco/linked/internal"
+)
+
+// reservedSlugs are the first path segments of the routes of the server, a link with one of
+// them as its slug would be shadowed by the route or shadow it.
+var reservedSlugs = []string{
+	"api", "branding", "csp-report", "dashboard", "health", "integrations",
+	"links", "login", "logout", "p", "ready", "static",
+}
+
+// LinkValidator checks the links requested against the limits of the instance.
+type LinkValidator struct {
+	// allowedSchemes are the URL schemes links may point to
+	allowedSchemes []string
+	// slugMinLength and slugMaxLength bound custom slugs, generated ones aren't checked
+	slugMinLength int
+	slugMaxLength int
+}
+
+func NewLinkValidator(allowedSchemes []string, slugMinLength, slugMaxLength int) *LinkValidator {
+	return &LinkValidator{
+		allowedSchemes: allowedSchemes,
+		slugMinLength:  slugMinLength,
+		slugMaxLength:  slugMaxLength,
+	}
+}
+
+// Validate normalizes r and checks that it describes a valid link to a destination with one of the allowed schemes.
+// The fields it refuses are reported together as a ValidationError.
+func (v *LinkValidator) Validate(r *CreateLinkRequest) error {
+	var errs internal.ValidationError
+	errs.Add("url", validateDestination(r.URL, v.allowedSchemes))
+	r.Title = strings.TrimSpace(r.Title)
+	const maxTitleLength = 200
+	if utf8.RuneCountInString(r.Title) > maxTitleLength {
+		errs.Add("title", fmt.Errorf("title must be at most %d characters long", maxTitleLength))
+	}
+	description, err := normalizeDescription(r.Description)
+	errs.Add("description", err)
+	r.Description = description
+	tags, err := normalizeTags(r.Tags)
+	errs.Add("tags", err)
+	r.Tags = tags
+	r.validateOpenGraph(&errs)
+	errs.Add("activates_at", validateSchedule(r.ActivatesAt, r.ExpiresAt))
+	rules, err := normalizeRules(r.Rules, v.allowedSchemes)
+	errs.Add("rules", err)
+	r.Rules = rules
+	if r.Slug != "" {
+		errs.Add("slug", v.ValidateSlug(r.Slug))
+	}
+	return errs.Err()
+}
+
+// ValidateSlug checks a custom slug. Slugs taken by the routes of the server are refused whatever their length.
+func (v *LinkValidator) ValidateSlug(slug string) error {
+	if !slugRegex.MatchString(slug) {
+		return errors.New("slug must contain only letters, numbers, and hyphens or underscores")
+	}
+	if slices.Contains(reservedSlugs, strings.ToLower(slug)) {
+		return fmt.Errorf("slug %q is reserved", slug)
+	}
+	if n := len(slug); n < v.slugMinLength || n > v.slugMaxLength {
+		return fmt.Errorf("slug must be between %d and %d characters long", v.slugMinLength, v.slugMaxLength)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPISpec is maintained by hand, keep it in sync with the handlers and their request and response types
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// ServeOpenAPISpec handles GET /api/openapi.json
+func ServeOpenAPISpec(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.JSONBlob(http.StatusOK, openAPISpec)
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/cache"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// publicStatsTTL bounds how stale a widget can be, and how long a revoked token keeps working.
+	publicStatsTTL        = time.Minute
+	publicStatsCacheSize  = 10_000
+	publicStatsSeriesDays = 7
+)
+
+func publicStatsURL(origin, token string) string {
+	return origin + "/api/public/stats/" + token + ".json"
+}
+
+type PublicStatsResponse struct {
+	Clicks       int64                  `json:"clicks"`
+	UniqueClicks int64                  `json:"unique_clicks"`
+	Series       []internal.DailyClicks `json:"series"`
+}
+
+// PublicStatsHandler serves unauthenticated, widget-friendly click counts for links
+// that opted in with a stats token.
+type PublicStatsHandler struct {
+	linksRepo  *repo.LinksRepo
+	clicksRepo *repo.ClicksRepo
+	// nil values are cached too, so unknown tokens don't hit the database on every request
+	cache *cache.TTL[string, *PublicStatsResponse]
+}
+
+func NewPublicStatsHandler(linksRepo *repo.LinksRepo, clicksRepo *repo.ClicksRepo) *PublicStatsHandler {
+	return &PublicStatsHandler{
+		linksRepo:  linksRepo,
+		clicksRepo: clicksRepo,
+		cache:      cache.NewTTL[string, *PublicStatsResponse](publicStatsTTL, publicStatsCacheSize),
+	}
+}
+
+// GetStats handles GET /api/public/stats/:token.json
+func (h *PublicStatsHandler) GetStats(c echo.Context) error {
+	token, ok := strings.CutSuffix(c.Param("file"), ".json")
+	if !ok || token == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+
+	resp, cached := h.cache.Get(token)
+	if !cached {
+		var err error
+		resp, err = h.loadStats(c, token)
+		if err != nil {
+			return err
+		}
+		h.cache.Set(token, resp)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(publicStatsTTL.Seconds())))
+	if resp == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// loadStats returns nil without an error when the token doesn't exist.
+func (h *PublicStatsHandler) loadStats(c echo.Context, token string) (*PublicStatsResponse, error) {
+	ctx := c.Request().Context()
+
+	link, err := h.linksRepo.GetByStatsToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stats, err := h.clicksRepo.GetStatsForLink(ctx, link.ID)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", link.ID).Msg("failed to get link stats")
+		return nil, err
+	}
+
+	series, err := h.clicksRepo.GetDailyClicks(ctx, link.ID, publicStatsSeriesDays)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", link.ID).Msg("failed to get daily clicks")
+		return nil, err
+	}
+
+	return &PublicStatsResponse{Clicks: stats.Clicks, UniqueClicks: stats.UniqueClicks, Series: series}, nil
+}
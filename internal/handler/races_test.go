@@ -0,0 +1,213 @@
+package handler_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// raceParallelism is how many requests each race test sends at once
+const raceParallelism = 20
+
+// newRaceServer starts a server and returns a client logged in to it.
+func newRaceServer(t *testing.T) (*sql.DB, *http.Client, string) {
+	t.Helper()
+
+	sqlDB := testutil.NewDB(t)
+	ts := testutil.NewServer(t, sqlDB, testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+	return sqlDB, client, ts.URL
+}
+
+// send sends a request without a body and returns the status it got.
+func send(t *testing.T, client *http.Client, method, url string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// createLink creates a link and returns the status it got, the link when it was created.
+func createLink(t *testing.T, client *http.Client, baseURL string, body map[string]any) (int, handler.LinkResponse) {
+	t.Helper()
+
+	res := testutil.PostJSON(t, client, baseURL+"/api/links", body)
+	if res.StatusCode != http.StatusCreated {
+		res.Body.Close()
+		return res.StatusCode, handler.LinkResponse{}
+	}
+	var created handler.CreateLinkResponse
+	testutil.DecodeJSON(t, res, &created)
+	return res.StatusCode, created.Link
+}
+
+// countRows counts the rows of query.
+func countRows(t *testing.T, sqlDB *sql.DB, query string, args ...any) int {
+	t.Helper()
+
+	var n int
+	if err := sqlDB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestConcurrentCreateOfTheSameSlug(t *testing.T) {
+	sqlDB, client, baseURL := newRaceServer(t)
+
+	statuses := make([]int, raceParallelism)
+	var winner handler.LinkResponse
+	var wg sync.WaitGroup
+	for i := range raceParallelism {
+		wg.Go(func() {
+			// Each to another destination, so only one of them is the link asked for
+			status, link := createLink(t, client, baseURL, map[string]any{
+				"slug": "contested",
+				"url":  fmt.Sprintf("https://example.com/%d", i),
+				"tags": []string{"race", fmt.Sprintf("request-%d", i)},
+			})
+			statuses[i] = status
+			if status == http.StatusCreated {
+				winner = link
+			}
+		})
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusConflict] != raceParallelism-1 {
+		t.Fatalf("got statuses %v, want one %d and %d %d", counts, http.StatusCreated, raceParallelism-1, http.StatusConflict)
+	}
+
+	// Nothing of the losers is left behind
+	if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM links WHERE slug = 'contested'"); n != 1 {
+		t.Errorf("got %d links with the slug, want 1", n)
+	}
+	if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM link_tags"); n != len(winner.Tags) {
+		t.Errorf("got %d tags of links, want the %d of the one created", n, len(winner.Tags))
+	}
+	if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM link_tags WHERE link_id != ?", winner.ID); n != 0 {
+		t.Errorf("got %d tags of other links, want none", n)
+	}
+}
+
+func TestConcurrentRetriesOfACreate(t *testing.T) {
+	sqlDB, client, baseURL := newRaceServer(t)
+
+	// The same request sent again and again, the retries get the link of the first one
+	const requests = 50
+	statuses := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Go(func() {
+			res := testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{"slug": "retried", "url": "https://example.com/retried"})
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		})
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusOK] != requests-1 {
+		t.Errorf("got statuses %v, want one %d and %d %d", counts, http.StatusCreated, requests-1, http.StatusOK)
+	}
+	if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM links WHERE slug = 'retried'"); n != 1 {
+		t.Errorf("got %d links with the slug, want 1", n)
+	}
+}
+
+func TestConcurrentCreateAndDelete(t *testing.T) {
+	sqlDB, client, baseURL := newRaceServer(t)
+
+	for round := range raceParallelism {
+		slug := fmt.Sprintf("replaced-%d", round)
+		if status, _ := createLink(t, client, baseURL, map[string]any{"slug": slug, "url": "https://example.com/old"}); status != http.StatusCreated {
+			t.Fatalf("round %d: create the old link: got %d, want %d", round, status, http.StatusCreated)
+		}
+
+		// The old link is deleted while a link to another destination is created with its slug
+		var deleted, created int
+		var wg sync.WaitGroup
+		wg.Go(func() { deleted = send(t, client, http.MethodDelete, baseURL+"/api/links/slug/"+slug) })
+		wg.Go(func() {
+			created, _ = createLink(t, client, baseURL, map[string]any{"slug": slug, "url": "https://example.com/new"})
+		})
+		wg.Wait()
+
+		if deleted != http.StatusNoContent {
+			t.Errorf("round %d: delete: got %d, want %d", round, deleted, http.StatusNoContent)
+		}
+		// Either the old link was gone in time for the new one, or the new one found it and was refused
+		var want int
+		switch created {
+		case http.StatusCreated:
+			want = 1
+		case http.StatusConflict:
+			want = 0
+		default:
+			t.Errorf("round %d: create: got %d, want %d or %d", round, created, http.StatusCreated, http.StatusConflict)
+			continue
+		}
+		if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM links WHERE slug = ?", slug); n != want {
+			t.Errorf("round %d: create got %d and left %d links, want %d", round, created, n, want)
+		}
+		if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM links WHERE slug = ? AND url != 'https://example.com/new'", slug); n != 0 {
+			t.Errorf("round %d: the old link survived its deletion", round)
+		}
+	}
+}
+
+func TestConcurrentRenameAndRedirect(t *testing.T) {
+	for _, keepOld := range []bool{true, false} {
+		t.Run(fmt.Sprintf("keep old %t", keepOld), func(t *testing.T) {
+			_, client, baseURL := newRaceServer(t)
+			status, link := createLink(t, client, baseURL, map[string]any{"slug": "renamed", "url": "https://example.com/"})
+			if status != http.StatusCreated {
+				t.Fatalf("create: got %d, want %d", status, http.StatusCreated)
+			}
+
+			var wg sync.WaitGroup
+			wg.Go(func() {
+				for range raceParallelism {
+					res := testutil.PostJSON(t, client, fmt.Sprintf("%s/api/links/%d/regenerate-slug", baseURL, link.ID), map[string]bool{"keep_old": keepOld})
+					res.Body.Close()
+					if res.StatusCode != http.StatusOK {
+						t.Errorf("rename: got %d, want %d", res.StatusCode, http.StatusOK)
+					}
+				}
+			})
+			for range raceParallelism {
+				wg.Go(func() {
+					status := send(t, client, http.MethodGet, baseURL+"/renamed")
+					// A kept slug redirects whenever it's visited, a dropped one until the first rename
+					if status != http.StatusPermanentRedirect && (keepOld || status != http.StatusNotFound) {
+						t.Errorf("redirect during a rename: got %d", status)
+					}
+				})
+			}
+			wg.Wait()
+		})
+	}
+}
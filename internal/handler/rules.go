@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/useragent"
+)
+
+// maxDestinationRules bounds the rules of a link, they're checked in order on every redirect
+const maxDestinationRules = 20
+
+// languageRegex matches language tags like en, pt-br or zh-hant-tw, lowercased
+var languageRegex = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeRules lowercases the conditions of rules and checks that each one is valid and
+// points to a destination with one of allowedSchemes. Invalid rules are reported as a ValidationError.
+func normalizeRules(rules []internal.DestinationRule, allowedSchemes []string) ([]internal.DestinationRule, error) {
+	if len(rules) > maxDestinationRules {
+		return nil, fmt.Errorf("a link can have at most %d rules", maxDestinationRules)
+	}
+	var errs internal.ValidationError
+	normalized := make([]internal.DestinationRule, 0, len(rules))
+	for i, rule := range rules {
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+		rule.URL = strings.TrimSpace(rule.URL)
+
+		field := fmt.Sprintf("rules[%d]", i)
+		if rule.Device == "" && rule.Language == "" {
+			errs.Add(field, fmt.Errorf("rule %d must have a device or a language", i+1))
+		}
+		if rule.Device != "" && !slices.Contains(useragent.Devices, rule.Device) {
+			errs.Add(field+".device", fmt.Errorf("rule %d: device must be one of %s", i+1, strings.Join(useragent.Devices, ", ")))
+		}
+		if rule.Language != "" && !languageRegex.MatchString(rule.Language) {
+			errs.Add(field+".language", fmt.Errorf("rule %d: language must be a language tag like en or pt-BR", i+1))
+		}
+		if err := validateDestination(rule.URL, allowedSchemes); err != nil {
+			errs.Add(field+".url", fmt.Errorf("rule %d: %w", i+1, err))
+		}
+		normalized = append(normalized, rule)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// validateDestination checks that rawURL is an absolute URL with one of allowedSchemes.
+func validateDestination(rawURL string, allowedSchemes []string) error {
+	if rawURL == "" {
+		return errors.New("url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+		return errors.New("url must be an absolute URL")
+	}
+	if !slices.Contains(allowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("url scheme %q is not allowed", u.Scheme)
+	}
+	return nil
+}
+
+// linkDestinations returns the URLs a link may redirect to, its own and those of its rules.
+func linkDestinations(link string, rules []internal.DestinationRule) []string {
+	urls := []string{link}
+	for _, rule := range rules {
+		urls = append(urls, rule.URL)
+	}
+	return urls
+}
+
+// preferredLanguage returns the language the client of r prefers most by its Accept-Language
+// header, lowercased, or an empty string without one.
+func preferredLanguage(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		// The first of equally preferred languages wins
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
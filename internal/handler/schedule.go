@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+func validateSchedule(activatesAt, expiresAt *time.Time) error {
+	if activatesAt != nil && expiresAt != nil && !activatesAt.Before(*expiresAt) {
+		return errors.New("activates_at must be before expires_at")
+	}
+	return nil
+}
+
+// optionalTime tells a field left out of a JSON object apart from one set to null.
+type optionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *optionalTime) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if bytes.Equal(b, []byte("null")) {
+		o.Value = nil
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
+
+// optionalID tells a field left out of a JSON object apart from one set to null.
+type optionalID struct {
+	Set   bool
+	Value *int64
+}
+
+func (o *optionalID) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if bytes.Equal(b, []byte("null")) {
+		o.Value = nil
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
+
+// UpdateLinkRequest changes the fields it contains, null removes a bound or the campaign.
+type UpdateLinkRequest struct {
+	Description *string      `json:"description"`
+	ActivatesAt optionalTime `json:"activates_at"`
+	ExpiresAt   optionalTime `json:"expires_at"`
+	CampaignID  optionalID   `json:"campaign_id"`
+}
+
+// UpdateLink handles PATCH /api/links/:id - changes the description, schedule and campaign
+func (h *LinkHandler) UpdateLink(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	var req UpdateLinkRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	link, err := h.linkToChange(c, id)
+	if err != nil {
+		return err
+	}
+
+	update := repo.LinkUpdate{
+		Description: link.Description,
+		ActivatesAt: link.ActivatesAt,
+		ExpiresAt:   link.ExpiresAt,
+		CampaignID:  link.CampaignID,
+	}
+	if req.Description != nil {
+		if update.Description, err = normalizeDescription(*req.Description); err != nil {
+			return internal.NewValidationError("description", err.Error())
+		}
+	}
+	if req.ActivatesAt.Set {
+		update.ActivatesAt = req.ActivatesAt.Value
+	}
+	if req.ExpiresAt.Set {
+		update.ExpiresAt = req.ExpiresAt.Value
+	}
+	if req.CampaignID.Set {
+		update.CampaignID = req.CampaignID.Value
+	}
+	if err := validateSchedule(update.ActivatesAt, update.ExpiresAt); err != nil {
+		return internal.NewValidationError("activates_at", err.Error())
+	}
+
+	// Checked when the campaign changes, or when a link already in one is saved again
+	warnings, err := h.checkUTM(ctx, update.CampaignID, link.URL, link.Rules)
+	if err != nil {
+		return err
+	}
+
+	link, err = h.linksRepo.Update(ctx, id, update)
+	if err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return internal.ErrLinkNotFound
+		}
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return internal.NewValidationError("campaign_id", "campaign not found")
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to update link")
+		return err
+	}
+
+	resp := newLinkResponseFor(c, link)
+	resp.Warning = strings.Join(warnings, "; ")
+	return c.JSON(http.StatusOK, resp)
+}
+
+type comingSoonPage struct {
+	ActivatesAt time.Time
+}
+
+// serveInactive answers visitors of links that don't redirect right now, and reports whether it did.
+// Scheduled links look like missing ones unless the coming soon page is enabled.
+func (h *LinkHandler) serveInactive(c echo.Context, link *internal.Link) (bool, error) {
+	switch link.Status(time.Now()) {
+	case internal.LinkExpired:
+		return true, echo.NewHTTPError(http.StatusGone, "link has expired")
+	case internal.LinkScheduled:
+		if !h.settings.Current(c.Request().Context()).ComingSoonPage {
+			// Not a miss, so the visitor isn't tarpitted
+			return true, internal.ErrLinkNotFound
+		}
+		data, err := renderVisitorPage(c.Request().Context(), h.assets, h.branding, "coming-soon.html", comingSoonPage{
+			ActivatesAt: link.ActivatesAt.UTC(),
+		})
+		if err != nil {
+			return true, err
+		}
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return true, c.HTMLBlob(http.StatusNotFound, data)
+	}
+	return false, nil
+}
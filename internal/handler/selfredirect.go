@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/urlutil"
+)
+
+// SelfRedirectPolicy decides what happens to links whose destination is another short link of this instance.
+type SelfRedirectPolicy string
+
+const (
+	// SelfRedirectReject refuses to create such links.
+	SelfRedirectReject SelfRedirectPolicy = "reject"
+	// SelfRedirectWarn creates them with a warning.
+	SelfRedirectWarn SelfRedirectPolicy = "warn"
+	// SelfRedirectAllow creates them, and redirects to them like to any other destination.
+	SelfRedirectAllow SelfRedirectPolicy = "allow"
+)
+
+func ParseSelfRedirectPolicy(s string) (SelfRedirectPolicy, error) {
+	switch policy := SelfRedirectPolicy(strings.ToLower(s)); policy {
+	case SelfRedirectReject, SelfRedirectWarn, SelfRedirectAllow:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid self redirect policy %q, must be one of reject, warn, allow", s)
+}
+
+// maxRedirectChain is how many short links of this instance a redirect follows before giving up
+const maxRedirectChain = 5
+
+var errRedirectLoop = errors.New("redirect loop")
+
+// selfLink returns the domain and slug when rawURL points at a short link of this instance,
+// on the host that received r or on one of its domains.
+func (h *LinkHandler) selfLink(r *http.Request, rawURL string) (domain, slug string, ok bool) {
+	dest, err := url.Parse(rawURL)
+	if err != nil || dest.Host == "" {
+		return "", "", false
+	}
+	origin, err := url.Parse(getOrigin(r))
+	if err != nil {
+		return "", "", false
+	}
+	domain = strings.ToLower(dest.Hostname())
+	if !urlutil.SameHost(dest, origin) && !slices.Contains(h.domains, domain) {
+		return "", "", false
+	}
+
+	slug = strings.TrimPrefix(dest.Path, "/")
+	if slug == "" || !slugRegex.MatchString(slug) {
+		return "", "", false
+	}
+	return domain, slug, true
+}
+
+// resolveSelfRedirects follows destinations that are short links of this instance, so visitors
+// reach the final destination in one redirect. The rules of every link on the way apply to the
+// device and language of the visitor. Cycles and chains longer than maxRedirectChain return errRedirectLoop.
+func (h *LinkHandler) resolveSelfRedirects(ctx context.Context, r *http.Request, link *internal.Link, device, language string) (string, error) {
+	seen := map[int64]bool{link.ID: true}
+	dest := link.Destination(device, language)
+	for hops := 0; ; hops++ {
+		domain, slug, ok := h.selfLink(r, dest)
+		if !ok {
+			return dest, nil
+		}
+		if hops == maxRedirectChain {
+			return "", errRedirectLoop
+		}
+
+		next, err := h.linksRepo.Resolve(ctx, domain, slug)
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			// Not a link, maybe one of the other pages
+			return dest, nil
+		} else if err != nil {
+			return "", err
+		}
+		if seen[next.ID] {
+			return "", errRedirectLoop
+		}
+		seen[next.ID] = true
+		dest = next.Destination(device, language)
+	}
+}
@@ -0,0 +1,176 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// newSelfRedirectServer starts a server with policy and returns a client logged in to it.
+func newSelfRedirectServer(t *testing.T, policy handler.SelfRedirectPolicy) (*http.Client, string) {
+	t.Helper()
+
+	cfg := testutil.ServerConfig(t)
+	cfg.SelfRedirectPolicy = policy
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+	return client, ts.URL
+}
+
+// createLinkAt creates a link through a request for host, and returns the status and warning it got.
+func createLinkAt(t *testing.T, client *http.Client, baseURL, host, slug, url string) (int, string) {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"slug": slug, "url": url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/api/links", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if host != "" {
+		// The client looks up cookies by the host a request claims, so the one of logging in is added here
+		for _, cookie := range client.Jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+		req.Host = host
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		res.Body.Close()
+		return res.StatusCode, ""
+	}
+	var created handler.CreateLinkResponse
+	testutil.DecodeJSON(t, res, &created)
+	return res.StatusCode, created.Warning
+}
+
+func TestSelfRedirectOnCreate(t *testing.T) {
+	client, baseURL := newSelfRedirectServer(t, handler.SelfRedirectReject)
+
+	tests := []struct {
+		name       string
+		host       string
+		url        string
+		wantStatus int
+	}{
+		// The slug of the link being created, see below
+		{"direct self-loop", "", baseURL + "/created-0", http.StatusBadRequest},
+		{"another slug", "", baseURL + "/elsewhere", http.StatusBadRequest},
+		{"not a slug", "", baseURL + "/api/links", http.StatusCreated},
+		{"root", "", baseURL + "/", http.StatusCreated},
+		{"another instance", "", "https://example.com/loop", http.StatusCreated},
+
+		// Hosts compare case-insensitively, with the default port of the scheme filled in
+		{"same host", "short.example", "http://short.example/loop", http.StatusBadRequest},
+		{"host in another case", "short.example", "http://SHORT.Example/loop", http.StatusBadRequest},
+		{"explicit default port", "short.example", "http://short.example:80/loop", http.StatusBadRequest},
+		{"explicit port of the request", "short.example:8080", "http://short.example:8080/loop", http.StatusBadRequest},
+		{"default port of the request", "short.example:80", "http://short.example/loop", http.StatusBadRequest},
+		{"another port", "short.example", "http://short.example:8080/loop", http.StatusCreated},
+		{"another port than the request", "short.example:8080", "http://short.example:8081/loop", http.StatusCreated},
+		{"no port of the request", "short.example:8080", "http://short.example/loop", http.StatusCreated},
+		// Without ports on either side, http and https are the same instance
+		{"other scheme", "short.example", "https://short.example/loop", http.StatusBadRequest},
+		// With one, https means 443, which isn't the 80 of the request
+		{"default port of the other scheme", "short.example", "https://short.example:443/loop", http.StatusCreated},
+		{"subdomain", "short.example", "http://www.short.example/loop", http.StatusCreated},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each link gets a slug of its own, so only its destination can be refused
+			if status, _ := createLinkAt(t, client, baseURL, tt.host, fmt.Sprintf("created-%d", i), tt.url); status != tt.wantStatus {
+				t.Errorf("create to %s through %q: got %d, want %d", tt.url, tt.host, status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSelfRedirectLoops(t *testing.T) {
+	client, baseURL := newSelfRedirectServer(t, handler.SelfRedirectWarn)
+	links := []struct{ slug, url string }{
+		{"self-loop", baseURL + "/self-loop"},
+		{"ping-pong", baseURL + "/pong-ping"},
+		{"pong-ping", baseURL + "/ping-pong"},
+		{"first-hop", baseURL + "/second-hop"},
+		{"second-hop", "https://example.com/end"},
+		{"chain1", baseURL + "/chain2"},
+		{"chain2", baseURL + "/chain3"},
+		{"chain3", baseURL + "/chain4"},
+		{"chain4", baseURL + "/chain5"},
+		{"chain5", baseURL + "/chain6"},
+		{"chain6", baseURL + "/chain7"},
+		{"chain7", "https://example.com/far"},
+		{"unknown", baseURL + "/nothing-here"},
+	}
+	for _, l := range links {
+		status, warning := createLinkAt(t, client, baseURL, "", l.slug, l.url)
+		if status != http.StatusCreated {
+			t.Fatalf("create %s: got %d, want %d", l.slug, status, http.StatusCreated)
+		}
+		want := ""
+		if strings.HasPrefix(l.url, baseURL) {
+			want = "url points at a short link of this instance"
+		}
+		if warning != want {
+			t.Errorf("create %s: got warning %q, want %q", l.slug, warning, want)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		slug         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"direct self-loop", "self-loop", http.StatusLoopDetected, ""},
+		{"two-link cycle", "ping-pong", http.StatusLoopDetected, ""},
+		{"two-link cycle from the other end", "pong-ping", http.StatusLoopDetected, ""},
+		// Chains are followed, so visitors get the final destination in one redirect
+		{"chain", "first-hop", http.StatusPermanentRedirect, "https://example.com/end"},
+		{"chain too long", "chain1", http.StatusLoopDetected, ""},
+		{"chain short enough", "chain3", http.StatusPermanentRedirect, "https://example.com/far"},
+		{"slug of no link", "unknown", http.StatusPermanentRedirect, baseURL + "/nothing-here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := testutil.NewClient(t).Get(baseURL + "/" + tt.slug)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus || res.Header.Get("Location") != tt.wantLocation {
+				t.Errorf("got %d to %q, want %d to %q", res.StatusCode, res.Header.Get("Location"), tt.wantStatus, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSelfRedirectAllowed(t *testing.T) {
+	client, baseURL := newSelfRedirectServer(t, handler.SelfRedirectAllow)
+	if status, warning := createLinkAt(t, client, baseURL, "", "self-loop", baseURL+"/self-loop"); status != http.StatusCreated || warning != "" {
+		t.Fatalf("create: got %d with warning %q, want %d without one", status, warning, http.StatusCreated)
+	}
+
+	// Left to the browser to follow, like any other destination
+	res, err := testutil.NewClient(t).Get(baseURL + "/self-loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect || res.Header.Get("Location") != baseURL+"/self-loop" {
+		t.Errorf("got %d to %q, want %d to %s", res.StatusCode, res.Header.Get("Location"), http.StatusPermanentRedirect, baseURL+"/self-loop")
+	}
+}
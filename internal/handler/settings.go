@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/labstack/echo/v4"
+)
+
+// SettingsHandler exposes the settings that can change without a restart.
+type SettingsHandler struct {
+	store *settings.Store
+}
+
+func NewSettingsHandler(store *settings.Store) *SettingsHandler {
+	return &SettingsHandler{store: store}
+}
+
+type SettingsResponse struct {
+	Settings settings.Settings `json:"settings"`
+	// Defaults come from the environment, and are used for the settings that weren't changed
+	Defaults settings.Settings `json:"defaults"`
+}
+
+type SettingsErrorResponse struct {
+	ErrorResponse
+	// Fields tells why each refused setting is invalid, by its name
+	Fields settings.FieldErrors `json:"fields"`
+}
+
+// GetSettings handles GET /api/settings
+func (h *SettingsHandler) GetSettings(c echo.Context) error {
+	current, err := h.store.Get(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to load settings")
+		return err
+	}
+	return c.JSON(http.StatusOK, SettingsResponse{Settings: current, Defaults: h.store.Defaults()})
+}
+
+// UpdateSettings handles PUT /api/settings - changes only the given settings, null resets one to its default
+func (h *SettingsHandler) UpdateSettings(c echo.Context) error {
+	var changes map[string]json.RawMessage
+	if err := c.Bind(&changes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	current, err := h.store.Update(c.Request().Context(), changes)
+	var fieldErrs settings.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		return c.JSON(http.StatusBadRequest, SettingsErrorResponse{
+			ErrorResponse: ErrorResponse{Code: CodeValidationFailed, Error: "invalid settings", Details: fieldErrs.Details()},
+			Fields:        fieldErrs,
+		})
+	} else if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to save settings")
+		return err
+	}
+
+	logger.FromContext(c.Request().Context()).Info().Interface("settings", current).Msg("settings changed")
+	return c.JSON(http.StatusOK, SettingsResponse{Settings: current, Defaults: h.store.Defaults()})
+}
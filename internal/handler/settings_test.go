@@ -0,0 +1,129 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// visit visits path without following the redirect, and returns the status and Location it got.
+func visit(t *testing.T, baseURL, path string) (int, string) {
+	t.Helper()
+
+	res, err := testutil.NewClient(t).Get(baseURL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("Location")
+}
+
+// settingsResponse is the body of a PUT of settings, whether it's accepted or not.
+type settingsResponse struct {
+	handler.SettingsResponse
+	handler.SettingsErrorResponse
+}
+
+// putSettings changes settings and returns the status and body it got.
+func putSettings(t *testing.T, client *http.Client, baseURL, body string) (int, settingsResponse) {
+	t.Helper()
+
+	status, got := request(t, client, http.MethodPut, baseURL+"/api/settings", body)
+	var res settingsResponse
+	if err := json.Unmarshal([]byte(got), &res); err != nil {
+		t.Fatalf("PUT %s: %v in %s", body, err, got)
+	}
+	return status, res
+}
+
+func TestSettingsChangeWithoutRestart(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+	if status, _ := createLink(t, client, ts.URL, map[string]any{"slug": "promo", "url": "https://example.com/promo"}); status != http.StatusCreated {
+		t.Fatalf("create promo: got %d", status)
+	}
+	activatesAt := time.Now().Add(24 * time.Hour)
+	if status, _ := createLink(t, client, ts.URL, map[string]any{"slug": "launch", "url": "https://example.com/launch", "activates_at": activatesAt}); status != http.StatusCreated {
+		t.Fatalf("create launch: got %d", status)
+	}
+
+	// The defaults of the config
+	if status, location := visit(t, ts.URL, "/promo"); status != http.StatusPermanentRedirect || location != "https://example.com/promo" {
+		t.Fatalf("visit promo: got %d to %q, want %d", status, location, http.StatusPermanentRedirect)
+	}
+	if status, _ := visit(t, ts.URL, "/missing"); status != http.StatusNotFound {
+		t.Fatalf("visit a missing slug: got %d, want %d", status, http.StatusNotFound)
+	}
+	_, notFoundPage := request(t, testutil.NewClient(t), http.MethodGet, ts.URL+"/launch", "")
+
+	status, res := putSettings(t, client, ts.URL, `{"redirect_status": 302, "not_found_url": "https://example.com/404", "coming_soon_page": true}`)
+	if status != http.StatusOK || res.Settings.RedirectStatus != http.StatusFound || res.Defaults.RedirectStatus != http.StatusPermanentRedirect {
+		t.Fatalf("PUT: got %d with %+v", status, res)
+	}
+
+	if status, location := visit(t, ts.URL, "/promo"); status != http.StatusFound || location != "https://example.com/promo" {
+		t.Errorf("visit promo: got %d to %q, want %d", status, location, http.StatusFound)
+	}
+	if status, location := visit(t, ts.URL, "/missing"); status != http.StatusFound || location != "https://example.com/404" {
+		t.Errorf("visit a missing slug: got %d to %q, want %d to the not found URL", status, location, http.StatusFound)
+	}
+	status, body := request(t, testutil.NewClient(t), http.MethodGet, ts.URL+"/launch", "")
+	if status != http.StatusNotFound || body == notFoundPage {
+		t.Errorf("visit launch: got %d with %q, want %d with the coming soon page", status, body, http.StatusNotFound)
+	}
+
+	// Resetting a setting brings its default back, the others stay
+	if status, res := putSettings(t, client, ts.URL, `{"redirect_status": null}`); status != http.StatusOK || res.Settings.NotFoundURL != "https://example.com/404" {
+		t.Fatalf("PUT: got %d with %+v", status, res)
+	}
+	if status, _ := visit(t, ts.URL, "/promo"); status != http.StatusPermanentRedirect {
+		t.Errorf("visit promo after the reset: got %d, want %d", status, http.StatusPermanentRedirect)
+	}
+
+	// GET reads back what was saved
+	status, body = request(t, client, http.MethodGet, ts.URL+"/api/settings", "")
+	var got handler.SettingsResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil || status != http.StatusOK {
+		t.Fatalf("GET: got %d with %s", status, body)
+	}
+	if got.Settings.RedirectStatus != http.StatusPermanentRedirect || got.Settings.NotFoundURL != "https://example.com/404" || !got.Settings.ComingSoonPage {
+		t.Errorf("GET: got %+v", got.Settings)
+	}
+}
+
+func TestSettingsInvalid(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	status, res := putSettings(t, client, ts.URL, `{"redirect_status": 200, "not_found_url": "ftp://example.com", "click_retention_days": -1, "coming_soon_page": true}`)
+	if status != http.StatusBadRequest || res.Code != handler.CodeValidationFailed {
+		t.Fatalf("got %d with code %q, want %d with %q", status, res.Code, http.StatusBadRequest, handler.CodeValidationFailed)
+	}
+	for _, name := range []string{"redirect_status", "not_found_url", "click_retention_days"} {
+		if res.Fields[name] == "" {
+			t.Errorf("no error for %s in %v", name, res.Fields)
+		}
+	}
+	if _, ok := res.Fields["coming_soon_page"]; ok {
+		t.Errorf("got an error for the valid coming_soon_page: %v", res.Fields)
+	}
+
+	// Nothing was changed, not even the valid setting
+	if status, _ := visit(t, ts.URL, "/missing"); status != http.StatusNotFound {
+		t.Errorf("visit a missing slug: got %d, want %d", status, http.StatusNotFound)
+	}
+	status, body := request(t, client, http.MethodGet, ts.URL+"/api/settings", "")
+	var got handler.SettingsResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil || status != http.StatusOK {
+		t.Fatalf("GET: got %d with %s", status, body)
+	}
+	if got.Settings != got.Defaults {
+		t.Errorf("got %+v, want the defaults %+v", got.Settings, got.Defaults)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"cmp"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abdusco/linked/internal/assets"
+	"github.com/labstack/echo/v4"
+)
+
+// Favicon is an icon served instead of the bundled favicon.ico.
+type Favicon struct {
+	Data        []byte
+	ContentType string
+}
+
+// ReadFavicon reads the icon at path, telling its type by the extension or else by the content.
+func ReadFavicon(path string) (*Favicon, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := cmp.Or(mime.TypeByExtension(strings.ToLower(filepath.Ext(path))), http.DetectContentType(data))
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%s is not an image", path)
+	}
+	return &Favicon{Data: data, ContentType: contentType}, nil
+}
+
+// RobotsTxt returns the robots.txt of policy: "disallow" keeps crawlers off the short links, which only
+// redirect, "allow" lets them follow short links but not the API or the dashboard, anything else is the
+// path of a file served as it is. The public directory, when enabled, is open to crawlers either way.
+func RobotsTxt(policy string, directoryEnabled bool) ([]byte, error) {
+	var b strings.Builder
+	switch policy {
+	case "allow":
+		b.WriteString("User-agent: *\nDisallow: /api/\nDisallow: /dashboard\n")
+	case "disallow":
+		b.WriteString("User-agent: *\n")
+		if directoryEnabled {
+			b.WriteString("Allow: /links$\n")
+		}
+		b.WriteString("Disallow: /\n")
+	default:
+		return os.ReadFile(policy)
+	}
+	return []byte(b.String()), nil
+}
+
+// SiteFilesHandler serves the files browsers and crawlers ask every site for. Without it they'd be
+// looked up as slugs, logged as missing links and counted towards the tarpit of their client.
+type SiteFilesHandler struct {
+	assets *assets.Assets
+	// favicon replaces the bundled favicon.ico, nil serves the bundled one
+	favicon *Favicon
+	robots  []byte
+}
+
+func NewSiteFilesHandler(assets *assets.Assets, favicon *Favicon, robots []byte) *SiteFilesHandler {
+	return &SiteFilesHandler{
+		assets:  assets,
+		favicon: favicon,
+		robots:  robots,
+	}
+}
+
+// Favicon handles GET and HEAD /favicon.ico
+func (h *SiteFilesHandler) Favicon(c echo.Context) error {
+	if h.favicon == nil {
+		return h.assets.ServeFile(c, "favicon.ico")
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.Blob(http.StatusOK, h.favicon.ContentType, h.favicon.Data)
+}
+
+// Robots handles GET and HEAD /robots.txt
+func (h *SiteFilesHandler) Robots(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, h.robots)
+}
+
+// WellKnown handles GET and HEAD /.well-known/*, which browsers, apps and scanners probe for files
+// this instance doesn't have. They're answered with 404 right away, without an error in the log.
+func (h *SiteFilesHandler) WellKnown(c echo.Context) error {
+	return c.NoContent(http.StatusNotFound)
+}
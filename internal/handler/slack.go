@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/slack"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// slackCommand is the slash command to set up in the Slack app
+	slackCommand = "/shorten"
+	// maxSlackBodySize bounds a slash command payload, real ones are well under a kilobyte
+	maxSlackBodySize = 16 << 10
+	slackUsage       = "Usage: /shorten <url> [slug]"
+)
+
+// SlackHandler creates links from a Slack slash command. Slack can't log in, so requests
+// are authenticated by the signature of the signing secret of the Slack app instead.
+type SlackHandler struct {
+	links         *LinkHandler
+	signingSecret string
+}
+
+func NewSlackHandler(links *LinkHandler, signingSecret string) *SlackHandler {
+	return &SlackHandler{links: links, signingSecret: signingSecret}
+}
+
+// slackMessage is the response to a slash command, in_channel messages are seen by everyone in the channel
+type slackMessage struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// SlashCommand handles POST /integrations/slack - /shorten <url> [slug]
+func (h *SlackHandler) SlashCommand(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxSlackBodySize+1))
+	if err != nil || len(body) > maxSlackBodySize {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	// The reason is only logged, callers without the secret learn nothing from the response
+	if err := slack.Verify(h.signingSecret, c.Request().Header, body, time.Now()); err != nil {
+		logger.FromContext(ctx).Warn().Err(err).Msg("refusing slack request")
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	// Slack checks the certificate of the endpoint now and then
+	if form.Get("ssl_check") == "1" {
+		return c.NoContent(http.StatusOK)
+	}
+	if form.Get("command") != slackCommand {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown command")
+	}
+
+	args := strings.Fields(form.Get("text"))
+	if len(args) == 0 || len(args) > 2 {
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: slackUsage})
+	}
+	req := CreateLinkRequest{
+		URL:         slackURL(args[0]),
+		Description: fmt.Sprintf("Created in Slack by @%s", form.Get("user_name")),
+	}
+	if len(args) == 2 {
+		req.Slug = args[1]
+	}
+
+	link, err := h.createLink(c, req)
+	if err != nil {
+		// Only the one who ran the command sees what went wrong
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "Couldn't shorten the link: " + err.Error()})
+	}
+
+	logger.FromContext(ctx).Info().
+		Str("slug", link.Slug).
+		Str("team_id", form.Get("team_id")).
+		Str("user_id", form.Get("user_id")).
+		Msg("link created from slack")
+	return c.JSON(http.StatusOK, slackMessage{
+		ResponseType: "in_channel",
+		Text:         fmt.Sprintf("%s → %s", shortURL(getOrigin(c.Request()), link), link.URL),
+	})
+}
+
+// createLink creates the link like CreateLink does for a link without options, returning
+// errors fit to show to the user.
+func (h *SlackHandler) createLink(c echo.Context, req CreateLinkRequest) (*internal.Link, error) {
+	ctx := c.Request().Context()
+
+	if err := h.links.validator.Validate(&req); err != nil {
+		return nil, err
+	}
+	if err := h.links.destinations.Check(req.URL); err != nil {
+		return nil, err
+	}
+	if _, _, ok := h.links.selfLink(c.Request(), req.URL); ok && h.links.selfRedirects == SelfRedirectReject {
+		return nil, errors.New("url points at a short link of this instance")
+	}
+	link, created, err := h.links.createOrGet(ctx, repo.NewLink{
+		Slug:        req.Slug,
+		URL:         req.URL,
+		Description: req.Description,
+	}, func(params repo.NewLink) (*internal.Link, bool, error) {
+		return h.links.linksRepo.CreateOrGet(ctx, params)
+	})
+	if errors.Is(err, internal.ErrSlugExists) || (err == nil && !created && link.URL != req.URL) {
+		return nil, errors.New("slug already exists")
+	} else if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Str("slug", req.Slug).Msg("failed to create link from slack")
+		return nil, errors.New("something went wrong, try again")
+	}
+	if created {
+		h.links.webhooks.LinkCreated(link)
+	}
+	return link, nil
+}
+
+// slackURL unwraps a URL Slack formatted as <https://example.com> or <https://example.com|example.com>,
+// which it does when the command escapes links.
+func slackURL(arg string) string {
+	if inner, ok := strings.CutPrefix(arg, "<"); ok {
+		inner = strings.TrimSuffix(inner, ">")
+		inner, _, _ = strings.Cut(inner, "|")
+		return inner
+	}
+	return arg
+}
@@ -0,0 +1,146 @@
+package handler_test
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/slack"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+const slackSecret = "slack-signing-secret"
+
+// postSlack sends the slash command recorded in testdata/slack/name.form to /integrations/slack,
+// signed with secret as Slack would sign it now.
+func postSlack(t *testing.T, baseURL, name, secret string) (int, string) {
+	t.Helper()
+
+	body, err := os.ReadFile("testdata/slack/" + name + ".form")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/integrations/slack", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(slack.HeaderTimestamp, timestamp)
+	req.Header.Set(slack.HeaderSignature, "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, strings.TrimSpace(string(got))
+}
+
+func TestSlashCommand(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.SlackSigningSecret = slackSecret
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	tests := []struct {
+		payload    string
+		secret     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			payload:    "shorten-slug",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"response_type":"in_channel","text":"http://` + host + `/release-notes → https://example.com/releases/v2"}`,
+		},
+		{
+			// Running it again finds the link of the same URL
+			payload:    "shorten-slug",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"response_type":"in_channel","text":"http://` + host + `/release-notes → https://example.com/releases/v2"}`,
+		},
+		{
+			payload:    "shorten-escaped",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"response_type":"in_channel","text":"http://` + host + `/the-docs → https://example.com/docs"}`,
+		},
+		{
+			payload:    "usage",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"response_type":"ephemeral","text":"Usage: /shorten \u003curl\u003e [slug]"}`,
+		},
+		{
+			payload:    "invalid-url",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"response_type":"ephemeral","text":"Couldn't shorten the link: url must be an absolute URL"}`,
+		},
+		{
+			payload:    "ssl-check",
+			wantStatus: http.StatusOK,
+		},
+		{
+			payload:    "unknown-command",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"unknown command"}`,
+		},
+		{
+			payload:    "shorten",
+			secret:     "another-app",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"error":"unauthorized"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			status, body := postSlack(t, ts.URL, tt.payload, cmp.Or(tt.secret, slackSecret))
+			if status != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("got %d %s\nwant %d %s", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSlashCommandGeneratesSlug(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.SlackSigningSecret = slackSecret
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+
+	status, body := postSlack(t, ts.URL, "shorten", slackSecret)
+	prefix := `{"response_type":"in_channel","text":"` + ts.URL + `/`
+	suffix := ` → https://example.com/releases/v2"}`
+	if status != http.StatusOK || !strings.HasPrefix(body, prefix) || !strings.HasSuffix(body, suffix) {
+		t.Fatalf("got %d %s", status, body)
+	}
+	slug := strings.TrimSuffix(strings.TrimPrefix(body, prefix), suffix)
+	if len(slug) < cfg.SlugMinLength {
+		t.Errorf("generated slug %q is shorter than %d", slug, cfg.SlugMinLength)
+	}
+}
+
+func TestSlashCommandDisabled(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+
+	// Without a signing secret the route isn't there
+	status, _ := postSlack(t, ts.URL, "shorten", "")
+	if status != http.StatusNotFound {
+		t.Errorf("got %d, want %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,60 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+func TestSlugCase(t *testing.T) {
+	for _, caseInsensitive := range []bool{false, true} {
+		t.Run(fmt.Sprintf("case-insensitive %t", caseInsensitive), func(t *testing.T) {
+			sqlDB := testutil.NewDB(t)
+			// Like main, which sets up the indexes before starting the server
+			if err := db.SetSlugCaseInsensitive(context.Background(), sqlDB, caseInsensitive); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testutil.ServerConfig(t)
+			cfg.SlugCaseInsensitive = caseInsensitive
+			ts := testutil.NewServer(t, sqlDB, cfg)
+			client := testutil.NewClient(t)
+			testutil.LogIn(t, client, ts.URL)
+
+			status, created := createLink(t, client, ts.URL, map[string]any{"slug": "Promo", "url": "https://example.com/promo"})
+			if status != http.StatusCreated || created.Slug != "Promo" {
+				t.Fatalf("create Promo: got %d with slug %q, want %d with the slug as typed", status, created.Slug, http.StatusCreated)
+			}
+
+			for _, slug := range []string{"Promo", "promo", "PROMO"} {
+				wantStatus, wantLocation := http.StatusPermanentRedirect, "https://example.com/promo"
+				if slug != "Promo" && !caseInsensitive {
+					wantStatus, wantLocation = http.StatusNotFound, ""
+				}
+				res, err := testutil.NewClient(t).Get(ts.URL + "/" + slug)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				if res.StatusCode != wantStatus || res.Header.Get("Location") != wantLocation {
+					t.Errorf("visit %s: got %d to %q, want %d to %q", slug, res.StatusCode, res.Header.Get("Location"), wantStatus, wantLocation)
+				}
+			}
+
+			// Another destination under a slug differing only in case
+			wantStatus, wantLinks := http.StatusCreated, 2
+			if caseInsensitive {
+				wantStatus, wantLinks = http.StatusConflict, 1
+			}
+			if status, _ := createLink(t, client, ts.URL, map[string]any{"slug": "PROMO", "url": "https://example.com/other"}); status != wantStatus {
+				t.Errorf("create PROMO: got %d, want %d", status, wantStatus)
+			}
+			if n := countRows(t, sqlDB, "SELECT COUNT(*) FROM links WHERE lower(slug) = 'promo'"); n != wantLinks {
+				t.Errorf("got %d links of the slug promo in any case, want %d", n, wantLinks)
+			}
+		})
+	}
+}
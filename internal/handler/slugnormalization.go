@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SlugNormalization decides what happens to requests for a slug that only matches a link once
+// it's cleaned up, like "/promo1/" or "/promo1." for promo1, as some apps mangle links they share.
+type SlugNormalization string
+
+const (
+	// SlugNormalizationCanonical redirects to the short link with its proper slug, which then redirects as usual.
+	SlugNormalizationCanonical SlugNormalization = "canonical"
+	// SlugNormalizationDestination redirects straight to the destination.
+	SlugNormalizationDestination SlugNormalization = "destination"
+	// SlugNormalizationOff only matches slugs as they are requested.
+	SlugNormalizationOff SlugNormalization = "off"
+)
+
+func ParseSlugNormalization(s string) (SlugNormalization, error) {
+	switch normalization := SlugNormalization(strings.ToLower(s)); normalization {
+	case SlugNormalizationCanonical, SlugNormalizationDestination, SlugNormalizationOff:
+		return normalization, nil
+	}
+	return "", fmt.Errorf("invalid slug normalization %q, must be one of canonical, destination, off", s)
+}
+
+// slugTrailingPunctuation is what ends up after a link pasted at the end of a sentence.
+// Slugs can't contain any of it, so it's never part of the slug.
+const slugTrailingPunctuation = ".,;:!?"
+
+// normalizeSlug decodes a slug as it appears in the path and strips a trailing slash and punctuation.
+// Hyphens and underscores are valid at the end of a slug and are kept.
+func normalizeSlug(slug string) string {
+	if decoded, err := url.PathUnescape(slug); err == nil {
+		slug = decoded
+	}
+	slug = strings.TrimSuffix(slug, "/")
+	return strings.TrimRight(slug, slugTrailingPunctuation)
+}
+
+// requestedSlug is the slug of a request to /:slug or /:slug/, keeping the trailing slash for normalizeSlug.
+func requestedSlug(c echo.Context) string {
+	slug := c.Param("slug")
+	if strings.HasSuffix(c.Path(), "/") {
+		slug += "/"
+	}
+	return slug
+}
+
+// redirectToCanonical sends the visitor to the short link of slug, keeping the query so UTM parameters
+// and the like survive. It's permanent, the mangled slug can never become a link of its own.
+func redirectToCanonical(c echo.Context, slug string) error {
+	target := getOrigin(c.Request()) + "/" + url.PathEscape(slug)
+	if query := c.Request().URL.RawQuery; query != "" {
+		target += "?" + query
+	}
+	return c.Redirect(http.StatusPermanentRedirect, target)
+}
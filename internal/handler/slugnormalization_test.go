@@ -0,0 +1,72 @@
+package handler
+
+import "testing"
+
+func TestNormalizeSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want string
+	}{
+		{"promo1", "promo1"},
+		{"promo1/", "promo1"},
+		{"promo1.", "promo1"},
+		{"promo1,", "promo1"},
+		{"promo1;", "promo1"},
+		{"promo1:", "promo1"},
+		{"promo1!", "promo1"},
+		{"promo1?", "promo1"},
+		{"promo1...", "promo1"},
+		{"promo1!?", "promo1"},
+		{"promo1./", "promo1"},
+		// A single trailing slash only
+		{"promo1//", "promo1/"},
+		// Punctuation after the slash is stripped with it
+		{"promo1/.", "promo1/"},
+		{"promo1%2E", "promo1"},
+		{"promo1%2F", "promo1"},
+		{"pr%6Fmo1", "promo1"},
+		// Invalid escapes are left as they are
+		{"promo1%zz", "promo1%zz"},
+		// Hyphens and underscores can end a slug
+		{"promo_", "promo_"},
+		{"promo-", "promo-"},
+		{"promo__", "promo__"},
+		{"promo-_", "promo-_"},
+		{"promo_/", "promo_"},
+		{"promo-.", "promo-"},
+		{"promo_%2E", "promo_"},
+		{"_", "_"},
+		{"-", "-"},
+		// Only the end is cleaned up
+		{"pro.mo", "pro.mo"},
+		{".promo", ".promo"},
+		{"", ""},
+		{"/", ""},
+		{"...", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeSlug(tt.slug); got != tt.want {
+			t.Errorf("normalizeSlug(%q) = %q, want %q", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestParseSlugNormalization(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    SlugNormalization
+		wantErr bool
+	}{
+		{s: "canonical", want: SlugNormalizationCanonical},
+		{s: "Destination", want: SlugNormalizationDestination},
+		{s: "OFF", want: SlugNormalizationOff},
+		{s: "strict", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSlugNormalization(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSlugNormalization(%q) = %q, %v, want %q, error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/snapshot"
+	"github.com/abdusco/linked/internal/timeout"
+	"github.com/labstack/echo/v4"
+)
+
+type SnapshotHandler struct {
+	linksRepo     *repo.LinksRepo
+	snapshotsRepo *repo.SnapshotsRepo
+	snapshotter   *snapshot.Snapshotter
+}
+
+func NewSnapshotHandler(linksRepo *repo.LinksRepo, snapshotsRepo *repo.SnapshotsRepo, snapshotter *snapshot.Snapshotter) *SnapshotHandler {
+	return &SnapshotHandler{
+		linksRepo:     linksRepo,
+		snapshotsRepo: snapshotsRepo,
+		snapshotter:   snapshotter,
+	}
+}
+
+type ListSnapshotsResponse struct {
+	Snapshots []*internal.Snapshot `json:"snapshots"`
+}
+
+// ListSnapshots handles GET /api/links/:id/snapshots
+func (h *SnapshotHandler) ListSnapshots(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	if _, err := h.linksRepo.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	snapshots, err := h.snapshotsRepo.ListForLink(ctx, id)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to list snapshots")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ListSnapshotsResponse{Snapshots: snapshots})
+}
+
+// TakeSnapshot handles POST /api/links/:id/snapshots - snapshots the destination right away
+func (h *SnapshotHandler) TakeSnapshot(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	link, err := h.linksRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, link); err != nil {
+		return err
+	}
+	if !link.Snapshot {
+		return echo.NewHTTPError(http.StatusBadRequest, "snapshots are not enabled for this link")
+	}
+
+	timeout.SetPhase(ctx, "fetch destination")
+	snap, err := h.snapshotter.Take(ctx, link)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to take snapshot")
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, snap)
+}
+
+// DownloadSnapshot handles GET /api/links/:id/snapshots/:snapshotId
+func (h *SnapshotHandler) DownloadSnapshot(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+	snapshotID, err := strconv.ParseInt(c.Param("snapshotId"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid snapshot id")
+	}
+
+	snap, err := h.snapshotsRepo.Get(ctx, id, snapshotID)
+	if err != nil {
+		return err
+	}
+	if len(snap.Body) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "snapshot has no stored content")
+	}
+
+	html, err := snapshot.Decompress(snap.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decompress snapshot %d: %w", snap.ID, err)
+	}
+
+	// Never render third-party HTML on our origin, always hand it out as a download
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="snapshot-%d.html"`, snap.ID))
+	c.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	return c.Blob(http.StatusOK, "application/octet-stream", html)
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultReferrersLimit = 10
+	maxReferrersLimit     = 100
+)
+
+// ReferrerStats handles GET /api/links/:id/stats/referrers?window=7d&limit=10&include_methods=GET,HEAD
+func (h *LinkHandler) ReferrerStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	since, err := parseWindow(c.QueryParam("window"))
+	if err != nil {
+		return internal.NewValidationError("window", err.Error())
+	}
+
+	methods, err := parseMethods(c.QueryParam("include_methods"))
+	if err != nil {
+		return internal.NewValidationError("include_methods", err.Error())
+	}
+
+	limit := defaultReferrersLimit
+	if s := c.QueryParam("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxReferrersLimit {
+			return internal.NewValidationError("limit", fmt.Sprintf("limit must be between 1 and %d", maxReferrersLimit))
+		}
+	}
+
+	if _, err := h.linksRepo.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	stats, err := h.clicksRepo.GetReferrerStats(ctx, id, since, limit, methods)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to get referrer stats")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// CountryStats handles GET /api/links/:id/stats/countries?window=7d&include_methods=GET,HEAD
+func (h *LinkHandler) CountryStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	since, err := parseWindow(c.QueryParam("window"))
+	if err != nil {
+		return internal.NewValidationError("window", err.Error())
+	}
+
+	methods, err := parseMethods(c.QueryParam("include_methods"))
+	if err != nil {
+		return internal.NewValidationError("include_methods", err.Error())
+	}
+
+	if _, err := h.linksRepo.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	stats, err := h.clicksRepo.GetCountryStats(ctx, id, since, methods)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to get country stats")
+		return err
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// clickMethods are the request methods clicks are recorded with
+var clickMethods = []string{http.MethodGet, http.MethodHead}
+
+// parseMethods parses a comma-separated list of the methods of clicks to include in stats.
+// An empty list is nil, which includes only the clicks counting as visits.
+func parseMethods(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var methods []string
+	for _, method := range strings.Split(s, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !slices.Contains(clickMethods, method) {
+			return nil, fmt.Errorf("invalid method %q, must be one of %s", method, strings.Join(clickMethods, ", "))
+		}
+		methods = append(methods, method)
+	}
+	return methods, nil
+}
+
+// parseWindow turns a window like "24h", "7d" or "30d" into the start of that window.
+// An empty window means all time and yields the zero time.
+func parseWindow(window string) (time.Time, error) {
+	if window == "" {
+		return time.Time{}, nil
+	}
+
+	var d time.Duration
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid window %q", window)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(window)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid window %q", window)
+		}
+	}
+
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("window must be positive")
+	}
+	return time.Now().Add(-d), nil
+}
+
+type StatsTokenResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// CreateStatsToken handles POST /api/links/:id/stats-token. It replaces any existing token.
+func (h *LinkHandler) CreateStatsToken(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	if _, err := h.linkToChange(c, id); err != nil {
+		return err
+	}
+
+	token, err := h.linksRepo.RotateStatsToken(ctx, id)
+	if err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return internal.ErrLinkNotFound
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to create stats token")
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, StatsTokenResponse{
+		Token: token,
+		URL:   publicStatsURL(getOrigin(c.Request()), token),
+	})
+}
+
+// RevokeStatsToken handles DELETE /api/links/:id/stats-token
+func (h *LinkHandler) RevokeStatsToken(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid link id")
+	}
+
+	if _, err := h.linkToChange(c, id); err != nil {
+		return err
+	}
+
+	if err := h.linksRepo.RevokeStatsToken(ctx, id); err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) {
+			return internal.ErrLinkNotFound
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to revoke stats token")
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type PurgeClicksResponse struct {
+	Purged int64     `json:"purged"`
+	Before time.Time `json:"before"`
+}
+
+// PurgeClicks handles POST /api/admin/purge-clicks?before=2024-01-01 - deletes older clicks,
+// keeping their daily counts so totals don't change
+func (h *LinkHandler) PurgeClicks(c echo.Context) error {
+	before, err := time.Parse(time.DateOnly, c.QueryParam("before"))
+	if err != nil {
+		return internal.NewValidationError("before", "before must be a date like 2024-01-01")
+	}
+	if before.After(time.Now()) {
+		return internal.NewValidationError("before", "before must not be in the future")
+	}
+
+	purged, err := h.clicksRepo.PurgeBefore(c.Request().Context(), before)
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("purged", purged).Msg("failed to purge clicks")
+		return err
+	}
+	logger.FromContext(c.Request().Context()).Info().Int64("purged", purged).Time("before", before).Msg("purged clicks")
+
+	return c.JSON(http.StatusOK, PurgeClicksResponse{Purged: purged, Before: before})
+}
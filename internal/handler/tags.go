@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxTagLength   = 32
+	maxTagsPerLink = 20
+)
+
+// normalizeTags trims and lowercases tags, drops duplicates and sorts them.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTagsPerLink {
+		return nil, fmt.Errorf("a link can have at most %d tags", maxTagsPerLink)
+	}
+
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags must not be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q must be at most %d characters long", tag, maxTagLength)
+		}
+		normalized = append(normalized, tag)
+	}
+
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+type ListTagsResponse struct {
+	Tags []TagResponse `json:"tags"`
+}
+
+type TagResponse struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// ListTags handles GET /api/tags
+func (h *LinkHandler) ListTags(c echo.Context) error {
+	tags, err := h.linksRepo.ListTags(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to list tags")
+		return err
+	}
+
+	resp := ListTagsResponse{Tags: make([]TagResponse, len(tags))}
+	for i, tag := range tags {
+		resp.Tags[i] = TagResponse{Tag: tag.Tag, Links: tag.Links}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+// authorizeChange returns 403 unless the user of the request may modify the link.
+func authorizeChange(c echo.Context, link *internal.Link) error {
+	if user := auth.UserFrom(c); user == nil || !user.CanModify(link) {
+		return echo.NewHTTPError(http.StatusForbidden, "only the owner of the link or an admin can change it")
+	}
+	return nil
+}
+
+// ownerID is the user of the request, who owns the links it creates.
+func ownerID(c echo.Context) *int64 {
+	if user := auth.UserFrom(c); user != nil {
+		return &user.ID
+	}
+	return nil
+}
+
+type UserHandler struct {
+	usersRepo *repo.UsersRepo
+}
+
+func NewUserHandler(usersRepo *repo.UsersRepo) *UserHandler {
+	return &UserHandler{usersRepo: usersRepo}
+}
+
+type UserRequest struct {
+	// Username can't be changed once the user is created
+	Username string `json:"username"`
+	// Password is required on create, and kept on update when empty
+	Password string        `json:"password"`
+	Role     internal.Role `json:"role"`
+}
+
+func (r *UserRequest) validate(create bool) error {
+	if create {
+		r.Username = strings.TrimSpace(r.Username)
+		if r.Username == "" {
+			return internal.NewValidationError("username", "username is required")
+		}
+		if strings.Contains(r.Username, ":") {
+			return internal.NewValidationError("username", "username must not contain a colon")
+		}
+		if r.Password == "" {
+			return internal.NewValidationError("password", "password is required")
+		}
+	}
+	if r.Password != "" && len(r.Password) < auth.MinPasswordLength {
+		return internal.NewValidationError("password", fmt.Sprintf("password must be at least %d characters long", auth.MinPasswordLength))
+	}
+	if !slices.Contains(internal.Roles, r.Role) {
+		return internal.NewValidationError("role", fmt.Sprintf("role must be one of %v", internal.Roles))
+	}
+	return nil
+}
+
+type ListUsersResponse struct {
+	Users []*internal.User `json:"users"`
+}
+
+// Me handles GET /api/auth/me - the user making the request
+func (h *UserHandler) Me(c echo.Context) error {
+	return c.JSON(http.StatusOK, auth.UserFrom(c))
+}
+
+// CreateUser handles POST /api/users
+func (h *UserHandler) CreateUser(c echo.Context) error {
+	var req UserRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.validate(true); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	user, err := h.usersRepo.Create(c.Request().Context(), repo.UserParams{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Role:         req.Role,
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrUsernameTaken) {
+			return internal.ErrUsernameTaken
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Str("username", req.Username).Msg("failed to create user")
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+// ListUsers handles GET /api/users
+func (h *UserHandler) ListUsers(c echo.Context) error {
+	users, err := h.usersRepo.List(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to list users")
+		return err
+	}
+	return c.JSON(http.StatusOK, ListUsersResponse{Users: users})
+}
+
+// GetUser handles GET /api/users/:id
+func (h *UserHandler) GetUser(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	user, err := h.usersRepo.Get(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// UpdateUser handles PUT /api/users/:id - changes the role, and the password when given
+func (h *UserHandler) UpdateUser(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	var req UserRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.validate(false); err != nil {
+		return err
+	}
+
+	params := repo.UserParams{Role: req.Role}
+	if req.Password != "" {
+		if params.PasswordHash, err = auth.HashPassword(req.Password); err != nil {
+			return err
+		}
+	}
+	user, err := h.usersRepo.Update(c.Request().Context(), id, params)
+	if err != nil {
+		if errors.Is(err, internal.ErrUserNotFound) || errors.Is(err, internal.ErrLastAdmin) {
+			return err
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to update user")
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser handles DELETE /api/users/:id - their links stay, without an owner
+func (h *UserHandler) DeleteUser(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	if err := h.usersRepo.Delete(c.Request().Context(), id); err != nil {
+		if errors.Is(err, internal.ErrUserNotFound) || errors.Is(err, internal.ErrLastAdmin) {
+			return err
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to delete user")
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
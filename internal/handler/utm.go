@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/utm"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// UTMCheckPolicy decides what happens to links whose destination disagrees with the UTM parameters their campaign expects.
+type UTMCheckPolicy string
+
+const (
+	// UTMCheckReject refuses to create or update such links.
+	UTMCheckReject UTMCheckPolicy = "reject"
+	// UTMCheckWarn saves them with a warning.
+	UTMCheckWarn UTMCheckPolicy = "warn"
+	// UTMCheckOff doesn't check them, the issues are still reported for the campaign.
+	UTMCheckOff UTMCheckPolicy = "off"
+)
+
+func ParseUTMCheckPolicy(s string) (UTMCheckPolicy, error) {
+	switch policy := UTMCheckPolicy(strings.ToLower(s)); policy {
+	case UTMCheckReject, UTMCheckWarn, UTMCheckOff:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid UTM check policy %q, must be one of reject, warn, off", s)
+}
+
+// checkUTM compares the destinations of a link in the campaign campaignID with the UTM parameters
+// the campaign expects, and returns the warnings about them. With UTMCheckReject, it returns an
+// error instead. A nil campaignID is no campaign and nothing to check.
+func (h *LinkHandler) checkUTM(ctx context.Context, campaignID *int64, link string, rules []internal.DestinationRule) ([]string, error) {
+	if campaignID == nil || h.utmCheck == UTMCheckOff {
+		return nil, nil
+	}
+	campaign, err := h.campaignsRepo.Get(ctx, *campaignID)
+	if err != nil {
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return nil, internal.NewValidationError("campaign_id", "campaign not found")
+		}
+		return nil, err
+	}
+
+	var warnings []string
+	for i, dest := range linkDestinations(link, rules) {
+		issues := utm.Check(dest, campaign.UTM)
+		if len(issues) == 0 {
+			continue
+		}
+		field := "url"
+		if i > 0 {
+			field = fmt.Sprintf("url of rule %d", i)
+		}
+		message := fmt.Sprintf("%s doesn't match the UTM parameters of campaign %q: %s", field, campaign.Name, strings.Join(lo.Map(issues, func(issue utm.Issue, _ int) string { return issue.String() }), ", "))
+		if h.utmCheck == UTMCheckReject {
+			return nil, newAPIError(http.StatusUnprocessableEntity, CodeUTMMismatch, message)
+		}
+		warnings = append(warnings, message)
+	}
+	return warnings, nil
+}
+
+// LinkUTMIssues are the issues of a destination of a link with the UTM parameters of its campaign.
+type LinkUTMIssues struct {
+	LinkID int64  `json:"link_id"`
+	Slug   string `json:"slug"`
+	// URL is the destination with the issues, the one of the link or of one of its rules
+	URL    string      `json:"url"`
+	Issues []utm.Issue `json:"issues"`
+}
+
+type UTMIssuesResponse struct {
+	Links []LinkUTMIssues `json:"links"`
+}
+
+// UTMIssues handles GET /api/campaigns/:id/utm-issues - the links whose destinations disagree with
+// the UTM parameters of the campaign, whatever the check policy was when they were saved
+func (h *CampaignHandler) UTMIssues(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid campaign id")
+	}
+
+	campaign, err := h.campaignsRepo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	links, err := h.linksRepo.List(ctx, repo.LinkFilter{CampaignID: &id}, repo.LinkOrder{}, repo.LinkPage{})
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to list campaign links")
+		return err
+	}
+
+	resp := UTMIssuesResponse{Links: []LinkUTMIssues{}}
+	for _, link := range links {
+		for _, dest := range linkDestinations(link.URL, link.Rules) {
+			if issues := utm.Check(dest, campaign.UTM); len(issues) > 0 {
+				resp.Links = append(resp.Links, LinkUTMIssues{LinkID: link.ID, Slug: link.Slug, URL: dest, Issues: issues})
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
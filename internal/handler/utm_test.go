@@ -0,0 +1,142 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/testutil"
+	"github.com/abdusco/linked/internal/utm"
+)
+
+// newUTMCampaign starts a server checking UTM parameters with policy, and creates a campaign expecting utm_source=newsletter.
+func newUTMCampaign(t *testing.T, policy handler.UTMCheckPolicy) (*http.Client, string, int64) {
+	t.Helper()
+
+	cfg := testutil.ServerConfig(t)
+	cfg.UTMCheckPolicy = policy
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	res := testutil.PostJSON(t, client, ts.URL+"/api/campaigns", map[string]any{
+		"name": "Fall launch",
+		"utm":  map[string]string{"utm_source": "newsletter"},
+	})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create campaign: got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	var campaign internal.Campaign
+	testutil.DecodeJSON(t, res, &campaign)
+	if campaign.UTM["utm_source"] != "newsletter" {
+		t.Fatalf("created campaign expects %v, want utm_source=newsletter", campaign.UTM)
+	}
+	return client, ts.URL, campaign.ID
+}
+
+func TestCreateLinkUTMWarn(t *testing.T) {
+	client, baseURL, campaignID := newUTMCampaign(t, handler.UTMCheckWarn)
+
+	res := testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{
+		"slug": "utm-warn", "url": "https://example.com/?utm_source=ads", "campaign_id": campaignID,
+	})
+	var resp handler.CreateLinkResponse
+	testutil.DecodeJSON(t, res, &resp)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	if !strings.Contains(resp.Warning, `utm_source is "ads", the campaign expects "newsletter"`) {
+		t.Errorf("got warning %q, want the conflicting utm_source", resp.Warning)
+	}
+
+	res = testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{
+		"slug": "utm-fine", "url": "https://example.com/?utm_source=newsletter", "campaign_id": campaignID,
+	})
+	resp = handler.CreateLinkResponse{}
+	testutil.DecodeJSON(t, res, &resp)
+	if res.StatusCode != http.StatusCreated || resp.Warning != "" {
+		t.Errorf("consistent link: got %d with warning %q, want %d without one", res.StatusCode, resp.Warning, http.StatusCreated)
+	}
+}
+
+func TestCreateLinkUTMReject(t *testing.T) {
+	client, baseURL, campaignID := newUTMCampaign(t, handler.UTMCheckReject)
+
+	res := testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{
+		"slug": "utm-reject", "url": "https://example.com/", "campaign_id": campaignID,
+	})
+	var resp handler.ErrorResponse
+	testutil.DecodeJSON(t, res, &resp)
+	if res.StatusCode != http.StatusUnprocessableEntity || resp.Code != handler.CodeUTMMismatch {
+		t.Errorf("got %d %s, want %d %s", res.StatusCode, resp.Code, http.StatusUnprocessableEntity, handler.CodeUTMMismatch)
+	}
+
+	// Without the campaign, the same destination is fine
+	res = testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{
+		"slug": "utm-reject", "url": "https://example.com/",
+	})
+	var created handler.CreateLinkResponse
+	testutil.DecodeJSON(t, res, &created)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("without a campaign: got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	// Nor can it be moved into the campaign
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/api/links/%d", baseURL, created.Link.ID), strings.NewReader(fmt.Sprintf(`{"campaign_id":%d}`, campaignID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = handler.ErrorResponse{}
+	testutil.DecodeJSON(t, res, &resp)
+	if res.StatusCode != http.StatusUnprocessableEntity || resp.Code != handler.CodeUTMMismatch {
+		t.Errorf("moving into the campaign: got %d %s, want %d %s", res.StatusCode, resp.Code, http.StatusUnprocessableEntity, handler.CodeUTMMismatch)
+	}
+}
+
+func TestCampaignUTMIssues(t *testing.T) {
+	client, baseURL, campaignID := newUTMCampaign(t, handler.UTMCheckOff)
+
+	for slug, url := range map[string]string{
+		"utm-consistent": "https://example.com/?utm_source=newsletter",
+		"utm-missing":    "https://example.com/",
+		"utm-extra":      "https://example.com/?utm_source=newsletter&utm_medium=email",
+	} {
+		res := testutil.PostJSON(t, client, baseURL+"/api/links", map[string]any{"slug": slug, "url": url, "campaign_id": campaignID})
+		res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("create %s: got %d, want %d", slug, res.StatusCode, http.StatusCreated)
+		}
+	}
+
+	res, err := client.Get(fmt.Sprintf("%s/api/campaigns/%d/utm-issues", baseURL, campaignID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp handler.UTMIssuesResponse
+	testutil.DecodeJSON(t, res, &resp)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	got := map[string][]utm.Issue{}
+	for _, link := range resp.Links {
+		got[link.Slug] = link.Issues
+	}
+	want := map[string]utm.Kind{"utm-missing": utm.Missing, "utm-extra": utm.Extra}
+	if len(got) != len(want) {
+		t.Errorf("got issues for %v, want them for %v", got, want)
+	}
+	for slug, kind := range want {
+		if issues := got[slug]; len(issues) != 1 || issues[0].Kind != kind {
+			t.Errorf("%s: got %+v, want one %s issue", slug, issues, kind)
+		}
+	}
+}
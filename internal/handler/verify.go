@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+)
+
+const (
+	verifyTimeout      = 5 * time.Second
+	verifyMaxRedirects = 2
+)
+
+// Verification is the outcome of following a link right after it's created, like its first visitor would.
+type Verification struct {
+	// Passed is whether every check passed
+	Passed bool                `json:"passed"`
+	Checks []VerificationCheck `json:"checks"`
+}
+
+type VerificationCheck struct {
+	// Name is resolves, active or reachable
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Detail says why the check failed
+	Detail string `json:"detail,omitempty"`
+}
+
+func (v *Verification) add(name, failure string) {
+	v.Checks = append(v.Checks, VerificationCheck{Name: name, Passed: failure == "", Detail: failure})
+	v.Passed = v.Passed && failure == ""
+}
+
+// errVerificationFailed rolls back a strictly verified link
+var errVerificationFailed = errors.New("link failed verification")
+
+// verifyLink looks up the slug of a just created link the way Redirect does, in tx when it's
+// not nil, and checks the link would redirect. unreachable is the outcome of checkReachable on
+// its destination, which is checked before the link is created to keep the transaction short.
+func (h *LinkHandler) verifyLink(ctx context.Context, r *http.Request, tx *repo.Tx, link *internal.Link, unreachable string) *Verification {
+	v := &Verification{Passed: true}
+
+	host := cmp.Or(link.Domain, requestHost(r))
+	var resolved *internal.Link
+	var err error
+	if tx != nil {
+		resolved, err = h.linksRepo.ResolveTx(ctx, tx, host, link.Slug)
+	} else {
+		resolved, err = h.linksRepo.Resolve(ctx, host, link.Slug)
+	}
+	switch {
+	case err != nil:
+		v.add("resolves", fmt.Sprintf("slug doesn't resolve: %v", err))
+	case resolved.ID != link.ID:
+		// A link on the visitor's domain wins over one on any domain
+		v.add("resolves", fmt.Sprintf("slug leads to link %d on %s instead", resolved.ID, host))
+	default:
+		v.add("resolves", "")
+	}
+
+	switch link.Status(time.Now()) {
+	case internal.LinkScheduled:
+		v.add("active", "link only redirects from "+link.ActivatesAt.UTC().Format(time.RFC3339))
+	case internal.LinkExpired:
+		v.add("active", "link has expired")
+	default:
+		v.add("active", "")
+	}
+
+	v.add("reachable", unreachable)
+	return v
+}
+
+// checkReachable requests the destination without its body and describes why it looks broken,
+// or returns an empty string when it answers with a success or redirect status.
+func (h *LinkHandler) checkReachable(ctx context.Context, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	status, err := h.probe(ctx, http.MethodHead, rawURL)
+	// Some servers don't implement HEAD
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = h.probe(ctx, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		return fmt.Sprintf("destination is unreachable: %v", err)
+	}
+	if status >= http.StatusBadRequest {
+		return fmt.Sprintf("destination responded with status %d", status)
+	}
+	return ""
+}
+
+func (h *LinkHandler) probe(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "linked-verifier")
+
+	resp, err := h.verifyClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Only the status matters, but draining a little lets the connection be reused
+	_, _ = io.CopyN(io.Discard, resp.Body, 4<<10)
+	return resp.StatusCode, nil
+}
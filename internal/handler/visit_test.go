@@ -0,0 +1,108 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+	"github.com/samber/lo"
+)
+
+func TestVisitWithoutRecording(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	promo := f.Link(t, func(l *repo.NewLink) { l.Slug = "promo"; l.URL = "https://example.com/promo" })
+	// Followed too, unlike by visitors
+	launch := f.Link(t, func(l *repo.NewLink) {
+		l.Slug = "launch"
+		l.URL = "https://example.com/launch"
+		l.ActivatesAt = lo.ToPtr(time.Now().Add(24 * time.Hour))
+	})
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	for _, link := range []*internal.Link{promo, launch} {
+		res, err := client.Get(fmt.Sprintf("%s/api/links/%d/visit", ts.URL, link.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusFound || res.Header.Get("Location") != link.URL {
+			t.Errorf("visit %s: got %d to %q, want %d to %s", link.Slug, res.StatusCode, res.Header.Get("Location"), http.StatusFound, link.URL)
+		}
+		if cacheControl := res.Header.Get("Cache-Control"); cacheControl != "no-store" {
+			t.Errorf("visit %s: got Cache-Control %q, want no-store", link.Slug, cacheControl)
+		}
+	}
+	if n := countRows(t, f.DB, "SELECT COUNT(*) FROM clicks"); n != 0 {
+		t.Errorf("got %d clicks, want none", n)
+	}
+
+	if status, _ := request(t, client, http.MethodGet, ts.URL+"/api/links/999/visit", ""); status != http.StatusNotFound {
+		t.Errorf("visit a missing link: got %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestVisitUnauthorized(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t, func(l *repo.NewLink) { l.Slug = "promo" })
+	ts := testutil.NewServer(t, f.DB, testutil.ServerConfig(t))
+
+	// Without logging in, or with a cookie that isn't a session, there's nothing to follow
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":      nil,
+		"forged session": {Name: "auth_token", Value: "forged"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/links/%d/visit", ts.URL, link.ID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res, err := testutil.NewClient(t).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized || res.Header.Get("Location") != "" {
+			t.Errorf("%s: got %d to %q, want %d", name, res.StatusCode, res.Header.Get("Location"), http.StatusUnauthorized)
+		}
+	}
+
+	// Visitors can't opt out of being recorded either
+	res, err := testutil.NewClient(t).Get(ts.URL + "/promo?notrack=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("visit promo: got %d, want %d", res.StatusCode, http.StatusPermanentRedirect)
+	}
+	if n := countRows(t, f.DB, "SELECT COUNT(*) FROM clicks WHERE link_id = ?", link.ID); n != 1 {
+		t.Errorf("got %d clicks, want 1", n)
+	}
+}
+
+func TestVisitOutsideScanBudget(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.ScanBudget = 2
+	cfg.ScanTarpitDelay = 500 * time.Millisecond
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+	client := testutil.NewClient(t)
+	testutil.LogIn(t, client, ts.URL)
+
+	// Links deleted meanwhile aren't guesses of the one testing them
+	for id := range cfg.ScanBudget + 2 {
+		if status, _ := request(t, client, http.MethodGet, fmt.Sprintf("%s/api/links/%d/visit", ts.URL, 1000+id), ""); status != http.StatusNotFound {
+			t.Fatalf("visit a missing link: got %d, want %d", status, http.StatusNotFound)
+		}
+	}
+	if status, took := getWithForwarding(t, ts.URL+"/missing-slug", ""); status != http.StatusNotFound || took >= cfg.ScanTarpitDelay {
+		t.Errorf("miss within the budget: got %d after %s", status, took)
+	}
+}
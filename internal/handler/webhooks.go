@@ -0,0 +1,185 @@
+package handler
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/labstack/echo/v4"
+)
+
+const webhookDeliveriesLimit = 50
+
+type WebhookHandler struct {
+	webhooksRepo *repo.WebhooksRepo
+}
+
+func NewWebhookHandler(webhooksRepo *repo.WebhooksRepo) *WebhookHandler {
+	return &WebhookHandler{webhooksRepo: webhooksRepo}
+}
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+	// Secret signs the payloads, one is generated on create when empty and kept on update
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func (r *WebhookRequest) Validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return internal.NewValidationError("url", "url must be an absolute http or https URL")
+	}
+	if len(r.Events) == 0 {
+		return internal.NewValidationError("events", "at least one event is required")
+	}
+	for _, event := range r.Events {
+		if !slices.Contains(internal.WebhookEvents, event) {
+			return internal.NewValidationError("events", fmt.Sprintf("unknown event %q, must be one of %v", event, internal.WebhookEvents))
+		}
+	}
+	slices.Sort(r.Events)
+	r.Events = slices.Compact(r.Events)
+	return nil
+}
+
+// CreateWebhookResponse is the only response that includes the secret.
+type CreateWebhookResponse struct {
+	Webhook *internal.Webhook `json:"webhook"`
+	Secret  string            `json:"secret"`
+}
+
+type ListWebhooksResponse struct {
+	Webhooks []*internal.Webhook `json:"webhooks"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []*internal.WebhookDelivery `json:"deliveries"`
+}
+
+// CreateWebhook handles POST /api/webhooks
+func (h *WebhookHandler) CreateWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	if req.Secret == "" {
+		req.Secret = crand.Text()
+	}
+
+	webhook, err := h.webhooksRepo.Create(ctx, repo.WebhookParams{
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to create webhook")
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, CreateWebhookResponse{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// ListWebhooks handles GET /api/webhooks
+func (h *WebhookHandler) ListWebhooks(c echo.Context) error {
+	webhooks, err := h.webhooksRepo.List(c.Request().Context())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Error().Err(err).Msg("failed to list webhooks")
+		return err
+	}
+	return c.JSON(http.StatusOK, ListWebhooksResponse{Webhooks: webhooks})
+}
+
+// GetWebhook handles GET /api/webhooks/:id
+func (h *WebhookHandler) GetWebhook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webhook id")
+	}
+
+	webhook, err := h.webhooksRepo.Get(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// UpdateWebhook handles PUT /api/webhooks/:id
+func (h *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webhook id")
+	}
+
+	var req WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	webhook, err := h.webhooksRepo.Update(c.Request().Context(), id, repo.WebhookParams{
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrWebhookNotFound) {
+			return internal.ErrWebhookNotFound
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to update webhook")
+		return err
+	}
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /api/webhooks/:id
+func (h *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webhook id")
+	}
+
+	if err := h.webhooksRepo.Delete(c.Request().Context(), id); err != nil {
+		if errors.Is(err, internal.ErrWebhookNotFound) {
+			return internal.ErrWebhookNotFound
+		}
+		logger.FromContext(c.Request().Context()).Error().Err(err).Int64("id", id).Msg("failed to delete webhook")
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /api/webhooks/:id/deliveries
+func (h *WebhookHandler) ListDeliveries(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webhook id")
+	}
+
+	if _, err := h.webhooksRepo.Get(ctx, id); err != nil {
+		return err
+	}
+
+	deliveries, err := h.webhooksRepo.ListDeliveries(ctx, id, webhookDeliveriesLimit)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Int64("id", id).Msg("failed to list webhook deliveries")
+		return err
+	}
+	return c.JSON(http.StatusOK, ListWebhookDeliveriesResponse{Deliveries: deliveries})
+}
@@ -0,0 +1,220 @@
+// Package health tracks whether the integrations the application depends on are working.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOK Status = "ok"
+	// StatusDegraded means the last use of the dependency failed, but its self-check passes
+	StatusDegraded Status = "degraded"
+	// StatusDown means the self-check of the dependency fails
+	StatusDown Status = "down"
+)
+
+const (
+	// checkTTL is how long a self-check result is reused, so polling doesn't hit the dependency every time
+	checkTTL = 30 * time.Second
+	// checkTimeout bounds a single self-check
+	checkTimeout = 5 * time.Second
+)
+
+var (
+	ErrUnknownDependency = errors.New("unknown dependency")
+	ErrNoCheck           = errors.New("dependency has no self-check")
+)
+
+// CheckFunc actively checks a dependency, it should be cheap.
+type CheckFunc func(ctx context.Context) error
+
+// Dependency is an integration registered with the Registry. Its users report the outcome
+// of using it with Succeeded and Failed, its self-check is run on demand and cached.
+type Dependency struct {
+	name  string
+	check CheckFunc
+
+	mu            sync.Mutex
+	critical      bool
+	lastSuccessAt time.Time
+	lastFailureAt time.Time
+	lastError     string
+	checkedAt     time.Time
+	checkErr      error
+}
+
+// Succeeded records a successful use of the dependency.
+func (d *Dependency) Succeeded() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSuccessAt = time.Now().UTC()
+}
+
+// Failed records a failed use of the dependency.
+func (d *Dependency) Failed(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastFailureAt = time.Now().UTC()
+	d.lastError = err.Error()
+}
+
+// Report is the state of a dependency.
+type Report struct {
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Critical bool   `json:"critical"`
+	// SelfCheck says whether the dependency can be tested, otherwise its status only comes from its use
+	SelfCheck     bool       `json:"self_check"`
+	CheckedAt     *time.Time `json:"checked_at"`
+	CheckError    string     `json:"check_error,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// report runs the self-check unless its result is recent enough, or force is set.
+func (d *Dependency) report(ctx context.Context, force bool) Report {
+	d.mu.Lock()
+	stale := d.check != nil && (force || time.Since(d.checkedAt) > checkTTL)
+	d.mu.Unlock()
+
+	if stale {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := d.check(ctx)
+		cancel()
+
+		d.mu.Lock()
+		d.checkedAt = time.Now().UTC()
+		d.checkErr = err
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := Report{
+		Name:          d.name,
+		Status:        StatusOK,
+		Critical:      d.critical,
+		SelfCheck:     d.check != nil,
+		CheckedAt:     timePtr(d.checkedAt),
+		LastSuccessAt: timePtr(d.lastSuccessAt),
+		LastFailureAt: timePtr(d.lastFailureAt),
+		LastError:     d.lastError,
+	}
+	if d.checkErr != nil {
+		r.Status = StatusDown
+		r.CheckError = d.checkErr.Error()
+	} else if d.lastFailureAt.After(d.lastSuccessAt) {
+		r.Status = StatusDegraded
+	}
+	return r
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Registry holds the dependencies, in the order they were registered.
+type Registry struct {
+	mu           sync.Mutex
+	dependencies []*Dependency
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a dependency, check may be nil when it can't be tested.
+// Only a failing critical dependency makes the application unready.
+func (r *Registry) Register(name string, critical bool, check CheckFunc) *Dependency {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := &Dependency{name: name, critical: critical, check: check}
+	r.dependencies = append(r.dependencies, d)
+	return d
+}
+
+// MarkCritical makes the named dependencies critical, unknown names are an error.
+func (r *Registry) MarkCritical(names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		d := r.find(name)
+		if d == nil {
+			known := make([]string, len(r.dependencies))
+			for i, d := range r.dependencies {
+				known[i] = d.name
+			}
+			return fmt.Errorf("%w %q, known ones are %s", ErrUnknownDependency, name, strings.Join(known, ", "))
+		}
+		d.mu.Lock()
+		d.critical = true
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// find returns the named dependency or nil, r.mu must be held.
+func (r *Registry) find(name string) *Dependency {
+	i := slices.IndexFunc(r.dependencies, func(d *Dependency) bool { return d.name == name })
+	if i < 0 {
+		return nil
+	}
+	return r.dependencies[i]
+}
+
+// Reports returns the state of every dependency, running the self-checks that are due.
+func (r *Registry) Reports(ctx context.Context) []Report {
+	r.mu.Lock()
+	dependencies := slices.Clone(r.dependencies)
+	r.mu.Unlock()
+
+	reports := make([]Report, len(dependencies))
+	for i, d := range dependencies {
+		reports[i] = d.report(ctx, false)
+	}
+	return reports
+}
+
+// Test runs the self-check of the named dependency right away.
+func (r *Registry) Test(ctx context.Context, name string) (Report, error) {
+	r.mu.Lock()
+	d := r.find(name)
+	r.mu.Unlock()
+	if d == nil {
+		return Report{}, ErrUnknownDependency
+	}
+	if d.check == nil {
+		return Report{}, ErrNoCheck
+	}
+	return d.report(ctx, true), nil
+}
+
+// Overall is ok when every dependency is, down when a critical one is down, and degraded otherwise.
+func Overall(reports []Report) Status {
+	status := StatusOK
+	for _, r := range reports {
+		if r.Status == StatusOK {
+			continue
+		}
+		if r.Critical && r.Status == StatusDown {
+			return StatusDown
+		}
+		status = StatusDegraded
+	}
+	return status
+}
@@ -0,0 +1,212 @@
+// Package linkcheck checks the destinations of links, to find those that rotted away.
+package linkcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/safehttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// checkTimeout bounds each request, a check falls back from HEAD to GET so it takes at most twice that
+	checkTimeout = 5 * time.Second
+	maxRedirects = 5
+)
+
+// Checker requests the destinations of links and stores whether they still work.
+type Checker struct {
+	links  *repo.LinksRepo
+	client *http.Client
+	// concurrency is how many hosts are checked at once, hostDelay the pause between two requests to the same host
+	concurrency int
+	hostDelay   time.Duration
+}
+
+func New(links *repo.LinksRepo, concurrency int, hostDelay time.Duration) *Checker {
+	return &Checker{
+		links:       links,
+		client:      safehttp.NewClient(checkTimeout, maxRedirects),
+		concurrency: max(concurrency, 1),
+		hostDelay:   hostDelay,
+	}
+}
+
+// Checkable reports whether the destination of link can be checked, only http and https ones can.
+func Checkable(link *internal.Link) bool {
+	u, err := url.Parse(link.URL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Check checks the destination of link now and stores the outcome.
+func (c *Checker) Check(ctx context.Context, link *internal.Link) (*internal.LinkHealth, error) {
+	health := c.probe(ctx, link.URL)
+	// A check cut short by shutdown says nothing about the destination
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.links.SaveHealth(ctx, link.ID, health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// Run checks the destinations not checked for interval, right away and then once per interval,
+// until ctx is done. It returns once the checks under way are over.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.checkDue(ctx, time.Now().Add(-interval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDue checks the destinations not checked since checkedBefore. The links of a host are
+// checked one after the other, hostDelay apart, and up to concurrency hosts at once.
+func (c *Checker) checkDue(ctx context.Context, checkedBefore time.Time) {
+	links, err := c.links.ListDueForCheck(ctx, checkedBefore)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("failed to list links to check")
+		}
+		return
+	}
+
+	byHost := map[string][]*internal.Link{}
+	var hosts []string
+	for _, link := range links {
+		if !Checkable(link) {
+			continue
+		}
+		u, _ := url.Parse(link.URL)
+		host := strings.ToLower(u.Hostname())
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], link)
+	}
+	if len(hosts) == 0 {
+		return
+	}
+	log.Debug().Int("links", len(links)).Int("hosts", len(hosts)).Msg("checking link destinations")
+
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, host := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c.checkHost(ctx, byHost[host])
+		}()
+	}
+}
+
+func (c *Checker) checkHost(ctx context.Context, links []*internal.Link) {
+	for i, link := range links {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.hostDelay):
+			}
+		}
+		health, err := c.Check(ctx, link)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Int64("link_id", link.ID).Msg("failed to check link destination")
+			}
+			continue
+		}
+		if health.Broken() {
+			log.Info().
+				Int64("link_id", link.ID).
+				Str("result", string(health.Result)).
+				Int("status", health.StatusCode).
+				Msg("link destination is broken")
+		}
+	}
+}
+
+// probe requests rawURL without its body, and again with GET when the server refuses HEAD
+// or errs on it, as some only implement GET.
+func (c *Checker) probe(ctx context.Context, rawURL string) *internal.LinkHealth {
+	health := &internal.LinkHealth{CheckedAt: time.Now().UTC()}
+	status, err := c.request(ctx, http.MethodHead, rawURL)
+	if err == nil && status >= http.StatusBadRequest {
+		status, err = c.request(ctx, http.MethodGet, rawURL)
+	}
+	switch {
+	case err != nil:
+		health.Result = classify(err)
+	case status >= http.StatusBadRequest:
+		health.Result, health.StatusCode = internal.CheckHTTPError, status
+	default:
+		health.Result, health.StatusCode = internal.CheckOK, status
+	}
+	return health
+}
+
+func (c *Checker) request(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "linked-checker")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Only the status matters, but draining a little lets the connection be reused
+	_, _ = io.CopyN(io.Discard, resp.Body, 4<<10)
+	return resp.StatusCode, nil
+}
+
+// classify tells why a request failed.
+func classify(err error) internal.CheckResult {
+	if errors.Is(err, safehttp.ErrTooManyRedirects) {
+		return internal.CheckTooManyRedirects
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return internal.CheckTimeout
+	}
+	var (
+		certErr     *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		certInvalid x509.CertificateInvalidError
+	)
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &unknownCA) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalid) {
+		return internal.CheckTLSError
+	}
+	return internal.CheckUnreachable
+}
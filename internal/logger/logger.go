@@ -0,0 +1,97 @@
+// Package logger carries a logger scoped to a request through its context, so every line
+// logged while handling a request can be correlated by its request ID.
+package logger
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// maxRequestIDLength bounds request IDs taken from upstream proxies, longer ones are replaced
+const maxRequestIDLength = 128
+
+// FromContext returns the logger of the request ctx belongs to, or the global logger.
+func FromContext(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log.Logger
+}
+
+// RequestID gives every request an ID and echoes it in the X-Request-ID response header.
+// An ID sent by an upstream proxy in the X-Request-ID request header is kept when it looks sane.
+func RequestID() echo.MiddlewareFunc {
+	requestID := middleware.RequestID()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		h := requestID(next)
+		return func(c echo.Context) error {
+			header := c.Request().Header
+			if id := header.Get(echo.HeaderXRequestID); id != "" && !validRequestID(id) {
+				header.Del(echo.HeaderXRequestID)
+			}
+			return h(c)
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		// Printable ASCII without spaces, so the ID can't forge log lines or headers
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware puts a logger with the request ID, method and path into the request context,
+// it goes after RequestID.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			l := log.With().
+				Str("request_id", c.Response().Header().Get(echo.HeaderXRequestID)).
+				Str("method", req.Method).
+				Str("path", req.URL.Path).
+				Logger()
+			c.SetRequest(req.WithContext(l.WithContext(req.Context())))
+			return next(c)
+		}
+	}
+}
+
+// AccessLog logs every request once it's handled, server errors at the error level.
+// It goes after Middleware, so its lines carry the request ID.
+func AccessLog() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogStatus:    true,
+		LogLatency:   true,
+		LogRemoteIP:  true,
+		LogUserAgent: true,
+		LogError:     true,
+		HandleError:  true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			l := FromContext(c.Request().Context())
+			event := l.Info()
+			if v.Status >= http.StatusInternalServerError {
+				event = l.Error().Err(v.Error)
+			}
+			event.
+				Int("status", v.Status).
+				Dur("latency", v.Latency).
+				Str("remote_ip", v.RemoteIP).
+				Str("user_agent", v.UserAgent).
+				Msg("request")
+			return nil
+		},
+	})
+}
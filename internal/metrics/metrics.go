@@ -0,0 +1,35 @@
+// Package metrics holds process-wide counters, published with expvar.
+package metrics
+
+import "expvar"
+
+var (
+	// SlugMisses counts lookups of slugs that don't exist
+	SlugMisses = expvar.NewInt("slug_misses")
+	// ScanSuspects counts clients that went over their budget of slug misses
+	ScanSuspects = expvar.NewInt("scan_suspects")
+	// TarpittedRequests counts responses delayed because of too many slug misses
+	TarpittedRequests = expvar.NewInt("tarpitted_requests")
+	// DBBusyRetries counts database operations repeated because another connection held a lock
+	DBBusyRetries = expvar.NewInt("db_busy_retries")
+	// ClickWriteBudget is how many clicks are written per second at most, zero is unlimited
+	ClickWriteBudget = expvar.NewInt("click_write_budget")
+	// ClickBacklog is how many clicks are waiting to be written
+	ClickBacklog = expvar.NewInt("click_backlog")
+	// ClicksDeferred counts clicks queued because they were over the write budget
+	ClicksDeferred = expvar.NewInt("clicks_deferred")
+	// ClicksDrained counts queued clicks taken off the backlog, its rate is the drain rate
+	ClicksDrained = expvar.NewInt("clicks_drained")
+	// ClicksDropped counts clicks lost because the backlog was full
+	ClicksDropped = expvar.NewInt("clicks_dropped")
+	// SlugBatches counts the batches of generated slugs checked for ones already taken
+	SlugBatches = expvar.NewInt("slug_batches")
+	// SlugCandidatesTaken counts generated slugs skipped because they were taken when checked
+	SlugCandidatesTaken = expvar.NewInt("slug_candidates_taken")
+	// SlugRetries counts links retried with another generated slug, as theirs was taken by the time of the insert
+	SlugRetries = expvar.NewInt("slug_retries")
+	// ClickSinkDropped counts clicks not sent to CLICK_SINK because it couldn't keep up
+	ClickSinkDropped = expvar.NewInt("click_sink_dropped")
+	// ClickSinkErrors counts failed writes to CLICK_SINK, the clicks buffered at the time are lost
+	ClickSinkErrors = expvar.NewInt("click_sink_errors")
+)
@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// checkNotAlias returns internal.ErrSlugExists when slug is kept as an alias of some link on domain.
+// Like slugs, an alias on a domain doesn't take the slug on another one, nor on any domain.
+func (r *LinksRepo) checkNotAlias(ctx context.Context, tx *goqu.TxDatabase, domain, slug string) error {
+	var n int64
+	if _, err := tx.From("slug_aliases").
+		Select(goqu.COUNT("*")).
+		Where(goqu.C("domain").Eq(domain), r.slugIs("slug", slug)).
+		ScanValContext(ctx, &n); err != nil {
+		return fmt.Errorf("failed to look up slug alias: %w", err)
+	}
+	if n > 0 {
+		return internal.ErrSlugExists
+	}
+	return nil
+}
+
+// ChangeSlug gives a link a new slug in one transaction. With keepOld the previous slug
+// stays as an alias on the domain of the link that still redirects to it.
+// It returns internal.ErrSlugExists when newSlug is taken by a link or an alias on that domain.
+func (r *LinksRepo) ChangeSlug(ctx context.Context, id int64, newSlug string, keepOld bool) (*internal.Link, error) {
+	now := r.Now().UTC()
+
+	// Safe to retry on a busy database, the transaction either applied fully or not at all
+	var row linkRow
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			var old struct {
+				Domain string `db:"domain"`
+				Slug   string `db:"slug"`
+			}
+			found, err := tx.From("links").Select("domain", "slug").Where(goqu.C("id").Eq(id)).ScanStructContext(ctx, &old)
+			if err != nil {
+				return fmt.Errorf("failed to scan link: %w", err)
+			} else if !found {
+				return internal.ErrLinkNotFound
+			}
+
+			if err := r.checkNotAlias(ctx, tx, old.Domain, newSlug); err != nil {
+				return err
+			}
+
+			if keepOld {
+				_, err := tx.Insert("slug_aliases").
+					Rows(goqu.Record{"domain": old.Domain, "slug": old.Slug, "link_id": id, "created_at": Date(now)}).
+					Executor().ExecContext(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to insert slug alias: %w", err)
+				}
+			}
+
+			found, err = tx.Update("links").
+				Set(goqu.Record{"slug": newSlug, "updated_at": Timestamp(now)}).
+				Where(goqu.C("id").Eq(id)).
+				Returning(linkRow{}).
+				Executor().ScanStructContext(ctx, &row)
+			if err != nil {
+				return err
+			} else if !found {
+				return internal.ErrLinkNotFound
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if isUniqueConstraintError(err) || errors.Is(err, internal.ErrSlugExists) {
+			return nil, internal.ErrSlugExists
+		} else if errors.Is(err, internal.ErrLinkNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to change slug: %w", err)
+	}
+
+	link := row.toDomain()
+	if err := r.attachTags(ctx, []*internal.Link{link}); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// TakenSlugs returns those of slugs that a link on any domain or an alias has, with a query per table
+// whatever the number of slugs, so generated slugs can be checked in batches.
+func (r *LinksRepo) TakenSlugs(ctx context.Context, slugs []string) ([]string, error) {
+	key := func(slug string) string { return slug }
+	col := func(name string) exp.Inable { return goqu.C(name) }
+	if r.caseInsensitiveSlugs {
+		key = strings.ToLower
+		col = func(name string) exp.Inable { return goqu.Func("lower", goqu.C(name)) }
+	}
+	keys := make([]string, len(slugs))
+	for i, slug := range slugs {
+		keys[i] = key(slug)
+	}
+
+	var found []string
+	for _, table := range []string{"links", "slug_aliases"} {
+		var inTable []string
+		err := retryBusy(ctx, func() error {
+			return r.db.From(table).Select("slug").Where(col("slug").In(keys)).ScanValsContext(ctx, &inTable)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up taken slugs: %w", err)
+		}
+		found = append(found, inTable...)
+	}
+
+	taken := make(map[string]bool, len(found))
+	for _, slug := range found {
+		taken[key(slug)] = true
+	}
+	var result []string
+	for _, slug := range slugs {
+		if taken[key(slug)] {
+			result = append(result, slug)
+		}
+	}
+	return result, nil
+}
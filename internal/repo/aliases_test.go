@@ -0,0 +1,90 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// unscopedAliasesVersion is the last schema version keeping aliases without a domain
+const unscopedAliasesVersion = 29
+
+func TestAliasesPerDomain(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+
+		// docs stays an alias of the link on go.example.com
+		scoped := f.Link(t, func(l *repo.NewLink) { l.Domain = "go.example.com"; l.Slug = "docs" })
+		if _, err := f.Links.ChangeSlug(ctx, scoped.ID, "manual", true); err != nil {
+			t.Fatal(err)
+		}
+
+		// which leaves the slug free on other domains and on any domain
+		other := f.Link(t, func(l *repo.NewLink) { l.Domain = "l.example.io"; l.Slug = "docs" })
+		anyDomain := f.Link(t, func(l *repo.NewLink) { l.Slug = "guide" })
+		if _, err := f.Links.ChangeSlug(ctx, anyDomain.ID, "docs", false); err != nil {
+			t.Errorf("taking the slug of an alias on another domain returned %v", err)
+		}
+
+		// but not on its own
+		_, err := f.Links.Create(ctx, repo.NewLink{Domain: "go.example.com", Slug: "docs", URL: "https://example.com/other"})
+		if !errors.Is(err, internal.ErrSlugExists) {
+			t.Errorf("creating the slug of an alias on its domain returned %v, want ErrSlugExists", err)
+		}
+		renamed := f.Link(t, func(l *repo.NewLink) { l.Domain = "go.example.com"; l.Slug = "howto" })
+		if _, err := f.Links.ChangeSlug(ctx, renamed.ID, "docs", false); !errors.Is(err, internal.ErrSlugExists) {
+			t.Errorf("renaming to the slug of an alias on its domain returned %v, want ErrSlugExists", err)
+		}
+
+		// Visitors of each domain reach the link of their own
+		for domain, want := range map[string]int64{
+			"go.example.com":  scoped.ID,
+			"l.example.io":    other.ID,
+			"www.example.com": anyDomain.ID,
+		} {
+			link, err := f.Links.Resolve(ctx, domain, "docs")
+			if err != nil {
+				t.Fatalf("%s: %v", domain, err)
+			}
+			if link.ID != want {
+				t.Errorf("docs on %s resolved to link %d, want %d", domain, link.ID, want)
+			}
+		}
+	})
+}
+
+func TestAliasesMigrateToTheDomainOfTheirLink(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := testutil.NewDBAt(t, unscopedAliasesVersion)
+	links := repo.NewLinksRepo(sqlDB, false)
+
+	link, err := links.Create(ctx, repo.NewLink{Domain: "go.example.com", Slug: "docs", URL: "https://example.com/docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDB.ExecContext(ctx, "INSERT INTO slug_aliases (slug, link_id, created_at) VALUES ('manual', ?, '2024-01-01T00:00:00Z')", link.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx, sqlDB); err != nil {
+		t.Fatal(err)
+	}
+
+	var domain string
+	if err := sqlDB.QueryRowContext(ctx, "SELECT domain FROM slug_aliases WHERE slug = 'manual'").Scan(&domain); err != nil {
+		t.Fatal(err)
+	}
+	if domain != "go.example.com" {
+		t.Errorf("alias migrated to domain %q, want the one of its link", domain)
+	}
+	got, err := links.GetBySlug(ctx, "go.example.com", "manual")
+	if err != nil || got.ID != link.ID {
+		t.Errorf("got %v, %v, want the alias to resolve to link %d", got, err, link.ID)
+	}
+}
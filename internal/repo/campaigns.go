@@ -0,0 +1,250 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+type campaignRow struct {
+	ID        int64      `db:"id" goqu:"skipinsert,skipupdate"`
+	Name      string     `db:"name"`
+	UTM       utmMapping `db:"utm"`
+	CreatedAt Date       `db:"created_at" goqu:"skipupdate"`
+}
+
+func (r campaignRow) toDomain() *internal.Campaign {
+	return &internal.Campaign{
+		ID:        r.ID,
+		Name:      r.Name,
+		UTM:       r.UTM,
+		CreatedAt: r.CreatedAt.Time(),
+	}
+}
+
+// CampaignParams are the fields of a campaign to create or update. On update, a nil UTM keeps the
+// expected UTM parameters as they are and an empty one removes them.
+type CampaignParams struct {
+	Name string
+	UTM  map[string]string
+}
+
+type CampaignsRepo struct {
+	db *goqu.Database
+}
+
+func NewCampaignsRepo(db *sql.DB) *CampaignsRepo {
+	return &CampaignsRepo{db: newDatabase(db)}
+}
+
+func (r *CampaignsRepo) Create(ctx context.Context, params CampaignParams) (*internal.Campaign, error) {
+	query := r.db.Insert("campaigns").
+		Rows(campaignRow{Name: params.Name, UTM: params.UTM, CreatedAt: Date(time.Now().UTC())}).
+		Returning(campaignRow{})
+
+	// Safe to retry on a busy database, the unique name keeps a repeated insert from adding a second campaign
+	var row campaignRow
+	err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return nil, internal.ErrCampaignNameTaken
+		}
+		return nil, fmt.Errorf("failed to insert campaign: %w", err)
+	}
+	return row.toDomain(), nil
+}
+
+// Update renames a campaign and changes the UTM parameters it expects, its links stay in it.
+func (r *CampaignsRepo) Update(ctx context.Context, id int64, params CampaignParams) (*internal.Campaign, error) {
+	record := goqu.Record{"name": params.Name}
+	if params.UTM != nil {
+		record["utm"] = utmMapping(params.UTM)
+	}
+	query := r.db.Update("campaigns").
+		Set(record).
+		Where(goqu.C("id").Eq(id)).
+		Returning(campaignRow{})
+
+	// Safe to retry on a busy database, setting the same values twice changes nothing
+	var row campaignRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.Executor().ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return nil, internal.ErrCampaignNameTaken
+		}
+		return nil, fmt.Errorf("failed to update campaign: %w", err)
+	} else if !found {
+		return nil, internal.ErrCampaignNotFound
+	}
+	return row.toDomain(), nil
+}
+
+func (r *CampaignsRepo) Get(ctx context.Context, id int64) (*internal.Campaign, error) {
+	query := r.db.From("campaigns").
+		Select(campaignRow{}).
+		Where(goqu.C("id").Eq(id))
+
+	var row campaignRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan campaign: %w", err)
+	} else if !found {
+		return nil, internal.ErrCampaignNotFound
+	}
+	return row.toDomain(), nil
+}
+
+// List returns all campaigns, newest first.
+func (r *CampaignsRepo) List(ctx context.Context) ([]*internal.Campaign, error) {
+	query := r.db.From("campaigns").
+		Select(campaignRow{}).
+		Order(goqu.C("id").Desc())
+
+	var rows []campaignRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan campaigns: %w", err)
+	}
+
+	campaigns := make([]*internal.Campaign, len(rows))
+	for i, row := range rows {
+		campaigns[i] = row.toDomain()
+	}
+	return campaigns, nil
+}
+
+// Delete removes a campaign, its links are kept without one.
+func (r *CampaignsRepo) Delete(ctx context.Context, id int64) error {
+	// Safe to retry on a busy database, the transaction either applied fully or not at all
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			// The foreign key would clear campaign_id too, but not mark the links as changed
+			if _, err := tx.Update("links").
+				Set(goqu.Record{"campaign_id": nil, "updated_at": Timestamp(time.Now().UTC())}).
+				Where(goqu.C("campaign_id").Eq(id)).
+				Executor().ExecContext(ctx); err != nil {
+				return fmt.Errorf("failed to remove links from campaign: %w", err)
+			}
+
+			result, err := tx.Delete("campaigns").Where(goqu.C("id").Eq(id)).Executor().ExecContext(ctx)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to get rows affected: %w", err)
+			} else if n == 0 {
+				return internal.ErrCampaignNotFound
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete campaign: %w", err)
+	}
+	return nil
+}
+
+// checkCampaign returns internal.ErrCampaignNotFound unless the campaign exists, a nil id is no campaign.
+func checkCampaign(ctx context.Context, tx *goqu.TxDatabase, id *int64) error {
+	if id == nil {
+		return nil
+	}
+	var n int64
+	if _, err := tx.From("campaigns").
+		Select(goqu.COUNT("*")).
+		Where(goqu.C("id").Eq(*id)).
+		ScanValContext(ctx, &n); err != nil {
+		return fmt.Errorf("failed to look up campaign: %w", err)
+	}
+	if n == 0 {
+		return internal.ErrCampaignNotFound
+	}
+	return nil
+}
+
+// inCampaign is the link_id condition of the clicks of the links in a campaign.
+func inCampaign(db *goqu.Database, campaignID int64) exp.Expression {
+	return goqu.C("link_id").In(db.From("links").Select("id").Where(goqu.C("campaign_id").Eq(campaignID)))
+}
+
+type campaignClicksRow struct {
+	Clicks        int64 `db:"clicks"`
+	UniqueIPs     int64 `db:"unique_ips"`
+	LastClickedAt *Date `db:"last_clicked_at"`
+}
+
+// GetCampaignStats adds up the clicks of the links in a campaign, with a daily series of the last
+// n days like GetDailyClicks. Purged clicks are counted in the totals but not as unique IPs.
+func (r *ClicksRepo) GetCampaignStats(ctx context.Context, campaignID int64, days int) (*internal.CampaignStats, error) {
+	linksQuery := r.db.From("links").
+		Select(goqu.COUNT("*")).
+		Where(goqu.C("campaign_id").Eq(campaignID))
+
+	var links int64
+	if err := retryBusy(ctx, func() error {
+		_, err := linksQuery.ScanValContext(ctx, &links)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to count campaign links: %w", err)
+	}
+
+	clicksQuery := r.db.From("clicks").
+		Where(inCampaign(r.db, campaignID), countedClick).
+		Select(
+			goqu.COUNT("*").As("clicks"),
+			goqu.COUNT(goqu.DISTINCT("ip_address")).As("unique_ips"),
+			goqu.MAX("clicked_at").As("last_clicked_at"),
+		)
+
+	var row campaignClicksRow
+	if err := retryBusy(ctx, func() error {
+		_, err := clicksQuery.ScanStructContext(ctx, &row)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan campaign clicks: %w", err)
+	}
+
+	rollup, err := r.getRollupStats(ctx, inCampaign(r.db, campaignID))
+	if err != nil {
+		return nil, err
+	}
+	row.Clicks += rollup.Total
+	if row.LastClickedAt == nil || (rollup.LastClickedAt != nil && rollup.LastClickedAt.Time().After(row.LastClickedAt.Time())) {
+		row.LastClickedAt = rollup.LastClickedAt
+	}
+
+	daily, err := r.dailyClicks(ctx, inCampaign(r.db, campaignID), days)
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.CampaignStats{
+		Links:         links,
+		Clicks:        row.Clicks,
+		UniqueIPs:     row.UniqueIPs,
+		LastClickedAt: fromDatePtr(row.LastClickedAt),
+		Daily:         daily,
+	}, nil
+}
@@ -1,15 +1,21 @@
 package repo
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
 	"github.com/doug-martin/goqu/v9"
 	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
-	"github.com/rs/zerolog/log"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/samber/lo"
 )
 
@@ -20,60 +26,334 @@ type ClickStats struct {
 
 type clickStatsRow struct {
 	Total         int64 `db:"total"`
+	UniqueClicks  int64 `db:"unique_clicks"`
 	LastClickedAt *Date `db:"last_clicked_at"`
 }
 
 func (r clickStatsRow) toDomain() *internal.LinkStats {
-	var lastClickedAt *time.Time
-	if r.LastClickedAt != nil {
-		lastClickedAt = lo.ToPtr(r.LastClickedAt.Time())
-	}
 	return &internal.LinkStats{
 		Clicks:        r.Total,
-		LastClickedAt: lastClickedAt,
+		UniqueClicks:  r.UniqueClicks,
+		LastClickedAt: fromDatePtr(r.LastClickedAt),
 	}
 }
 
 type ClicksRepo struct {
 	db *goqu.Database
+	// Now is the clock that daily stats count back from, tests can replace it
+	Now func() time.Time
+	// partitions are the names of the partitions known to exist, see partitionFor
+	partitions sync.Map
 }
 
 func NewClicksRepo(db *sql.DB) *ClicksRepo {
-	return &ClicksRepo{db: goqu.New("sqlite", db)}
+	return &ClicksRepo{db: newDatabase(db), Now: time.Now}
+}
+
+// NewClick is a click to record.
+type NewClick struct {
+	LinkID    int64
+	ClickedAt time.Time
+	UserAgent string
+	IPAddress string
+	Referer   string
+	// CountryCode is empty when unknown
+	CountryCode string
+	// VisitorHash tells the visitor apart from others on the day of the click, empty when unknown
+	VisitorHash string
+	// Method is the request method, empty is GET
+	Method string
+}
+
+// Counted reports whether the click is a visit. Others, like HEAD requests of link checkers,
+// are only recorded to audit them.
+func (c NewClick) Counted() bool {
+	return c.Method == "" || c.Method == http.MethodGet
+}
+
+// countedClick is the condition of a click counting as a visit, see NewClick.Counted
+var countedClick = goqu.C("method").Eq(http.MethodGet)
+
+// methodsIn is the condition of a click made with one of methods, only counted clicks without any.
+func methodsIn(methods []string) exp.Expression {
+	if len(methods) == 0 {
+		return countedClick
+	}
+	return goqu.C("method").In(methods)
 }
 
-func (r *ClicksRepo) Create(ctx context.Context, linkID int64, userAgent, ipAddress string) error {
-	now := Date(time.Now().UTC())
-	query := r.db.Insert("clicks").
-		Cols("link_id", "clicked_at", "user_agent", "ip_address").
-		Vals([]any{linkID, now, userAgent, ipAddress}).
-		Returning("id", "link_id", "clicked_at", "user_agent", "ip_address")
+// Create records a click in the partition of its month. Only the host of the referer is stored.
+func (r *ClicksRepo) Create(ctx context.Context, click NewClick) error {
+	var refererCol any
+	if host := refererHost(click.Referer); host != "" {
+		refererCol = host
+	}
+	var countryCol any
+	if click.CountryCode != "" {
+		countryCol = click.CountryCode
+	}
+	var visitorCol any
+	if click.VisitorHash != "" {
+		visitorCol = click.VisitorHash
+	}
+	month := click.ClickedAt.UTC().Format(monthFormat)
 
-	_, err := query.Executor().ExecContext(ctx)
+	var err error
+	for attempt := 1; attempt <= 2; attempt++ {
+		var partition string
+		if partition, err = r.partitionFor(ctx, month); err != nil {
+			break
+		}
+		// Safe to retry on a busy database, the transaction either applied fully or not at all.
+		// Other failures aren't retried, they might have recorded the click already.
+		err = retryBusy(ctx, func() error {
+			return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+				id, err := nextClickID(ctx, tx, isPostgres(r.db))
+				if err != nil {
+					return err
+				}
+				_, err = tx.Insert(partition).
+					Cols("id", "link_id", "clicked_at", "user_agent", "ip_address", "referer", "country_code", "visitor_hash", "method").
+					Vals([]any{id, click.LinkID, Date(click.ClickedAt.UTC()), click.UserAgent, click.IPAddress, refererCol, countryCol, visitorCol, cmp.Or(click.Method, http.MethodGet)}).
+					Executor().ExecContext(ctx)
+				if err != nil {
+					return err
+				}
+				return bumpLinksVersion(ctx, tx)
+			})
+		})
+		if !isUndefinedTableError(err) {
+			break
+		}
+		// The month was purged by another replica, a late click creates its partition again
+		r.partitions.Delete(partition)
+	}
 	if err != nil {
-		log.Error().Err(err).Int64("link_id", linkID).Msg("failed to record click")
+		// The link can be deleted between the redirect looking it up and the click being recorded
+		if isForeignKeyConstraintError(err) {
+			return internal.ErrLinkNotFound
+		}
+		logger.FromContext(ctx).Error().Err(err).Int64("link_id", click.LinkID).Msg("failed to record click")
 		return err
 	}
 
-	log.Debug().Int64("link_id", linkID).Str("ip", ipAddress).Msg("click recorded successfully")
+	logger.FromContext(ctx).Debug().Int64("link_id", click.LinkID).Str("ip", click.IPAddress).Msg("click recorded successfully")
 	return nil
 }
 
-func (r *ClicksRepo) GetStatsForLink(ctx context.Context, linkID int64) (*internal.LinkStats, any) {
+// GetStatsForLink counts the clicks of a link, including purged ones. Only counted clicks are included.
+// Visitor hashes change every day, so a visitor counts as unique once per day.
+func (r *ClicksRepo) GetStatsForLink(ctx context.Context, linkID int64) (*internal.LinkStats, error) {
 	query := r.db.From("clicks").
-		Where(goqu.I("link_id").Eq(linkID)).
+		Where(goqu.I("link_id").Eq(linkID), countedClick).
 		Select(
 			goqu.COUNT("*").As("total"),
+			goqu.COUNT(goqu.DISTINCT("visitor_hash")).As("unique_clicks"),
 			goqu.MAX("clicked_at").As("last_clicked_at"),
 		)
 
 	var row clickStatsRow
-	found, err := query.ScanStructContext(ctx, &row)
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan links stats: %w", err)
 	} else if !found {
 		return nil, internal.ErrLinkNotFound
 	}
 
+	rollup, err := r.getRollupStats(ctx, goqu.C("link_id").Eq(linkID))
+	if err != nil {
+		return nil, err
+	}
+	row.Total += rollup.Total
+	row.UniqueClicks += rollup.UniqueClicks
+	if row.LastClickedAt == nil || (rollup.LastClickedAt != nil && rollup.LastClickedAt.Time().After(row.LastClickedAt.Time())) {
+		row.LastClickedAt = rollup.LastClickedAt
+	}
+
 	return row.toDomain(), nil
 }
+
+type referrerCountRow struct {
+	Host   string `db:"host"`
+	Clicks int64  `db:"clicks"`
+}
+
+// GetReferrerStats returns the top referer hosts of a link's clicks since the given time.
+// Clicks without a referer are counted separately as direct traffic.
+// A zero since means all time. Only clicks made with methods are included, counted clicks without any.
+func (r *ClicksRepo) GetReferrerStats(ctx context.Context, linkID int64, since time.Time, limit int, methods []string) (*internal.ReferrerStats, error) {
+	where := []goqu.Expression{goqu.C("link_id").Eq(linkID), methodsIn(methods)}
+	if !since.IsZero() {
+		where = append(where, goqu.C("clicked_at").Gte(Date(since.UTC())))
+	}
+
+	var rows []referrerCountRow
+	var direct int64
+	err := r.withClicksSince(ctx, since, func(clicks exp.Expression) error {
+		topQuery := r.db.From(clicks).
+			Where(where...).
+			Where(goqu.C("referer").Neq("")).
+			Select(
+				goqu.C("referer").As("host"),
+				goqu.COUNT("*").As("clicks"),
+			).
+			GroupBy("referer").
+			Order(goqu.I("clicks").Desc(), goqu.C("referer").Asc()).
+			Limit(uint(limit))
+
+		if err := retryBusy(ctx, func() error {
+			return topQuery.ScanStructsContext(ctx, &rows)
+		}); err != nil {
+			return fmt.Errorf("failed to scan referrer stats: %w", err)
+		}
+
+		directQuery := r.db.From(clicks).
+			Where(where...).
+			Where(goqu.Or(goqu.C("referer").IsNull(), goqu.C("referer").Eq(""))).
+			Select(goqu.COUNT("*"))
+
+		if err := retryBusy(ctx, func() error {
+			_, err := directQuery.ScanValContext(ctx, &direct)
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to count direct clicks: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.ReferrerStats{
+		Referrers: lo.Map(rows, func(row referrerCountRow, _ int) internal.ReferrerCount {
+			return internal.ReferrerCount{Host: row.Host, Clicks: row.Clicks}
+		}),
+		Direct: direct,
+	}, nil
+}
+
+type countryCountRow struct {
+	Country string `db:"country"`
+	Clicks  int64  `db:"clicks"`
+}
+
+// GetCountryStats returns the click counts of a link per country since the given time, most clicks first.
+// Clicks whose country isn't known are counted separately. A zero since means all time.
+// Only clicks made with methods are included, counted clicks without any.
+func (r *ClicksRepo) GetCountryStats(ctx context.Context, linkID int64, since time.Time, methods []string) (*internal.CountryStats, error) {
+	where := []goqu.Expression{goqu.C("link_id").Eq(linkID), methodsIn(methods)}
+	if !since.IsZero() {
+		where = append(where, goqu.C("clicked_at").Gte(Date(since.UTC())))
+	}
+
+	var rows []countryCountRow
+	err := r.withClicksSince(ctx, since, func(clicks exp.Expression) error {
+		query := r.db.From(clicks).
+			Where(where...).
+			Select(
+				goqu.COALESCE(goqu.C("country_code"), "").As("country"),
+				goqu.COUNT("*").As("clicks"),
+			).
+			GroupBy(goqu.C("country_code")).
+			Order(goqu.I("clicks").Desc(), goqu.I("country").Asc())
+
+		if err := retryBusy(ctx, func() error {
+			return query.ScanStructsContext(ctx, &rows)
+		}); err != nil {
+			return fmt.Errorf("failed to scan country stats: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &internal.CountryStats{Countries: []internal.CountryCount{}}
+	for _, row := range rows {
+		if row.Country == "" {
+			stats.Unknown += row.Clicks
+			continue
+		}
+		stats.Countries = append(stats.Countries, internal.CountryCount{Country: row.Country, Clicks: row.Clicks})
+	}
+	return stats, nil
+}
+
+// refererHost reduces a Referer header to its lowercased host, dropping paths and query strings.
+func refererHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+type dailyClicksRow struct {
+	Day          string `db:"day"`
+	Clicks       int64  `db:"clicks"`
+	UniqueClicks int64  `db:"unique_clicks"`
+}
+
+// GetDailyClicks returns per-day click and unique visitor counts for the last n days (UTC), oldest first.
+// Days without clicks are included with a zero count, purged clicks are counted too.
+func (r *ClicksRepo) GetDailyClicks(ctx context.Context, linkID int64, days int) ([]internal.DailyClicks, error) {
+	return r.dailyClicks(ctx, goqu.C("link_id").Eq(linkID), days)
+}
+
+// dailyClicks is GetDailyClicks for the clicks of the links matching the link_id condition.
+func (r *ClicksRepo) dailyClicks(ctx context.Context, links exp.Expression, days int) ([]internal.DailyClicks, error) {
+	today := r.Now().UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, -(days - 1))
+
+	day := clickDay(r.db)
+	var rows []dailyClicksRow
+	err := r.withClicksSince(ctx, start, func(clicks exp.Expression) error {
+		query := r.db.From(clicks).
+			Where(
+				links,
+				countedClick,
+				goqu.C("clicked_at").Gte(Date(start)),
+			).
+			Select(
+				day.As("day"),
+				goqu.COUNT("*").As("clicks"),
+				goqu.COUNT(goqu.DISTINCT("visitor_hash")).As("unique_clicks"),
+			).
+			GroupBy(day)
+
+		if err := retryBusy(ctx, func() error {
+			return query.ScanStructsContext(ctx, &rows)
+		}); err != nil {
+			return fmt.Errorf("failed to scan daily clicks: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rollups, err := r.getRollupDailyClicks(ctx, links, start)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]internal.DailyClicks, len(rows)+len(rollups))
+	for _, row := range append(rows, rollups...) {
+		count := counts[row.Day]
+		count.Clicks += row.Clicks
+		count.UniqueClicks += row.UniqueClicks
+		counts[row.Day] = count
+	}
+
+	series := make([]internal.DailyClicks, days)
+	for i := range series {
+		date := start.AddDate(0, 0, i).Format(time.DateOnly)
+		series[i] = counts[date]
+		series[i].Date = date
+	}
+	return series, nil
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/abdusco/linked/internal/db"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// newDatabase wraps sqlDB in a query builder for the dialect of its driver.
+func newDatabase(sqlDB *sql.DB) *goqu.Database {
+	return goqu.New(db.Dialect(sqlDB), sqlDB)
+}
+
+// queryer is a database or a transaction to read from
+type queryer interface {
+	From(from ...any) *goqu.SelectDataset
+}
+
+// Tx is a transaction that the repo methods taking one run in, see LinksRepo.WithTx.
+type Tx struct {
+	tx *goqu.TxDatabase
+}
+
+// WithTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+// fn runs again when the database is busy, so it must be safe to repeat. The transaction holds
+// the sqlite write lock from its first write, fn should do no slow work after that.
+func (r *LinksRepo) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	return retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			return fn(&Tx{tx: tx})
+		})
+	})
+}
+
+func isPostgres(d *goqu.Database) bool {
+	return d.Dialect() == "postgres"
+}
+
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "unique_violation"
+	}
+	return false
+}
+
+func isForeignKeyConstraintError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "foreign_key_violation"
+	}
+	return false
+}
+
+// isUndefinedTableError reports whether err is about a table that doesn't exist, like a click
+// partition dropped since it was looked up.
+func isUndefinedTableError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return strings.Contains(sqliteErr.Error(), "no such table")
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "undefined_table"
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// LinkCursor is the place of a link in any order of List, for a page to continue after it.
+// It holds what the link sorted by when it was listed, so clicks since don't move the page.
+type LinkCursor struct {
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Clicks        int64      `json:"clicks"`
+	LastClickedAt *time.Time `json:"last_clicked_at,omitempty"`
+}
+
+// NewLinkCursor returns the cursor of a link of List.
+func NewLinkCursor(link *internal.Link) LinkCursor {
+	cursor := LinkCursor{ID: link.ID, CreatedAt: link.CreatedAt}
+	if link.Stats != nil {
+		cursor.Clicks = link.Stats.Clicks
+		cursor.LastClickedAt = link.Stats.LastClickedAt
+	}
+	return cursor
+}
+
+// String encodes the cursor for a URL.
+func (c LinkCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseLinkCursor(s string) (LinkCursor, error) {
+	var cursor LinkCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	if err != nil || cursor.ID == 0 {
+		return LinkCursor{}, errors.New("invalid cursor, must be the next_cursor of a page")
+	}
+	return cursor, nil
+}
+
+// condition keeps the links after the cursor in order, mirroring linkOrder.
+func (c LinkCursor) condition(order LinkOrder) exp.Expression {
+	after := func(col exp.Comparable, val any) exp.Expression {
+		if order.Ascending {
+			return col.Gt(val)
+		}
+		return col.Lt(val)
+	}
+	createdAt := Date(c.CreatedAt.UTC())
+	byCreation := goqu.Or(
+		after(goqu.I("links.created_at"), createdAt),
+		goqu.And(goqu.I("links.created_at").Eq(createdAt), after(goqu.I("links.id"), c.ID)),
+	)
+
+	switch order.By {
+	case SortByClicks:
+		return goqu.Or(
+			after(activityClicks, c.Clicks),
+			goqu.And(activityClicks.Eq(c.Clicks), byCreation),
+		)
+	case SortByLastClick:
+		// Links never clicked come last in descending order, and first in ascending order
+		if c.LastClickedAt == nil {
+			if order.Ascending {
+				return goqu.Or(activityLastClick.IsNotNull(), goqu.And(activityLastClick.IsNull(), byCreation))
+			}
+			return goqu.And(activityLastClick.IsNull(), byCreation)
+		}
+		lastClick := Date(c.LastClickedAt.UTC())
+		sameClick := goqu.And(activityLastClick.Eq(lastClick), byCreation)
+		if order.Ascending {
+			return goqu.Or(activityLastClick.Gt(lastClick), sameClick)
+		}
+		return goqu.Or(activityLastClick.Lt(lastClick), activityLastClick.IsNull(), sameClick)
+	}
+	return byCreation
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// HealthFilter keeps the links of List by the outcome of the last check of their destination.
+type HealthFilter string
+
+const (
+	// HealthBroken keeps the links whose destination failed its last check
+	HealthBroken HealthFilter = "broken"
+	HealthOK     HealthFilter = "ok"
+	// HealthUnchecked keeps the links whose destination wasn't checked yet
+	HealthUnchecked HealthFilter = "unchecked"
+)
+
+func ParseHealthFilter(s string) (HealthFilter, error) {
+	switch filter := HealthFilter(s); filter {
+	case "", HealthBroken, HealthOK, HealthUnchecked:
+		return filter, nil
+	}
+	return "", fmt.Errorf("invalid health %q, must be one of broken, ok, unchecked", s)
+}
+
+func (f HealthFilter) condition() exp.Expression {
+	switch f {
+	case HealthBroken:
+		return goqu.And(goqu.C("checked_at").IsNotNull(), goqu.C("check_result").Neq(internal.CheckOK))
+	case HealthOK:
+		return goqu.And(goqu.C("checked_at").IsNotNull(), goqu.C("check_result").Eq(internal.CheckOK))
+	default:
+		return goqu.C("checked_at").IsNull()
+	}
+}
+
+// health is the outcome of the last check of the link, nil when it wasn't checked.
+func (r *linkRow) health() *internal.LinkHealth {
+	if r.CheckedAt == nil || r.CheckedAt.IsZero() {
+		return nil
+	}
+	return &internal.LinkHealth{
+		Result:     internal.CheckResult(r.CheckResult),
+		StatusCode: r.CheckStatus,
+		CheckedAt:  r.CheckedAt.Time(),
+	}
+}
+
+// SaveHealth stores the outcome of a check of the destination of a link. The link isn't marked
+// as updated, it's the destination that changed.
+func (r *LinksRepo) SaveHealth(ctx context.Context, id int64, health *internal.LinkHealth) error {
+	query := r.db.Update("links").
+		Set(goqu.Record{
+			"check_result": health.Result,
+			"check_status": health.StatusCode,
+			"checked_at":   Date(health.CheckedAt.UTC()),
+		}).
+		Where(goqu.C("id").Eq(id))
+
+	// Safe to retry on a busy database, setting the same values twice changes nothing
+	var affected int64
+	err := retryBusy(ctx, func() error {
+		result, err := query.Executor().ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save link health: %w", err)
+	} else if affected == 0 {
+		return internal.ErrLinkNotFound
+	}
+	return nil
+}
+
+// ListDueForCheck returns the links whose destination wasn't checked since checkedBefore, those
+// never checked first and then those checked longest ago.
+func (r *LinksRepo) ListDueForCheck(ctx context.Context, checkedBefore time.Time) ([]*internal.Link, error) {
+	query := r.db.From("links").
+		Select(linkRow{}).
+		Where(goqu.Or(goqu.C("checked_at").IsNull(), goqu.C("checked_at").Lt(Date(checkedBefore.UTC())))).
+		Order(goqu.C("checked_at").Asc().NullsFirst(), goqu.C("id").Asc())
+
+	var rows []linkRow
+	err := retryBusy(ctx, func() error {
+		return query.Executor().ScanStructsContext(ctx, &rows)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links due for a check: %w", err)
+	}
+	links := make([]*internal.Link, len(rows))
+	for i, row := range rows {
+		links[i] = row.toDomain()
+	}
+	return links, nil
+}
@@ -2,67 +2,346 @@ package repo
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/urlutil"
 	"github.com/doug-martin/goqu/v9"
 	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
-	"modernc.org/sqlite"
-	sqlite3 "modernc.org/sqlite/lib"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/samber/lo"
 )
 
 type linkRow struct {
-	ID        int64  `db:"id" goqu:"skipinsert,skipupdate"`
-	Slug      string `db:"slug"`
-	URL       string `db:"url"`
-	CreatedAt Date   `db:"created_at" goqu:"skipupdate"`
+	ID            int64            `db:"id" goqu:"skipinsert,skipupdate"`
+	Domain        string           `db:"domain"`
+	Slug          string           `db:"slug"`
+	URL           string           `db:"url"`
+	URLKey        string           `db:"url_key"`
+	Title         string           `db:"title"`
+	Description   string           `db:"description"`
+	CreatedAt     Date             `db:"created_at" goqu:"skipupdate"`
+	UpdatedAt     Timestamp        `db:"updated_at"`
+	StatsToken    *string          `db:"stats_token"`
+	Snapshot      bool             `db:"snapshot"`
+	Preview       bool             `db:"preview"`
+	Listed        bool             `db:"listed"`
+	OGTitle       string           `db:"og_title"`
+	OGDescription string           `db:"og_description"`
+	OGImage       string           `db:"og_image"`
+	ActivatesAt   *Date            `db:"activates_at"`
+	ExpiresAt     *Date            `db:"expires_at"`
+	OwnerID       *int64           `db:"owner_id"`
+	Rules         destinationRules `db:"rules"`
+	CampaignID    *int64           `db:"campaign_id"`
+	CheckResult   string           `db:"check_result"`
+	CheckStatus   int              `db:"check_status"`
+	CheckedAt     *Date            `db:"checked_at"`
 }
 
 type LinksRepo struct {
 	db *goqu.Database
+	// caseInsensitiveSlugs matches slugs regardless of case, the unique indexes added by
+	// db.SetSlugCaseInsensitive keep slugs differing only in case apart
+	caseInsensitiveSlugs bool
+	// Now is the clock stamping links and deciding which are active, tests can replace it
+	Now func() time.Time
 }
 
-func NewLinksRepo(db *sql.DB) *LinksRepo {
-	return &LinksRepo{db: goqu.New("sqlite", db)}
+func NewLinksRepo(db *sql.DB, caseInsensitiveSlugs bool) *LinksRepo {
+	return &LinksRepo{db: newDatabase(db), caseInsensitiveSlugs: caseInsensitiveSlugs, Now: time.Now}
 }
 
-func (r *LinksRepo) Create(ctx context.Context, slug, url string) (*internal.Link, error) {
-	q := r.db.Insert("links").
-		Rows(linkRow{
-			Slug:      slug,
-			URL:       url,
-			CreatedAt: Date(time.Now().UTC()),
-		}).
-		Returning(linkRow{})
+// slugIs is the condition of the slug column col matching slug.
+func (r *LinksRepo) slugIs(col, slug string) exp.Expression {
+	if r.caseInsensitiveSlugs {
+		// Slugs are ASCII, which lower() of both databases handles, and it uses the index on lower(slug)
+		return goqu.Func("lower", goqu.C(col)).Eq(strings.ToLower(slug))
+	}
+	return goqu.C(col).Eq(slug)
+}
 
+// SameSlug reports whether two slugs lead to the same link.
+func (r *LinksRepo) SameSlug(a, b string) bool {
+	if r.caseInsensitiveSlugs {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// NewLink holds the user-provided fields of a link to create.
+type NewLink struct {
+	// Domain scopes the slug to one of the domains of the instance, empty is any domain
+	Domain      string
+	Slug        string
+	URL         string
+	Title       string
+	Description string
+	Snapshot    bool
+	Preview     bool
+	Listed      bool
+	// Tags must already be normalized
+	Tags        []string
+	OpenGraph   internal.OpenGraph
+	ActivatesAt *time.Time
+	ExpiresAt   *time.Time
+	// CreatedAt keeps the creation time of an imported link, zero means now
+	CreatedAt time.Time
+	// OwnerID is the user creating the link, nil when made outside the API
+	OwnerID *int64
+	// Rules must already be validated
+	Rules []internal.DestinationRule
+	// CampaignID must be an existing campaign, or nil
+	CampaignID *int64
+}
+
+func (r *LinksRepo) Create(ctx context.Context, params NewLink) (*internal.Link, error) {
+	// Safe to retry on a busy database, the unique slug keeps a repeated insert from adding a second link
 	var row linkRow
-	found, err := q.Executor().ScanStructContext(ctx, &row)
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			if err := r.checkNotAlias(ctx, tx, params.Domain, params.Slug); err != nil {
+				return err
+			}
+			if err := checkCampaign(ctx, tx, params.CampaignID); err != nil {
+				return err
+			}
+			found, err := tx.Insert("links").
+				Rows(newLinkRow(params, r.Now())).
+				Returning(linkRow{}).
+				Executor().ScanStructContext(ctx, &row)
+			if err != nil {
+				return err
+			} else if !found {
+				return errors.New("insert did not return anything")
+			}
+			return insertTags(ctx, tx, row.ID, params.Tags)
+		})
+	})
 	if err != nil {
-		if isUniqueConstraintError(err) {
+		if isUniqueConstraintError(err) || errors.Is(err, internal.ErrSlugExists) {
 			return nil, internal.ErrSlugExists
 		}
+		if errors.Is(err, internal.ErrCampaignNotFound) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to insert link: %w", err)
-	} else if !found {
-		return nil, errors.New("insert did not return anything")
 	}
 
 	link := row.toDomain()
+	link.Tags = append([]string{}, params.Tags...)
+
+	return link, nil
+}
+
+// CreateOrGet creates the link, or returns the link that already has its slug with created false.
+// Both happen in one transaction, so of concurrent calls with the same slug exactly one creates
+// the link and the others get it. It returns internal.ErrSlugExists when the slug is an alias.
+func (r *LinksRepo) CreateOrGet(ctx context.Context, params NewLink) (link *internal.Link, created bool, err error) {
+	// Safe to retry on a busy database, a repeated insert finds the link of the first one
+	err = r.WithTx(ctx, func(tx *Tx) error {
+		link, created, err = r.CreateOrGetTx(ctx, tx, params)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return link, created, nil
+}
+
+// CreateOrGetTx is CreateOrGet in tx, which others only see the link of once it's committed.
+func (r *LinksRepo) CreateOrGetTx(ctx context.Context, tx *Tx, params NewLink) (*internal.Link, bool, error) {
+	if err := r.checkNotAlias(ctx, tx.tx, params.Domain, params.Slug); err != nil {
+		return nil, false, err
+	}
+	if err := checkCampaign(ctx, tx.tx, params.CampaignID); err != nil {
+		return nil, false, err
+	}
+
+	var row linkRow
+	created, err := tx.tx.Insert("links").
+		Rows(newLinkRow(params, r.Now())).
+		OnConflict(goqu.DoNothing()).
+		Returning(linkRow{}).
+		Executor().ScanStructContext(ctx, &row)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create or get link: %w", err)
+	}
+	if created {
+		if err := insertTags(ctx, tx.tx, row.ID, params.Tags); err != nil {
+			return nil, false, fmt.Errorf("failed to create or get link: %w", err)
+		}
+		link := row.toDomain()
+		link.Tags = append([]string{}, params.Tags...)
+		return link, true, nil
+	}
+
+	found, err := tx.tx.From("links").
+		Select(linkRow{}).
+		Where(goqu.C("domain").Eq(params.Domain), r.slugIs("slug", params.Slug)).
+		ScanStructContext(ctx, &row)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create or get link: %w", err)
+	} else if !found {
+		return nil, false, errors.New("insert conflicted but no link has the slug")
+	}
+	link := row.toDomain()
+	if err := attachTags(ctx, tx.tx, []*internal.Link{link}); err != nil {
+		return nil, false, err
+	}
+	return link, false, nil
+}
+
+func newLinkRow(params NewLink, now time.Time) linkRow {
+	now = now.UTC()
+	createdAt := now
+	if !params.CreatedAt.IsZero() {
+		createdAt = params.CreatedAt.UTC()
+	}
+	return linkRow{
+		Domain:        params.Domain,
+		Slug:          params.Slug,
+		URL:           params.URL,
+		URLKey:        urlKey(params.URL),
+		Title:         params.Title,
+		Description:   params.Description,
+		CreatedAt:     Date(createdAt),
+		UpdatedAt:     Timestamp(now),
+		Snapshot:      params.Snapshot,
+		Preview:       params.Preview,
+		Listed:        params.Listed,
+		OGTitle:       params.OpenGraph.Title,
+		OGDescription: params.OpenGraph.Description,
+		OGImage:       params.OpenGraph.Image,
+		ActivatesAt:   toDatePtr(params.ActivatesAt),
+		ExpiresAt:     toDatePtr(params.ExpiresAt),
+		OwnerID:       params.OwnerID,
+		Rules:         params.Rules,
+		CampaignID:    params.CampaignID,
+	}
+}
+
+// GetBySlug returns the link on domain with the given slug, or with the slug as one of its aliases.
+// An empty domain is that of the links on any domain.
+func (r *LinksRepo) GetBySlug(ctx context.Context, domain, slug string) (*internal.Link, error) {
+	return r.getBySlug(ctx, r.db, []string{domain}, slug)
+}
+
+// Resolve returns the link a visitor of slug on host is sent to, the one on host or else the one on any domain.
+func (r *LinksRepo) Resolve(ctx context.Context, host, slug string) (*internal.Link, error) {
+	return r.getBySlug(ctx, r.db, []string{host, ""}, slug)
+}
+
+// ResolveTx is Resolve in tx, which sees the links created in it.
+func (r *LinksRepo) ResolveTx(ctx context.Context, tx *Tx, host, slug string) (*internal.Link, error) {
+	return r.getBySlug(ctx, tx.tx, []string{host, ""}, slug)
+}
+
+// ExistingSlugs returns those of slugs that lead a visitor of host to a link, at most limit of them.
+// Candidates are matched in a single lookup on the slug index, so a long list costs one query.
+func (r *LinksRepo) ExistingSlugs(ctx context.Context, host string, slugs []string, limit int) ([]string, error) {
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+	var slugCol interface {
+		exp.Expression
+		exp.Inable
+	} = goqu.C("slug")
+	if r.caseInsensitiveSlugs {
+		// Candidates are lowercase, as are the generated slugs they're near
+		slugCol = goqu.Func("lower", goqu.C("slug"))
+	}
+	q := r.db.
+		From("links").
+		Select(slugCol).
+		Where(goqu.C("domain").In(host, ""), slugCol.In(slugs)).
+		Order(goqu.C("slug").Asc()).
+		Limit(uint(limit))
+
+	var existing []string
+	if err := retryBusy(ctx, func() error {
+		existing = nil
+		return q.ScanValsContext(ctx, &existing)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan slugs: %w", err)
+	}
+	return lo.Uniq(existing), nil
+}
+
+// getBySlug is GetBySlug for the links on any of domains, those on a domain win over those on any.
+func (r *LinksRepo) getBySlug(ctx context.Context, db queryer, domains []string, slug string) (*internal.Link, error) {
+	q := db.
+		From("links").
+		Where(
+			goqu.C("domain").In(domains),
+			goqu.Or(
+				r.slugIs("slug", slug),
+				goqu.I("id").In(db.From("slug_aliases").Select("link_id").Where(goqu.C("domain").In(domains), r.slugIs("slug", slug))),
+			),
+		).
+		Order(goqu.C("domain").Desc()).
+		Limit(1).
+		Select(linkRow{})
+
+	var row linkRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = q.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan link: %w", err)
+	} else if !found {
+		return nil, internal.ErrLinkNotFound
+	}
+
+	return row.toDomain(), nil
+}
+
+func (r *LinksRepo) GetByID(ctx context.Context, id int64) (*internal.Link, error) {
+	q := r.db.
+		From("links").
+		Where(goqu.I("id").Eq(id)).
+		Select(linkRow{})
 
+	var row linkRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = q.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan link: %w", err)
+	} else if !found {
+		return nil, internal.ErrLinkNotFound
+	}
+
+	link := row.toDomain()
+	if err := r.attachTags(ctx, []*internal.Link{link}); err != nil {
+		return nil, err
+	}
 	return link, nil
 }
 
-func (r *LinksRepo) GetBySlug(ctx context.Context, slug string) (*internal.Link, error) {
+func (r *LinksRepo) GetByStatsToken(ctx context.Context, token string) (*internal.Link, error) {
 	q := r.db.
 		From("links").
-		Where(goqu.I("slug").Eq(slug)).
+		Where(goqu.I("stats_token").Eq(token)).
 		Select(linkRow{})
 
 	var row linkRow
-	found, err := q.ScanStructContext(ctx, &row)
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = q.ScanStructContext(ctx, &row)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan link: %w", err)
 	} else if !found {
@@ -72,40 +351,359 @@ func (r *LinksRepo) GetBySlug(ctx context.Context, slug string) (*internal.Link,
 	return row.toDomain(), nil
 }
 
+// RotateStatsToken gives the link a fresh public stats token, invalidating any previous one.
+func (r *LinksRepo) RotateStatsToken(ctx context.Context, id int64) (string, error) {
+	token := crand.Text()
+	if err := r.setStatsToken(ctx, id, &token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (r *LinksRepo) RevokeStatsToken(ctx context.Context, id int64) error {
+	return r.setStatsToken(ctx, id, nil)
+}
+
+func (r *LinksRepo) setStatsToken(ctx context.Context, id int64, token *string) error {
+	query := r.db.Update("links").
+		Set(goqu.Record{"stats_token": token, "updated_at": Timestamp(r.Now())}).
+		Where(goqu.I("id").Eq(id))
+
+	var result sql.Result
+	err := retryBusy(ctx, func() (err error) {
+		result, err = query.Executor().ExecContext(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update stats token: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return internal.ErrLinkNotFound
+	}
+
+	return nil
+}
+
 func (r *LinksRepo) ListAll(ctx context.Context) ([]*internal.Link, error) {
-	query := r.db.From("links").
+	return r.List(ctx, LinkFilter{}, LinkOrder{}, LinkPage{})
+}
+
+// LinkFilter narrows down the links of List, its zero value keeps all of them.
+type LinkFilter struct {
+	// URL keeps the links whose destination normalizes to the same URL
+	URL string
+	// Tags keeps the links that have all of them
+	Tags []string
+	// Search keeps the links with it in their slug, URL, title or description, ignoring case
+	Search string
+	// CampaignID keeps the links of the campaign
+	CampaignID *int64
+	// Domain keeps the links on the domain, an empty one those on any domain
+	Domain *string
+	// Health keeps the links by the outcome of the last check of their destination
+	Health HealthFilter
+}
+
+// LinkSort is what List orders the links by.
+type LinkSort string
+
+const (
+	SortByCreatedAt LinkSort = "created_at"
+	// SortByClicks orders by all clicks of the links, purged ones included
+	SortByClicks LinkSort = "clicks"
+	// SortByLastClick orders by the last click, links never clicked are the least recently clicked
+	SortByLastClick LinkSort = "last_click"
+)
+
+// LinkOrder is the order of the links of List, its zero value is newest first.
+type LinkOrder struct {
+	By        LinkSort
+	Ascending bool
+}
+
+// LinkPage is the part of the links of List to return, its zero value returns all of them.
+type LinkPage struct {
+	// Limit is the most links to return, zero for no limit
+	Limit int
+	// After starts the page right after the link of the cursor, nil at the first link
+	After *LinkCursor
+}
+
+// List returns the page of the links matching filter in order, with their stats. Links that sort
+// the same are newest first, or oldest first in ascending order.
+func (r *LinksRepo) List(ctx context.Context, filter LinkFilter, order LinkOrder, page LinkPage) ([]*internal.Link, error) {
+	search, tags := filter.Search, filter.Tags
+	// The stats come with the links, and sorting by them in the database keeps links without
+	// clicks and the order across pages
+	query := r.joinActivity(r.db.From("links")).
 		Select(linkRow{}).
-		Order(goqu.C("id").Desc())
+		SelectAppend(
+			activityClicks.As("total"),
+			activityUniqueClicks.As("unique_clicks"),
+			activityLastClick.As("last_clicked_at"),
+		).
+		Order(r.linkOrder(order)...)
+	if page.After != nil {
+		query = query.Where(page.After.condition(order))
+	}
+	if page.Limit > 0 {
+		query = query.Limit(uint(page.Limit))
+	}
+	if filter.URL != "" {
+		query = query.Where(goqu.C("url_key").Eq(urlKey(filter.URL)))
+	}
+	if filter.CampaignID != nil {
+		query = query.Where(goqu.C("campaign_id").Eq(*filter.CampaignID))
+	}
+	if filter.Domain != nil {
+		query = query.Where(goqu.C("domain").Eq(*filter.Domain))
+	}
+	if filter.Health != "" {
+		query = query.Where(filter.Health.condition())
+	}
+	if search != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(search)) + "%"
+		query = query.Where(goqu.Or(lo.Map(searchedColumns, func(col string, _ int) exp.Expression {
+			// LOWER on both sides, as LIKE ignores case in SQLite but not in Postgres
+			return goqu.L(`LOWER(?) LIKE ? ESCAPE '\'`, goqu.C(col), pattern)
+		})...))
+	}
+	if len(tags) > 0 {
+		query = query.Where(goqu.C("id").In(
+			r.db.From("link_tags").
+				Select("link_id").
+				Where(goqu.C("tag").In(tags)).
+				GroupBy("link_id").
+				Having(goqu.COUNT("*").Eq(len(tags))),
+		))
+	}
 
-	var rows []linkRow
-	err := query.Executor().ScanStructsContext(ctx, &rows)
+	var rows []listedLinkRow
+	err := retryBusy(ctx, func() error {
+		return query.Executor().ScanStructsContext(ctx, &rows)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	clicksRepo := NewClicksRepo(r.db.Db.(*sql.DB))
+	links := lo.Map(rows, func(row listedLinkRow, _ int) *internal.Link {
+		link := row.linkRow.toDomain()
+		link.Stats = row.clickStatsRow.toDomain()
+		return link
+	})
 
-	links := make([]*internal.Link, len(rows))
-	for i, row := range rows {
-		link := row.toDomain()
+	if err := r.attachTags(ctx, links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// listedLinkRow is a link of List with its stats, counted the same as GetStatsForLink does.
+type listedLinkRow struct {
+	linkRow
+	clickStatsRow
+}
 
-		stats, err := clicksRepo.GetStatsForLink(ctx, link.ID)
-		if err == nil {
-			link.Stats = stats
+// joinActivity joins the clicks and purged clicks of the links, counted per link, for the stats of
+// List and for linkOrder to sort by. Links without clicks are kept with nulls.
+func (r *LinksRepo) joinActivity(query *goqu.SelectDataset) *goqu.SelectDataset {
+	clicks := r.db.From("clicks").
+		Select(
+			goqu.C("link_id"),
+			goqu.COUNT("*").As("clicks"),
+			goqu.COUNT(goqu.DISTINCT("visitor_hash")).As("unique_clicks"),
+			goqu.MAX("clicked_at").As("last_clicked_at"),
+		).
+		Where(countedClick).
+		GroupBy("link_id")
+	rollups := r.db.From("click_rollups").
+		Select(
+			goqu.C("link_id"),
+			goqu.SUM("clicks").As("clicks"),
+			goqu.SUM("unique_clicks").As("unique_clicks"),
+			goqu.MAX("last_clicked_at").As("last_clicked_at"),
+		).
+		GroupBy("link_id")
+	return query.
+		LeftJoin(clicks.As("recent"), goqu.On(goqu.I("recent.link_id").Eq(goqu.I("links.id")))).
+		LeftJoin(rollups.As("purged"), goqu.On(goqu.I("purged.link_id").Eq(goqu.I("links.id"))))
+}
+
+var (
+	// activityClicks are all clicks of a link of joinActivity
+	activityClicks = goqu.L("COALESCE(?, 0) + COALESCE(?, 0)", goqu.I("recent.clicks"), goqu.I("purged.clicks"))
+	// activityUniqueClicks are the unique clicks of a link of joinActivity
+	activityUniqueClicks = goqu.L("COALESCE(?, 0) + COALESCE(?, 0)", goqu.I("recent.unique_clicks"), goqu.I("purged.unique_clicks"))
+	// activityLastClick is the last click of a link of joinActivity, null when it has none.
+	// A CASE rather than MAX/GREATEST, which differ between SQLite and Postgres.
+	activityLastClick = goqu.L("CASE WHEN ? IS NULL OR ? > ? THEN ? ELSE ? END",
+		goqu.I("purged.last_clicked_at"), goqu.I("recent.last_clicked_at"), goqu.I("purged.last_clicked_at"),
+		goqu.I("recent.last_clicked_at"), goqu.I("purged.last_clicked_at"))
+)
+
+// linkOrder is the ORDER BY of order, ties are broken by creation in the same direction.
+func (r *LinksRepo) linkOrder(order LinkOrder) []exp.OrderedExpression {
+	sorted := func(col exp.Orderable) exp.OrderedExpression {
+		if order.Ascending {
+			return col.Asc()
 		}
+		return col.Desc()
+	}
+	// Links created in the same second keep the order they were created in
+	byCreation := []exp.OrderedExpression{sorted(goqu.I("links.created_at")), sorted(goqu.I("links.id"))}
 
-		links[i] = link
+	switch order.By {
+	case SortByClicks:
+		return append([]exp.OrderedExpression{sorted(activityClicks)}, byCreation...)
+	case SortByLastClick:
+		lastClick := activityLastClick.Desc().NullsLast()
+		if order.Ascending {
+			lastClick = activityLastClick.Asc().NullsFirst()
+		}
+		return append([]exp.OrderedExpression{lastClick}, byCreation...)
+	}
+	return byCreation
+}
+
+// FindByURL returns the links whose destination normalizes to the same URL as rawURL, oldest first.
+func (r *LinksRepo) FindByURL(ctx context.Context, rawURL string) ([]*internal.Link, error) {
+	query := r.db.From("links").
+		Select(linkRow{}).
+		Where(goqu.C("url_key").Eq(urlKey(rawURL))).
+		Order(goqu.C("id").Asc())
+
+	var rows []linkRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan links by url: %w", err)
 	}
 
+	links := lo.Map(rows, func(row linkRow, _ int) *internal.Link {
+		return row.toDomain()
+	})
+	if err := r.attachTags(ctx, links); err != nil {
+		return nil, err
+	}
 	return links, nil
 }
 
+// BackfillURLKeys fills in the normalized URL of links created before it was stored.
+func (r *LinksRepo) BackfillURLKeys(ctx context.Context) error {
+	query := r.db.From("links").
+		Select("id", "url").
+		Where(goqu.C("url_key").Eq(""))
+
+	var rows []struct {
+		ID  int64  `db:"id"`
+		URL string `db:"url"`
+	}
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return fmt.Errorf("failed to scan links without url key: %w", err)
+	}
+
+	for _, row := range rows {
+		err := retryBusy(ctx, func() error {
+			_, err := r.db.Update("links").
+				Set(goqu.Record{"url_key": urlKey(row.URL)}).
+				Where(goqu.C("id").Eq(row.ID)).
+				Executor().ExecContext(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set url key of link %d: %w", row.ID, err)
+		}
+	}
+	return nil
+}
+
+// urlKey is the normalized URL, or the URL itself when it can't be normalized so it only matches exactly.
+func urlKey(rawURL string) string {
+	key, err := urlutil.Normalize(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return key
+}
+
+// ChangeToken returns a value that changes whenever links are created, updated, deleted or
+// checked, clicks are recorded, or a link becomes active or expires, so clients can tell cheaply
+// whether a list is still current. It reads the version of migration 28 and the next time a status
+// of a link changes, both off an index.
+func (r *LinksRepo) ChangeToken(ctx context.Context) (string, error) {
+	now := Date(r.Now().UTC())
+	query := r.db.Select(
+		r.db.From("links_version").Select("version").As("version"),
+		r.db.From("links").Select(goqu.MIN("activates_at")).Where(goqu.C("activates_at").Gt(now)).As("next_activation"),
+		r.db.From("links").Select(goqu.MIN("expires_at")).Where(goqu.C("expires_at").Gt(now)).As("next_expiry"),
+	)
+
+	var row struct {
+		Version        int64 `db:"version"`
+		NextActivation *Date `db:"next_activation"`
+		NextExpiry     *Date `db:"next_expiry"`
+	}
+	if err := retryBusy(ctx, func() error {
+		_, err := query.ScanStructContext(ctx, &row)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("failed to read links change token: %w", err)
+	}
+
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d/%d/%d", row.Version,
+		lo.FromPtr(row.NextActivation).Time().UnixNano(), lo.FromPtr(row.NextExpiry).Time().UnixNano()))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// bumpLinksVersion changes the token of ChangeToken for writes its triggers don't see.
+func bumpLinksVersion(ctx context.Context, tx *goqu.TxDatabase) error {
+	_, err := tx.Update("links_version").Set(goqu.Record{"version": goqu.L("version + 1")}).Executor().ExecContext(ctx)
+	return err
+}
+
+// ListListed returns a page of active links opted into the public directory, newest first.
+// Scheduled links stay hidden until they activate.
+func (r *LinksRepo) ListListed(ctx context.Context, limit, offset int) ([]*internal.Link, error) {
+	now := Date(r.Now().UTC())
+	query := r.db.From("links").
+		Select(linkRow{}).
+		Where(
+			goqu.C("listed").IsTrue(),
+			goqu.Or(goqu.C("activates_at").IsNull(), goqu.C("activates_at").Lte(now)),
+			goqu.Or(goqu.C("expires_at").IsNull(), goqu.C("expires_at").Gt(now)),
+		).
+		Order(goqu.C("id").Desc()).
+		Limit(uint(limit)).
+		Offset(uint(offset))
+
+	var rows []linkRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan listed links: %w", err)
+	}
+
+	return lo.Map(rows, func(row linkRow, _ int) *internal.Link {
+		return row.toDomain()
+	}), nil
+}
+
 func (r *LinksRepo) Delete(ctx context.Context, id int64) error {
 	query := r.db.From("links").
 		Where(goqu.I("id").Eq(id)).
 		Delete()
 
-	result, err := query.Executor().ExecContext(ctx)
+	var result sql.Result
+	err := retryBusy(ctx, func() (err error) {
+		result, err = query.Executor().ExecContext(ctx)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete link: %w", err)
 	}
@@ -120,29 +718,145 @@ func (r *LinksRepo) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
+// searchedColumns are the columns of a link matched by a search
+var searchedColumns = []string{"slug", "url", "title", "description"}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, with a backslash as the escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func (r *linkRow) toDomain() *internal.Link {
 	return &internal.Link{
-		ID:        r.ID,
-		Slug:      r.Slug,
-		URL:       r.URL,
-		CreatedAt: r.CreatedAt.Time(),
+		ID:          r.ID,
+		Domain:      r.Domain,
+		Slug:        r.Slug,
+		URL:         r.URL,
+		Title:       r.Title,
+		Description: r.Description,
+		CreatedAt:   r.CreatedAt.Time(),
+		UpdatedAt:   r.UpdatedAt.Time(),
+		StatsToken:  lo.FromPtr(r.StatsToken),
+		Snapshot:    r.Snapshot,
+		Preview:     r.Preview,
+		Listed:      r.Listed,
+		OpenGraph: internal.OpenGraph{
+			Title:       r.OGTitle,
+			Description: r.OGDescription,
+			Image:       r.OGImage,
+		},
+		ActivatesAt: fromDatePtr(r.ActivatesAt),
+		ExpiresAt:   fromDatePtr(r.ExpiresAt),
+		OwnerID:     r.OwnerID,
+		Rules:       r.Rules,
+		CampaignID:  r.CampaignID,
+		Health:      r.health(),
+	}
+}
+
+func toDatePtr(t *time.Time) *Date {
+	if t == nil {
+		return nil
 	}
+	return lo.ToPtr(Date(t.UTC()))
 }
 
-func GenerateSlug() string {
-	const charset = "abcdefghjkmnopqrstuvwxyz0123456789"
-	const slugLength = 6
-	slug := make([]byte, slugLength)
-	for i := range slug {
-		slug[i] = charset[rand.Intn(len(charset))]
+// fromDatePtr returns nil for a missing or zero date.
+func fromDatePtr(d *Date) *time.Time {
+	if d == nil || d.IsZero() {
+		return nil
 	}
-	return string(slug)
+	return lo.ToPtr(d.Time())
 }
 
-func isUniqueConstraintError(err error) bool {
-	var sqliteErr *sqlite.Error
-	if errors.As(err, &sqliteErr) {
-		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+// LinkUpdate holds the fields of a link that can be changed after it's created.
+type LinkUpdate struct {
+	Description string
+	// ActivatesAt and ExpiresAt bound when the link redirects, nil removes a bound
+	ActivatesAt *time.Time
+	ExpiresAt   *time.Time
+	// CampaignID must be an existing campaign, nil removes the link from its campaign
+	CampaignID *int64
+}
+
+// Update replaces the changeable fields of a link.
+func (r *LinksRepo) Update(ctx context.Context, id int64, params LinkUpdate) (*internal.Link, error) {
+	// Safe to retry on a busy database, setting the same values twice changes nothing
+	var row linkRow
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			if err := checkCampaign(ctx, tx, params.CampaignID); err != nil {
+				return err
+			}
+			found, err := tx.Update("links").
+				Set(goqu.Record{
+					"description":  params.Description,
+					"activates_at": toDatePtr(params.ActivatesAt),
+					"expires_at":   toDatePtr(params.ExpiresAt),
+					"campaign_id":  params.CampaignID,
+					"updated_at":   Timestamp(r.Now().UTC()),
+				}).
+				Where(goqu.C("id").Eq(id)).
+				Returning(linkRow{}).
+				Executor().ScanStructContext(ctx, &row)
+			if err != nil {
+				return err
+			} else if !found {
+				return internal.ErrLinkNotFound
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrLinkNotFound) || errors.Is(err, internal.ErrCampaignNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update link: %w", err)
+	}
+
+	link := row.toDomain()
+	if err := r.attachTags(ctx, []*internal.Link{link}); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// ReplaceDestination points the link with the given slug at another URL, keeping its clicks.
+// Its title, description and, when non-zero, creation time are replaced too. It returns internal.ErrLinkNotFound
+// when no link has the slug, also when the slug is an alias.
+func (r *LinksRepo) ReplaceDestination(ctx context.Context, params NewLink) (*internal.Link, error) {
+	record := goqu.Record{
+		"url":         params.URL,
+		"url_key":     urlKey(params.URL),
+		"title":       params.Title,
+		"description": params.Description,
+		"updated_at":  Timestamp(r.Now().UTC()),
+		// The check was of the old destination
+		"check_result": "",
+		"check_status": 0,
+		"checked_at":   nil,
 	}
-	return false
+	if !params.CreatedAt.IsZero() {
+		record["created_at"] = Date(params.CreatedAt.UTC())
+	}
+	query := r.db.Update("links").
+		Set(record).
+		Where(goqu.C("domain").Eq(params.Domain), r.slugIs("slug", params.Slug)).
+		Returning(linkRow{})
+
+	// Safe to retry on a busy database, setting the same values twice changes nothing
+	var row linkRow
+	var found bool
+	if err := retryBusy(ctx, func() (err error) {
+		found, err = query.Executor().ScanStructContext(ctx, &row)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to replace link destination: %w", err)
+	} else if !found {
+		return nil, internal.ErrLinkNotFound
+	}
+
+	link := row.toDomain()
+	if err := r.attachTags(ctx, []*internal.Link{link}); err != nil {
+		return nil, err
+	}
+	return link, nil
 }
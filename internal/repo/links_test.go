@@ -0,0 +1,372 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+	"github.com/samber/lo"
+)
+
+func TestCreate(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+
+		link := f.Link(t, func(l *repo.NewLink) {
+			l.Slug = "promo"
+			l.URL = "https://example.com/promo?utm_source=mail"
+			l.Title = "Spring promo"
+			l.Description = "shared in the newsletter"
+			l.Tags = []string{"mail", "spring"}
+		})
+		testutil.Golden(t, "create", link)
+
+		_, err := f.Links.Create(context.Background(), repo.NewLink{Slug: "promo", URL: "https://example.com/other"})
+		if !errors.Is(err, internal.ErrSlugExists) {
+			t.Errorf("creating a taken slug returned %v, want ErrSlugExists", err)
+		}
+	})
+}
+
+func TestGetBySlug(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+
+		created := f.Link(t, func(l *repo.NewLink) { l.Slug = "docs" })
+		f.Clock.Advance(time.Hour)
+		if _, err := f.Links.ChangeSlug(ctx, created.ID, "manual", true); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := f.Links.GetBySlug(ctx, "", "manual")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Golden(t, "get_by_slug", link)
+
+		// The old slug is kept as an alias of the link
+		alias, err := f.Links.GetBySlug(ctx, "", "docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias.ID != created.ID {
+			t.Errorf("alias resolved to link %d, want %d", alias.ID, created.ID)
+		}
+
+		if _, err := f.Links.GetBySlug(ctx, "", "missing"); !errors.Is(err, internal.ErrLinkNotFound) {
+			t.Errorf("looking up a missing slug returned %v, want ErrLinkNotFound", err)
+		}
+	})
+}
+
+func TestCreateOrGetConcurrently(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		params := repo.NewLink{Slug: "retried", URL: "https://example.com/retried", Tags: []string{"a", "b"}}
+
+		// Like a client retrying a request that timed out, many times over
+		const callers = 50
+		var created atomic.Int64
+		ids := make([]int64, callers)
+		var wg sync.WaitGroup
+		for i := range callers {
+			wg.Go(func() {
+				link, ok, err := f.Links.CreateOrGet(context.Background(), params)
+				if err != nil {
+					t.Errorf("caller %d: %v", i, err)
+					return
+				}
+				if ok {
+					created.Add(1)
+				}
+				ids[i] = link.ID
+			})
+		}
+		wg.Wait()
+
+		if n := created.Load(); n != 1 {
+			t.Errorf("%d callers created the link, want 1", n)
+		}
+		for i, id := range ids {
+			if id != ids[0] {
+				t.Errorf("caller %d got link %d, caller 0 link %d", i, id, ids[0])
+			}
+		}
+		var links, tags int
+		if err := sqlDB.QueryRow("SELECT COUNT(*) FROM links").Scan(&links); err != nil {
+			t.Fatal(err)
+		}
+		if err := sqlDB.QueryRow("SELECT COUNT(*) FROM link_tags").Scan(&tags); err != nil {
+			t.Fatal(err)
+		}
+		if links != 1 || tags != len(params.Tags) {
+			t.Errorf("got %d links with %d tags, want 1 with %d", links, tags, len(params.Tags))
+		}
+	})
+}
+
+func TestListAll(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+
+		first := f.Link(t)
+		f.Clock.Advance(time.Minute)
+		second := f.Link(t, func(l *repo.NewLink) { l.Tags = []string{"b", "a"} })
+		f.Click(t, first)
+		f.Clock.Advance(time.Minute)
+		f.Click(t, first)
+		f.Click(t, second)
+
+		links, err := f.Links.ListAll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Golden(t, "list_all", links)
+	})
+}
+
+func TestListPages(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+
+		// Ties in clicks, in last clicks and in creation, links never clicked, and purged clicks
+		var links []*internal.Link
+		for i := range 7 {
+			if i%2 == 0 {
+				f.Clock.Advance(time.Minute)
+			}
+			links = append(links, f.Link(t))
+		}
+		clickAt(t, f, links[0], "2023-11-10 09:00:00", "v1", "")
+		clickAt(t, f, links[0], "2024-01-14 10:00:00", "v2", "")
+		clickAt(t, f, links[1], "2024-01-14 10:00:00", "v1", "")
+		clickAt(t, f, links[1], "2024-01-14 11:00:00", "v1", "")
+		clickAt(t, f, links[2], "2024-01-14 11:00:00", "v3", "")
+		clickAt(t, f, links[4], "2023-11-10 09:00:00", "v1", "")
+		clickAt(t, f, links[4], "2023-11-11 09:00:00", "v2", "")
+		clickAt(t, f, links[5], "2024-01-14 12:00:00", "v1", http.MethodHead)
+		if _, err := f.Clicks.PurgeBefore(ctx, f.Clock.Now().AddDate(0, 0, -5)); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, by := range []repo.LinkSort{repo.SortByCreatedAt, repo.SortByClicks, repo.SortByLastClick} {
+			for _, ascending := range []bool{false, true} {
+				order := repo.LinkOrder{By: by, Ascending: ascending}
+				t.Run(fmt.Sprintf("%s ascending %t", by, ascending), func(t *testing.T) {
+					all, err := f.Links.List(ctx, repo.LinkFilter{}, order, repo.LinkPage{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(all) != len(links) {
+						t.Fatalf("got %d links, want %d", len(all), len(links))
+					}
+					// The stats of the list are those of each link on its own
+					for _, link := range all {
+						stats, err := f.Clicks.GetStatsForLink(ctx, link.ID)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !reflect.DeepEqual(link.Stats, stats) {
+							t.Errorf("stats of link %d: got %+v, want %+v", link.ID, link.Stats, stats)
+						}
+					}
+
+					// Pages continue where the last one ended, in the same order
+					var paged []int64
+					page := repo.LinkPage{Limit: 2}
+					for range len(links) {
+						links, err := f.Links.List(ctx, repo.LinkFilter{}, order, page)
+						if err != nil {
+							t.Fatal(err)
+						}
+						for _, link := range links {
+							paged = append(paged, link.ID)
+						}
+						if len(links) < page.Limit {
+							break
+						}
+						cursor, err := repo.ParseLinkCursor(repo.NewLinkCursor(links[len(links)-1]).String())
+						if err != nil {
+							t.Fatal(err)
+						}
+						page.After = &cursor
+					}
+					want := lo.Map(all, func(link *internal.Link, _ int) int64 { return link.ID })
+					if !slices.Equal(paged, want) {
+						t.Errorf("got pages of %v, want %v", paged, want)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestGetStatsForLink(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+
+		link := f.Link(t)
+		unclicked := f.Link(t)
+		f.Click(t, link, func(c *repo.NewClick) { c.VisitorHash = "a" })
+		f.Clock.Advance(time.Hour)
+		f.Click(t, link, func(c *repo.NewClick) { c.VisitorHash = "a" })
+		f.Click(t, link, func(c *repo.NewClick) { c.VisitorHash = "b" })
+		// Checks of the link aren't visits
+		f.Click(t, link, func(c *repo.NewClick) { c.Method = "HEAD" })
+
+		stats, err := f.Clicks.GetStatsForLink(ctx, link.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		none, err := f.Clicks.GetStatsForLink(ctx, unclicked.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Golden(t, "stats_for_link", map[string]*internal.LinkStats{"clicked": stats, "unclicked": none})
+	})
+}
+
+func TestSlugCase(t *testing.T) {
+	for _, caseInsensitive := range []bool{false, true} {
+		t.Run(fmt.Sprintf("case-insensitive %t", caseInsensitive), func(t *testing.T) {
+			testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+				ctx := context.Background()
+				if err := db.SetSlugCaseInsensitive(ctx, sqlDB, caseInsensitive); err != nil {
+					t.Fatal(err)
+				}
+				f := testutil.NewFixturesIn(sqlDB)
+				f.Links = repo.NewLinksRepo(sqlDB, caseInsensitive)
+				f.Links.Now = f.Clock.Now
+
+				// Slugs keep the case they're created with either way
+				promo := f.Link(t, func(l *repo.NewLink) { l.Slug = "Promo" })
+				if promo.Slug != "Promo" {
+					t.Errorf("created slug %q, want Promo", promo.Slug)
+				}
+
+				for _, slug := range []string{"Promo", "promo", "PROMO"} {
+					link, err := f.Links.GetBySlug(ctx, "", slug)
+					switch {
+					case slug == "Promo" || caseInsensitive:
+						if err != nil || link.ID != promo.ID {
+							t.Errorf("looking up %s: got %v, %v, want link %d", slug, link, err, promo.ID)
+						}
+					case !errors.Is(err, internal.ErrLinkNotFound):
+						t.Errorf("looking up %s: got %v, want ErrLinkNotFound", slug, err)
+					}
+				}
+
+				_, err := f.Links.Create(ctx, repo.NewLink{Slug: "PROMO", URL: "https://example.com/other"})
+				if caseInsensitive && !errors.Is(err, internal.ErrSlugExists) {
+					t.Errorf("creating PROMO next to Promo returned %v, want ErrSlugExists", err)
+				}
+				if !caseInsensitive && err != nil {
+					t.Errorf("creating PROMO next to Promo returned %v, want it created", err)
+				}
+
+				// Aliases of an old slug collide the same way
+				if _, err := f.Links.ChangeSlug(ctx, promo.ID, "Spring", true); err != nil {
+					t.Fatal(err)
+				}
+				if link, err := f.Links.GetBySlug(ctx, "", "promo"); caseInsensitive && (err != nil || link.ID != promo.ID) {
+					t.Errorf("looking up the alias promo: got %v, %v, want link %d", link, err, promo.ID)
+				}
+				taken, err := f.Links.TakenSlugs(ctx, []string{"spring", "promo", "summer"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var want []string
+				if caseInsensitive {
+					want = []string{"spring", "promo"}
+				}
+				if !slices.Equal(taken, want) {
+					t.Errorf("taken slugs: got %v, want %v", taken, want)
+				}
+			})
+		})
+	}
+}
+
+func TestDeleteCascades(t *testing.T) {
+	run := func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+
+		// A link with a row in each table depending on it, and another one whose rows stay
+		var links []*internal.Link
+		for _, slug := range []string{"deleted", "kept-link"} {
+			link := f.Link(t, func(l *repo.NewLink) { l.Slug = slug; l.Tags = []string{"mail", "spring"} })
+			f.Click(t, link, func(c *repo.NewClick) { c.ClickedAt = testutil.Epoch.AddDate(0, -2, 0) })
+			f.Click(t, link, func(c *repo.NewClick) { c.ClickedAt = testutil.Epoch.AddDate(0, -1, 0) })
+			f.Click(t, link)
+			if _, err := f.Links.ChangeSlug(ctx, link.ID, slug+"-renamed", true); err != nil {
+				t.Fatal(err)
+			}
+			snapshot := &internal.Snapshot{LinkID: link.ID, TakenAt: f.Clock.Now(), Headers: map[string]string{}, Body: []byte("<html>")}
+			if err := repo.NewSnapshotsRepo(sqlDB).Create(ctx, snapshot, repo.SnapshotLimits{MaxPerLink: 5, MaxTotalBytes: 1 << 20}); err != nil {
+				t.Fatal(err)
+			}
+			links = append(links, link)
+		}
+		// The oldest clicks are rolled up, those of last month stay in a partition of their own
+		if _, err := f.Clicks.PurgeBefore(ctx, testutil.Epoch.AddDate(0, -1, -1)); err != nil {
+			t.Fatal(err)
+		}
+
+		dependents := []string{"clicks", "click_rollups", "link_tags", "slug_aliases", "snapshots"}
+		count := func(table string, link *internal.Link) int {
+			var n int
+			if err := sqlDB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE link_id = $1", link.ID).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+		for _, table := range dependents {
+			if count(table, links[0]) == 0 {
+				t.Fatalf("no %s of the link to delete, the test doesn't cover them", table)
+			}
+		}
+
+		if err := f.Links.Delete(ctx, links[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range dependents {
+			if n := count(table, links[0]); n != 0 {
+				t.Errorf("%d %s of the deleted link left", n, table)
+			}
+			if count(table, links[1]) == 0 {
+				t.Errorf("%s of the other link deleted", table)
+			}
+		}
+		if _, err := f.Links.GetBySlug(ctx, "", "deleted"); !errors.Is(err, internal.ErrLinkNotFound) {
+			t.Errorf("looking up the alias of the deleted link returned %v, want ErrLinkNotFound", err)
+		}
+
+		if err := f.Links.Delete(ctx, links[0].ID); !errors.Is(err, internal.ErrLinkNotFound) {
+			t.Errorf("deleting it again returned %v, want ErrLinkNotFound", err)
+		}
+	}
+
+	testutil.ForEachBackend(t, run)
+	// The foreign_keys pragma is set on each connection, so it holds on any connection of a pool
+	t.Run("file", func(t *testing.T) {
+		sqlDB, _ := testutil.NewFileDB(t, "WAL")
+		sqlDB.SetMaxOpenConns(4)
+		run(t, sqlDB)
+	})
+}
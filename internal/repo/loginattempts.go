@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+)
+
+type loginAttemptRow struct {
+	ID          int64  `db:"id" goqu:"skipinsert"`
+	Username    string `db:"username"`
+	IPAddress   string `db:"ip_address"`
+	Cleared     bool   `db:"cleared"`
+	AttemptedAt Date   `db:"attempted_at"`
+}
+
+func (r loginAttemptRow) toDomain() *internal.LoginAttempt {
+	return &internal.LoginAttempt{
+		ID:          r.ID,
+		Username:    r.Username,
+		IPAddress:   r.IPAddress,
+		Cleared:     r.Cleared,
+		AttemptedAt: r.AttemptedAt.Time(),
+	}
+}
+
+type LoginAttemptsRepo struct {
+	db *goqu.Database
+}
+
+func NewLoginAttemptsRepo(db *sql.DB) *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{db: newDatabase(db)}
+}
+
+// RecordFailure stores a failed login of username from ipAddress.
+func (r *LoginAttemptsRepo) RecordFailure(ctx context.Context, username, ipAddress string) error {
+	query := r.db.Insert("login_attempts").Rows(loginAttemptRow{
+		Username:    username,
+		IPAddress:   ipAddress,
+		AttemptedAt: Date(time.Now().UTC()),
+	})
+
+	// Retrying a busy insert may count a failure twice, which only brings a lockout closer
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// CountFailures returns the failed logins from ipAddress since, not cleared by a successful one.
+func (r *LoginAttemptsRepo) CountFailures(ctx context.Context, ipAddress string, since time.Time) (int, error) {
+	query := r.db.From("login_attempts").
+		Select(goqu.COUNT("*")).
+		Where(
+			goqu.C("ip_address").Eq(ipAddress),
+			goqu.C("cleared").IsFalse(),
+			goqu.C("attempted_at").Gte(Date(since.UTC())),
+		)
+
+	var n int
+	if err := retryBusy(ctx, func() error {
+		_, err := query.ScanValContext(ctx, &n)
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("failed to count login attempts: %w", err)
+	}
+	return n, nil
+}
+
+// ClearFailures stops the failed logins from ipAddress counting towards a lockout, they're kept for review.
+func (r *LoginAttemptsRepo) ClearFailures(ctx context.Context, ipAddress string) error {
+	query := r.db.Update("login_attempts").
+		Set(goqu.Record{"cleared": true}).
+		Where(goqu.C("ip_address").Eq(ipAddress), goqu.C("cleared").IsFalse())
+
+	// Safe to retry on a busy database, clearing twice changes nothing
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to clear login attempts: %w", err)
+	}
+	return nil
+}
+
+// LoginAttemptFilter narrows down the attempts of List, empty fields keep all of them.
+type LoginAttemptFilter struct {
+	IPAddress string
+	Username  string
+	Since     time.Time
+	Limit     int
+}
+
+// List returns the failed logins matching filter, newest first.
+func (r *LoginAttemptsRepo) List(ctx context.Context, filter LoginAttemptFilter) ([]*internal.LoginAttempt, error) {
+	query := r.db.From("login_attempts").
+		Select(loginAttemptRow{}).
+		Order(goqu.C("id").Desc()).
+		Limit(uint(filter.Limit))
+	if filter.IPAddress != "" {
+		query = query.Where(goqu.C("ip_address").Eq(filter.IPAddress))
+	}
+	if filter.Username != "" {
+		query = query.Where(goqu.C("username").Eq(filter.Username))
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where(goqu.C("attempted_at").Gte(Date(filter.Since.UTC())))
+	}
+
+	var rows []loginAttemptRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan login attempts: %w", err)
+	}
+
+	attempts := make([]*internal.LoginAttempt, len(rows))
+	for i, row := range rows {
+		attempts[i] = row.toDomain()
+	}
+	return attempts, nil
+}
@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal/db"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// Clicks are kept in a table per month they were made in, see db.ClickPartition, registered in
+// click_partitions. Queries over a time window only read the partitions of the months it covers,
+// queries over all clicks read the clicks view of all partitions. Old months are purged by
+// dropping their partition.
+
+// monthFormat is the month of a partition, like 2024-01
+const monthFormat = "2006-01"
+
+// clickMonth is the UTC month of a click, the first 7 characters of its date, see clickDay.
+func clickMonth(db *goqu.Database) exp.SQLFunctionExpression {
+	if isPostgres(db) {
+		return goqu.Func("to_char", goqu.L("? AT TIME ZONE 'UTC'", goqu.C("clicked_at")), "YYYY-MM")
+	}
+	return goqu.Func("substr", goqu.C("clicked_at"), 1, 7)
+}
+
+type clickPartitionRow struct {
+	Name string `db:"name"`
+	// Month is empty for db.UnpartitionedClicks, which holds clicks of any month
+	Month string `db:"month"`
+}
+
+// partitionFor returns the partition of the clicks of month, which is created unless this repo
+// created or found it before.
+func (r *ClicksRepo) partitionFor(ctx context.Context, month string) (string, error) {
+	name := db.ClickPartition(month)
+	if _, ok := r.partitions.Load(name); ok {
+		return name, nil
+	}
+	// Safe to retry on a busy database, the transaction either applied fully or not at all
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			return r.createPartition(ctx, tx, name, month)
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	r.partitions.Store(name, true)
+	return name, nil
+}
+
+// createPartition creates the partition name of month in tx and adds it to the clicks view,
+// unless it's registered already.
+func (r *ClicksRepo) createPartition(ctx context.Context, tx *goqu.TxDatabase, name, month string) error {
+	// Registered before it's created, so of two replicas creating the same partition, the
+	// second waits for the first to commit and finds it registered
+	res, err := tx.Insert("click_partitions").
+		Rows(goqu.Record{"name": name, "month": month}).
+		OnConflict(goqu.DoNothing()).
+		Executor().ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to register click partition %s: %w", name, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, db.ClickPartitionSchema(r.db.Dialect(), name)); err != nil {
+		return fmt.Errorf("failed to create click partition %s: %w", name, err)
+	}
+	return rebuildClicksView(ctx, tx)
+}
+
+// dropPartition rolls up the clicks of the partition name and drops it, returning the number
+// of clicks it had. Rolling up a whole month at once counts each visitor once a day, unlike
+// the batches of PurgeBefore.
+func (r *ClicksRepo) dropPartition(ctx context.Context, name string) (int64, error) {
+	var n int64
+	// Safe to retry on a busy database, the transaction either applied fully or not at all
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			if _, err := tx.From(name).Select(goqu.COUNT("*")).ScanValContext(ctx, &n); err != nil {
+				return fmt.Errorf("failed to count clicks of %s: %w", name, err)
+			}
+			if err := rollUp(ctx, tx, r.db, goqu.From(name).Where(countedClick)); err != nil {
+				return err
+			}
+			_, err := tx.Delete("click_partitions").Where(goqu.C("name").Eq(name)).Executor().ExecContext(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to unregister click partition %s: %w", name, err)
+			}
+			if err := rebuildClicksView(ctx, tx); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DROP TABLE "+name); err != nil {
+				return fmt.Errorf("failed to drop click partition %s: %w", name, err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.partitions.Delete(name)
+	return n, nil
+}
+
+// nextClickID takes the ID of a new click in tx from click_ids, which all partitions share so
+// that IDs stay unique in the clicks view.
+func nextClickID(ctx context.Context, tx *goqu.TxDatabase, postgres bool) (int64, error) {
+	var id int64
+	var err error
+	if postgres {
+		_, err = tx.Select(goqu.Func("nextval", "click_ids")).ScanValContext(ctx, &id)
+	} else {
+		_, err = tx.Update("click_ids").
+			Set(goqu.Record{"last": goqu.L("last + 1")}).
+			Returning("last").
+			Executor().ScanValContext(ctx, &id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to take click id: %w", err)
+	}
+	return id, nil
+}
+
+// rebuildClicksView points the clicks view at the partitions registered in tx.
+func rebuildClicksView(ctx context.Context, tx *goqu.TxDatabase) error {
+	var names []string
+	err := tx.From("click_partitions").
+		Select("name").
+		Order(goqu.C("month").Asc()).
+		ScanValsContext(ctx, &names)
+	if err != nil {
+		return fmt.Errorf("failed to scan click partitions: %w", err)
+	}
+	if len(names) == 0 {
+		// PurgeBefore never drops the partition of the current month, which it creates first
+		return fmt.Errorf("no click partitions left for the clicks view")
+	}
+	if _, err := tx.ExecContext(ctx, db.ClickViewSchema(names)); err != nil {
+		return fmt.Errorf("failed to rebuild clicks view: %w", err)
+	}
+	return nil
+}
+
+// listPartitions returns the registered partitions, oldest month first, unpartitioned clicks before them.
+func (r *ClicksRepo) listPartitions(ctx context.Context, where ...exp.Expression) ([]clickPartitionRow, error) {
+	query := r.db.From("click_partitions").
+		Select(clickPartitionRow{}).
+		Where(where...).
+		Order(goqu.C("month").Asc())
+
+	var rows []clickPartitionRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan click partitions: %w", err)
+	}
+	return rows, nil
+}
+
+// withClicksSince runs fn with the clicks made since the given time to select from, as clicks.
+// Only the partitions of the months since then and the unpartitioned clicks are read, fn still
+// has to leave out the earlier clicks of the first month. A zero since reads the clicks view.
+// fn runs again with the partitions looked up anew when one was dropped in between, so it must
+// be safe to repeat.
+func (r *ClicksRepo) withClicksSince(ctx context.Context, since time.Time, fn func(clicks exp.Expression) error) error {
+	if since.IsZero() {
+		return fn(goqu.T("clicks"))
+	}
+
+	for attempt := 1; ; attempt++ {
+		partitions, err := r.listPartitions(ctx, goqu.Or(
+			goqu.C("month").Gte(since.UTC().Format(monthFormat)),
+			goqu.C("month").Eq(""),
+		))
+		if err != nil {
+			return err
+		}
+
+		var clicks exp.Expression
+		switch len(partitions) {
+		case 0:
+			// No clicks were recorded since, the view has none either
+			clicks = goqu.T("clicks")
+		case 1:
+			clicks = goqu.T(partitions[0].Name).As("clicks")
+		default:
+			names := make([]string, len(partitions))
+			for i, partition := range partitions {
+				names[i] = partition.Name
+			}
+			clicks = goqu.L("(" + db.ClickUnion(names) + ")").As("clicks")
+		}
+
+		err = fn(clicks)
+		if attempt == 1 && isUndefinedTableError(err) {
+			continue
+		}
+		return err
+	}
+}
+
+// MoveUnpartitioned moves the clicks recorded before clicks were kept by month into the
+// partitions of their months, in batches, and drops their table once it's empty. Clicks keep
+// their IDs, nothing changes for them. It's safe to run on several replicas at once, and again after it
+// failed. It returns the number of moved clicks, which is accurate even when it fails halfway.
+func (r *ClicksRepo) MoveUnpartitioned(ctx context.Context) (int64, error) {
+	month := clickMonth(r.db)
+
+	var moved int64
+	for {
+		var n int
+		// Safe to retry on a busy database, the transaction either applied fully or not at all
+		err := retryBusy(ctx, func() error {
+			return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+				n = 0
+				// The row of the table is locked, so replicas move one batch after another
+				registered := tx.From("click_partitions").
+					Select("name").
+					Where(goqu.C("name").Eq(db.UnpartitionedClicks))
+				if isPostgres(r.db) {
+					registered = registered.ForUpdate(exp.Wait)
+				}
+				var name string
+				found, err := registered.ScanValContext(ctx, &name)
+				if err != nil {
+					return fmt.Errorf("failed to look up unpartitioned clicks: %w", err)
+				} else if !found {
+					return nil
+				}
+
+				var rows []struct {
+					ID    int64  `db:"id"`
+					Month string `db:"month"`
+				}
+				err = tx.From(db.UnpartitionedClicks).
+					Select(goqu.C("id"), month.As("month")).
+					Order(goqu.C("id").Asc()).
+					Limit(purgeBatchSize).
+					ScanStructsContext(ctx, &rows)
+				if err != nil {
+					return fmt.Errorf("failed to scan unpartitioned clicks: %w", err)
+				}
+				if len(rows) == 0 {
+					return dropUnpartitioned(ctx, tx)
+				}
+				n = len(rows)
+
+				byMonth := make(map[string][]int64)
+				ids := make([]int64, len(rows))
+				for i, row := range rows {
+					byMonth[row.Month] = append(byMonth[row.Month], row.ID)
+					ids[i] = row.ID
+				}
+				columns := []any{"id", "link_id", "clicked_at", "user_agent", "ip_address", "referer", "country_code", "visitor_hash", "method"}
+				for m, monthIDs := range byMonth {
+					partition := db.ClickPartition(m)
+					if err := r.createPartition(ctx, tx, partition, m); err != nil {
+						return err
+					}
+					_, err := tx.Insert(partition).
+						Cols(columns...).
+						FromQuery(goqu.From(db.UnpartitionedClicks).Select(columns...).Where(goqu.C("id").In(monthIDs))).
+						Executor().ExecContext(ctx)
+					if err != nil {
+						return fmt.Errorf("failed to move clicks into %s: %w", partition, err)
+					}
+				}
+
+				_, err = tx.Delete(db.UnpartitionedClicks).Where(goqu.C("id").In(ids)).Executor().ExecContext(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to delete moved clicks: %w", err)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		moved += int64(n)
+		// The batch finding none drops the table
+		if n == 0 {
+			return moved, nil
+		}
+	}
+}
+
+// dropUnpartitioned drops the emptied table of unpartitioned clicks in tx. Without a partition it's
+// kept, as the only table of the clicks view, until the first click is recorded.
+func dropUnpartitioned(ctx context.Context, tx *goqu.TxDatabase) error {
+	var partitions int64
+	_, err := tx.From("click_partitions").
+		Select(goqu.COUNT("*")).
+		Where(goqu.C("name").Neq(db.UnpartitionedClicks)).
+		ScanValContext(ctx, &partitions)
+	if err != nil {
+		return fmt.Errorf("failed to count click partitions: %w", err)
+	} else if partitions == 0 {
+		return nil
+	}
+
+	_, err = tx.Delete("click_partitions").Where(goqu.C("name").Eq(db.UnpartitionedClicks)).Executor().ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to unregister unpartitioned clicks: %w", err)
+	}
+	if err := rebuildClicksView(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DROP TABLE "+db.UnpartitionedClicks); err != nil {
+		return fmt.Errorf("failed to drop unpartitioned clicks: %w", err)
+	}
+	return nil
+}
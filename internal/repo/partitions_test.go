@@ -0,0 +1,396 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// unpartitionedVersion is the last schema version keeping clicks in a single table
+const unpartitionedVersion = 26
+
+// legacyClick is a click of the single clicks table, as the versions before partitioning recorded it.
+type legacyClick struct {
+	ID          int64
+	LinkID      int64
+	ClickedAt   time.Time
+	Referer     any
+	CountryCode any
+	VisitorHash any
+	Method      string
+}
+
+func insertLegacyClick(t *testing.T, sqlDB *sql.DB, table string, c legacyClick) {
+	t.Helper()
+
+	_, err := sqlDB.Exec(
+		"INSERT INTO "+table+" (id, link_id, clicked_at, user_agent, ip_address, referer, country_code, visitor_hash, method) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.LinkID, repo.Date(c.ClickedAt), "Mozilla/5.0", "203.0.113.7", c.Referer, c.CountryCode, c.VisitorHash, c.Method,
+	)
+	if err != nil {
+		t.Fatalf("failed to insert click %d: %v", c.ID, err)
+	}
+}
+
+// seedLegacy fills sqlDB, which has the schema of unpartitionedVersion, with links and a year of clicks
+// made up from seed. The clicks with the highest IDs are deleted, like a purge of the newest would.
+func seedLegacy(t *testing.T, sqlDB *sql.DB, seed uint64) (linkIDs []int64) {
+	t.Helper()
+
+	for i := range 3 {
+		res, err := sqlDB.Exec("INSERT INTO links (slug, url) VALUES (?, ?)", fmt.Sprintf("link%d", i), "https://example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		linkIDs = append(linkIDs, id)
+	}
+
+	rng := rand.New(rand.NewPCG(seed, seed))
+	pick := func(values ...any) any { return values[rng.IntN(len(values))] }
+	start := testutil.Epoch.AddDate(-1, 0, 0)
+	span := testutil.Epoch.Sub(start)
+	for id := int64(1); id <= 3000; id++ {
+		method := "GET"
+		if rng.IntN(10) == 0 {
+			method = "HEAD"
+		}
+		insertLegacyClick(t, sqlDB, "clicks", legacyClick{
+			ID:          id,
+			LinkID:      linkIDs[rng.IntN(len(linkIDs))],
+			ClickedAt:   start.Add(time.Duration(rng.Int64N(int64(span)))).Truncate(time.Second),
+			Referer:     pick(nil, "", "news.ycombinator.com", "t.co", "example.org"),
+			CountryCode: pick(nil, "US", "DE", "TR"),
+			VisitorHash: pick(nil, "a", "b", "c", "d", "e", "f"),
+			Method:      method,
+		})
+	}
+	if _, err := sqlDB.Exec("DELETE FROM clicks WHERE id > 2950"); err != nil {
+		t.Fatal(err)
+	}
+	return linkIDs
+}
+
+// singleTableStats computes the stats of linkID from the single clicks table with the queries used
+// before partitioning, to compare the partitioned ones with.
+type singleTableStats struct {
+	Stats     internal.LinkStats
+	Referrers map[string]*internal.ReferrerStats
+	Countries map[string]*internal.CountryStats
+	Daily     map[string]internal.DailyClicks
+}
+
+// windows are the times since which referrer and country stats are compared, by name
+var windows = map[string]time.Time{
+	"all time":       {},
+	"last 40 days":   testutil.Epoch.AddDate(0, 0, -40),
+	"last 200 days":  testutil.Epoch.AddDate(0, 0, -200),
+	"before any":     testutil.Epoch.AddDate(-2, 0, 0),
+	"current month":  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	"mid last month": time.Date(2023, 12, 15, 0, 0, 0, 0, time.UTC),
+}
+
+const dailyDays = 90
+
+func querySingleTable(t *testing.T, sqlDB *sql.DB, linkID int64) singleTableStats {
+	t.Helper()
+
+	var got singleTableStats
+	var last sql.NullString
+	err := sqlDB.QueryRow(
+		"SELECT COUNT(*), COUNT(DISTINCT visitor_hash), MAX(clicked_at) FROM clicks WHERE link_id = ? AND method = 'GET'",
+		linkID,
+	).Scan(&got.Stats.Clicks, &got.Stats.UniqueClicks, &last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Valid {
+		lastClickedAt, err := time.Parse(time.RFC3339, last.String)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Stats.LastClickedAt = &lastClickedAt
+	}
+
+	got.Referrers = make(map[string]*internal.ReferrerStats)
+	got.Countries = make(map[string]*internal.CountryStats)
+	for name, since := range windows {
+		after := ""
+		if !since.IsZero() {
+			after = since.UTC().Format(time.RFC3339)
+		}
+
+		referrers := &internal.ReferrerStats{}
+		rows, err := sqlDB.Query(
+			"SELECT referer, COUNT(*) AS n FROM clicks WHERE link_id = ? AND method = 'GET' AND clicked_at >= ? AND referer != '' GROUP BY referer ORDER BY n DESC, referer ASC LIMIT 10",
+			linkID, after,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var count internal.ReferrerCount
+			if err := rows.Scan(&count.Host, &count.Clicks); err != nil {
+				t.Fatal(err)
+			}
+			referrers.Referrers = append(referrers.Referrers, count)
+		}
+		rows.Close()
+		err = sqlDB.QueryRow(
+			"SELECT COUNT(*) FROM clicks WHERE link_id = ? AND method = 'GET' AND clicked_at >= ? AND (referer IS NULL OR referer = '')",
+			linkID, after,
+		).Scan(&referrers.Direct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Referrers[name] = referrers
+
+		countries := &internal.CountryStats{Countries: []internal.CountryCount{}}
+		rows, err = sqlDB.Query(
+			"SELECT COALESCE(country_code, '') AS country, COUNT(*) AS n FROM clicks WHERE link_id = ? AND method = 'GET' AND clicked_at >= ? GROUP BY country_code ORDER BY n DESC, country ASC",
+			linkID, after,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var count internal.CountryCount
+			if err := rows.Scan(&count.Country, &count.Clicks); err != nil {
+				t.Fatal(err)
+			}
+			if count.Country == "" {
+				countries.Unknown += count.Clicks
+				continue
+			}
+			countries.Countries = append(countries.Countries, count)
+		}
+		rows.Close()
+		got.Countries[name] = countries
+	}
+
+	got.Daily = make(map[string]internal.DailyClicks)
+	start := testutil.Epoch.Truncate(24*time.Hour).AddDate(0, 0, -(dailyDays - 1))
+	rows, err := sqlDB.Query(
+		"SELECT substr(clicked_at, 1, 10) AS day, COUNT(*), COUNT(DISTINCT visitor_hash) FROM clicks WHERE link_id = ? AND method = 'GET' AND clicked_at >= ? GROUP BY day",
+		linkID, start.Format(time.RFC3339),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day internal.DailyClicks
+		if err := rows.Scan(&day.Date, &day.Clicks, &day.UniqueClicks); err != nil {
+			t.Fatal(err)
+		}
+		got.Daily[day.Date] = day
+	}
+	return got
+}
+
+func queryPartitioned(t *testing.T, clicks *repo.ClicksRepo, linkID int64) singleTableStats {
+	t.Helper()
+	ctx := context.Background()
+
+	var got singleTableStats
+	stats, err := clicks.GetStatsForLink(ctx, linkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Stats = *stats
+
+	got.Referrers = make(map[string]*internal.ReferrerStats)
+	got.Countries = make(map[string]*internal.CountryStats)
+	for name, since := range windows {
+		if got.Referrers[name], err = clicks.GetReferrerStats(ctx, linkID, since, 10, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got.Countries[name], err = clicks.GetCountryStats(ctx, linkID, since, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	daily, err := clicks.GetDailyClicks(ctx, linkID, dailyDays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Daily = make(map[string]internal.DailyClicks)
+	for _, day := range daily {
+		// The single table has no rows for days without clicks
+		if day.Clicks > 0 {
+			got.Daily[day.Date] = day
+		}
+	}
+	return got
+}
+
+// dumpClicks lists every click of the clicks table or view, by ID.
+func dumpClicks(t *testing.T, sqlDB *sql.DB) []string {
+	t.Helper()
+
+	rows, err := sqlDB.Query(`
+		SELECT id, link_id, clicked_at, COALESCE(referer, '-'), COALESCE(country_code, '-'), COALESCE(visitor_hash, '-'), method
+		FROM clicks ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var dump []string
+	for rows.Next() {
+		var id, linkID int64
+		var clickedAt, referer, country, visitor, method string
+		if err := rows.Scan(&id, &linkID, &clickedAt, &referer, &country, &visitor, &method); err != nil {
+			t.Fatal(err)
+		}
+		dump = append(dump, strings.Join([]string{fmt.Sprint(id), fmt.Sprint(linkID), clickedAt, referer, country, visitor, method}, " "))
+	}
+	return dump
+}
+
+func TestPartitionsMatchSingleTable(t *testing.T) {
+	ctx := context.Background()
+
+	// Both start out with the same clicks, single keeps them in one table as before partitioning
+	single := testutil.NewDBAt(t, unpartitionedVersion)
+	partitioned := testutil.NewDBAt(t, unpartitionedVersion)
+	linkIDs := seedLegacy(t, single, 1)
+	seedLegacy(t, partitioned, 1)
+	if err := db.Migrate(ctx, partitioned); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := testutil.NewClock()
+	clicks := repo.NewClicksRepo(partitioned)
+	clicks.Now = clock.Now
+
+	compare := func(stage string) {
+		t.Helper()
+		for _, linkID := range linkIDs {
+			want := querySingleTable(t, single, linkID)
+			got := queryPartitioned(t, clicks, linkID)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: stats of link %d differ from the single table\ngot:  %+v\nwant: %+v", stage, linkID, got, want)
+			}
+		}
+		if got, want := dumpClicks(t, partitioned), dumpClicks(t, single); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: clicks differ from the single table, %d clicks vs %d", stage, len(got), len(want))
+		}
+	}
+	compare("before moving")
+
+	// Clicks recorded now go into partitions, next to the ones still waiting to be moved
+	for i, at := range []time.Time{
+		testutil.Epoch.Add(-time.Hour),
+		testutil.Epoch.AddDate(0, -1, 0),
+		testutil.Epoch.AddDate(0, -7, 0),
+	} {
+		err := clicks.Create(ctx, repo.NewClick{
+			LinkID:      linkIDs[i],
+			ClickedAt:   at,
+			UserAgent:   "Mozilla/5.0",
+			IPAddress:   "203.0.113.7",
+			Referer:     "https://t.co/abc",
+			VisitorHash: "new",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The ID comes from the counter shared by all partitions, past the deleted clicks too
+		var id int64
+		if err := partitioned.QueryRow("SELECT MAX(id) FROM clicks").Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(3001 + i); id != want {
+			t.Errorf("click %d got ID %d, want %d", i, id, want)
+		}
+		insertLegacyClick(t, single, "clicks", legacyClick{
+			ID:          id,
+			LinkID:      linkIDs[i],
+			ClickedAt:   at,
+			Referer:     "t.co",
+			VisitorHash: "new",
+			Method:      "GET",
+		})
+	}
+	compare("with new clicks")
+
+	moved, err := clicks.MoveUnpartitioned(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2950 {
+		t.Errorf("moved %d clicks, want 2950", moved)
+	}
+	compare("after moving")
+
+	var left int
+	if err := partitioned.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'clicks_unpartitioned'").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Error("the emptied clicks_unpartitioned wasn't dropped")
+	}
+}
+
+func TestClickIDsUniqueWhileMoving(t *testing.T) {
+	ctx := context.Background()
+
+	sqlDB := testutil.NewDBAt(t, unpartitionedVersion)
+	linkIDs := seedLegacy(t, sqlDB, 2)
+	if err := db.Migrate(ctx, sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	clicks := repo.NewClicksRepo(sqlDB)
+
+	const writers, perWriter = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter+2)
+	for w := range writers {
+		wg.Go(func() {
+			for i := range perWriter {
+				errs <- clicks.Create(ctx, repo.NewClick{
+					LinkID: linkIDs[w%len(linkIDs)],
+					// Spread over months so new partitions are created meanwhile too
+					ClickedAt: testutil.Epoch.AddDate(0, -i%14, 0),
+				})
+			}
+		})
+	}
+	// Two movers, like two replicas starting at once
+	for range 2 {
+		wg.Go(func() {
+			_, err := clicks.MoveUnpartitioned(ctx)
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var total, distinct int
+	if err := sqlDB.QueryRow("SELECT COUNT(*), COUNT(DISTINCT id) FROM clicks").Scan(&total, &distinct); err != nil {
+		t.Fatal(err)
+	}
+	if want := 2950 + writers*perWriter; total != want {
+		t.Errorf("got %d clicks, want %d", total, want)
+	}
+	if distinct != total {
+		t.Errorf("%d of %d click IDs are taken twice", total-distinct, total)
+	}
+}
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/metrics"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	busyRetryBaseDelay = 10 * time.Millisecond
+	busyRetryMaxDelay  = 250 * time.Millisecond
+	// busyRetryMaxAttempts bounds retries when ctx has no deadline
+	busyRetryMaxAttempts = 8
+	// busyRetryMargin is kept free before ctx's deadline, as the wait before an attempt can run late
+	busyRetryMargin = 5 * time.Millisecond
+)
+
+// retryBusy runs op again while it fails because the database is locked by another writer,
+// with jittered exponential backoff, as long as the next attempt can finish before ctx's deadline.
+//
+// Only operations that are safe to repeat may be retried: reads, and writes that are idempotent
+// or guarded by a unique constraint. Repo methods that aren't say so, call the database directly and
+// pass its errors through busyError.
+// A lock that outlasts the retries is returned wrapped in internal.ErrDatabaseBusy.
+func retryBusy(ctx context.Context, op func() error) error {
+	delay := busyRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := op()
+		if err == nil || !isBusyError(err) {
+			return err
+		}
+
+		// The next attempt waits for the lock as long as this one did, it has to fit before the deadline too
+		wait := delay/2 + rand.N(delay/2+1)
+		deadline, hasDeadline := ctx.Deadline()
+		if hasDeadline && time.Until(deadline) <= wait+time.Since(start)+busyRetryMargin {
+			return fmt.Errorf("%w: %w", internal.ErrDatabaseBusy, err)
+		}
+		if !hasDeadline && attempt >= busyRetryMaxAttempts {
+			return fmt.Errorf("%w: %w", internal.ErrDatabaseBusy, err)
+		}
+
+		metrics.DBBusyRetries.Add(1)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", internal.ErrDatabaseBusy, err)
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, busyRetryMaxDelay)
+	}
+}
+
+// busyError wraps err in internal.ErrDatabaseBusy when a lock held by another connection refused
+// a statement that isn't retried. The statement didn't apply, so the client can try again itself.
+func busyError(err error) error {
+	if isBusyError(err) {
+		return fmt.Errorf("%w: %w", internal.ErrDatabaseBusy, err)
+	}
+	return err
+}
+
+// isBusyError reports whether err is SQLite refusing a statement because of a lock held by another connection.
+func isBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// extended codes like SQLITE_BUSY_SNAPSHOT keep the primary code in the low byte
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+	"modernc.org/sqlite"
+)
+
+// busyInjector fails the next statements of its connections with a busy error, and counts all of them.
+type busyInjector struct {
+	err error
+
+	mu         sync.Mutex
+	failures   int
+	statements int
+}
+
+func (i *busyInjector) fail(n int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.failures, i.statements = n, 0
+}
+
+func (i *busyInjector) count() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.statements
+}
+
+func (i *busyInjector) next() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.statements++
+	if i.failures > 0 {
+		i.failures--
+		return i.err
+	}
+	return nil
+}
+
+// busyConnector opens sqlite connections that run their statements through an injector first.
+type busyConnector struct {
+	dsn      string
+	injector *busyInjector
+}
+
+func (c *busyConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &busyConn{Conn: conn, injector: c.injector}, nil
+}
+
+func (c *busyConnector) Driver() driver.Driver {
+	return &sqlite.Driver{}
+}
+
+type busyConn struct {
+	driver.Conn
+	injector *busyInjector
+}
+
+func (c *busyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.injector.next(); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *busyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.injector.next(); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *busyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.injector.next(); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *busyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+// realBusyError makes sqlite refuse a write because another connection holds the lock, for the
+// injector to return the same error.
+func realBusyError(t *testing.T) error {
+	t.Helper()
+
+	sqlDB, dsn := testutil.NewFileDB(t, "DELETE")
+	ctx := context.Background()
+	other, err := sql.Open(db.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	_, err = sqlDB.ExecContext(ctx, "INSERT INTO settings (key, value) VALUES ('key', 'value')")
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		t.Fatalf("write under an exclusive lock returned %v, want a sqlite error", err)
+	}
+	return sqliteErr
+}
+
+// newBusyDB opens a database with the full schema whose statements fail while the returned injector says so.
+func newBusyDB(t *testing.T) (*sql.DB, *busyInjector) {
+	t.Helper()
+
+	injector := &busyInjector{err: realBusyError(t)}
+	sqlDB := sql.OpenDB(&busyConnector{
+		dsn:      "file:" + filepath.Join(t.TempDir(), "test.db") + "?_time_format=sqlite&_pragma=foreign_keys(1)",
+		injector: injector,
+	})
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.Migrate(context.Background(), sqlDB); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return sqlDB, injector
+}
+
+func TestRetryBusy(t *testing.T) {
+	sqlDB, injector := newBusyDB(t)
+	settings := repo.NewSettingsRepo(sqlDB)
+	ctx := context.Background()
+	if err := settings.Set(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	retries := metrics.DBBusyRetries.Value()
+	injector.fail(3)
+	got, err := settings.Get(ctx, "key")
+	if err != nil || got != "value" {
+		t.Fatalf("got %q, %v, want the value once the lock is gone", got, err)
+	}
+	if n := injector.count(); n != 4 {
+		t.Errorf("ran the query %d times, want 4", n)
+	}
+	if n := metrics.DBBusyRetries.Value() - retries; n != 3 {
+		t.Errorf("counted %d retries, want 3", n)
+	}
+}
+
+func TestRetryBusyGivesUp(t *testing.T) {
+	sqlDB, injector := newBusyDB(t)
+	settings := repo.NewSettingsRepo(sqlDB)
+
+	t.Run("after the attempts without a deadline", func(t *testing.T) {
+		injector.fail(1000)
+		_, err := settings.Get(context.Background(), "key")
+		if !errors.Is(err, internal.ErrDatabaseBusy) {
+			t.Fatalf("got %v, want ErrDatabaseBusy", err)
+		}
+		if n := injector.count(); n != 8 {
+			t.Errorf("ran the query %d times, want 8", n)
+		}
+
+		status, resp := handler.ResolveError(err)
+		if status != http.StatusServiceUnavailable || resp.Code != handler.CodeDatabaseBusy {
+			t.Errorf("resolved to %d %s, want %d %s", status, resp.Code, http.StatusServiceUnavailable, handler.CodeDatabaseBusy)
+		}
+	})
+
+	t.Run("before the deadline", func(t *testing.T) {
+		injector.fail(1000)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+
+		_, err := settings.Get(ctx, "key")
+		if !errors.Is(err, internal.ErrDatabaseBusy) {
+			t.Fatalf("got %v, want ErrDatabaseBusy", err)
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("gave up %v after the deadline", time.Since(deadline))
+		}
+		if n := injector.count(); n < 2 {
+			t.Errorf("ran the query %d times, want it retried", n)
+		}
+	})
+}
+
+func TestRetryBusySkipsUnrepeatableWrites(t *testing.T) {
+	sqlDB, injector := newBusyDB(t)
+	settings := repo.NewSettingsRepo(sqlDB)
+	ctx := context.Background()
+
+	injector.fail(1)
+	_, err := settings.Increment(ctx, "counter")
+	if !errors.Is(err, internal.ErrDatabaseBusy) {
+		t.Fatalf("got %v, want ErrDatabaseBusy", err)
+	}
+	if n := injector.count(); n != 1 {
+		t.Errorf("ran the increment %d times, want 1", n)
+	}
+
+	// The next one counts from where the failed one left it
+	if n, err := settings.Increment(ctx, "counter"); err != nil || n != 1 {
+		t.Errorf("got %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// purgeBatchSize is how many clicks are purged or moved per transaction, so writers aren't locked out for long
+const purgeBatchSize = 1000
+
+// clickDay is the UTC date of a click, like 2024-01-31.
+func clickDay(db *goqu.Database) exp.SQLFunctionExpression {
+	if isPostgres(db) {
+		return goqu.Func("to_char", goqu.L("? AT TIME ZONE 'UTC'", goqu.C("clicked_at")), "YYYY-MM-DD")
+	}
+	// sqlite stores RFC 3339 text, the date is its first 10 characters
+	return goqu.Func("substr", goqu.C("clicked_at"), 1, 10)
+}
+
+// PurgeBefore deletes the clicks made before the given time. The clicks of each link and day are
+// added to click_rollups first, so click totals and daily series stay the same. Clicks that
+// don't count as visits are deleted without being rolled up.
+// The partitions of months before the given time are dropped whole, the clicks of the month it's
+// in and the unpartitioned ones are deleted in batches. A visitor whose clicks of a day land in
+// two batches is counted as unique twice.
+// Referrer and country stats only cover the clicks that are kept.
+// It returns the number of deleted clicks, which is accurate even when it fails halfway.
+func (r *ClicksRepo) PurgeBefore(ctx context.Context, before time.Time) (int64, error) {
+	// The partition of the current month is kept even when before is later, so the clicks view
+	// is never left without a table
+	current := r.Now().UTC().Format(monthFormat)
+	if _, err := r.partitionFor(ctx, current); err != nil {
+		return 0, err
+	}
+	partitions, err := r.listPartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := before.UTC().Format(monthFormat)
+	var purged int64
+	for _, partition := range partitions {
+		var n int64
+		switch {
+		case partition.Month > cutoff:
+			continue
+		case partition.Month != "" && partition.Month < cutoff && partition.Month < current:
+			n, err = r.dropPartition(ctx, partition.Name)
+		default:
+			n, err = r.purgeBatches(ctx, partition.Name, before)
+		}
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purgeBatches is PurgeBefore for the clicks in table, in batches so writers aren't locked out for long.
+func (r *ClicksRepo) purgeBatches(ctx context.Context, table string, before time.Time) (int64, error) {
+	var purged int64
+	for {
+		var n int
+		// Safe to retry on a busy database, the transaction either applied fully or not at all
+		err := retryBusy(ctx, func() error {
+			return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+				var ids []int64
+				err := tx.From(table).
+					Select("id").
+					Where(goqu.C("clicked_at").Lt(Date(before.UTC()))).
+					Order(goqu.C("id").Asc()).
+					Limit(purgeBatchSize).
+					ScanValsContext(ctx, &ids)
+				if err != nil {
+					return fmt.Errorf("failed to scan purgeable clicks: %w", err)
+				}
+				n = len(ids)
+				if n == 0 {
+					return nil
+				}
+
+				if err := rollUp(ctx, tx, r.db, goqu.From(table).Where(goqu.C("id").In(ids), countedClick)); err != nil {
+					return err
+				}
+
+				_, err = tx.Delete(table).Where(goqu.C("id").In(ids)).Executor().ExecContext(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to delete clicks: %w", err)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return purged, err
+		}
+
+		purged += int64(n)
+		if n < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// rollUp adds the clicks selected by clicks to click_rollups in tx, counted per link and day.
+// db is the database of tx, whose dialect the query is built for.
+func rollUp(ctx context.Context, tx *goqu.TxDatabase, db *goqu.Database, clicks *goqu.SelectDataset) error {
+	day := clickDay(db)
+	// goqu rejects a subquery built by the transaction, as its dialect doesn't compare equal
+	rollups := clicks.
+		Select(
+			goqu.C("link_id"),
+			day,
+			goqu.COUNT("*"),
+			goqu.COUNT(goqu.DISTINCT("visitor_hash")),
+			goqu.MAX("clicked_at"),
+		).
+		GroupBy(goqu.C("link_id"), day)
+	_, err := tx.Insert("click_rollups").
+		Cols("link_id", "day", "clicks", "unique_clicks", "last_clicked_at").
+		FromQuery(rollups).
+		// A day can be split across batches, or purged in several runs
+		OnConflict(goqu.DoUpdate("link_id, day", goqu.Record{
+			"clicks":        goqu.L("click_rollups.clicks + excluded.clicks"),
+			"unique_clicks": goqu.L("click_rollups.unique_clicks + excluded.unique_clicks"),
+			"last_clicked_at": goqu.L("CASE WHEN excluded.last_clicked_at > click_rollups.last_clicked_at " +
+				"THEN excluded.last_clicked_at ELSE click_rollups.last_clicked_at END"),
+		})).
+		Executor().ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to roll up clicks: %w", err)
+	}
+	return nil
+}
+
+type rollupStatsRow struct {
+	Total         int64 `db:"total"`
+	UniqueClicks  int64 `db:"unique_clicks"`
+	LastClickedAt *Date `db:"last_clicked_at"`
+}
+
+// getRollupStats sums up the purged clicks of the links matching the link_id condition.
+func (r *ClicksRepo) getRollupStats(ctx context.Context, links exp.Expression) (rollupStatsRow, error) {
+	query := r.db.From("click_rollups").
+		Where(links).
+		Select(
+			goqu.COALESCE(goqu.SUM("clicks"), 0).As("total"),
+			goqu.COALESCE(goqu.SUM("unique_clicks"), 0).As("unique_clicks"),
+			goqu.MAX("last_clicked_at").As("last_clicked_at"),
+		)
+
+	var row rollupStatsRow
+	if err := retryBusy(ctx, func() error {
+		_, err := query.ScanStructContext(ctx, &row)
+		return err
+	}); err != nil {
+		return rollupStatsRow{}, fmt.Errorf("failed to scan click rollups: %w", err)
+	}
+	return row, nil
+}
+
+// getRollupDailyClicks returns the purged clicks of the links matching the link_id condition
+// per day and link, starting at the given date.
+func (r *ClicksRepo) getRollupDailyClicks(ctx context.Context, links exp.Expression, start time.Time) ([]dailyClicksRow, error) {
+	query := r.db.From("click_rollups").
+		Where(
+			links,
+			goqu.C("day").Gte(start.Format(time.DateOnly)),
+		).
+		Select(goqu.C("day"), goqu.C("clicks"), goqu.C("unique_clicks"))
+
+	var rows []dailyClicksRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan daily click rollups: %w", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,208 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+// rollup is a row of click_rollups.
+type rollup struct {
+	clicks, uniqueClicks int64
+	lastClickedAt        time.Time
+}
+
+// readRollups returns the rollups of a link by day.
+func readRollups(t *testing.T, sqlDB *sql.DB, linkID int64) map[string]rollup {
+	t.Helper()
+
+	rows, err := sqlDB.Query("SELECT day, clicks, unique_clicks, last_clicked_at FROM click_rollups WHERE link_id = $1", linkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	rollups := map[string]rollup{}
+	for rows.Next() {
+		var day string
+		var r rollup
+		var last repo.Date
+		if err := rows.Scan(&day, &r.clicks, &r.uniqueClicks, &last); err != nil {
+			t.Fatal(err)
+		}
+		r.lastClickedAt = last.Time().UTC()
+		rollups[day] = r
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rollups
+}
+
+// clickAt records a click of link by visitor at the given time, with method, GET when empty.
+func clickAt(t *testing.T, f *testutil.Fixtures, link *internal.Link, at string, visitor, method string) {
+	t.Helper()
+
+	clickedAt, err := time.Parse(time.DateTime, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Click(t, link, func(c *repo.NewClick) {
+		c.ClickedAt = clickedAt
+		c.VisitorHash = visitor
+		if method != "" {
+			c.Method = method
+		}
+	})
+}
+
+func TestPurgeRollsUp(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+		a, b := f.Link(t), f.Link(t)
+
+		// A month whose partition is dropped whole
+		clickAt(t, f, a, "2023-11-10 09:00:00", "v1", "")
+		clickAt(t, f, a, "2023-11-10 10:00:00", "v1", "")
+		clickAt(t, f, a, "2023-11-10 11:00:00", "v2", "")
+		// Counted, but not as a visitor of its own
+		clickAt(t, f, a, "2023-11-10 12:00:00", "", "")
+		// Not a visit, so not rolled up at all
+		clickAt(t, f, a, "2023-11-10 13:00:00", "v3", http.MethodHead)
+		clickAt(t, f, b, "2023-11-10 15:00:00", "v1", "")
+		// Days on both sides of the end of a month
+		clickAt(t, f, a, "2023-12-31 23:30:00", "v1", "")
+		clickAt(t, f, a, "2024-01-01 00:10:00", "v1", "")
+		// The month of the cutoff, purged in batches
+		clickAt(t, f, a, "2024-01-09 08:00:00", "v4", "")
+		clickAt(t, f, a, "2024-01-09 20:00:00", "v5", "")
+		// Within retention
+		clickAt(t, f, a, "2024-01-14 10:00:00", "v6", "")
+
+		// Clicks are kept for 5 days
+		purged, err := f.Clicks.PurgeBefore(ctx, f.Clock.Now().AddDate(0, 0, -5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != 10 {
+			t.Errorf("purged %d clicks, want 10", purged)
+		}
+
+		want := map[string]rollup{
+			"2023-11-10": {4, 2, time.Date(2023, 11, 10, 12, 0, 0, 0, time.UTC)},
+			"2023-12-31": {1, 1, time.Date(2023, 12, 31, 23, 30, 0, 0, time.UTC)},
+			"2024-01-01": {1, 1, time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)},
+			"2024-01-09": {2, 2, time.Date(2024, 1, 9, 20, 0, 0, 0, time.UTC)},
+		}
+		if got := readRollups(t, sqlDB, a.ID); !reflect.DeepEqual(got, want) {
+			t.Errorf("rollups of a: got %v, want %v", got, want)
+		}
+		want = map[string]rollup{"2023-11-10": {1, 1, time.Date(2023, 11, 10, 15, 0, 0, 0, time.UTC)}}
+		if got := readRollups(t, sqlDB, b.ID); !reflect.DeepEqual(got, want) {
+			t.Errorf("rollups of b: got %v, want %v", got, want)
+		}
+
+		// Stats add up the rollups and the clicks that are kept
+		stats, err := f.Clicks.GetStatsForLink(ctx, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Clicks != 9 || stats.UniqueClicks != 7 || !stats.LastClickedAt.Equal(time.Date(2024, 1, 14, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("stats of a: got %d clicks, %d unique, last at %v, want 9, 7, at 2024-01-14 10:00", stats.Clicks, stats.UniqueClicks, stats.LastClickedAt)
+		}
+		// Only rollups are left of b, which still tell when it was last clicked
+		stats, err = f.Clicks.GetStatsForLink(ctx, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Clicks != 1 || stats.UniqueClicks != 1 || stats.LastClickedAt == nil || !stats.LastClickedAt.Equal(time.Date(2023, 11, 10, 15, 0, 0, 0, time.UTC)) {
+			t.Errorf("stats of b: got %d clicks, %d unique, last at %v, want 1, 1, at 2023-11-10 15:00", stats.Clicks, stats.UniqueClicks, stats.LastClickedAt)
+		}
+
+		daily, err := f.Clicks.GetDailyClicks(ctx, a.ID, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDaily := []internal.DailyClicks{
+			{Date: "2024-01-09", Clicks: 2, UniqueClicks: 2},
+			{Date: "2024-01-10"},
+			{Date: "2024-01-11"},
+			{Date: "2024-01-12"},
+			{Date: "2024-01-13"},
+			{Date: "2024-01-14", Clicks: 1, UniqueClicks: 1},
+			{Date: "2024-01-15"},
+		}
+		if !reflect.DeepEqual(daily, wantDaily) {
+			t.Errorf("daily clicks of a: got %v, want %v", daily, wantDaily)
+		}
+	})
+}
+
+func TestPurgeMergesRollups(t *testing.T) {
+	testutil.ForEachBackend(t, func(t *testing.T, sqlDB *sql.DB) {
+		f := testutil.NewFixturesIn(sqlDB)
+		ctx := context.Background()
+		link := f.Link(t)
+		cutoff := f.Clock.Now().AddDate(0, 0, -5)
+		purge := func() {
+			t.Helper()
+			if _, err := f.Clicks.PurgeBefore(ctx, cutoff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		clickAt(t, f, link, "2024-01-09 08:00:00", "v1", "")
+		clickAt(t, f, link, "2024-01-09 20:00:00", "v2", "")
+		purge()
+
+		// Clicks recorded late, like those of an import, add to the day purged already
+		clickAt(t, f, link, "2024-01-09 07:00:00", "v3", "")
+		purge()
+		want := rollup{3, 3, time.Date(2024, 1, 9, 20, 0, 0, 0, time.UTC)}
+		if got := readRollups(t, sqlDB, link.ID)["2024-01-09"]; got != want {
+			t.Errorf("after an earlier click: got %v, want %v keeping the last click", got, want)
+		}
+
+		// A visitor purged in another run is counted as unique again
+		clickAt(t, f, link, "2024-01-09 21:00:00", "v1", "")
+		purge()
+		want = rollup{4, 4, time.Date(2024, 1, 9, 21, 0, 0, 0, time.UTC)}
+		if got := readRollups(t, sqlDB, link.ID)["2024-01-09"]; got != want {
+			t.Errorf("after a later click: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestPurgeAcrossBatches(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	link := f.Link(t)
+
+	// More clicks of a day than a batch, each of another visitor, so batches don't overlap in visitors
+	const clicks = 2500
+	day := time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)
+	for i := range clicks {
+		f.Click(t, link, func(c *repo.NewClick) {
+			c.ClickedAt = day.Add(time.Duration(i) * time.Second)
+			c.VisitorHash = fmt.Sprintf("v%d", i)
+		})
+	}
+	purged, err := f.Clicks.PurgeBefore(context.Background(), f.Clock.Now().AddDate(0, 0, -5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != clicks {
+		t.Errorf("purged %d clicks, want %d", purged, clicks)
+	}
+	want := rollup{clicks, clicks, day.Add((clicks - 1) * time.Second)}
+	if got := readRollups(t, f.DB, link.ID); len(got) != 1 || got["2024-01-09"] != want {
+		t.Errorf("got %v, want %v on 2024-01-09 only", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+)
+
+const (
+	SettingTokenVersion    = "auth.token_version"
+	SettingCredentialsHash = "auth.credentials_hash"
+	SettingBranding        = "branding"
+	SettingRuntime         = "runtime"
+	SettingVisitorSalt     = "visitors.salt"
+)
+
+type SettingsRepo struct {
+	db *goqu.Database
+}
+
+func NewSettingsRepo(db *sql.DB) *SettingsRepo {
+	return &SettingsRepo{db: newDatabase(db)}
+}
+
+func (r *SettingsRepo) Get(ctx context.Context, key string) (string, error) {
+	query := r.db.From("settings").
+		Where(goqu.C("key").Eq(key)).
+		Select("value")
+
+	var value string
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanValContext(ctx, &value)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read setting %s: %w", key, err)
+	} else if !found {
+		return "", internal.ErrSettingNotFound
+	}
+
+	return value, nil
+}
+
+func (r *SettingsRepo) Set(ctx context.Context, key, value string) error {
+	query := r.db.Insert("settings").
+		Rows(goqu.Record{"key": key, "value": value}).
+		OnConflict(goqu.DoUpdate("key", goqu.Record{"value": value}))
+
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to write setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Replace sets a setting unless another writer changed it from old first, an empty old only
+// sets an unset one. It returns the value the setting ends up with.
+func (r *SettingsRepo) Replace(ctx context.Context, key, old, value string) (string, error) {
+	query := r.db.Insert("settings").
+		Rows(goqu.Record{"key": key, "value": value}).
+		OnConflict(goqu.DoUpdate("key", goqu.Record{"value": value}).Where(goqu.L("settings.value").Eq(old)))
+
+	// Safe to retry on a busy database, once replaced the old value no longer matches
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("failed to write setting %s: %w", key, err)
+	}
+	return r.Get(ctx, key)
+}
+
+// Increment atomically adds one to an integer setting, starting from 0 if unset, and returns the new value.
+func (r *SettingsRepo) Increment(ctx context.Context, key string) (int64, error) {
+	query := r.db.Insert("settings").
+		Rows(goqu.Record{"key": key, "value": "1"}).
+		OnConflict(goqu.DoUpdate("key", goqu.Record{
+			"value": goqu.L("CAST(CAST(settings.value AS INTEGER) + 1 AS TEXT)"),
+		})).
+		Returning("value")
+
+	var value string
+	// Not retried on a busy database, a repeated increment would count twice
+	if _, err := query.Executor().ScanValContext(ctx, &value); err != nil {
+		return 0, fmt.Errorf("failed to increment setting %s: %w", key, busyError(err))
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
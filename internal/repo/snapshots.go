@@ -0,0 +1,189 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+	"github.com/samber/lo"
+)
+
+type snapshotRow struct {
+	ID          int64  `db:"id" goqu:"skipinsert"`
+	LinkID      int64  `db:"link_id"`
+	TakenAt     Date   `db:"taken_at"`
+	FinalURL    string `db:"final_url"`
+	StatusCode  int    `db:"status_code"`
+	ContentType string `db:"content_type"`
+	Headers     string `db:"headers"`
+	Size        int64  `db:"size"`
+	Truncated   bool   `db:"truncated"`
+	Error       string `db:"error"`
+}
+
+type snapshotWithBodyRow struct {
+	snapshotRow
+	Body []byte `db:"body"`
+}
+
+func (r snapshotRow) toDomain() (*internal.Snapshot, error) {
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(r.Headers), &headers); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot headers: %w", err)
+	}
+	return &internal.Snapshot{
+		ID:          r.ID,
+		LinkID:      r.LinkID,
+		TakenAt:     r.TakenAt.Time(),
+		FinalURL:    r.FinalURL,
+		StatusCode:  r.StatusCode,
+		ContentType: r.ContentType,
+		Headers:     headers,
+		Size:        r.Size,
+		Truncated:   r.Truncated,
+		Error:       r.Error,
+	}, nil
+}
+
+// SnapshotLimits bound the storage used by snapshots.
+type SnapshotLimits struct {
+	MaxPerLink    int
+	MaxTotalBytes int64
+}
+
+type SnapshotsRepo struct {
+	db *goqu.Database
+}
+
+func NewSnapshotsRepo(db *sql.DB) *SnapshotsRepo {
+	return &SnapshotsRepo{db: newDatabase(db)}
+}
+
+// Create stores a snapshot, then evicts the oldest ones until the limits hold again.
+// Not retried on a busy database, a repeated insert would store the snapshot twice.
+func (r *SnapshotsRepo) Create(ctx context.Context, s *internal.Snapshot, limits SnapshotLimits) error {
+	headers, err := json.Marshal(s.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot headers: %w", err)
+	}
+
+	err = r.db.WithTx(func(tx *goqu.TxDatabase) error {
+		_, err := tx.Insert("snapshots").
+			Rows(goqu.Record{
+				"link_id":      s.LinkID,
+				"taken_at":     Date(s.TakenAt.UTC()),
+				"final_url":    s.FinalURL,
+				"status_code":  s.StatusCode,
+				"content_type": s.ContentType,
+				"headers":      string(headers),
+				"body":         lo.Ternary(len(s.Body) > 0, any(s.Body), nil),
+				"size":         len(s.Body),
+				"truncated":    s.Truncated,
+				"error":        s.Error,
+			}).
+			// Interpolation can't encode the binary body, bind it as a parameter instead
+			Prepared(true).
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert snapshot: %w", err)
+		}
+
+		// Keep only the newest MaxPerLink snapshots of this link
+		_, err = tx.From("snapshots").
+			Where(
+				goqu.C("link_id").Eq(s.LinkID),
+				goqu.C("id").NotIn(
+					tx.From("snapshots").
+						Select("id").
+						Where(goqu.C("link_id").Eq(s.LinkID)).
+						Order(goqu.C("id").Desc()).
+						Limit(uint(limits.MaxPerLink)),
+				),
+			).
+			Delete().
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to evict snapshots of link: %w", err)
+		}
+
+		// Then drop the oldest snapshots across all links until the total fits
+		_, err = tx.From("snapshots").
+			Where(goqu.C("id").In(
+				goqu.From(
+					tx.From("snapshots").
+						Select(
+							"id",
+							goqu.L("SUM(size) OVER (ORDER BY id DESC)").As("running_size"),
+						),
+				).
+					Select("id").
+					Where(goqu.C("running_size").Gt(limits.MaxTotalBytes)),
+			)).
+			Delete().
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to evict oldest snapshots: %w", err)
+		}
+
+		return nil
+	})
+	return busyError(err)
+}
+
+// ListForLink returns snapshot metadata of a link, newest first, without bodies.
+func (r *SnapshotsRepo) ListForLink(ctx context.Context, linkID int64) ([]*internal.Snapshot, error) {
+	query := r.db.From("snapshots").
+		Select(snapshotRow{}).
+		Where(goqu.C("link_id").Eq(linkID)).
+		Order(goqu.C("id").Desc())
+
+	var rows []snapshotRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan snapshots: %w", err)
+	}
+
+	snapshots := make([]*internal.Snapshot, len(rows))
+	for i, row := range rows {
+		s, err := row.toDomain()
+		if err != nil {
+			return nil, err
+		}
+		snapshots[i] = s
+	}
+	return snapshots, nil
+}
+
+// Get returns a snapshot of a link including its body.
+func (r *SnapshotsRepo) Get(ctx context.Context, linkID, id int64) (*internal.Snapshot, error) {
+	query := r.db.From("snapshots").
+		Select(snapshotWithBodyRow{}).
+		Where(
+			goqu.C("link_id").Eq(linkID),
+			goqu.C("id").Eq(id),
+		)
+
+	var row snapshotWithBodyRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+	} else if !found {
+		return nil, internal.ErrSnapshotNotFound
+	}
+
+	s, err := row.toDomain()
+	if err != nil {
+		return nil, err
+	}
+	s.Body = row.Body
+	return s, nil
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/samber/lo"
+)
+
+type linkTagRow struct {
+	LinkID int64  `db:"link_id"`
+	Tag    string `db:"tag"`
+}
+
+type tagCountRow struct {
+	Tag   string `db:"tag"`
+	Links int64  `db:"links"`
+}
+
+func insertTags(ctx context.Context, tx *goqu.TxDatabase, linkID int64, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	rows := lo.Map(tags, func(tag string, _ int) linkTagRow {
+		return linkTagRow{LinkID: linkID, Tag: tag}
+	})
+	if _, err := tx.Insert("link_tags").Rows(rows).Executor().ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to insert link tags: %w", err)
+	}
+	return nil
+}
+
+// attachTags fills in the tags of links, sorted by name, with a single query.
+func (r *LinksRepo) attachTags(ctx context.Context, links []*internal.Link) error {
+	return attachTags(ctx, r.db, links)
+}
+
+// attachTags is LinksRepo.attachTags in db, which can be a transaction.
+func attachTags(ctx context.Context, db queryer, links []*internal.Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	query := db.From("link_tags").
+		Select(linkTagRow{}).
+		Where(goqu.C("link_id").In(lo.Map(links, func(link *internal.Link, _ int) int64 {
+			return link.ID
+		}))).
+		Order(goqu.C("tag").Asc())
+
+	var rows []linkTagRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return fmt.Errorf("failed to scan link tags: %w", err)
+	}
+
+	tags := make(map[int64][]string, len(links))
+	for _, row := range rows {
+		tags[row.LinkID] = append(tags[row.LinkID], row.Tag)
+	}
+	for _, link := range links {
+		link.Tags = append([]string{}, tags[link.ID]...)
+	}
+	return nil
+}
+
+// ListTags returns every tag in use with the number of links that have it, sorted by name.
+func (r *LinksRepo) ListTags(ctx context.Context) ([]internal.TagCount, error) {
+	query := r.db.From("link_tags").
+		Select(
+			goqu.C("tag"),
+			goqu.COUNT("*").As("links"),
+		).
+		GroupBy("tag").
+		Order(goqu.C("tag").Asc())
+
+	var rows []tagCountRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan tags: %w", err)
+	}
+
+	return lo.Map(rows, func(row tagCountRow, _ int) internal.TagCount {
+		return internal.TagCount{Tag: row.Tag, Links: row.Links}
+	}), nil
+}
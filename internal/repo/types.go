@@ -5,45 +5,61 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/abdusco/linked/internal"
 )
 
+// Date is a time stored as RFC 3339 text, and as TIMESTAMPTZ on postgres. A zero Date is stored
+// as is, but marshals to JSON null, so clients never see the year 1.
 type Date time.Time
 
 func (d Date) Value() (driver.Value, error) {
 	return time.Time(d).Format(time.RFC3339), nil
 }
 
+// sqliteDateTimeFormat is what sqlite's own functions like datetime('now') return, in UTC
+const sqliteDateTimeFormat = "2006-01-02 15:04:05"
+
 func (d *Date) Scan(value any) error {
-	if value == nil {
+	switch v := value.(type) {
+	case nil:
 		*d = Date(time.Time{})
 		return nil
+	case time.Time:
+		*d = Date(v)
+		return nil
+	case []byte:
+		value = string(v)
 	}
 
-	if str, ok := value.(string); ok {
-		t, err := time.Parse(time.RFC3339, str)
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("cannot scan type %T into Date", value)
+	}
+	// RFC 3339 parsing accepts fractional seconds too
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		t, err = time.Parse(sqliteDateTimeFormat, str)
 		if err != nil {
-			t, err = time.Parse("2006-01-02 15:04:05", str)
-			if err != nil {
-				return err
-			}
+			return err
 		}
-		*d = Date(t)
-		return nil
-	}
-
-	if t, ok := value.(time.Time); ok {
-		*d = Date(t)
-		return nil
 	}
-
-	return fmt.Errorf("cannot scan type %T into Date", value)
+	*d = Date(t)
+	return nil
 }
 
 func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
 	return json.Marshal(time.Time(d))
 }
 
 func (d *Date) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*d = Date(time.Time{})
+		return nil
+	}
 	var t time.Time
 	if err := json.Unmarshal(b, &t); err != nil {
 		return err
@@ -59,3 +75,90 @@ func (d Date) String() string {
 func (d Date) Time() time.Time {
 	return time.Time(d)
 }
+
+func (d Date) IsZero() bool {
+	return time.Time(d).IsZero()
+}
+
+// timestampFormat is RFC 3339 with a fixed number of fractional digits, so stored values sort as text.
+const timestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Timestamp is a Date with nanosecond precision, for telling apart changes within the same second.
+type Timestamp time.Time
+
+func (t Timestamp) Value() (driver.Value, error) {
+	return time.Time(t).UTC().Format(timestampFormat), nil
+}
+
+func (t *Timestamp) Scan(value any) error {
+	var d Date
+	if err := d.Scan(value); err != nil {
+		return err
+	}
+	*t = Timestamp(d)
+	return nil
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.Time(t)
+}
+
+// destinationRules are stored as a JSON array.
+type destinationRules []internal.DestinationRule
+
+func (r destinationRules) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]internal.DestinationRule(r))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (r *destinationRules) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan type %T into destination rules", value)
+	}
+	return json.Unmarshal(b, (*[]internal.DestinationRule)(r))
+}
+
+// utmMapping is stored as a JSON object of the UTM parameters and their values.
+type utmMapping map[string]string
+
+func (m utmMapping) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *utmMapping) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan type %T into UTM mapping", value)
+	}
+	return json.Unmarshal(b, (*map[string]string)(m))
+}
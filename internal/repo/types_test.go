@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDateScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  time.Time
+	}{
+		{"nil", nil, time.Time{}},
+		{"time", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"RFC 3339", "2024-01-31T12:30:45Z", time.Date(2024, 1, 31, 12, 30, 45, 0, time.UTC)},
+		{"RFC 3339 with offset", "2024-01-31T14:30:45+02:00", time.Date(2024, 1, 31, 12, 30, 45, 0, time.UTC)},
+		{"fractional seconds", "2024-01-31T12:30:45.123456789Z", time.Date(2024, 1, 31, 12, 30, 45, 123456789, time.UTC)},
+		{"sqlite datetime", "2024-01-31 12:30:45", time.Date(2024, 1, 31, 12, 30, 45, 0, time.UTC)},
+		{"bytes", []byte("2024-01-31 12:30:45"), time.Date(2024, 1, 31, 12, 30, 45, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Date
+			if err := d.Scan(tt.value); err != nil {
+				t.Fatalf("Scan(%v) failed: %v", tt.value, err)
+			}
+			if !d.Time().Equal(tt.want) {
+				t.Errorf("Scan(%v) = %v, want %v", tt.value, d.Time(), tt.want)
+			}
+		})
+	}
+
+	for _, value := range []any{"yesterday", "2024-01-31", 42} {
+		var d Date
+		if err := d.Scan(value); err == nil {
+			t.Errorf("Scan(%v) = %v, want an error", value, d.Time())
+		}
+	}
+}
+
+func TestDateJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		date Date
+		json string
+	}{
+		{"zero", Date{}, "null"},
+		{"UTC", Date(time.Date(2024, 1, 31, 12, 30, 45, 0, time.UTC)), `"2024-01-31T12:30:45Z"`},
+		{"offset", Date(time.Date(2024, 1, 31, 14, 30, 45, 0, time.FixedZone("", 2*60*60))), `"2024-01-31T14:30:45+02:00"`},
+		{"fractional seconds", Date(time.Date(2024, 1, 31, 12, 30, 45, 123000000, time.UTC)), `"2024-01-31T12:30:45.123Z"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.json {
+				t.Errorf("Marshal(%v) = %s, want %s", tt.date, b, tt.json)
+			}
+
+			var d Date
+			if err := json.Unmarshal(b, &d); err != nil {
+				t.Fatalf("Unmarshal(%s) failed: %v", b, err)
+			}
+			if !d.Time().Equal(tt.date.Time()) || d.IsZero() != tt.date.IsZero() {
+				t.Errorf("Unmarshal(%s) = %v, want %v", b, d.Time(), tt.date.Time())
+			}
+		})
+	}
+
+	// null clears a date that's set, inside objects too
+	v := struct {
+		At Date `json:"at"`
+	}{At: Date(time.Now())}
+	if err := json.Unmarshal([]byte(`{"at": null}`), &v); err != nil {
+		t.Fatalf("Unmarshal of null failed: %v", err)
+	}
+	if !v.At.IsZero() {
+		t.Errorf("Unmarshal of null = %v, want a zero date", v.At.Time())
+	}
+
+	for _, s := range []string{`"yesterday"`, `"2024-01-31"`, `42`, `""`} {
+		var d Date
+		if err := json.Unmarshal([]byte(s), &d); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want an error", s, d.Time())
+		}
+	}
+}
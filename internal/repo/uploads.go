@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+)
+
+type uploadRow struct {
+	Name        string `db:"name"`
+	ContentType string `db:"content_type"`
+	Data        []byte `db:"data"`
+	UpdatedAt   Date   `db:"updated_at"`
+}
+
+type UploadsRepo struct {
+	db *goqu.Database
+}
+
+func NewUploadsRepo(db *sql.DB) *UploadsRepo {
+	return &UploadsRepo{db: newDatabase(db)}
+}
+
+// Put stores an upload, replacing any previous one with the same name.
+func (r *UploadsRepo) Put(ctx context.Context, u *internal.Upload) error {
+	row := uploadRow{
+		Name:        u.Name,
+		ContentType: u.ContentType,
+		Data:        u.Data,
+		UpdatedAt:   Date(time.Now().UTC()),
+	}
+	query := r.db.Insert("uploads").
+		Rows(row).
+		OnConflict(goqu.DoUpdate("name", goqu.Record{
+			"content_type": row.ContentType,
+			"data":         row.Data,
+			"updated_at":   row.UpdatedAt,
+		})).
+		// Interpolation can't encode the binary data, bind it as a parameter instead
+		Prepared(true)
+
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to store upload %s: %w", u.Name, err)
+	}
+	return nil
+}
+
+func (r *UploadsRepo) Get(ctx context.Context, name string) (*internal.Upload, error) {
+	query := r.db.From("uploads").
+		Select(uploadRow{}).
+		Where(goqu.C("name").Eq(name))
+
+	var row uploadRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan upload %s: %w", name, err)
+	} else if !found {
+		return nil, internal.ErrUploadNotFound
+	}
+
+	return &internal.Upload{
+		Name:        row.Name,
+		ContentType: row.ContentType,
+		Data:        row.Data,
+		UpdatedAt:   row.UpdatedAt.Time(),
+	}, nil
+}
+
+func (r *UploadsRepo) Delete(ctx context.Context, name string) error {
+	query := r.db.From("uploads").
+		Where(goqu.C("name").Eq(name)).
+		Delete()
+
+	if err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ExecContext(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to delete upload %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+)
+
+type userRow struct {
+	ID           int64  `db:"id" goqu:"skipinsert,skipupdate"`
+	Username     string `db:"username"`
+	PasswordHash string `db:"password_hash"`
+	Role         string `db:"role"`
+	CreatedAt    Date   `db:"created_at" goqu:"skipupdate"`
+	UpdatedAt    Date   `db:"updated_at"`
+}
+
+func (r userRow) toDomain() *internal.User {
+	return &internal.User{
+		ID:        r.ID,
+		Username:  r.Username,
+		Role:      internal.Role(r.Role),
+		CreatedAt: r.CreatedAt.Time(),
+		UpdatedAt: r.UpdatedAt.Time(),
+	}
+}
+
+type UsersRepo struct {
+	db *goqu.Database
+}
+
+func NewUsersRepo(db *sql.DB) *UsersRepo {
+	return &UsersRepo{db: newDatabase(db)}
+}
+
+// UserParams are the fields of a user to create or update, PasswordHash is a bcrypt hash.
+type UserParams struct {
+	Username     string
+	PasswordHash string
+	Role         internal.Role
+}
+
+func (r *UsersRepo) Create(ctx context.Context, params UserParams) (*internal.User, error) {
+	now := Date(time.Now().UTC())
+	query := r.db.Insert("users").
+		Rows(userRow{
+			Username:     params.Username,
+			PasswordHash: params.PasswordHash,
+			Role:         string(params.Role),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}).
+		Returning(userRow{})
+
+	// Safe to retry on a busy database, the unique username keeps a repeated insert from adding a second user
+	var row userRow
+	err := retryBusy(ctx, func() error {
+		_, err := query.Executor().ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return nil, internal.ErrUsernameTaken
+		}
+		return nil, fmt.Errorf("failed to insert user: %w", err)
+	}
+	return row.toDomain(), nil
+}
+
+func (r *UsersRepo) Get(ctx context.Context, id int64) (*internal.User, error) {
+	row, err := r.get(ctx, goqu.C("id").Eq(id))
+	if err != nil {
+		return nil, err
+	}
+	return row.toDomain(), nil
+}
+
+// GetByUsername returns the user along with its password hash, to check a login.
+func (r *UsersRepo) GetByUsername(ctx context.Context, username string) (*internal.User, string, error) {
+	row, err := r.get(ctx, goqu.C("username").Eq(username))
+	if err != nil {
+		return nil, "", err
+	}
+	return row.toDomain(), row.PasswordHash, nil
+}
+
+func (r *UsersRepo) get(ctx context.Context, where goqu.Expression) (*userRow, error) {
+	query := r.db.From("users").Select(userRow{}).Where(where)
+
+	var row userRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	} else if !found {
+		return nil, internal.ErrUserNotFound
+	}
+	return &row, nil
+}
+
+func (r *UsersRepo) List(ctx context.Context) ([]*internal.User, error) {
+	query := r.db.From("users").
+		Select(userRow{}).
+		Order(goqu.C("id").Asc())
+
+	var rows []userRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan users: %w", err)
+	}
+
+	users := make([]*internal.User, len(rows))
+	for i, row := range rows {
+		users[i] = row.toDomain()
+	}
+	return users, nil
+}
+
+// Update changes the role of a user, and its password unless params.PasswordHash is empty.
+// The username can't be changed. It returns internal.ErrLastAdmin instead of demoting the only admin.
+func (r *UsersRepo) Update(ctx context.Context, id int64, params UserParams) (*internal.User, error) {
+	record := goqu.Record{
+		"role":       string(params.Role),
+		"updated_at": Date(time.Now().UTC()),
+	}
+	if params.PasswordHash != "" {
+		record["password_hash"] = params.PasswordHash
+	}
+
+	// Safe to retry on a busy database, setting the same values twice changes nothing
+	var row userRow
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			if params.Role != internal.RoleAdmin {
+				if err := checkOtherAdmin(ctx, tx, id); err != nil {
+					return err
+				}
+			}
+			found, err := tx.Update("users").
+				Set(record).
+				Where(goqu.C("id").Eq(id)).
+				Returning(userRow{}).
+				Executor().ScanStructContext(ctx, &row)
+			if err != nil {
+				return err
+			} else if !found {
+				return internal.ErrUserNotFound
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrUserNotFound) || errors.Is(err, internal.ErrLastAdmin) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return row.toDomain(), nil
+}
+
+// Delete removes a user, their links are kept without an owner.
+// It returns internal.ErrLastAdmin instead of deleting the only admin.
+func (r *UsersRepo) Delete(ctx context.Context, id int64) error {
+	err := retryBusy(ctx, func() error {
+		return r.db.WithTx(func(tx *goqu.TxDatabase) error {
+			if err := checkOtherAdmin(ctx, tx, id); err != nil {
+				return err
+			}
+			result, err := tx.Delete("users").Where(goqu.C("id").Eq(id)).Executor().ExecContext(ctx)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to get rows affected: %w", err)
+			} else if n == 0 {
+				return internal.ErrUserNotFound
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrUserNotFound) || errors.Is(err, internal.ErrLastAdmin) {
+			return err
+		}
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// checkOtherAdmin returns internal.ErrLastAdmin when the user is the only admin, so removing
+// them would leave nobody to manage users.
+func checkOtherAdmin(ctx context.Context, tx *goqu.TxDatabase, id int64) error {
+	var role string
+	found, err := tx.From("users").Select("role").Where(goqu.C("id").Eq(id)).ScanValContext(ctx, &role)
+	if err != nil {
+		return fmt.Errorf("failed to scan user role: %w", err)
+	} else if !found || internal.Role(role) != internal.RoleAdmin {
+		return nil
+	}
+
+	others, err := tx.From("users").
+		Where(goqu.C("role").Eq(string(internal.RoleAdmin)), goqu.C("id").Neq(id)).
+		CountContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	} else if others == 0 {
+		return internal.ErrLastAdmin
+	}
+	return nil
+}
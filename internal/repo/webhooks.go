@@ -0,0 +1,275 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+)
+
+// maxDeliveriesPerWebhook is how many delivery attempts are kept for each webhook.
+const maxDeliveriesPerWebhook = 100
+
+type webhookRow struct {
+	ID        int64  `db:"id" goqu:"skipinsert"`
+	URL       string `db:"url"`
+	Secret    string `db:"secret"`
+	Events    string `db:"events"`
+	CreatedAt Date   `db:"created_at"`
+}
+
+func (r webhookRow) toDomain() (*internal.Webhook, error) {
+	var events []string
+	if err := json.Unmarshal([]byte(r.Events), &events); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook events: %w", err)
+	}
+	return &internal.Webhook{
+		ID:        r.ID,
+		URL:       r.URL,
+		Secret:    r.Secret,
+		Events:    events,
+		CreatedAt: r.CreatedAt.Time(),
+	}, nil
+}
+
+type webhookDeliveryRow struct {
+	ID          int64  `db:"id" goqu:"skipinsert"`
+	WebhookID   int64  `db:"webhook_id"`
+	Event       string `db:"event"`
+	Attempt     int    `db:"attempt"`
+	StatusCode  int    `db:"status_code"`
+	Error       string `db:"error"`
+	DurationMs  int64  `db:"duration_ms"`
+	DeliveredAt Date   `db:"delivered_at"`
+}
+
+func (r webhookDeliveryRow) toDomain() *internal.WebhookDelivery {
+	return &internal.WebhookDelivery{
+		ID:          r.ID,
+		WebhookID:   r.WebhookID,
+		Event:       r.Event,
+		Attempt:     r.Attempt,
+		StatusCode:  r.StatusCode,
+		Error:       r.Error,
+		DurationMs:  r.DurationMs,
+		DeliveredAt: r.DeliveredAt.Time(),
+	}
+}
+
+type WebhooksRepo struct {
+	db *goqu.Database
+}
+
+func NewWebhooksRepo(db *sql.DB) *WebhooksRepo {
+	return &WebhooksRepo{db: newDatabase(db)}
+}
+
+// WebhookParams are the user-editable fields of a webhook.
+type WebhookParams struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (r *WebhooksRepo) Create(ctx context.Context, params WebhookParams) (*internal.Webhook, error) {
+	events, err := json.Marshal(params.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook events: %w", err)
+	}
+
+	query := r.db.Insert("webhooks").
+		Rows(webhookRow{
+			URL:       params.URL,
+			Secret:    params.Secret,
+			Events:    string(events),
+			CreatedAt: Date(time.Now().UTC()),
+		}).
+		Returning(webhookRow{})
+
+	var row webhookRow
+	// Not retried on a busy database, nothing stops a repeated insert from adding a second webhook
+	if _, err := query.Executor().ScanStructContext(ctx, &row); err != nil {
+		return nil, fmt.Errorf("failed to insert webhook: %w", busyError(err))
+	}
+	return row.toDomain()
+}
+
+// Update replaces the URL and events of a webhook, and its secret unless params.Secret is empty.
+func (r *WebhooksRepo) Update(ctx context.Context, id int64, params WebhookParams) (*internal.Webhook, error) {
+	events, err := json.Marshal(params.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook events: %w", err)
+	}
+
+	record := goqu.Record{
+		"url":    params.URL,
+		"events": string(events),
+	}
+	if params.Secret != "" {
+		record["secret"] = params.Secret
+	}
+
+	query := r.db.Update("webhooks").
+		Set(record).
+		Where(goqu.C("id").Eq(id)).
+		Returning(webhookRow{})
+
+	var row webhookRow
+	var found bool
+	err = retryBusy(ctx, func() (err error) {
+		found, err = query.Executor().ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	} else if !found {
+		return nil, internal.ErrWebhookNotFound
+	}
+	return row.toDomain()
+}
+
+func (r *WebhooksRepo) Get(ctx context.Context, id int64) (*internal.Webhook, error) {
+	query := r.db.From("webhooks").
+		Select(webhookRow{}).
+		Where(goqu.C("id").Eq(id))
+
+	var row webhookRow
+	var found bool
+	err := retryBusy(ctx, func() (err error) {
+		found, err = query.ScanStructContext(ctx, &row)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhook: %w", err)
+	} else if !found {
+		return nil, internal.ErrWebhookNotFound
+	}
+	return row.toDomain()
+}
+
+func (r *WebhooksRepo) List(ctx context.Context) ([]*internal.Webhook, error) {
+	query := r.db.From("webhooks").
+		Select(webhookRow{}).
+		Order(goqu.C("id").Asc())
+
+	var rows []webhookRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan webhooks: %w", err)
+	}
+
+	webhooks := make([]*internal.Webhook, len(rows))
+	for i, row := range rows {
+		w, err := row.toDomain()
+		if err != nil {
+			return nil, err
+		}
+		webhooks[i] = w
+	}
+	return webhooks, nil
+}
+
+// ListForEvent returns the webhooks subscribed to event.
+func (r *WebhooksRepo) ListForEvent(ctx context.Context, event string) ([]*internal.Webhook, error) {
+	webhooks, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(webhooks, func(w *internal.Webhook) bool {
+		return !slices.Contains(w.Events, event)
+	}), nil
+}
+
+func (r *WebhooksRepo) Delete(ctx context.Context, id int64) error {
+	var result sql.Result
+	err := retryBusy(ctx, func() (err error) {
+		result, err = r.db.From("webhooks").
+			Where(goqu.C("id").Eq(id)).
+			Delete().
+			Executor().ExecContext(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return internal.ErrWebhookNotFound
+	}
+	return nil
+}
+
+// CreateDelivery logs a delivery attempt and drops the oldest ones beyond the per-webhook limit.
+// Not retried on a busy database, a repeated insert would log the attempt twice.
+func (r *WebhooksRepo) CreateDelivery(ctx context.Context, d *internal.WebhookDelivery) error {
+	err := r.db.WithTx(func(tx *goqu.TxDatabase) error {
+		_, err := tx.Insert("webhook_deliveries").
+			Rows(webhookDeliveryRow{
+				WebhookID:   d.WebhookID,
+				Event:       d.Event,
+				Attempt:     d.Attempt,
+				StatusCode:  d.StatusCode,
+				Error:       d.Error,
+				DurationMs:  d.DurationMs,
+				DeliveredAt: Date(d.DeliveredAt.UTC()),
+			}).
+			Executor().ExecContext(ctx)
+		if err != nil {
+			if isForeignKeyConstraintError(err) {
+				return internal.ErrWebhookNotFound
+			}
+			return fmt.Errorf("failed to insert webhook delivery: %w", err)
+		}
+
+		_, err = tx.From("webhook_deliveries").
+			Where(
+				goqu.C("webhook_id").Eq(d.WebhookID),
+				goqu.C("id").NotIn(
+					tx.From("webhook_deliveries").
+						Select("id").
+						Where(goqu.C("webhook_id").Eq(d.WebhookID)).
+						Order(goqu.C("id").Desc()).
+						Limit(maxDeliveriesPerWebhook),
+				),
+			).
+			Delete().
+			Executor().ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to evict webhook deliveries: %w", err)
+		}
+		return nil
+	})
+	return busyError(err)
+}
+
+// ListDeliveries returns the most recent delivery attempts of a webhook, newest first.
+func (r *WebhooksRepo) ListDeliveries(ctx context.Context, webhookID int64, limit int) ([]*internal.WebhookDelivery, error) {
+	query := r.db.From("webhook_deliveries").
+		Select(webhookDeliveryRow{}).
+		Where(goqu.C("webhook_id").Eq(webhookID)).
+		Order(goqu.C("id").Desc()).
+		Limit(uint(limit))
+
+	var rows []webhookDeliveryRow
+	if err := retryBusy(ctx, func() error {
+		return query.ScanStructsContext(ctx, &rows)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to scan webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]*internal.WebhookDelivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = row.toDomain()
+	}
+	return deliveries, nil
+}
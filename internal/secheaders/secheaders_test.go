@@ -0,0 +1,68 @@
+package secheaders
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// headers returns the headers of a response of a server with the middleware configured by cfg.
+func headers(t *testing.T, cfg Config) http.Header {
+	t.Helper()
+
+	e := echo.New()
+	e.Use(Middleware(cfg))
+	e.GET("/", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Header()
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              Config
+		wantFrameOptions string
+		wantPolicy       string
+	}{
+		{
+			"default", Config{Policy: DefaultPolicy},
+			"DENY", DefaultPolicy,
+		},
+		{
+			"embedding", Config{Policy: DefaultPolicy, FrameAncestors: []string{"'self'", "https://intranet.example.com"}},
+			"", strings.TrimSuffix(DefaultPolicy, "frame-ancestors 'none'") + "frame-ancestors 'self' https://intranet.example.com",
+		},
+		{
+			"embedding with reports", Config{Policy: DefaultPolicy, ReportURI: "/csp-report", FrameAncestors: []string{"*"}},
+			"", strings.TrimSuffix(DefaultPolicy, "frame-ancestors 'none'") + "frame-ancestors *; report-uri /csp-report",
+		},
+		{
+			// A custom policy gets the directive, whatever it had
+			"custom policy", Config{Policy: "default-src 'self'; FRAME-ANCESTORS 'none';", FrameAncestors: []string{"https://intranet.example.com"}},
+			"", "default-src 'self'; frame-ancestors https://intranet.example.com",
+		},
+		{
+			"custom policy without the directive", Config{Policy: "default-src 'self'", FrameAncestors: []string{"https://intranet.example.com"}},
+			"", "default-src 'self'; frame-ancestors https://intranet.example.com",
+		},
+		{
+			"no policy", Config{FrameAncestors: []string{"https://intranet.example.com"}},
+			"", "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := headers(t, tt.cfg)
+			if got := h.Get(echo.HeaderXFrameOptions); got != tt.wantFrameOptions {
+				t.Errorf("got X-Frame-Options %q, want %q", got, tt.wantFrameOptions)
+			}
+			if got := h.Get(echo.HeaderContentSecurityPolicy); got != tt.wantPolicy {
+				t.Errorf("got policy %q, want %q", got, tt.wantPolicy)
+			}
+		})
+	}
+}
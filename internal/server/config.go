@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/buildinfo"
+	"github.com/abdusco/linked/internal/destpolicy"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/secheaders"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/abdusco/linked/internal/slugs"
+)
+
+// Config is the configuration of the application, read from the environment by main.
+type Config struct {
+	Host     string
+	Port     string
+	DBDriver string
+	// DBDSN is the path of the sqlite file or the postgres connection string. It and the other
+	// secrets are left out of the configuration main logs
+	DBDSN          string `json:"-"`
+	AdminCreds     string `json:"-"`
+	JWTSecret      string `json:"-"`
+	LogLevel       string
+	Debug          bool
+	CookieSecure   auth.SecureMode
+	CookieSameSite http.SameSite
+	// CORSAllowedOrigins may call the API from the browser, none means same-origin only
+	CORSAllowedOrigins []string
+	// CORSAllowCredentials lets the allowed origins send the auth cookie along
+	CORSAllowCredentials bool
+	CORSAllowedMethods   []string
+	// PublicStatsOrigins are the origins allowed to fetch public stats from the browser
+	PublicStatsOrigins []string
+	// SecurityHeaders holds the Content-Security-Policy sent with every response
+	SecurityHeaders    secheaders.Config
+	SnapshotsEnabled   bool
+	SnapshotMaxPerLink int
+	SnapshotMaxTotalMB int
+	// ScanBudget is how many missing slugs a client may look up per minute before its responses are delayed
+	ScanBudget      int
+	ScanTarpitDelay time.Duration
+	// LoginLockout locks out clients after too many failed logins
+	LoginLockout auth.Lockout
+	// TrustedProxies are the proxies whose X-Forwarded-For tells the address of clients, without any
+	// clients are told apart by the address they connect from
+	TrustedProxies []*net.IPNet
+	// ClickDedupWindow ignores repeated clicks of a visitor on a link within it, zero counts every click
+	ClickDedupWindow time.Duration
+	ExcludeBotClicks bool
+	// SlugCaseInsensitive matches slugs regardless of case, and keeps slugs differing only in case from both existing
+	SlugCaseInsensitive bool
+	// SlugCharset is what generated slugs are made of, SlugChecksum ends them with a check character
+	SlugCharset  slugs.Charset
+	SlugChecksum bool
+	// SlugMinLength and SlugMaxLength bound the length of custom slugs
+	SlugMinLength int
+	SlugMaxLength int
+	// ClickWritesPerSecond is the budget of click writes, clicks above it are queued. Zero is unlimited
+	ClickWritesPerSecond int
+	// LinkCheckInterval is how often the destinations of links are checked, zero disables the checks
+	LinkCheckInterval time.Duration
+	// LinkCheckConcurrency is how many hosts are checked at once, LinkCheckHostDelay the pause between requests to one host
+	LinkCheckConcurrency int
+	LinkCheckHostDelay   time.Duration
+	// ClickSink is where clicks are written as NDJSON: none, stdout or the path of a file
+	ClickSink string
+	// VacuumInterval schedules incremental vacuums of the sqlite database, zero disables them
+	VacuumInterval time.Duration
+	// BackupDir receives scheduled backups of the sqlite database, empty disables them
+	BackupDir      string
+	BackupInterval time.Duration
+	// BackupKeep is how many scheduled backups are kept, older ones are deleted
+	BackupKeep int
+	// RequestTimeout bounds how long a request may take, some routes override it
+	RequestTimeout time.Duration
+	// FaviconPath is an icon served at /favicon.ico instead of the bundled one, empty keeps that
+	FaviconPath string
+	// RobotsPolicy is what /robots.txt tells crawlers: allow, disallow or the path of a file to serve
+	RobotsPolicy string
+	// DirectoryEnabled exposes listed links at /links and /api/public/links
+	DirectoryEnabled bool
+	// SlackSigningSecret enables the Slack slash command at /integrations/slack, empty disables it
+	SlackSigningSecret string `json:"-"`
+	// AllowedURLSchemes are the schemes link destinations may use
+	AllowedURLSchemes []string
+	// Domains are the hosts pointed at this instance that links can be scoped to
+	Domains []string
+	// Settings are the defaults of the settings admins can change at runtime
+	Settings settings.Settings
+	// DestinationPolicy restricts the hosts link destinations may have
+	DestinationPolicy *destpolicy.Policy
+	// SelfRedirectPolicy decides about links to other short links of this instance
+	SelfRedirectPolicy handler.SelfRedirectPolicy
+	// UTMCheckPolicy decides about links whose destination disagrees with the UTM parameters of their campaign
+	UTMCheckPolicy handler.UTMCheckPolicy
+	// DeleteConfirmClicks is the click count above which deleting a link needs a confirmation, zero disables it
+	DeleteConfirmClicks int64
+	// DeleteForceEnabled lets ?force=true delete such links without one
+	DeleteForceEnabled bool
+	// SlugNormalization decides about slugs that only match once cleaned up, like "/promo1/" or "/promo1."
+	SlugNormalization handler.SlugNormalization
+	// CriticalIntegrations make the application unready when they are down, the database always does
+	CriticalIntegrations []string
+	// GeoIPDBPath is a MaxMind-format database to resolve the countries of clicks, empty disables it
+	GeoIPDBPath string
+	// TLSCertFile and TLSKeyFile make the server speak HTTPS with that certificate
+	TLSCertFile string
+	TLSKeyFile  string
+	// AutoTLSDomains make the server speak HTTPS with certificates from Let's Encrypt for those domains
+	AutoTLSDomains  []string
+	AutoTLSCacheDir string
+	// HTTPRedirectPort serves redirects from HTTP to HTTPS, empty disables it
+	HTTPRedirectPort string
+	// Build is reported by /health and /api/version, main sets it from the linker flags
+	Build buildinfo.Info
+}
+
+// TLSEnabled tells whether the server terminates TLS itself.
+func (cfg Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != "" || len(cfg.AutoTLSDomains) > 0
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConfigLeavesOutSecrets(t *testing.T) {
+	cfg := Config{
+		DBDriver:   "postgres",
+		DBDSN:      "postgres://linked:dsn-secret@db/linked",
+		AdminCreds: "admin:creds-secret",
+		JWTSecret:  "jwt-secret",
+
+		SlackSigningSecret: "slack-secret",
+	}
+	// main logs the configuration as JSON
+	logged, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"dsn-secret", "creds-secret", "jwt-secret", "slack-secret"} {
+		if strings.Contains(string(logged), secret) {
+			t.Errorf("logged configuration contains %q: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(string(logged), `"DBDriver":"postgres"`) {
+		t.Errorf("logged configuration lacks the driver: %s", logged)
+	}
+}
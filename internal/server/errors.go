@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// errorHandler answers errors of the API with a handler.ErrorResponse, and sends browsers that aren't
+// logged in to the login page. Other paths get only the message of the error.
+func errorHandler(err error, c echo.Context) {
+	code, resp := handler.ResolveError(err)
+	// Integrations are called by other services, which want the status rather than the login page
+	isAPICall := strings.HasPrefix(c.Path(), "/api/") || strings.HasPrefix(c.Path(), "/integrations/")
+
+	if !isAPICall && code == http.StatusUnauthorized {
+		c.Redirect(http.StatusTemporaryRedirect, "/")
+		return
+	}
+
+	if errors.Is(err, internal.ErrDatabaseBusy) {
+		c.Response().Header().Set("Retry-After", "1")
+	}
+
+	if code >= 500 {
+		log.Error().
+			Int("code", code).
+			Str("method", c.Request().Method).
+			Str("path", c.Request().URL.Path).
+			Err(err).
+			Msg("error while handling request")
+	}
+
+	if c.Response().Committed {
+		return
+	}
+
+	if strings.HasPrefix(c.Path(), "/api/") {
+		c.JSON(code, resp)
+		return
+	}
+	c.JSON(code, map[string]any{
+		"error": resp.Error,
+	})
+}
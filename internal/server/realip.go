@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ipExtractor finds the client of a request for c.RealIP, which login lockouts, rate limits and
+// recorded clicks are keyed on. Clients can send any headers they like, so without trusted proxies
+// it's the address the request came from. Behind them it's the last address of X-Forwarded-For
+// that isn't one of them, as long as the request came from one.
+func ipExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// Only the configured proxies are trusted, not every private address like echo does by default
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range trustedProxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractor(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		trusted    []*net.IPNet
+		remoteAddr string
+		xff        string
+		xRealIP    string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:4321", want: "203.0.113.7"},
+		{name: "spoofed without proxies", remoteAddr: "203.0.113.7:4321", xff: "198.51.100.1", xRealIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "private without proxies", remoteAddr: "192.168.1.10:4321", xff: "198.51.100.1", want: "192.168.1.10"},
+		{name: "through a proxy", trusted: []*net.IPNet{proxies}, remoteAddr: "10.0.0.2:4321", xff: "198.51.100.1", want: "198.51.100.1"},
+		{name: "through two proxies", trusted: []*net.IPNet{proxies}, remoteAddr: "10.0.0.2:4321", xff: "198.51.100.1, 10.0.0.3", want: "198.51.100.1"},
+		// The proxy appends the address it got the request from, what the client sent comes before
+		{name: "spoofed through a proxy", trusted: []*net.IPNet{proxies}, remoteAddr: "10.0.0.2:4321", xff: "192.0.2.1, 198.51.100.1", want: "198.51.100.1"},
+		{name: "around the proxy", trusted: []*net.IPNet{proxies}, remoteAddr: "203.0.113.7:4321", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "private not trusted", trusted: []*net.IPNet{proxies}, remoteAddr: "192.168.1.10:4321", xff: "198.51.100.1", want: "192.168.1.10"},
+		{name: "X-Real-IP ignored", trusted: []*net.IPNet{proxies}, remoteAddr: "10.0.0.2:4321", xRealIP: "198.51.100.2", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.IPExtractor = ipExtractor(tt.trusted)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, tt.xff)
+			}
+			if tt.xRealIP != "" {
+				req.Header.Set(echo.HeaderXRealIP, tt.xRealIP)
+			}
+
+			if got := e.NewContext(req, httptest.NewRecorder()).RealIP(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,552 @@
+// Package server wires the repos, handlers and middleware of the application into an HTTP server.
+package server
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/assets"
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/branding"
+	"github.com/abdusco/linked/internal/buildinfo"
+	"github.com/abdusco/linked/internal/clickfilter"
+	"github.com/abdusco/linked/internal/clicksink"
+	"github.com/abdusco/linked/internal/clickwriter"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/geoip"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/health"
+	"github.com/abdusco/linked/internal/linkcheck"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/secheaders"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/abdusco/linked/internal/slugs"
+	"github.com/abdusco/linked/internal/snapshot"
+	"github.com/abdusco/linked/internal/tarpit"
+	"github.com/abdusco/linked/internal/timeout"
+	"github.com/abdusco/linked/internal/visitor"
+	"github.com/abdusco/linked/internal/webhook"
+	"github.com/abdusco/linked/web"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/rs/zerolog/log"
+)
+
+// RedirectTimeout bounds redirects, which only touch the database so anything slower is a stuck lock
+const RedirectTimeout = 3 * time.Second
+
+// deleteConfirmationTTL is how long a client has to repeat the deletion of a link with a lot of clicks
+const deleteConfirmationTTL = 5 * time.Minute
+
+// incrementalVacuumPages is how many free pages each scheduled incremental vacuum returns to the file system
+const incrementalVacuumPages = 5000
+
+// clickPurgeInterval is how often clicks older than the retention are purged after the purge on startup
+const clickPurgeInterval = 24 * time.Hour
+
+// Server is the echo instance serving the application, along with what runs in the background for it.
+type Server struct {
+	*echo.Echo
+	// closers stop the background work in reverse order of starting it
+	closers []func()
+}
+
+// Close stops the background work of the server, writing the queued clicks, and closes echo.
+// The database is left open, it belongs to the caller.
+func (s *Server) Close() error {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	return s.Echo.Close()
+}
+
+// Option replaces a part of the server, so tests can wire in their own.
+type Option func(*options)
+
+type options struct {
+	linksRepo  *repo.LinksRepo
+	clicksRepo *repo.ClicksRepo
+	geo        geoip.Resolver
+	clickSink  clicksink.Sink
+}
+
+// WithLinksRepo serves links from linksRepo, like one running on the clock of a test.
+func WithLinksRepo(linksRepo *repo.LinksRepo) Option {
+	return func(o *options) { o.linksRepo = linksRepo }
+}
+
+// WithClicksRepo records and counts clicks with clicksRepo.
+func WithClicksRepo(clicksRepo *repo.ClicksRepo) Option {
+	return func(o *options) { o.clicksRepo = clicksRepo }
+}
+
+// WithGeoResolver resolves the countries of clicks with geo instead of the database at GeoIPDBPath.
+func WithGeoResolver(geo geoip.Resolver) Option {
+	return func(o *options) { o.geo = geo }
+}
+
+// WithClickSink sends the clicks recorded to sink instead of the one of CLICK_SINK.
+func WithClickSink(sink clicksink.Sink) Option {
+	return func(o *options) { o.clickSink = sink }
+}
+
+// New wires up the server for dbInstance, which must be migrated already. Background work such as
+// webhook deliveries runs until ctx is done or the server is closed.
+func New(ctx context.Context, cfg Config, dbInstance *sql.DB, opts ...Option) (_ *Server, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	credentials, err := auth.NewCredentials(cfg.AdminCreds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse admin credentials: %w", err)
+	}
+
+	// Migrations only run at startup, the version stays the same while the server runs
+	schemaVersion, err := db.SchemaVersion(ctx, dbInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	e := echo.New()
+	srv := &Server{Echo: e}
+	ctx, cancel := context.WithCancel(ctx)
+	srv.closers = append(srv.closers, cancel)
+	defer func() {
+		if err != nil {
+			srv.Close()
+		}
+	}()
+
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = errorHandler
+	e.IPExtractor = ipExtractor(cfg.TrustedProxies)
+
+	e.Use(logger.RequestID())
+	e.Use(logger.Middleware())
+	e.Use(logger.AccessLog())
+	e.Use(middleware.Recover())
+	e.Use(secheaders.Middleware(cfg.SecurityHeaders))
+	e.Use(buildinfo.Middleware(cfg.Build))
+	// Without allowed origins there are no CORS headers, so browsers keep the API same-origin
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			// Public endpoints carry their own CORS policy
+			Skipper: func(c echo.Context) bool {
+				return strings.HasPrefix(c.Request().URL.Path, "/api/public/")
+			},
+			AllowOrigins:     cfg.CORSAllowedOrigins,
+			AllowMethods:     cfg.CORSAllowedMethods,
+			AllowCredentials: cfg.CORSAllowCredentials,
+		}))
+	}
+	e.Use(timeout.Middleware(timeout.Config{
+		Default: cfg.RequestTimeout,
+		Overrides: map[string]time.Duration{
+			"GET /:slug":   RedirectTimeout,
+			"HEAD /:slug":  RedirectTimeout,
+			"GET /:slug/":  RedirectTimeout,
+			"HEAD /:slug/": RedirectTimeout,
+			"GET /p/:slug": RedirectTimeout,
+			// Fetches the destination synchronously
+			"POST /api/links/:id/snapshots": 45 * time.Second,
+			// Reads the upload as it arrives, a large export takes a while
+			"POST /api/import": timeout.NoDeadline,
+			// Deletes in batches until done, stopping halfway loses nothing
+			"POST /api/admin/purge-clicks": timeout.NoDeadline,
+			// Rewrites the whole database file and can't be interrupted
+			"POST /api/admin/vacuum": timeout.NoDeadline,
+			// Copies the whole database, then streams it
+			"GET /api/admin/backup": timeout.NoDeadline,
+		},
+	}))
+	var staticFS fs.FS = web.FS
+	if cfg.Debug {
+		log.Info().Msg("serving static files from disk")
+		staticFS = os.DirFS("web")
+	} else {
+		log.Info().Msg("serving static files from embedded filesystem")
+	}
+	staticAssets, err := assets.New(staticFS, cfg.Debug)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load web assets: %w", err)
+	}
+
+	healthRegistry := health.NewRegistry()
+	healthRegistry.Register("database", true, dbInstance.PingContext)
+
+	webhooksRepo := repo.NewWebhooksRepo(dbInstance)
+	// Receivers are third-party servers, so webhooks have no self-check
+	dispatcher := webhook.NewDispatcher(webhooksRepo, healthRegistry.Register("webhooks", false, nil))
+	go dispatcher.Run(ctx)
+
+	settingsRepo := repo.NewSettingsRepo(dbInstance)
+	settingsStore := settings.NewStore(settingsRepo, cfg.Settings)
+	usersRepo := repo.NewUsersRepo(dbInstance)
+	loginAttemptsRepo := repo.NewLoginAttemptsRepo(dbInstance)
+	authenticator := auth.NewAuthenticator(credentials, cfg.JWTSecret, auth.CookieOptions{
+		Secure:         cfg.CookieSecure,
+		SameSite:       cfg.CookieSameSite,
+		TrustedProxies: cfg.TrustedProxies,
+	}, settingsRepo, usersRepo, loginAttemptsRepo, cfg.LoginLockout, dispatcher)
+	if err := authenticator.Init(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize authenticator: %w", err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(authenticator)
+	authHandler := handler.NewAuthHandler(authenticator, loginAttemptsRepo, staticAssets)
+
+	// Pages at a single path segment answer HEAD too, which would otherwise reach the slug route
+	getOrHead := []string{http.MethodGet, http.MethodHead}
+	e.Match(getOrHead, "/", authHandler.ServeLoginPage)
+	e.POST("/login", authHandler.Login)
+	e.Match(getOrHead, "/logout", authHandler.Logout)
+
+	dashboardHandler := handler.NewDashboardHandler(staticAssets)
+	e.Match(getOrHead, "/dashboard", dashboardHandler.ServeDashboardPage, authMiddleware)
+
+	// The spec is public, so clients can be generated without credentials
+	e.GET("/api/openapi.json", handler.ServeOpenAPISpec)
+	// Point CSP_REPORT_URI here to collect the violations in the log
+	e.POST("/csp-report", handler.CollectCSPReport, newPublicRateLimiter())
+
+	api := e.Group("/api")
+	api.Use(authMiddleware)
+	// Viewers can only read, editors can also change their own links, admins can do anything
+	requireEditor := auth.RequireRole(internal.RoleEditor)
+	requireAdmin := auth.RequireRole(internal.RoleAdmin)
+
+	api.POST("/auth/revoke-all", authHandler.RevokeAll, requireAdmin)
+	api.GET("/admin/login-attempts", authHandler.ListLoginAttempts, requireAdmin)
+	api.GET("/metrics", echo.WrapHandler(expvar.Handler()), requireAdmin)
+
+	userHandler := handler.NewUserHandler(usersRepo)
+	api.GET("/auth/me", userHandler.Me)
+	api.POST("/users", userHandler.CreateUser, requireAdmin)
+	api.GET("/users", userHandler.ListUsers, requireAdmin)
+	api.GET("/users/:id", userHandler.GetUser, requireAdmin)
+	api.PUT("/users/:id", userHandler.UpdateUser, requireAdmin)
+	api.DELETE("/users/:id", userHandler.DeleteUser, requireAdmin)
+
+	brandingStore := branding.NewStore(settingsRepo, repo.NewUploadsRepo(dbInstance))
+	brandingHandler := handler.NewBrandingHandler(brandingStore, staticAssets)
+	api.GET("/admin/branding", brandingHandler.GetBranding, requireAdmin)
+	api.PUT("/admin/branding", brandingHandler.UpdateBranding, requireAdmin)
+	api.GET("/admin/branding/preview", brandingHandler.Preview, requireAdmin)
+	api.PUT("/admin/branding/logo", brandingHandler.UploadLogo, requireAdmin)
+	api.DELETE("/admin/branding/logo", brandingHandler.DeleteLogo, requireAdmin)
+	e.GET(branding.LogoPath, brandingHandler.ServeLogo)
+
+	settingsHandler := handler.NewSettingsHandler(settingsStore)
+	api.GET("/settings", settingsHandler.GetSettings)
+	api.PUT("/settings", settingsHandler.UpdateSettings, requireAdmin)
+
+	if cfg.DBDriver == db.DriverSQLite {
+		adminHandler := handler.NewAdminHandler(dbInstance)
+		api.GET("/admin/db-stats", adminHandler.DBStats, requireAdmin)
+		api.POST("/admin/vacuum", adminHandler.Vacuum, requireAdmin)
+		api.GET("/admin/backup", adminHandler.Backup, requireAdmin)
+
+		if cfg.VacuumInterval > 0 {
+			go db.ScheduleIncrementalVacuum(ctx, dbInstance, cfg.VacuumInterval, incrementalVacuumPages)
+		}
+		if cfg.BackupDir != "" {
+			if err := os.MkdirAll(cfg.BackupDir, 0o700); err != nil {
+				return nil, fmt.Errorf("failed to create backup directory: %w", err)
+			}
+			go db.ScheduleBackups(ctx, dbInstance, cfg.BackupDir, cfg.BackupInterval, cfg.BackupKeep)
+		}
+	}
+
+	linksRepo := o.linksRepo
+	if linksRepo == nil {
+		linksRepo = repo.NewLinksRepo(dbInstance, cfg.SlugCaseInsensitive)
+	}
+	if err := linksRepo.BackfillURLKeys(ctx); err != nil {
+		return nil, fmt.Errorf("failed to backfill url keys: %w", err)
+	}
+	clicksRepo := o.clicksRepo
+	if clicksRepo == nil {
+		clicksRepo = repo.NewClicksRepo(dbInstance)
+	}
+	campaignsRepo := repo.NewCampaignsRepo(dbInstance)
+	snapshotsRepo := repo.NewSnapshotsRepo(dbInstance)
+	var snapshotter *snapshot.Snapshotter
+	if cfg.SnapshotsEnabled {
+		snapshotter = snapshot.NewSnapshotter(snapshotsRepo, repo.SnapshotLimits{
+			MaxPerLink:    cfg.SnapshotMaxPerLink,
+			MaxTotalBytes: int64(cfg.SnapshotMaxTotalMB) << 20,
+		})
+	}
+	guard := tarpit.NewGuard(cfg.ScanBudget, cfg.ScanTarpitDelay)
+	clickFilter := clickfilter.New(cfg.ClickDedupWindow, cfg.ExcludeBotClicks)
+	geo := o.geo
+	if geo == nil {
+		geo = geoip.Nop{}
+		if cfg.GeoIPDBPath != "" {
+			maxmind, err := geoip.Open(cfg.GeoIPDBPath)
+			if err != nil {
+				return nil, err
+			}
+			srv.closers = append(srv.closers, func() { maxmind.Close() })
+			geo = maxmind
+			healthRegistry.Register("geoip", false, maxmind.Check)
+		}
+	}
+	if err := healthRegistry.MarkCritical(cfg.CriticalIntegrations); err != nil {
+		return nil, fmt.Errorf("CRITICAL_INTEGRATIONS: %w", err)
+	}
+	healthHandler := handler.NewHealthHandler(healthRegistry)
+	api.GET("/admin/integrations", healthHandler.ListIntegrations, requireAdmin)
+	api.POST("/admin/integrations/:name/test", healthHandler.TestIntegration, requireAdmin)
+	e.Match(getOrHead, "/ready", healthHandler.Ready)
+	clickSink := o.clickSink
+	if clickSink == nil {
+		if clickSink, err = startClickSink(ctx, srv, cfg.ClickSink); err != nil {
+			return nil, fmt.Errorf("CLICK_SINK: %w", err)
+		}
+	}
+	clickWriter := clickwriter.New(clicksRepo, dispatcher, clickSink, cfg.ClickWritesPerSecond)
+	clickWriterCtx, stopClickWriter := context.WithCancel(ctx)
+	clickWriterDone := make(chan struct{})
+	go func() {
+		defer close(clickWriterDone)
+		clickWriter.Run(clickWriterCtx)
+	}()
+	// Write the queued clicks before the database is closed
+	srv.closers = append(srv.closers, func() {
+		stopClickWriter()
+		<-clickWriterDone
+	})
+
+	deleteProtection := handler.DeleteProtection{
+		MinClicks:  cfg.DeleteConfirmClicks,
+		AllowForce: cfg.DeleteForceEnabled,
+		Confirmer:  auth.NewConfirmer(cfg.JWTSecret, deleteConfirmationTTL),
+	}
+	linkHandler := handler.NewLinkHandler(handler.LinkHandlerDeps{
+		LinksRepo:         linksRepo,
+		ClicksRepo:        clicksRepo,
+		Snapshotter:       snapshotter,
+		Webhooks:          dispatcher,
+		Guard:             guard,
+		ClickFilter:       clickFilter,
+		ClickWriter:       clickWriter,
+		Visitors:          visitor.NewHasher(settingsRepo),
+		Geo:               geo,
+		Assets:            staticAssets,
+		Branding:          brandingStore,
+		Validator:         handler.NewLinkValidator(cfg.AllowedURLSchemes, cfg.SlugMinLength, cfg.SlugMaxLength),
+		Domains:           cfg.Domains,
+		SlugGen:           slugs.NewGenerator(cfg.SlugCharset, cfg.SlugChecksum, linksRepo),
+		Destinations:      cfg.DestinationPolicy,
+		SelfRedirects:     cfg.SelfRedirectPolicy,
+		CampaignsRepo:     campaignsRepo,
+		UTMCheck:          cfg.UTMCheckPolicy,
+		SlugNormalization: cfg.SlugNormalization,
+		DeleteProtection:  deleteProtection,
+		Settings:          settingsStore,
+	})
+	api.POST("/links", linkHandler.CreateLink, requireEditor)
+	api.GET("/links", linkHandler.ListLinks)
+	api.GET("/links/:id", linkHandler.GetLink)
+	api.GET("/links/:id/visit", linkHandler.Visit)
+	api.POST("/import", linkHandler.ImportLinks, requireEditor)
+	api.PATCH("/links/:id", linkHandler.UpdateLink, requireEditor)
+	api.DELETE("/links/:id", linkHandler.DeleteLink, requireEditor)
+	api.DELETE("/links/slug/:slug", linkHandler.DeleteLinkBySlug, requireEditor)
+	api.POST("/links/:id/regenerate-slug", linkHandler.RegenerateSlug, requireEditor)
+	api.POST("/links/:id/clone", linkHandler.CloneLink, requireEditor)
+	api.GET("/links/:id/stats/referrers", linkHandler.ReferrerStats)
+	api.GET("/links/:id/stats/countries", linkHandler.CountryStats)
+	api.GET("/tags", linkHandler.ListTags)
+	api.POST("/links/:id/stats-token", linkHandler.CreateStatsToken, requireEditor)
+	api.DELETE("/links/:id/stats-token", linkHandler.RevokeStatsToken, requireEditor)
+	api.POST("/admin/purge-clicks", linkHandler.PurgeClicks, requireAdmin)
+	api.GET("/admin/destination-policy", linkHandler.DestinationPolicy, requireAdmin)
+	go scheduleClickPurge(ctx, clicksRepo, settingsStore)
+
+	// Campaigns aren't owned by anyone, so only admins may delete them out from under others' links
+	campaignHandler := handler.NewCampaignHandler(campaignsRepo, linksRepo, clicksRepo)
+	api.POST("/campaigns", campaignHandler.CreateCampaign, requireEditor)
+	api.GET("/campaigns", campaignHandler.ListCampaigns)
+	api.GET("/campaigns/:id", campaignHandler.GetCampaign)
+	api.PUT("/campaigns/:id", campaignHandler.UpdateCampaign, requireEditor)
+	api.DELETE("/campaigns/:id", campaignHandler.DeleteCampaign, requireAdmin)
+	api.GET("/campaigns/:id/stats", campaignHandler.CampaignStats)
+	api.GET("/campaigns/:id/utm-issues", campaignHandler.UTMIssues)
+
+	if snapshotter != nil {
+		snapshotHandler := handler.NewSnapshotHandler(linksRepo, snapshotsRepo, snapshotter)
+		api.GET("/links/:id/snapshots", snapshotHandler.ListSnapshots)
+		api.POST("/links/:id/snapshots", snapshotHandler.TakeSnapshot, requireEditor)
+		api.GET("/links/:id/snapshots/:snapshotId", snapshotHandler.DownloadSnapshot)
+	}
+
+	checker := linkcheck.New(linksRepo, cfg.LinkCheckConcurrency, cfg.LinkCheckHostDelay)
+	linkCheckHandler := handler.NewLinkCheckHandler(linksRepo, checker)
+	api.POST("/links/:id/check", linkCheckHandler.CheckLink, requireEditor)
+	if cfg.LinkCheckInterval > 0 {
+		checkerCtx, stopChecker := context.WithCancel(ctx)
+		checkerDone := make(chan struct{})
+		go func() {
+			defer close(checkerDone)
+			checker.Run(checkerCtx, cfg.LinkCheckInterval)
+		}()
+		// Let the checks under way give up before the database is closed
+		srv.closers = append(srv.closers, func() {
+			stopChecker()
+			<-checkerDone
+		})
+	}
+
+	webhookHandler := handler.NewWebhookHandler(webhooksRepo)
+	api.POST("/webhooks", webhookHandler.CreateWebhook, requireAdmin)
+	api.GET("/webhooks", webhookHandler.ListWebhooks, requireAdmin)
+	api.GET("/webhooks/:id", webhookHandler.GetWebhook, requireAdmin)
+	api.PUT("/webhooks/:id", webhookHandler.UpdateWebhook, requireAdmin)
+	api.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook, requireAdmin)
+	api.GET("/webhooks/:id/deliveries", webhookHandler.ListDeliveries, requireAdmin)
+
+	publicCORS := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: cfg.PublicStatsOrigins,
+		AllowMethods: []string{http.MethodGet},
+	})
+
+	publicStatsHandler := handler.NewPublicStatsHandler(linksRepo, clicksRepo)
+	e.GET("/api/public/stats/:file", publicStatsHandler.GetStats, publicCORS, newPublicRateLimiter())
+
+	// Outside of /api, Slack signs its requests instead of logging in
+	if cfg.SlackSigningSecret != "" {
+		slackHandler := handler.NewSlackHandler(linkHandler, cfg.SlackSigningSecret)
+		e.POST("/integrations/slack", slackHandler.SlashCommand, newPublicRateLimiter())
+	}
+
+	if cfg.DirectoryEnabled {
+		directoryHandler := handler.NewDirectoryHandler(linksRepo, staticAssets, brandingStore)
+		e.GET("/api/public/links", directoryHandler.ListLinks, publicCORS, newPublicRateLimiter())
+		e.Match(getOrHead, "/links", directoryHandler.ServeDirectoryPage, newPublicRateLimiter())
+	}
+
+	e.GET(assets.URLPrefix+"*", staticAssets.ServeStatic)
+
+	// Asked for by every browser and crawler, they must not be taken for slugs
+	var favicon *handler.Favicon
+	if cfg.FaviconPath != "" {
+		if favicon, err = handler.ReadFavicon(cfg.FaviconPath); err != nil {
+			return nil, fmt.Errorf("FAVICON_PATH: %w", err)
+		}
+	}
+	robots, err := handler.RobotsTxt(cfg.RobotsPolicy, cfg.DirectoryEnabled)
+	if err != nil {
+		return nil, fmt.Errorf("ROBOTS_POLICY: %w", err)
+	}
+	siteFiles := handler.NewSiteFilesHandler(staticAssets, favicon, robots)
+	e.Match(getOrHead, "/favicon.ico", siteFiles.Favicon)
+	e.Match(getOrHead, "/robots.txt", siteFiles.Robots)
+	e.Match(getOrHead, "/.well-known", siteFiles.WellKnown)
+	e.Match(getOrHead, "/.well-known/*", siteFiles.WellKnown)
+
+	e.Match(getOrHead, "/health", func(c echo.Context) error {
+		return c.JSON(200, map[string]any{"status": "ok", "version": cfg.Build.Version})
+	})
+	e.GET("/api/version", func(c echo.Context) error {
+		return c.JSON(200, struct {
+			buildinfo.Info
+			SchemaVersion int `json:"schema_version"`
+		}{cfg.Build, schemaVersion})
+	})
+
+	e.GET("/p/:slug", linkHandler.Preview)
+
+	// Parameterized route (must be last)
+	e.GET("/:slug", linkHandler.Redirect)
+	e.HEAD("/:slug", linkHandler.Redirect)
+	if cfg.SlugNormalization != handler.SlugNormalizationOff {
+		e.GET("/:slug/", linkHandler.Redirect)
+		e.HEAD("/:slug/", linkHandler.Redirect)
+	}
+
+	return srv, nil
+}
+
+// newPublicRateLimiter limits unauthenticated endpoints per client IP.
+func newPublicRateLimiter() echo.MiddlewareFunc {
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:  1,
+			Burst: 30,
+		}),
+	})
+}
+
+// scheduleClickPurge purges the clicks older than the retention of the settings on startup and
+// daily after that, so a changed retention applies without a restart. Each run first moves the
+// clicks recorded before they were kept by month into their partitions, until none are left.
+func scheduleClickPurge(ctx context.Context, clicksRepo *repo.ClicksRepo, settingsStore *settings.Store) {
+	ticker := time.NewTicker(clickPurgeInterval)
+	defer ticker.Stop()
+	for {
+		moved, err := clicksRepo.MoveUnpartitioned(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Int64("moved", moved).Msg("failed to move clicks into monthly partitions")
+		} else if moved > 0 {
+			log.Info().Int64("moved", moved).Msg("moved clicks into monthly partitions")
+		}
+
+		if days := settingsStore.Current(ctx).ClickRetentionDays; days > 0 {
+			purged, err := clicksRepo.PurgeBefore(ctx, time.Now().AddDate(0, 0, -days))
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Int64("purged", purged).Msg("failed to purge old clicks")
+			} else if purged > 0 {
+				log.Info().Int64("purged", purged).Msg("purged old clicks")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startClickSink runs the sink of target: none, stdout or the path of a file. It's stopped after
+// the click writer, so the clicks written on shutdown still reach it.
+func startClickSink(ctx context.Context, srv *Server, target string) (clicksink.Sink, error) {
+	var sink *clicksink.NDJSON
+	switch target {
+	case "", "none":
+		return clicksink.Nop{}, nil
+	case "stdout":
+		sink = clicksink.NewStdout()
+	default:
+		var err error
+		if sink, err = clicksink.OpenFile(target); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only stopped by closing the server, the click writer may still send clicks once ctx is done
+	sinkCtx, stopSink := context.WithCancel(context.WithoutCancel(ctx))
+	sinkDone := make(chan struct{})
+	go func() {
+		defer close(sinkDone)
+		sink.Run(sinkCtx)
+	}()
+	srv.closers = append(srv.closers, func() {
+		stopSink()
+		<-sinkDone
+	})
+	return sink, nil
+}
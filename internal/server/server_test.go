@@ -0,0 +1,378 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+)
+
+func TestLoginCreateRedirectCount(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+	client := testutil.NewClient(t)
+
+	// Outside of the API, failing to log in leads back to the login page
+	res := testutil.PostJSON(t, client, ts.URL+"/login", auth.Credentials{Username: "admin", Password: "wrong"})
+	res.Body.Close()
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != "/" {
+		t.Fatalf("login with a wrong password: got %d to %q, want %d to /", res.StatusCode, res.Header.Get("Location"), http.StatusTemporaryRedirect)
+	}
+
+	res = testutil.PostJSON(t, client, ts.URL+"/login", auth.Credentials{Username: "admin", Password: "admin"})
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("login: got %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+
+	res = testutil.PostJSON(t, client, ts.URL+"/api/links", map[string]string{
+		"slug": "hello",
+		"url":  "https://example.com/landing",
+	})
+	if res.StatusCode != http.StatusCreated {
+		res.Body.Close()
+		t.Fatalf("create link: got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	var created struct {
+		Link struct {
+			ID   int64  `json:"id"`
+			Slug string `json:"slug"`
+		} `json:"link"`
+	}
+	testutil.DecodeJSON(t, res, &created)
+	link := created.Link
+	if link.Slug != "hello" {
+		t.Fatalf("created slug: got %q, want %q", link.Slug, "hello")
+	}
+
+	// Visitors aren't logged in
+	res, err := testutil.NewClient(t).Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("redirect: got %d, want %d", res.StatusCode, http.StatusPermanentRedirect)
+	}
+	if got := res.Header.Get("Location"); got != "https://example.com/landing" {
+		t.Fatalf("redirect location: got %q", got)
+	}
+
+	// Clicks are written in the background, so the count catches up shortly after
+	url := fmt.Sprintf("%s/api/links/%d", ts.URL, link.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			t.Fatalf("get link: got %d, want %d", res.StatusCode, http.StatusOK)
+		}
+		var got struct {
+			Stats struct {
+				Clicks int64 `json:"clicks"`
+			} `json:"stats"`
+		}
+		testutil.DecodeJSON(t, res, &got)
+		if got.Stats.Clicks == 1 {
+			return
+		}
+		if got.Stats.Clicks > 1 || time.Now().After(deadline) {
+			t.Fatalf("clicks: got %d, want 1", got.Stats.Clicks)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAPIRequiresLogin(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+
+	res, err := testutil.NewClient(t).Get(ts.URL + "/api/links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestMetricsRequireAdmin(t *testing.T) {
+	ts := testutil.NewServer(t, testutil.NewDB(t), testutil.ServerConfig(t))
+	admin := testutil.NewClient(t)
+	testutil.LogIn(t, admin, ts.URL)
+
+	for _, role := range []internal.Role{internal.RoleEditor, internal.RoleViewer} {
+		user := handler.UserRequest{Username: string(role), Password: "correct horse battery", Role: role}
+		res := testutil.PostJSON(t, admin, ts.URL+"/api/users", user)
+		res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("create %s: got %d, want %d", role, res.StatusCode, http.StatusCreated)
+		}
+		client := testutil.NewClient(t)
+		res = testutil.PostJSON(t, client, ts.URL+"/login", auth.Credentials{Username: user.Username, Password: user.Password})
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("login as %s: got %d, want %d", role, res.StatusCode, http.StatusNoContent)
+		}
+
+		res, err := client.Get(ts.URL + "/api/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: got %d, want %d", role, res.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	res, err := admin.Get(ts.URL + "/api/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("admin: got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+// logIn logs in as admin with password, from the client forwarded for by a proxy as xff.
+func logIn(t *testing.T, baseURL, password, xff string) int {
+	t.Helper()
+
+	payload, err := json.Marshal(auth.Credentials{Username: "admin", Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/login", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", xff)
+	req.Header.Set("X-Real-IP", xff)
+	res, err := testutil.NewClient(t).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestLockoutIgnoresForwardingHeaders(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.LoginLockout.MaxFailures = 2
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+
+	// Without trusted proxies, claiming to be someone else doesn't get another try
+	logIn(t, ts.URL, "wrong", "198.51.100.1")
+	logIn(t, ts.URL, "wrong", "198.51.100.2")
+	if got := logIn(t, ts.URL, "admin", "198.51.100.3"); got != http.StatusTooManyRequests {
+		t.Errorf("login after failing from spoofed addresses: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestLockoutBehindTrustedProxy(t *testing.T) {
+	cfg := testutil.ServerConfig(t)
+	cfg.LoginLockout.MaxFailures = 2
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TrustedProxies = []*net.IPNet{loopback}
+	ts := testutil.NewServer(t, testutil.NewDB(t), cfg)
+
+	logIn(t, ts.URL, "wrong", "198.51.100.1")
+	logIn(t, ts.URL, "wrong", "198.51.100.1")
+	if got := logIn(t, ts.URL, "admin", "198.51.100.1"); got != http.StatusTooManyRequests {
+		t.Errorf("login of the locked out client: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+	// Other clients behind the same proxy aren't locked out along with it
+	if got := logIn(t, ts.URL, "admin", "198.51.100.2"); got != http.StatusNoContent {
+		t.Errorf("login of another client: got %d, want %d", got, http.StatusNoContent)
+	}
+}
+
+// holdLock runs begin on a connection of another pool of the database of dsn, and keeps the transaction
+// open until the test ends like a long-running one would.
+func holdLock(t *testing.T, dsn, begin string) {
+	t.Helper()
+
+	ctx := context.Background()
+	other, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := other.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.ExecContext(ctx, "ROLLBACK")
+		conn.Close()
+		other.Close()
+	})
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabaseBusy(t *testing.T) {
+	tests := []struct {
+		name        string
+		journalMode string
+		lock        string
+		method      string
+		path        string
+		body        string
+	}{
+		{
+			// Readers carry on in WAL mode, only writers wait for the lock
+			name:        "retried write",
+			journalMode: "WAL",
+			lock:        "BEGIN IMMEDIATE",
+			method:      http.MethodPost,
+			path:        "/api/links",
+			body:        `{"slug":"busy-link","url":"https://example.com/busy"}`,
+		},
+		{
+			// Writes that can't be repeated fail on the first busy error
+			name:        "write that isn't retried",
+			journalMode: "WAL",
+			lock:        "BEGIN IMMEDIATE",
+			method:      http.MethodPost,
+			path:        "/api/webhooks",
+			body:        `{"url":"https://example.com/hook","events":["link.created"]}`,
+		},
+		{
+			// Loading the user of the cookie fails first, which isn't a reason to log the client out
+			name:        "read",
+			journalMode: "DELETE",
+			lock:        "BEGIN EXCLUSIVE",
+			method:      http.MethodGet,
+			path:        "/api/links",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlDB, dsn := testutil.NewFileDB(t, tt.journalMode)
+			// Hashing the password takes longer than the short deadline below under the race
+			// detector, so the client logs in to a server with the usual one
+			client := testutil.NewClient(t)
+			login := testutil.NewServer(t, sqlDB, testutil.ServerConfig(t))
+			testutil.LogIn(t, client, login.URL)
+
+			cfg := testutil.ServerConfig(t)
+			// Retries give up once the next one can't finish in time
+			cfg.RequestTimeout = 300 * time.Millisecond
+			ts := testutil.NewServer(t, sqlDB, cfg)
+			copyCookies(t, client, login.URL, ts.URL)
+
+			holdLock(t, dsn, tt.lock)
+
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := `{"code":"database_busy","error":"database is temporarily unavailable"}`
+			if res.StatusCode != http.StatusServiceUnavailable || strings.TrimSpace(string(body)) != want {
+				t.Errorf("got %d %s, want %d %s", res.StatusCode, body, http.StatusServiceUnavailable, want)
+			}
+			if got := res.Header.Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After: got %q, want %q", got, "1")
+			}
+		})
+	}
+}
+
+// copyCookies gives client the cookies it has for the server at from for the one at to as well.
+func copyCookies(t *testing.T, client *http.Client, from, to string) {
+	t.Helper()
+
+	fromURL, err := url.Parse(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toURL, err := url.Parse(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Jar.SetCookies(toURL, client.Jar.Cookies(fromURL))
+}
+
+func TestFixedRoutesWinOverSlugs(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	// Links whose slugs are the paths of routes, which the API refuses but older databases may have
+	for _, slug := range []string{"favicon.ico", "robots.txt", ".well-known", "health", "ready", "dashboard", "logout", "links"} {
+		f.Link(t, func(l *repo.NewLink) { l.Slug = slug; l.URL = "https://example.com/" + slug })
+	}
+	cfg := testutil.ServerConfig(t)
+	cfg.DirectoryEnabled = true
+	ts := testutil.NewServer(t, f.DB, cfg)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/favicon.ico", http.StatusOK},
+		{"/robots.txt", http.StatusOK},
+		{"/.well-known", http.StatusNotFound},
+		{"/.well-known/security.txt", http.StatusNotFound},
+		{"/health", http.StatusOK},
+		{"/ready", http.StatusOK},
+		// Login is required, which sends visitors to the login page
+		{"/dashboard", http.StatusTemporaryRedirect},
+		{"/logout", http.StatusFound},
+		{"/links", http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req, err := http.NewRequest(method, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := testutil.NewClient(t).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if location := res.Header.Get("Location"); res.StatusCode != tt.wantStatus || strings.HasPrefix(location, "https://example.com/") {
+				t.Errorf("%s %s: got %d to %q, want %d from its route", method, tt.path, res.StatusCode, location, tt.wantStatus)
+			}
+		}
+	}
+
+	var clicks int
+	if err := f.DB.QueryRow("SELECT COUNT(*) FROM clicks").Scan(&clicks); err != nil {
+		t.Fatal(err)
+	}
+	if clicks != 0 {
+		t.Errorf("got %d clicks, want none", clicks)
+	}
+}
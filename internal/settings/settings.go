@@ -0,0 +1,244 @@
+// Package settings holds the options an admin can change while the server runs.
+// Environment variables give their defaults, values saved through the API override them.
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+)
+
+// cacheTTL bounds how long other replicas keep using the settings before a change
+const cacheTTL = 10 * time.Second
+
+// maxRetentionDays bounds ClickRetentionDays, far beyond anything useful
+const maxRetentionDays = 36500
+
+// RedirectStatuses are the statuses a short link may redirect with.
+var RedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// Names are the JSON names of the settings.
+var Names = []string{"redirect_status", "not_found_url", "click_retention_days", "coming_soon_page"}
+
+type Settings struct {
+	// RedirectStatus is the status short links redirect with, permanent ones are cached by browsers
+	RedirectStatus int `json:"redirect_status"`
+	// NotFoundURL is where visitors of unknown slugs are sent, empty shows a not found error
+	NotFoundURL string `json:"not_found_url"`
+	// ClickRetentionDays is how long clicks are kept before they're rolled up into daily counts, zero keeps them forever
+	ClickRetentionDays int `json:"click_retention_days"`
+	// ComingSoonPage shows a page for links that aren't active yet, instead of not found
+	ComingSoonPage bool `json:"coming_soon_page"`
+}
+
+// Validate checks the values of the settings, and returns the invalid ones as FieldErrors.
+func (s *Settings) Validate() error {
+	errs := FieldErrors{}
+	if !slices.Contains(RedirectStatuses, s.RedirectStatus) {
+		errs["redirect_status"] = "must be one of 301, 302, 307 or 308"
+	}
+	s.NotFoundURL = strings.TrimSpace(s.NotFoundURL)
+	if s.NotFoundURL != "" {
+		u, err := url.Parse(s.NotFoundURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs["not_found_url"] = "must be an http or https URL"
+		}
+	}
+	if s.ClickRetentionDays < 0 || s.ClickRetentionDays > maxRetentionDays {
+		errs["click_retention_days"] = fmt.Sprintf("must be between 0 and %d", maxRetentionDays)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FieldErrors tells why each invalid setting was refused, by its JSON name.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fieldErr := range e.Details() {
+		parts = append(parts, fieldErr.Field+" "+fieldErr.Message)
+	}
+	return "invalid settings: " + strings.Join(parts, ", ")
+}
+
+// Details lists the invalid settings by their name, as the API reports invalid fields.
+func (e FieldErrors) Details() internal.ValidationError {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	details := make(internal.ValidationError, len(names))
+	for i, name := range names {
+		details[i] = internal.FieldError{Field: name, Message: e[name]}
+	}
+	return details
+}
+
+// Store keeps the settings changed by an admin in the settings table. They're cached, and
+// read again after cacheTTL so replicas sharing the database pick up each other's changes.
+type Store struct {
+	settings *repo.SettingsRepo
+	defaults Settings
+
+	mu       sync.Mutex
+	cached   map[string]json.RawMessage
+	cachedAt time.Time
+}
+
+// NewStore returns a store falling back to defaults for the settings that weren't changed.
+func NewStore(settings *repo.SettingsRepo, defaults Settings) *Store {
+	return &Store{settings: settings, defaults: defaults}
+}
+
+func (s *Store) Defaults() Settings {
+	return s.defaults
+}
+
+// Get returns the current settings.
+func (s *Store) Get(ctx context.Context) (Settings, error) {
+	overrides, err := s.overrides(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	return s.apply(overrides)
+}
+
+// Current returns the current settings, or the defaults when they can't be read, so a failing
+// database doesn't take the behavior they control down with it.
+func (s *Store) Current(ctx context.Context) Settings {
+	settings, err := s.Get(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to read settings, using defaults")
+		return s.defaults
+	}
+	return settings
+}
+
+// Update changes the settings given by their JSON name, null resets one to its default.
+// Invalid values are returned as FieldErrors and nothing is changed.
+func (s *Store) Update(ctx context.Context, changes map[string]json.RawMessage) (Settings, error) {
+	overrides, err := s.overrides(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	errs := FieldErrors{}
+	next := make(map[string]json.RawMessage, len(overrides)+len(changes))
+	for name, value := range overrides {
+		next[name] = value
+	}
+	for name, value := range changes {
+		if !slices.Contains(Names, name) {
+			errs[name] = "is not a setting"
+		} else if string(value) == "null" {
+			delete(next, name)
+		} else {
+			next[name] = value
+		}
+	}
+	settings, err := s.apply(next)
+	var invalid FieldErrors
+	if errors.As(err, &invalid) {
+		for name, msg := range invalid {
+			errs[name] = msg
+		}
+	} else if err != nil {
+		return Settings{}, err
+	}
+	if len(errs) > 0 {
+		return Settings{}, errs
+	}
+	// Saved in the normalized form, like a trimmed URL
+	normalized, err := json.Marshal(settings)
+	if err != nil {
+		return Settings{}, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(normalized, &all); err != nil {
+		return Settings{}, err
+	}
+	for name := range next {
+		next[name] = all[name]
+	}
+
+	value, err := json.Marshal(next)
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to encode settings: %w", err)
+	}
+	if err := s.settings.Set(ctx, repo.SettingRuntime, string(value)); err != nil {
+		return Settings{}, err
+	}
+
+	s.mu.Lock()
+	s.cached, s.cachedAt = next, time.Now()
+	s.mu.Unlock()
+	return settings, nil
+}
+
+// apply returns the defaults with the overrides on top, validated.
+func (s *Store) apply(overrides map[string]json.RawMessage) (Settings, error) {
+	settings := s.defaults
+	errs := FieldErrors{}
+	for name, value := range overrides {
+		// Each one on its own, so a bad value is reported under its name
+		raw, _ := json.Marshal(map[string]json.RawMessage{name: value})
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			errs[name] = "has the wrong type"
+		}
+	}
+	// A value of the wrong type leaves the default, which is valid, so it isn't reported twice
+	var invalid FieldErrors
+	if errors.As(settings.Validate(), &invalid) {
+		for name, msg := range invalid {
+			errs[name] = msg
+		}
+	}
+	if len(errs) > 0 {
+		return Settings{}, errs
+	}
+	return settings, nil
+}
+
+// overrides returns the saved settings by their JSON name.
+func (s *Store) overrides(ctx context.Context) (map[string]json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && time.Since(s.cachedAt) < cacheTTL {
+		return s.cached, nil
+	}
+
+	value, err := s.settings.Get(ctx, repo.SettingRuntime)
+	if errors.Is(err, internal.ErrSettingNotFound) {
+		value = "{}"
+	} else if err != nil {
+		return nil, err
+	}
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode settings: %w", err)
+	}
+
+	s.cached, s.cachedAt = overrides, time.Now()
+	return overrides, nil
+}
@@ -0,0 +1,61 @@
+// Package slack verifies the requests Slack sends to the slash commands of an app.
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Slack-Signature"
+	HeaderTimestamp = "X-Slack-Request-Timestamp"
+	// signatureVersion prefixes the signed string and the signature, it's the only one Slack uses
+	signatureVersion = "v0"
+	// MaxAge bounds how old a request can be, so a captured one can't be replayed later on
+	MaxAge = 5 * time.Minute
+)
+
+var (
+	ErrMissingSignature = errors.New("missing slack signature")
+	ErrInvalidSignature = errors.New("invalid slack signature")
+	ErrStaleRequest     = errors.New("slack request is too old or from the future")
+)
+
+// Verify checks that body was signed with the signing secret of the app within MaxAge of now.
+func Verify(secret string, header http.Header, body []byte, now time.Time) error {
+	signature, timestamp := header.Get(HeaderSignature), header.Get(HeaderTimestamp)
+	if signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > MaxAge || age < -MaxAge {
+		return ErrStaleRequest
+	}
+
+	want, err := hex.DecodeString(strings.TrimPrefix(signature, signatureVersion+"="))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(sign(secret, timestamp, body), want) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// sign is the HMAC of the string Slack signs, the version, timestamp and body of a request.
+func sign(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
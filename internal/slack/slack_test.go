@@ -0,0 +1,75 @@
+package slack
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The request Slack documents signing with, recorded in testdata
+const (
+	recordedSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	recordedTimestamp = "1531420618"
+	recordedSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+)
+
+var recordedAt = time.Unix(1531420618, 0)
+
+func TestVerify(t *testing.T) {
+	body, err := os.ReadFile("testdata/webhook-collect.form")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		now       time.Time
+		want      error
+	}{
+		{name: "recorded", now: recordedAt},
+		{name: "delivered late", now: recordedAt.Add(MaxAge)},
+		{name: "clock behind", now: recordedAt.Add(-MaxAge)},
+		{name: "replayed", now: recordedAt.Add(MaxAge + time.Second), want: ErrStaleRequest},
+		{name: "from the future", now: recordedAt.Add(-MaxAge - time.Second), want: ErrStaleRequest},
+		{name: "other secret", secret: "0000000000000000", now: recordedAt, want: ErrInvalidSignature},
+		{name: "tampered body", body: []byte(strings.Replace(string(body), "roadrunner", "coyote", 1)), now: recordedAt, want: ErrInvalidSignature},
+		{name: "tampered timestamp", timestamp: "1531420619", now: recordedAt, want: ErrInvalidSignature},
+		{name: "invalid timestamp", timestamp: "yesterday", now: recordedAt, want: ErrInvalidSignature},
+		{name: "not hex", signature: "v0=signed", now: recordedAt, want: ErrInvalidSignature},
+		{name: "no signature", signature: "-", now: recordedAt, want: ErrMissingSignature},
+		{name: "no timestamp", timestamp: "-", now: recordedAt, want: ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(HeaderSignature, or(tt.signature, recordedSignature))
+			header.Set(HeaderTimestamp, or(tt.timestamp, recordedTimestamp))
+			if tt.body == nil {
+				tt.body = body
+			}
+
+			err := Verify(or(tt.secret, recordedSecret), header, tt.body, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// or is value unless it's empty, and empty for "-".
+func or(value, fallback string) string {
+	switch value {
+	case "":
+		return fallback
+	case "-":
+		return ""
+	}
+	return value
+}
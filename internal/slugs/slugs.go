@@ -0,0 +1,191 @@
+// Package slugs generates the slugs of links created without one.
+package slugs
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/abdusco/linked/internal/metrics"
+)
+
+// Charset is the characters generated slugs are made of.
+type Charset string
+
+const (
+	// CharsetSafe leaves out characters that are easily mistaken for one another when read
+	// off print, 0 and o, 1 and l and i
+	CharsetSafe Charset = "abcdefghjkmnpqrstuvwxyz23456789"
+	CharsetFull Charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// ParseCharset parses the name of a charset, safe or full.
+func ParseCharset(s string) (Charset, error) {
+	switch strings.ToLower(s) {
+	case "safe":
+		return CharsetSafe, nil
+	case "full":
+		return CharsetFull, nil
+	}
+	return "", fmt.Errorf("invalid slug charset %q, must be one of safe, full", s)
+}
+
+const (
+	// length is the length of generated slugs, check character included
+	length = 6
+	// batchSize is how many slugs are generated and checked against the store at once
+	batchSize = 32
+)
+
+// Store tells which slugs are in use, by links or otherwise.
+type Store interface {
+	TakenSlugs(ctx context.Context, slugs []string) ([]string, error)
+}
+
+// Generator generates random slugs. With checksums the last character is a check character of
+// the others, so a mistyped slug can be told apart from one that was never generated.
+//
+// Slugs are generated in batches, of which the store is asked which are taken in one go. The free
+// ones are handed out one at a time, each only once, so concurrent callers never get the same slug.
+// One may still be taken in the meantime, by a custom slug or another replica, so callers retry
+// with the next slug when the insert conflicts.
+type Generator struct {
+	charset  Charset
+	checksum bool
+	store    Store
+
+	mu sync.Mutex
+	// free are generated slugs that weren't taken when they were checked
+	free []string
+}
+
+func NewGenerator(charset Charset, checksum bool, store Store) *Generator {
+	return &Generator{charset: charset, checksum: checksum, store: store}
+}
+
+// Next returns a slug that wasn't taken when it was checked, and wasn't handed out before.
+func (g *Generator) Next(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.free) == 0 {
+		if err := g.refill(ctx); err != nil {
+			return "", err
+		}
+	}
+	slug := g.free[len(g.free)-1]
+	g.free = g.free[:len(g.free)-1]
+	return slug, nil
+}
+
+func (g *Generator) refill(ctx context.Context) error {
+	candidates := make([]string, 0, batchSize)
+	for len(candidates) < batchSize {
+		if slug := g.generate(); !slices.Contains(candidates, slug) {
+			candidates = append(candidates, slug)
+		}
+	}
+
+	taken, err := g.store.TakenSlugs(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	metrics.SlugBatches.Add(1)
+	metrics.SlugCandidatesTaken.Add(int64(len(taken)))
+	for _, slug := range candidates {
+		if !slices.Contains(taken, slug) {
+			g.free = append(g.free, slug)
+		}
+	}
+	return nil
+}
+
+func (g *Generator) generate() string {
+	n := length
+	if g.checksum {
+		n--
+	}
+	slug := make([]byte, 0, length)
+	// Bytes at or above limit are skipped, so that every character is as likely
+	limit := 256 - 256%len(g.charset)
+	var random [2 * length]byte
+	for len(slug) < n {
+		rand.Read(random[:])
+		for _, b := range random {
+			if int(b) < limit && len(slug) < n {
+				slug = append(slug, g.charset[int(b)%len(g.charset)])
+			}
+		}
+	}
+	if g.checksum {
+		slug = append(slug, g.checkChar(slug))
+	}
+	return string(slug)
+}
+
+// NearMisses returns the slugs the generator could have generated that are one typo away from slug:
+// a character replaced, left out, added, or swapped with the next one. A slug with a valid check
+// character isn't mistyped, it has none, as do all slugs without checksums.
+// There is at most one replacement per position that makes a valid slug, so there are few of them.
+func (g *Generator) NearMisses(slug string) []string {
+	slug = strings.ToLower(slug)
+	if !g.checksum || g.valid(slug) || len(slug) < length-1 || len(slug) > length+1 {
+		return nil
+	}
+
+	var candidates []string
+	for i := range len(slug) + 1 {
+		for _, c := range []byte(g.charset) {
+			if i < len(slug) {
+				candidates = append(candidates, slug[:i]+string(c)+slug[i+1:])
+			}
+			candidates = append(candidates, slug[:i]+string(c)+slug[i:])
+		}
+		if i < len(slug) {
+			candidates = append(candidates, slug[:i]+slug[i+1:])
+		}
+		if i+1 < len(slug) {
+			candidates = append(candidates, slug[:i]+string(slug[i+1])+string(slug[i])+slug[i+2:])
+		}
+	}
+
+	seen := make(map[string]bool)
+	var misses []string
+	for _, candidate := range candidates {
+		if candidate != slug && !seen[candidate] && g.valid(candidate) {
+			seen[candidate] = true
+			misses = append(misses, candidate)
+		}
+	}
+	return misses
+}
+
+// valid reports whether slug could have been generated, its last character checking the others.
+func (g *Generator) valid(slug string) bool {
+	if len(slug) != length {
+		return false
+	}
+	for i := range len(slug) {
+		if strings.IndexByte(string(g.charset), slug[i]) < 0 {
+			return false
+		}
+	}
+	return g.checkChar([]byte(slug[:length-1])) == slug[length-1]
+}
+
+// checkChar is the Luhn mod N check character of payload, which catches any single
+// replaced character and most swapped neighbours.
+func (g *Generator) checkChar(payload []byte) byte {
+	n := len(g.charset)
+	factor, sum := 2, 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(string(g.charset), payload[i])
+		addend = addend/n + addend%n
+		sum += addend
+		factor = 3 - factor
+	}
+	return g.charset[(n-sum%n)%n]
+}
@@ -0,0 +1,81 @@
+package slugs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore keeps the slugs handed out, and says slugs starting with reserved are taken too.
+type memoryStore struct {
+	reserved string
+
+	mu    sync.Mutex
+	taken map[string]bool
+}
+
+func (s *memoryStore) TakenSlugs(_ context.Context, slugs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var taken []string
+	for _, slug := range slugs {
+		if s.taken[slug] || strings.HasPrefix(slug, s.reserved) {
+			taken = append(taken, slug)
+		}
+	}
+	return taken, nil
+}
+
+func (s *memoryStore) take(slug string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.taken[slug] {
+		return false
+	}
+	s.taken[slug] = true
+	return true
+}
+
+func TestNextUnique(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "checksum"}[checksum], func(t *testing.T) {
+			store := &memoryStore{reserved: "a", taken: make(map[string]bool)}
+			g := NewGenerator(CharsetSafe, checksum, store)
+
+			// Callers take slugs at once, like a bulk import next to the API
+			const callers, perCaller = 20, 500
+			var wg sync.WaitGroup
+			for range callers {
+				wg.Go(func() {
+					for range perCaller {
+						slug, err := g.Next(context.Background())
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if !store.take(slug) {
+							t.Errorf("%s was handed out twice", slug)
+						}
+						if strings.HasPrefix(slug, store.reserved) {
+							t.Errorf("%s was taken when it was checked", slug)
+						}
+						if len(slug) != length || strings.Trim(slug, string(CharsetSafe)) != "" {
+							t.Errorf("%s isn't %d characters of the charset", slug, length)
+						}
+						if checksum && !g.valid(slug) {
+							t.Errorf("%s has the wrong check character", slug)
+						}
+					}
+				})
+			}
+			wg.Wait()
+
+			if n := len(store.taken); n != callers*perCaller {
+				t.Errorf("got %d different slugs, want %d", n, callers*perCaller)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/safehttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	fetchTimeout = 15 * time.Second
+	maxRedirects = 5
+	// maxBodySize caps how much HTML of a single snapshot is kept, before compression.
+	maxBodySize = 2 << 20
+)
+
+// Snapshotter fetches link destinations and stores a copy for later forensics.
+type Snapshotter struct {
+	repo   *repo.SnapshotsRepo
+	client *http.Client
+	limits repo.SnapshotLimits
+}
+
+func NewSnapshotter(snapshotsRepo *repo.SnapshotsRepo, limits repo.SnapshotLimits) *Snapshotter {
+	return &Snapshotter{
+		repo:   snapshotsRepo,
+		client: safehttp.NewClient(fetchTimeout, maxRedirects),
+		limits: limits,
+	}
+}
+
+// TakeAsync snapshots the link in the background so callers aren't held up by slow destinations.
+func (s *Snapshotter) TakeAsync(link *internal.Link) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*fetchTimeout)
+		defer cancel()
+
+		if _, err := s.Take(ctx, link); err != nil {
+			log.Error().Err(err).Int64("link_id", link.ID).Msg("failed to take snapshot")
+		}
+	}()
+}
+
+// Take fetches the link destination and stores the result. Fetch failures are
+// stored as part of the snapshot, only storage failures are returned as errors.
+func (s *Snapshotter) Take(ctx context.Context, link *internal.Link) (*internal.Snapshot, error) {
+	snap := s.fetch(ctx, link)
+	if err := s.repo.Create(ctx, snap, s.limits); err != nil {
+		return nil, err
+	}
+
+	log.Debug().
+		Int64("link_id", link.ID).
+		Int("status", snap.StatusCode).
+		Int("size", len(snap.Body)).
+		Msg("snapshot taken")
+	return snap, nil
+}
+
+func (s *Snapshotter) fetch(ctx context.Context, link *internal.Link) *internal.Snapshot {
+	snap := &internal.Snapshot{
+		LinkID:   link.ID,
+		TakenAt:  time.Now().UTC(),
+		FinalURL: link.URL,
+		Headers:  map[string]string{},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.URL, nil)
+	if err != nil {
+		snap.Error = err.Error()
+		return snap
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		snap.Error = err.Error()
+		return snap
+	}
+	defer resp.Body.Close()
+
+	snap.FinalURL = resp.Request.URL.String()
+	snap.StatusCode = resp.StatusCode
+	snap.ContentType = resp.Header.Get("Content-Type")
+	for name := range resp.Header {
+		snap.Headers[name] = resp.Header.Get(name)
+	}
+
+	// Only keep headers of binary and other non-HTML content
+	if !isHTML(snap.ContentType) {
+		return snap
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		snap.Error = fmt.Sprintf("failed to read body: %v", err)
+		return snap
+	}
+	if len(body) > maxBodySize {
+		body = body[:maxBodySize]
+		snap.Truncated = true
+	}
+
+	snap.Body, err = compress(body)
+	if err != nil {
+		snap.Error = fmt.Sprintf("failed to compress body: %v", err)
+	}
+	return snap
+}
+
+// Decompress returns the HTML stored in a snapshot body.
+func Decompress(body []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
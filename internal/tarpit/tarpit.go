@@ -0,0 +1,84 @@
+package tarpit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdusco/linked/internal/cache"
+	"github.com/abdusco/linked/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	window = time.Minute
+	// maxTrackedClients bounds memory use, the counters are reset when it fills up
+	maxTrackedClients = 10_000
+)
+
+// Guard slows down clients that look up many missing slugs, which is what scanning
+// the slug space looks like. Only responses for missing slugs are delayed, so
+// visitors of existing links are never held up.
+type Guard struct {
+	budget int64
+	delay  time.Duration
+
+	mu     sync.Mutex
+	misses *cache.TTL[string, *atomic.Int64]
+}
+
+// NewGuard allows each client budget misses per minute, after which every miss is delayed.
+func NewGuard(budget int, delay time.Duration) *Guard {
+	return &Guard{
+		budget: int64(budget),
+		delay:  delay,
+		misses: cache.NewTTL[string, *atomic.Int64](window, maxTrackedClients),
+	}
+}
+
+// Miss records a lookup of a missing slug by the client and returns how long to hold the response.
+func (g *Guard) Miss(client string) time.Duration {
+	metrics.SlugMisses.Add(1)
+
+	n := g.counter(client).Add(1)
+	if n <= g.budget {
+		return 0
+	}
+	if n == g.budget+1 {
+		metrics.ScanSuspects.Add(1)
+		log.Warn().
+			Str("ip", client).
+			Int64("misses", n).
+			Dur("window", window).
+			Msg("client is looking up many missing slugs, delaying its not found responses")
+	}
+	metrics.TarpittedRequests.Add(1)
+	return g.delay
+}
+
+// counter returns the misses of the client in the current window.
+func (g *Guard) counter(client string) *atomic.Int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.misses.Get(client)
+	if !ok {
+		c = &atomic.Int64{}
+		g.misses.Set(client, c)
+	}
+	return c
+}
+
+// Wait blocks for d or until ctx is done.
+func Wait(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,32 @@
+package testutil
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/abdusco/linked/internal/db"
+)
+
+// Backend opens a database of its own with the full schema for a test, closed when the test ends.
+type Backend struct {
+	Name string
+	Open func(t testing.TB) *sql.DB
+}
+
+// backends are the databases ForEachBackend runs tests against. Postgres is added by the postgres
+// build tag, see postgres.go.
+var backends = []Backend{
+	{Name: db.DriverSQLite, Open: NewDB},
+}
+
+// ForEachBackend runs fn as a subtest against a database of each backend, for tests of queries
+// that differ between sqlite and postgres.
+func ForEachBackend(t *testing.T, fn func(t *testing.T, sqlDB *sql.DB)) {
+	t.Helper()
+
+	for _, backend := range backends {
+		t.Run(backend.Name, func(t *testing.T) {
+			fn(t, backend.Open(t))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Epoch is where a Clock starts, a fixed time so stamped dates can be compared with golden values.
+var Epoch = time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+
+// Clock is a clock that only moves when told to, its Now can replace the one of a repo.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock() *Clock {
+	return &Clock{now: Epoch}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, or back when d is negative.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t.UTC()
+}
@@ -0,0 +1,90 @@
+// Package testutil sets up the database for tests of the repos and handlers: an in-memory sqlite
+// database with every migration applied, and fixtures stamped by a clock the test controls.
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/abdusco/linked/internal/db"
+	_ "modernc.org/sqlite"
+)
+
+// dbCount keeps the in-memory databases of tests running in parallel apart
+var dbCount atomic.Int64
+
+// NewDB opens an in-memory sqlite database of its own with the full schema, closed when the test ends.
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	sqlDB := openDB(t)
+	if err := db.Migrate(context.Background(), sqlDB); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return sqlDB
+}
+
+// NewDBAt is NewDB with the schema of version, for tests of migrating the data of an older one.
+func NewDBAt(t testing.TB, version int) *sql.DB {
+	t.Helper()
+
+	sqlDB := openDB(t)
+	if err := db.MigrateTo(context.Background(), sqlDB, version); err != nil {
+		t.Fatalf("failed to migrate database to %d: %v", version, err)
+	}
+	return sqlDB
+}
+
+// NewFileDB opens a sqlite database file of its own with the full schema and the given journal mode, closed
+// when the test ends. Unlike NewDB's, its connections don't share a cache, so a connection of another pool
+// opened with the returned DSN contends for its locks like another process would. Statements give up
+// waiting for a lock after a few milliseconds.
+func NewFileDB(t testing.TB, journalMode string) (*sql.DB, string) {
+	t.Helper()
+
+	params := url.Values{}
+	params.Set("_time_format", "sqlite")
+	params.Set("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", fmt.Sprintf("journal_mode(%s)", journalMode))
+	params.Add("_pragma", "busy_timeout(20)")
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?" + params.Encode()
+
+	sqlDB, err := sql.Open(db.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.Migrate(context.Background(), sqlDB); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return sqlDB, dsn
+}
+
+// openDB opens an in-memory sqlite database of its own without any schema, closed when the test ends.
+func openDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	params := url.Values{}
+	params.Set("mode", "memory")
+	// Connections of the pool share the database, a private one would be empty for all but the first
+	params.Set("cache", "shared")
+	params.Set("_time_format", "sqlite")
+	params.Set("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	dsn := fmt.Sprintf("file:test-%d?%s", dbCount.Add(1), params.Encode())
+
+	sqlDB, err := sql.Open(db.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// The database is gone once its last connection closes, an idle one keeps it around
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxIdleTime(0)
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/repo"
+)
+
+// Fixtures creates links and clicks in a database of NewDB or ForEachBackend, with repos running on Clock.
+type Fixtures struct {
+	DB     *sql.DB
+	Clock  *Clock
+	Links  *repo.LinksRepo
+	Clicks *repo.ClicksRepo
+
+	linkCount int
+}
+
+// NewFixtures sets up fixtures in a database of NewDB.
+func NewFixtures(t testing.TB) *Fixtures {
+	t.Helper()
+	return NewFixturesIn(NewDB(t))
+}
+
+// NewFixturesIn sets up fixtures in sqlDB, which must be migrated already.
+func NewFixturesIn(sqlDB *sql.DB) *Fixtures {
+	clock := NewClock()
+	links := repo.NewLinksRepo(sqlDB, false)
+	links.Now = clock.Now
+	clicks := repo.NewClicksRepo(sqlDB)
+	clicks.Now = clock.Now
+
+	return &Fixtures{DB: sqlDB, Clock: clock, Links: links, Clicks: clicks}
+}
+
+// Link creates a link made now by the clock, with a slug and URL of its own unless set by opts.
+func (f *Fixtures) Link(t testing.TB, opts ...func(*repo.NewLink)) *internal.Link {
+	t.Helper()
+
+	f.linkCount++
+	params := repo.NewLink{
+		Slug: fmt.Sprintf("link-%d", f.linkCount),
+		URL:  fmt.Sprintf("https://example.com/%d", f.linkCount),
+	}
+	for _, opt := range opts {
+		opt(&params)
+	}
+
+	link, err := f.Links.Create(context.Background(), params)
+	if err != nil {
+		t.Fatalf("failed to create link %q: %v", params.Slug, err)
+	}
+	return link
+}
+
+// Click records a GET of link made now by the clock, unless changed by opts.
+func (f *Fixtures) Click(t testing.TB, link *internal.Link, opts ...func(*repo.NewClick)) {
+	t.Helper()
+
+	click := repo.NewClick{
+		LinkID:    link.ID,
+		ClickedAt: f.Clock.Now(),
+		UserAgent: "Mozilla/5.0",
+		IPAddress: "192.0.2.1",
+		Method:    http.MethodGet,
+	}
+	for _, opt := range opts {
+		opt(&click)
+	}
+
+	if err := f.Clicks.Create(context.Background(), click); err != nil {
+		t.Fatalf("failed to record click on link %q: %v", link.Slug, err)
+	}
+}
@@ -0,0 +1,127 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/destpolicy"
+	"github.com/abdusco/linked/internal/handler"
+	"github.com/abdusco/linked/internal/secheaders"
+	"github.com/abdusco/linked/internal/server"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/abdusco/linked/internal/slugs"
+)
+
+// ServerConfig is the configuration main reads from an empty environment, with admin:admin as credentials.
+func ServerConfig(t testing.TB) server.Config {
+	t.Helper()
+
+	charset, err := slugs.ParseCharset("safe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := destpolicy.New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server.Config{
+		DBDriver:           db.DriverSQLite,
+		RobotsPolicy:       "disallow",
+		AdminCreds:         "admin:admin",
+		JWTSecret:          "test-secret",
+		CookieSecure:       auth.SecureAuto,
+		CookieSameSite:     http.SameSiteLaxMode,
+		SecurityHeaders:    secheaders.Config{Policy: secheaders.DefaultPolicy},
+		ScanBudget:         30,
+		ScanTarpitDelay:    time.Second,
+		LoginLockout:       auth.Lockout{MaxFailures: 10, Window: 15 * time.Minute},
+		SlugCharset:        charset,
+		SlugMinLength:      5,
+		SlugMaxLength:      64,
+		AllowedURLSchemes:  []string{"http", "https"},
+		RequestTimeout:     15 * time.Second,
+		Settings:           settings.Settings{RedirectStatus: http.StatusPermanentRedirect},
+		SelfRedirectPolicy: handler.SelfRedirectReject,
+		UTMCheckPolicy:     handler.UTMCheckWarn,
+		SlugNormalization:  handler.SlugNormalizationCanonical,
+		DestinationPolicy:  policy,
+	}
+}
+
+// NewServer boots the whole application with cfg against sqlDB, which must be migrated already.
+// The server and its background work stop when the test ends.
+func NewServer(t testing.TB, sqlDB *sql.DB, cfg server.Config, opts ...server.Option) *httptest.Server {
+	t.Helper()
+
+	srv, err := server.New(context.Background(), cfg, sqlDB, opts...)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return ts
+}
+
+// NewClient keeps cookies, like the one of logging in, and returns redirects instead of following them.
+func NewClient(t testing.TB) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// LogIn logs client in to the server at baseURL as admin:admin.
+func LogIn(t testing.TB, client *http.Client, baseURL string) {
+	t.Helper()
+
+	res := PostJSON(t, client, baseURL+"/login", auth.Credentials{Username: "admin", Password: "admin"})
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("login: got %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+}
+
+// PostJSON posts body as JSON to url.
+func PostJSON(t testing.TB, client *http.Client, url string, body any) *http.Response {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	return res
+}
+
+// DecodeJSON decodes the body of res into v and closes it.
+func DecodeJSON(t testing.TB, res *http.Response, v any) {
+	t.Helper()
+
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response of %s: %v", res.Request.URL, err)
+	}
+}
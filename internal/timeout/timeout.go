@@ -0,0 +1,78 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// NoDeadline exempts a route from the request deadline, for streaming responses
+// that are expected to run for as long as the client keeps reading.
+const NoDeadline time.Duration = -1
+
+type Config struct {
+	// Default applies to every route without an override
+	Default time.Duration
+	// Overrides are keyed by method and route path, e.g. "GET /:slug"
+	Overrides map[string]time.Duration
+}
+
+type phaseKey struct{}
+
+// Middleware bounds every request with a context deadline. Handlers that pass the request
+// context down to the database or outgoing requests are cut off once it expires and the
+// client gets a 503 instead of waiting indefinitely.
+//
+// It must be registered with Echo#Use rather than Echo#Pre, so the route is already matched.
+func Middleware(cfg Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Request().Method + " " + c.Path()
+			budget, ok := cfg.Overrides[route]
+			if !ok {
+				budget = cfg.Default
+			}
+			if budget == NoDeadline {
+				return next(c)
+			}
+
+			phase := &atomic.Value{}
+			phase.Store("handler")
+			ctx := context.WithValue(c.Request().Context(), phaseKey{}, phase)
+			ctx, cancel := context.WithTimeout(ctx, budget)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			start := time.Now()
+			err := next(c)
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return err
+			}
+
+			logger.FromContext(ctx).Warn().
+				Err(err).
+				Str("route", route).
+				Str("phase", phase.Load().(string)).
+				Dur("budget", budget).
+				Dur("elapsed", time.Since(start)).
+				Msg("request deadline exceeded")
+
+			if c.Response().Committed {
+				return nil
+			}
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "request timed out")
+		}
+	}
+}
+
+// SetPhase records what the request is doing, so an exceeded deadline can be attributed in the logs.
+func SetPhase(ctx context.Context, name string) {
+	if phase, ok := ctx.Value(phaseKey{}).(*atomic.Value); ok {
+		phase.Store(name)
+	}
+}
@@ -0,0 +1,168 @@
+package timeout_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/timeout"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+)
+
+const budget = 50 * time.Millisecond
+
+// newTestServer returns a server with the middleware on the routes used by the tests,
+// and the buffer its log goes to.
+func newTestServer(t *testing.T) (*echo.Echo, *bytes.Buffer) {
+	t.Helper()
+
+	var logs bytes.Buffer
+	e := echo.New()
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			l := zerolog.New(&logs)
+			c.SetRequest(c.Request().WithContext(l.WithContext(c.Request().Context())))
+			return next(c)
+		}
+	})
+	e.Use(timeout.Middleware(timeout.Config{
+		Default: budget,
+		Overrides: map[string]time.Duration{
+			"GET /stream":    timeout.NoDeadline,
+			"GET /generous":  10 * budget,
+			"POST /generous": timeout.NoDeadline,
+		},
+	}))
+
+	// Waits on the database until the deadline cuts it off
+	e.GET("/slow", func(c echo.Context) error {
+		ctx := c.Request().Context()
+		timeout.SetPhase(ctx, "database")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	// Ignores the deadline, and answers anyway once it's past
+	e.GET("/late", func(c echo.Context) error {
+		time.Sleep(2 * budget)
+		return c.String(http.StatusOK, "late")
+	})
+	e.GET("/fast", func(c echo.Context) error {
+		return c.String(http.StatusOK, "fast")
+	})
+	// Answers once it has seen twice the default budget, unless its deadline cuts it off
+	busy := func(c echo.Context) error {
+		ctx := c.Request().Context()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * budget):
+		}
+		return c.String(http.StatusOK, "done")
+	}
+	e.GET("/stream", func(c echo.Context) error {
+		if _, ok := c.Request().Context().Deadline(); ok {
+			return c.String(http.StatusInternalServerError, "stream has a deadline")
+		}
+		return busy(c)
+	})
+	e.GET("/generous", busy)
+	e.POST("/generous", busy)
+	e.PUT("/generous", busy)
+	return e, &logs
+}
+
+// serve sends a request to e and returns the response.
+func serve(e *echo.Echo, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+// logLines parses the lines logged to logs.
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	dec := json.NewDecoder(logs)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	e, logs := newTestServer(t)
+
+	start := time.Now()
+	rec := serve(e, http.MethodGet, "/slow")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if took := time.Since(start); took > 10*budget {
+		t.Errorf("took %s, want about the budget of %s", took, budget)
+	}
+
+	lines := logLines(t, logs)
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1: %v", len(lines), lines)
+	}
+	line := lines[0]
+	if line["message"] != "request deadline exceeded" || line["level"] != "warn" {
+		t.Errorf("got log line %v", line)
+	}
+	if line["route"] != "GET /slow" || line["phase"] != "database" {
+		t.Errorf("got route %v in phase %v, want GET /slow in database", line["route"], line["phase"])
+	}
+	if line["budget"] != float64(budget.Milliseconds()) {
+		t.Errorf("got budget %v, want %d", line["budget"], budget.Milliseconds())
+	}
+}
+
+func TestDeadlineExceededAfterResponse(t *testing.T) {
+	e, logs := newTestServer(t)
+
+	// What was sent already stays, it's only logged
+	rec := serve(e, http.MethodGet, "/late")
+	if rec.Code != http.StatusOK || rec.Body.String() != "late" {
+		t.Errorf("got %d with %q, want %d with the response", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	lines := logLines(t, logs)
+	if len(lines) != 1 || lines[0]["route"] != "GET /late" || lines[0]["phase"] != "handler" {
+		t.Errorf("got log lines %v, want one of GET /late in the handler", lines)
+	}
+}
+
+func TestWithinDeadline(t *testing.T) {
+	e, logs := newTestServer(t)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/fast", http.StatusOK},
+		// Streaming routes are exempt, and see no deadline at all
+		{http.MethodGet, "/stream", http.StatusOK},
+		{http.MethodGet, "/generous", http.StatusOK},
+		{http.MethodPost, "/generous", http.StatusOK},
+		// Overrides are by method, others get the default
+		{http.MethodPut, "/generous", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if rec := serve(e, tt.method, tt.path); rec.Code != tt.want {
+			t.Errorf("%s %s: got %d with %q, want %d", tt.method, tt.path, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+
+	lines := logLines(t, logs)
+	if len(lines) != 1 || lines[0]["route"] != "PUT /generous" {
+		t.Errorf("got log lines %v, want one of PUT /generous", lines)
+	}
+}
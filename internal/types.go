@@ -1,16 +1,303 @@
 package internal
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Link struct {
-	ID        int64      `json:"id"`
-	Slug      string     `json:"slug"`
-	URL       string     `json:"url"`
-	CreatedAt time.Time  `json:"created_at"`
-	Stats     *LinkStats `json:"stats,omitempty"`
+	ID int64 `json:"id"`
+	// Domain is the domain the slug belongs to, empty when it works on every domain of the instance
+	Domain string `json:"domain,omitempty"`
+	Slug   string `json:"slug"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	// Description is a note on why the link exists, it's never shown to visitors
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	StatsToken  string     `json:"stats_token,omitempty"`
+	Snapshot    bool       `json:"snapshot"`
+	Preview     bool       `json:"preview"`
+	Listed      bool       `json:"listed"`
+	Tags        []string   `json:"tags"`
+	Stats       *LinkStats `json:"stats,omitempty"`
+	// OpenGraph is shown to bots unfurling the short link, instead of the preview of the destination
+	OpenGraph OpenGraph `json:"open_graph"`
+	// ActivatesAt and ExpiresAt bound when the link redirects, nil means no bound
+	ActivatesAt *time.Time `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// OwnerID is the user who created the link, nil for links made before users or by a deleted user
+	OwnerID *int64 `json:"owner_id"`
+	// Rules send some visitors elsewhere than URL, the first matching one wins
+	Rules []DestinationRule `json:"rules"`
+	// CampaignID is the campaign the link belongs to, if any
+	CampaignID *int64 `json:"campaign_id"`
+	// Health is the outcome of the last check of the destination, nil until it's checked
+	Health *LinkHealth `json:"health,omitempty"`
+}
+
+// DestinationRule sends the visitors it matches to its own URL. A rule has at least one
+// condition, and matches when all of them do.
+type DestinationRule struct {
+	// Device is ios, android or desktop, as told by the user agent
+	Device string `json:"device,omitempty"`
+	// Language is matched against the preferred language of the visitor, en matches en-GB too
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+// Matches reports whether a visitor with device and preferred language gets the URL of the rule.
+// Both are lowercase, and empty when unknown.
+func (r DestinationRule) Matches(device, language string) bool {
+	if r.Device != "" && r.Device != device {
+		return false
+	}
+	if r.Language != "" && language != r.Language && !strings.HasPrefix(language, r.Language+"-") {
+		return false
+	}
+	return r.Device != "" || r.Language != ""
+}
+
+// Destination returns the URL of the first rule matching a visitor, or the URL of the link when none does.
+func (l *Link) Destination(device, language string) string {
+	for _, rule := range l.Rules {
+		if rule.Matches(device, language) {
+			return rule.URL
+		}
+	}
+	return l.URL
+}
+
+type LinkStatus string
+
+const (
+	LinkScheduled LinkStatus = "scheduled"
+	LinkActive    LinkStatus = "active"
+	LinkExpired   LinkStatus = "expired"
+)
+
+// Status tells whether the link redirects at the given time. It's active from ActivatesAt
+// on and expired from ExpiresAt on.
+func (l *Link) Status(now time.Time) LinkStatus {
+	if l.ExpiresAt != nil && !now.Before(*l.ExpiresAt) {
+		return LinkExpired
+	}
+	if l.ActivatesAt != nil && now.Before(*l.ActivatesAt) {
+		return LinkScheduled
+	}
+	return LinkActive
+}
+
+// CheckResult is the outcome of checking the destination of a link.
+type CheckResult string
+
+const (
+	CheckOK CheckResult = "ok"
+	// CheckHTTPError is a destination answering with a 4xx or 5xx status
+	CheckHTTPError CheckResult = "http_error"
+	CheckTimeout   CheckResult = "timeout"
+	// CheckTLSError is a destination whose certificate or TLS handshake is invalid
+	CheckTLSError         CheckResult = "tls_error"
+	CheckTooManyRedirects CheckResult = "too_many_redirects"
+	// CheckUnreachable is a destination that couldn't be connected to, like one whose host doesn't resolve
+	CheckUnreachable CheckResult = "unreachable"
+)
+
+// LinkHealth is the outcome of the last check of the destination of a link.
+type LinkHealth struct {
+	Result CheckResult `json:"result"`
+	// StatusCode is the status the destination answered with, zero when it didn't answer
+	StatusCode int       `json:"status_code,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Broken reports whether the destination failed its last check.
+func (h *LinkHealth) Broken() bool {
+	return h.Result != CheckOK
+}
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+var Roles = []Role{RoleAdmin, RoleEditor, RoleViewer}
+
+// Includes reports whether r is allowed everything other is. Admins can do anything,
+// editors can change links and viewers can only read.
+func (r Role) Includes(other Role) bool {
+	rank := func(role Role) int {
+		switch role {
+		case RoleAdmin:
+			return 3
+		case RoleEditor:
+			return 2
+		case RoleViewer:
+			return 1
+		}
+		return 0
+	}
+	return rank(r) >= rank(other) && rank(other) > 0
+}
+
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CanModify reports whether the user may change the link, editors only their own ones.
+func (u *User) CanModify(link *Link) bool {
+	switch u.Role {
+	case RoleAdmin:
+		return true
+	case RoleEditor:
+		return link.OwnerID != nil && *link.OwnerID == u.ID
+	}
+	return false
+}
+
+// OpenGraph is the preview metadata of a link, empty fields aren't shown.
+type OpenGraph struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+}
+
+// IsZero reports whether no metadata is set.
+func (og OpenGraph) IsZero() bool {
+	return og == OpenGraph{}
+}
+
+// TagCount is a tag with the number of links that have it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// Campaign groups links whose stats are looked at together.
+type Campaign struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// UTM are the UTM parameters the destinations of its links should have, and their values
+	UTM       map[string]string `json:"utm,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// CampaignStats adds up the clicks of all links of a campaign.
+type CampaignStats struct {
+	Links  int64 `json:"links"`
+	Clicks int64 `json:"clicks"`
+	// UniqueIPs only counts the clicks that weren't purged yet
+	UniqueIPs     int64         `json:"unique_ips"`
+	LastClickedAt *time.Time    `json:"last_clicked_at"`
+	Daily         []DailyClicks `json:"daily"`
 }
 
 type LinkStats struct {
-	Clicks        int64      `json:"clicks"`
+	Clicks int64 `json:"clicks"`
+	// UniqueClicks counts each visitor once a day, clicks recorded before visitors were told apart aren't included
+	UniqueClicks  int64      `json:"unique_clicks"`
 	LastClickedAt *time.Time `json:"last_clicked_at"`
 }
+
+type DailyClicks struct {
+	Date         string `json:"date"`
+	Clicks       int64  `json:"clicks"`
+	UniqueClicks int64  `json:"unique_clicks"`
+}
+
+type ReferrerStats struct {
+	Referrers []ReferrerCount `json:"referrers"`
+	Direct    int64           `json:"direct"`
+}
+
+type ReferrerCount struct {
+	Host   string `json:"host"`
+	Clicks int64  `json:"clicks"`
+}
+
+type CountryStats struct {
+	Countries []CountryCount `json:"countries"`
+	// Unknown counts clicks without a resolved country
+	Unknown int64 `json:"unknown"`
+}
+
+type CountryCount struct {
+	// Country is an ISO 3166-1 alpha-2 code
+	Country string `json:"country"`
+	Clicks  int64  `json:"clicks"`
+}
+
+// Snapshot is a copy of a link destination taken at some point in time.
+// Body holds gzip-compressed HTML and is empty for other content types.
+type Snapshot struct {
+	ID          int64             `json:"id"`
+	LinkID      int64             `json:"link_id"`
+	TakenAt     time.Time         `json:"taken_at"`
+	FinalURL    string            `json:"final_url"`
+	StatusCode  int               `json:"status_code"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	Size        int64             `json:"size"`
+	Truncated   bool              `json:"truncated"`
+	Error       string            `json:"error,omitempty"`
+	Body        []byte            `json:"-"`
+}
+
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+	// EventLoginLockedOut is sent when a client is locked out after too many failed logins
+	EventLoginLockedOut = "login.locked_out"
+)
+
+// WebhookEvents are the events a webhook can subscribe to.
+var WebhookEvents = []string{EventLinkCreated, EventLinkDeleted, EventLinkClicked, EventLoginLockedOut}
+
+// Webhook receives signed POST requests when subscribed events happen.
+// The secret signs payloads and is never exposed after creation.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records a single attempt at delivering an event to a webhook.
+type WebhookDelivery struct {
+	ID          int64     `json:"id"`
+	WebhookID   int64     `json:"webhook_id"`
+	Event       string    `json:"event"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// LoginAttempt is a failed login, with the login form or basic auth.
+type LoginAttempt struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	IPAddress string `json:"ip_address"`
+	// Cleared is set once a login from the same IP succeeded, it no longer counts towards a lockout
+	Cleared     bool      `json:"cleared"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// Upload is a file uploaded by the admin, such as the logo of the instance.
+type Upload struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,100 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/useragent"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36"
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	unknownUA = "curl/8.7.1"
+)
+
+func TestDestination(t *testing.T) {
+	stores := []internal.DestinationRule{
+		{Device: useragent.DeviceIOS, URL: "https://apps.apple.com/app/id1"},
+		{Device: useragent.DeviceAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+	}
+	byLanguage := []internal.DestinationRule{
+		{Device: useragent.DeviceDesktop, Language: "de", URL: "https://example.com/de/desktop"},
+		{Language: "de", URL: "https://example.com/de"},
+		{Language: "pt-br", URL: "https://example.com/pt-br"},
+	}
+
+	tests := []struct {
+		name      string
+		rules     []internal.DestinationRule
+		userAgent string
+		language  string
+		want      string
+	}{
+		{"iPhone", stores, iPhoneUA, "", "https://apps.apple.com/app/id1"},
+		{"Android", stores, androidUA, "", "https://play.google.com/store/apps/details?id=app"},
+		{"desktop", stores, desktopUA, "", "https://example.com/"},
+		{"unknown device", stores, unknownUA, "", "https://example.com/"},
+		{"empty user agent", stores, "", "", "https://example.com/"},
+		{"no rules", nil, iPhoneUA, "de", "https://example.com/"},
+		{"empty rules", []internal.DestinationRule{}, androidUA, "", "https://example.com/"},
+		{"first matching rule wins", byLanguage, desktopUA, "de", "https://example.com/de/desktop"},
+		{"rule with all conditions only", byLanguage, iPhoneUA, "de", "https://example.com/de"},
+		{"language prefix", byLanguage, iPhoneUA, "de-at", "https://example.com/de"},
+		{"region of the rule", byLanguage, unknownUA, "pt-br", "https://example.com/pt-br"},
+		{"other region than the rule", byLanguage, unknownUA, "pt-pt", "https://example.com/"},
+		{"prefix that isn't the language", byLanguage, unknownUA, "dev", "https://example.com/"},
+		{"unknown language", byLanguage, desktopUA, "", "https://example.com/"},
+		// Rules without conditions don't match anyone, validation refuses them
+		{"rule without conditions", []internal.DestinationRule{{URL: "https://example.com/any"}}, desktopUA, "en", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := &internal.Link{URL: "https://example.com/", Rules: tt.rules}
+			if got := link.Destination(useragent.Device(tt.userAgent), tt.language); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkStatus(t *testing.T) {
+	activates := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	expires := time.Date(2024, 3, 31, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		activatesAt *time.Time
+		expiresAt   *time.Time
+		now         time.Time
+		want        internal.LinkStatus
+	}{
+		{"no bounds", nil, nil, activates, internal.LinkActive},
+		{"before activation", &activates, &expires, activates.Add(-time.Nanosecond), internal.LinkScheduled},
+		// Both bounds are inclusive of the moment itself
+		{"at activation", &activates, &expires, activates, internal.LinkActive},
+		{"between", &activates, &expires, activates.Add(time.Hour), internal.LinkActive},
+		{"just before expiry", &activates, &expires, expires.Add(-time.Nanosecond), internal.LinkActive},
+		{"at expiry", &activates, &expires, expires, internal.LinkExpired},
+		{"after expiry", &activates, &expires, expires.Add(time.Hour), internal.LinkExpired},
+		{"only activation, before", &activates, nil, activates.Add(-time.Second), internal.LinkScheduled},
+		{"only activation, after", &activates, nil, expires.AddDate(10, 0, 0), internal.LinkActive},
+		{"only expiry, long before", nil, &expires, time.Time{}, internal.LinkActive},
+		{"only expiry, at", nil, &expires, expires, internal.LinkExpired},
+		// A link that expires before it activates never redirects, expiry wins
+		{"expiry before activation", &expires, &activates, activates, internal.LinkExpired},
+		{"same activation and expiry", &activates, &activates, activates, internal.LinkExpired},
+		// Bounds are instants, the zone they're written in doesn't matter
+		{"other zone at activation", &activates, &expires, activates.In(time.FixedZone("", -5*60*60)), internal.LinkActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := &internal.Link{ActivatesAt: tt.activatesAt, ExpiresAt: tt.expiresAt}
+			if got := link.Status(tt.now); got != tt.want {
+				t.Errorf("Status(%v) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}
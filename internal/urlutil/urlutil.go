@@ -0,0 +1,69 @@
+package urlutil
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrNotAbsolute = errors.New("url must be absolute, with a scheme and a host")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize returns the form of rawURL used to tell whether two links point at the same destination.
+// The scheme and host are lowercased and a default port is dropped. Everything after the host,
+// the path, query and fragment, is kept byte for byte since servers are free to treat it case-sensitively.
+//
+//	HTTPS://Example.COM:443/A?b=C -> https://example.com/A?b=C
+func Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", ErrNotAbsolute
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && port == defaultPorts[scheme] {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+
+	// Slice the original string rather than re-encoding u, which could alter escaping in the path
+	rest := rawURL[len(u.Scheme)+len("://"):]
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[i:]
+	} else {
+		rest = ""
+	}
+
+	authority := host
+	if u.User != nil {
+		authority = u.User.String() + "@" + host
+	}
+	return scheme + "://" + authority + rest, nil
+}
+
+// SameHost reports whether a and b point at the same host. Ports are compared with the
+// scheme's default filled in, unless neither URL has one, so http and https links to
+// the same name count as the same host.
+func SameHost(a, b *url.URL) bool {
+	if !strings.EqualFold(a.Hostname(), b.Hostname()) {
+		return false
+	}
+	if a.Port() == "" && b.Port() == "" {
+		return true
+	}
+	return portOrDefault(a) == portOrDefault(b)
+}
+
+func portOrDefault(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return defaultPorts[strings.ToLower(u.Scheme)]
+}
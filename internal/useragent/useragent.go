@@ -0,0 +1,97 @@
+package useragent
+
+import "strings"
+
+// unfurlers are substrings of the user agents of bots fetching links to render previews in chats and feeds.
+var unfurlers = []string{
+	"slackbot",
+	"slack-imgproxy",
+	"whatsapp",
+	"twitterbot",
+	"facebookexternalhit",
+	"facebookcatalog",
+	"linkedinbot",
+	"discordbot",
+	"telegrambot",
+	"skypeuripreview",
+	"microsoftpreview",
+	"teamsbot",
+	"redditbot",
+	"embedly",
+	"mastodon",
+	"iframely",
+	"pinterestbot",
+	"google-pagerenderer",
+	"applebot",
+	"bitlybot",
+	"vkshare",
+}
+
+// IsUnfurler reports whether userAgent belongs to a link preview bot rather than a person following the link.
+func IsUnfurler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, bot := range unfurlers {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// monitors are substrings of the user agents of uptime monitors and link checkers, which
+// follow links on a schedule to see that they work.
+var monitors = []string{
+	"uptimerobot",
+	"pingdom",
+	"statuscake",
+	"betteruptime",
+	"better stack",
+	"site24x7",
+	"freshping",
+	"hetrixtools",
+	"updown.io",
+	"uptime-kuma",
+	"checkly",
+	"newrelicpinger",
+	"datadog agent",
+	"w3c-checklink",
+	"linkchecker",
+	"lychee",
+}
+
+// IsMonitor reports whether userAgent belongs to an uptime monitor or link checker rather than a person.
+func IsMonitor(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, bot := range monitors {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Devices a visitor can be told apart by, as used by the destination rules of links.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceDesktop = "desktop"
+)
+
+var Devices = []string{DeviceIOS, DeviceAndroid, DeviceDesktop}
+
+// Device returns the kind of device userAgent belongs to, or an empty string when it's unknown,
+// like for command line clients. iPads asking for desktop sites look like a Mac, and count as desktop.
+func Device(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return DeviceIOS
+	// Checked before desktops, Android user agents mention Linux too
+	case strings.Contains(ua, "android"):
+		return DeviceAndroid
+	// ChromeOS is X11 too
+	case strings.Contains(ua, "windows nt"), strings.Contains(ua, "macintosh"), strings.Contains(ua, "x11"), strings.Contains(ua, "linux"):
+		return DeviceDesktop
+	}
+	return ""
+}
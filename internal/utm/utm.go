@@ -0,0 +1,85 @@
+package utm
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Params are the UTM parameters a campaign can expect, in the order issues are reported.
+var Params = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// Kind tells how a UTM parameter of a destination disagrees with its campaign.
+type Kind string
+
+const (
+	// Missing is a parameter the campaign expects that the destination doesn't have
+	Missing Kind = "missing"
+	// Extra is a parameter of the destination the campaign doesn't expect
+	Extra Kind = "extra"
+	// Conflicting is a parameter the destination has with another value than the campaign expects
+	Conflicting Kind = "conflicting"
+)
+
+// Issue is a UTM parameter of a destination that disagrees with its campaign.
+type Issue struct {
+	Param string `json:"param"`
+	Kind  Kind   `json:"kind"`
+	// Expected is the value of the campaign, empty for extra parameters
+	Expected string `json:"expected,omitempty"`
+	// Actual is the value of the destination, repeated values are joined by commas. Empty for missing parameters.
+	Actual string `json:"actual,omitempty"`
+}
+
+func (i Issue) String() string {
+	switch i.Kind {
+	case Missing:
+		return fmt.Sprintf("%s is missing, the campaign expects %q", i.Param, i.Expected)
+	case Extra:
+		return fmt.Sprintf("%s is %q, the campaign doesn't expect it", i.Param, i.Actual)
+	}
+	return fmt.Sprintf("%s is %q, the campaign expects %q", i.Param, i.Actual, i.Expected)
+}
+
+// ValidateMapping checks the UTM parameters a campaign expects: only those of Params, with a value.
+func ValidateMapping(expected map[string]string) error {
+	for param, value := range expected {
+		if !slices.Contains(Params, param) {
+			return fmt.Errorf("unknown UTM parameter %q, must be one of %s", param, strings.Join(Params, ", "))
+		}
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("%s must have a value", param)
+		}
+	}
+	return nil
+}
+
+// Check compares the UTM parameters of destination with the expected ones of its campaign. Values
+// are compared exactly, analytics tools treat them case-sensitively. A campaign that expects
+// nothing has no issues, nor does a destination that isn't a URL.
+func Check(destination string, expected map[string]string) []Issue {
+	if len(expected) == 0 {
+		return nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil
+	}
+	query := u.Query()
+
+	var issues []Issue
+	for _, param := range Params {
+		want, isExpected := expected[param]
+		values, has := query[param]
+		switch {
+		case isExpected && !has:
+			issues = append(issues, Issue{Param: param, Kind: Missing, Expected: want})
+		case !isExpected && has:
+			issues = append(issues, Issue{Param: param, Kind: Extra, Actual: strings.Join(values, ",")})
+		case isExpected && slices.ContainsFunc(values, func(v string) bool { return v != want }):
+			issues = append(issues, Issue{Param: param, Kind: Conflicting, Expected: want, Actual: strings.Join(values, ",")})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,117 @@
+package utm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	expected := map[string]string{"utm_source": "newsletter", "utm_campaign": "fall-launch"}
+
+	tests := []struct {
+		name        string
+		destination string
+		expected    map[string]string
+		want        []Issue
+	}{
+		{
+			name:        "consistent",
+			destination: "https://example.com/?utm_source=newsletter&utm_campaign=fall-launch&ref=home",
+			expected:    expected,
+		},
+		{
+			name:        "missing",
+			destination: "https://example.com/?utm_source=newsletter",
+			expected:    expected,
+			want:        []Issue{{Param: "utm_campaign", Kind: Missing, Expected: "fall-launch"}},
+		},
+		{
+			name:        "extra",
+			destination: "https://example.com/?utm_source=newsletter&utm_campaign=fall-launch&utm_medium=email",
+			expected:    expected,
+			want:        []Issue{{Param: "utm_medium", Kind: Extra, Actual: "email"}},
+		},
+		{
+			name:        "conflicting",
+			destination: "https://example.com/?utm_source=newsletter&utm_campaign=spring",
+			expected:    expected,
+			want:        []Issue{{Param: "utm_campaign", Kind: Conflicting, Expected: "fall-launch", Actual: "spring"}},
+		},
+		{
+			name:        "conflicting case",
+			destination: "https://example.com/?utm_source=Newsletter&utm_campaign=fall-launch",
+			expected:    expected,
+			want:        []Issue{{Param: "utm_source", Kind: Conflicting, Expected: "newsletter", Actual: "Newsletter"}},
+		},
+		{
+			name:        "repeated with another value",
+			destination: "https://example.com/?utm_source=newsletter&utm_source=ads&utm_campaign=fall-launch",
+			expected:    expected,
+			want:        []Issue{{Param: "utm_source", Kind: Conflicting, Expected: "newsletter", Actual: "newsletter,ads"}},
+		},
+		{
+			name:        "all kinds in parameter order",
+			destination: "https://example.com/?utm_content=banner&utm_campaign=spring",
+			expected:    expected,
+			want: []Issue{
+				{Param: "utm_source", Kind: Missing, Expected: "newsletter"},
+				{Param: "utm_campaign", Kind: Conflicting, Expected: "fall-launch", Actual: "spring"},
+				{Param: "utm_content", Kind: Extra, Actual: "banner"},
+			},
+		},
+		{
+			name:        "nothing expected",
+			destination: "https://example.com/?utm_campaign=spring",
+		},
+		{
+			name:        "fragment isn't the query",
+			destination: "https://example.com/#utm_source=newsletter&utm_campaign=fall-launch",
+			expected:    expected,
+			want: []Issue{
+				{Param: "utm_source", Kind: Missing, Expected: "newsletter"},
+				{Param: "utm_campaign", Kind: Missing, Expected: "fall-launch"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Check(tt.destination, tt.expected); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueString(t *testing.T) {
+	tests := []struct {
+		issue Issue
+		want  string
+	}{
+		{Issue{Param: "utm_campaign", Kind: Missing, Expected: "fall-launch"}, `utm_campaign is missing, the campaign expects "fall-launch"`},
+		{Issue{Param: "utm_medium", Kind: Extra, Actual: "email"}, `utm_medium is "email", the campaign doesn't expect it`},
+		{Issue{Param: "utm_campaign", Kind: Conflicting, Expected: "fall-launch", Actual: "spring"}, `utm_campaign is "spring", the campaign expects "fall-launch"`},
+	}
+	for _, tt := range tests {
+		if got := tt.issue.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestValidateMapping(t *testing.T) {
+	tests := []struct {
+		mapping map[string]string
+		wantErr bool
+	}{
+		{mapping: nil},
+		{mapping: map[string]string{"utm_source": "newsletter", "utm_content": "banner"}},
+		{mapping: map[string]string{"utm_id": "1"}, wantErr: true},
+		{mapping: map[string]string{"UTM_SOURCE": "newsletter"}, wantErr: true},
+		{mapping: map[string]string{"utm_source": " "}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateMapping(tt.mapping); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateMapping(%v) = %v, want error %v", tt.mapping, err, tt.wantErr)
+		}
+	}
+}
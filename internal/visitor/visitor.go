@@ -0,0 +1,106 @@
+// Package visitor tells visitors of a link apart without keeping anything that identifies them.
+package visitor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/logger"
+	"github.com/abdusco/linked/internal/repo"
+)
+
+// saltSize is the size of the salt of a day in bytes
+const saltSize = 32
+
+// Hasher hashes the IP and user agent of visitors with a salt that's replaced every day (UTC).
+// The salt is kept in the settings so replicas share it, and only the one of the current day
+// is kept, so hashes of different days can't be linked to each other or to a visitor.
+type Hasher struct {
+	settings *repo.SettingsRepo
+	// Now is the clock deciding the day of the salt, tests can replace it
+	Now func() time.Time
+
+	mu   sync.Mutex
+	day  string
+	salt []byte
+}
+
+func NewHasher(settings *repo.SettingsRepo) *Hasher {
+	return &Hasher{settings: settings, Now: time.Now}
+}
+
+// Hash returns the hash of a visitor for today, or an empty string when the salt can't be loaded,
+// which leaves the click out of unique visitor counts without holding it up.
+func (h *Hasher) Hash(ctx context.Context, ipAddress, userAgent string) string {
+	salt, err := h.saltOf(ctx, h.Now().UTC().Format(time.DateOnly))
+	if err != nil {
+		logger.FromContext(ctx).Error().Err(err).Msg("failed to load visitor salt")
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(ipAddress + "\x00" + userAgent))
+	// Half of it is plenty to tell the visitors of a day apart
+	return hex.EncodeToString(hash.Sum(nil)[:sha256.Size/2])
+}
+
+// saltOf returns the salt of day, replacing the stored one when it's of an earlier day.
+// A replica with a clock running behind uses the salt of the next day rather than bringing back its own.
+func (h *Hasher) saltOf(ctx context.Context, day string) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.day == day {
+		return h.salt, nil
+	}
+
+	stored, err := h.settings.Get(ctx, repo.SettingVisitorSalt)
+	if errors.Is(err, internal.ErrSettingNotFound) {
+		stored = ""
+	} else if err != nil {
+		return nil, err
+	}
+
+	storedDay, salt, err := parseSalt(stored)
+	if err != nil || storedDay < day {
+		fresh := make([]byte, saltSize)
+		if _, err := rand.Read(fresh); err != nil {
+			return nil, fmt.Errorf("failed to generate visitor salt: %w", err)
+		}
+		// Another replica may have replaced it first, then its salt is used
+		stored, err = h.settings.Replace(ctx, repo.SettingVisitorSalt, stored, day+":"+hex.EncodeToString(fresh))
+		if err != nil {
+			return nil, err
+		}
+		if storedDay, salt, err = parseSalt(stored); err != nil {
+			return nil, err
+		}
+	}
+	if storedDay != day {
+		return salt, nil
+	}
+
+	h.day, h.salt = day, salt
+	return salt, nil
+}
+
+// parseSalt splits a stored salt like 2024-01-31:<hex> into its day and value.
+func parseSalt(stored string) (string, []byte, error) {
+	day, value, ok := strings.Cut(stored, ":")
+	if !ok {
+		return "", nil, errors.New("invalid visitor salt")
+	}
+	salt, err := hex.DecodeString(value)
+	if err != nil || len(salt) != saltSize {
+		return "", nil, errors.New("invalid visitor salt")
+	}
+	return day, salt, nil
+}
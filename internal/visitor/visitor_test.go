@@ -0,0 +1,128 @@
+package visitor_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/testutil"
+	"github.com/abdusco/linked/internal/visitor"
+)
+
+const (
+	ip        = "198.51.100.1"
+	userAgent = "Mozilla/5.0"
+)
+
+func TestHash(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	settings := repo.NewSettingsRepo(f.DB)
+	h := visitor.NewHasher(settings)
+	h.Now = f.Clock.Now
+	ctx := context.Background()
+
+	first := h.Hash(ctx, ip, userAgent)
+	if len(first) != 32 {
+		t.Fatalf("got hash %q, want 32 hex digits", first)
+	}
+
+	// The same visitor hashes the same all day, on every replica
+	f.Clock.Advance(11 * time.Hour)
+	if got := h.Hash(ctx, ip, userAgent); got != first {
+		t.Errorf("later that day: got %s, want %s", got, first)
+	}
+	replica := visitor.NewHasher(settings)
+	replica.Now = f.Clock.Now
+	if got := replica.Hash(ctx, ip, userAgent); got != first {
+		t.Errorf("on another replica: got %s, want %s", got, first)
+	}
+	for _, other := range [][2]string{{"198.51.100.2", userAgent}, {ip, "curl/8.0"}} {
+		if got := h.Hash(ctx, other[0], other[1]); got == first {
+			t.Errorf("%s with %s hashes like %s with %s", other[0], other[1], ip, userAgent)
+		}
+	}
+
+	// The salt is replaced with the day, so the visitor can't be followed from one day to the next
+	f.Clock.Set(testutil.Epoch.AddDate(0, 0, 1))
+	second := h.Hash(ctx, ip, userAgent)
+	if second == first || second == "" {
+		t.Errorf("next day: got %q, want a hash other than %s", second, first)
+	}
+	if got := replica.Hash(ctx, ip, userAgent); got != second {
+		t.Errorf("next day on another replica: got %s, want %s", got, second)
+	}
+
+	// A replica whose clock runs behind uses the salt of the next day rather than bringing back its own
+	behind := visitor.NewHasher(settings)
+	behind.Now = func() time.Time { return testutil.Epoch }
+	if got := behind.Hash(ctx, ip, userAgent); got != second {
+		t.Errorf("on a replica behind: got %s, want %s", got, second)
+	}
+	stored, err := settings.Get(ctx, repo.SettingVisitorSalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if day := testutil.Epoch.AddDate(0, 0, 1).Format(time.DateOnly); !strings.HasPrefix(stored, day+":") {
+		t.Errorf("stored salt %q, want the one of %s", stored, day)
+	}
+}
+
+func TestHashWithInvalidSalt(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	settings := repo.NewSettingsRepo(f.DB)
+	if err := settings.Set(context.Background(), repo.SettingVisitorSalt, "garbage"); err != nil {
+		t.Fatal(err)
+	}
+	h := visitor.NewHasher(settings)
+	h.Now = f.Clock.Now
+
+	// A salt that can't be read is replaced
+	if got := h.Hash(context.Background(), ip, userAgent); len(got) != 32 {
+		t.Errorf("got hash %q, want 32 hex digits", got)
+	}
+}
+
+func TestUniqueClicks(t *testing.T) {
+	f := testutil.NewFixtures(t)
+	settings := repo.NewSettingsRepo(f.DB)
+	h := visitor.NewHasher(settings)
+	h.Now = f.Clock.Now
+	link := f.Link(t)
+	ctx := context.Background()
+
+	click := func(ip string) {
+		hash := h.Hash(ctx, ip, userAgent)
+		f.Click(t, link, func(c *repo.NewClick) { c.IPAddress = ip; c.VisitorHash = hash })
+		f.Clock.Advance(time.Minute)
+	}
+	uniqueClicks := func() int64 {
+		t.Helper()
+		stats, err := f.Clicks.GetStatsForLink(ctx, link.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.UniqueClicks
+	}
+
+	// One visitor clicking all day counts once, another visitor once more
+	click("198.51.100.1")
+	click("198.51.100.1")
+	click("198.51.100.1")
+	if got := uniqueClicks(); got != 1 {
+		t.Errorf("one visitor on one day: got %d unique clicks, want 1", got)
+	}
+	click("198.51.100.2")
+	if got := uniqueClicks(); got != 2 {
+		t.Errorf("two visitors on one day: got %d unique clicks, want 2", got)
+	}
+
+	// The next day the salt rotates, and the same visitor counts again, once
+	f.Clock.Set(testutil.Epoch.AddDate(0, 0, 1))
+	click("198.51.100.1")
+	click("198.51.100.1")
+	if got := uniqueClicks(); got != 3 {
+		t.Errorf("a visitor again on the next day: got %d unique clicks, want 3", got)
+	}
+}
@@ -0,0 +1,310 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abdusco/linked/internal"
+	"github.com/abdusco/linked/internal/health"
+	"github.com/abdusco/linked/internal/repo"
+	"github.com/abdusco/linked/internal/safehttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of the body, keyed with the webhook secret.
+	SignatureHeader = "X-Linked-Signature"
+	EventHeader     = "X-Linked-Event"
+	// DeliveryHeader identifies an event, it stays the same across retries so receivers can deduplicate.
+	DeliveryHeader = "X-Linked-Delivery"
+
+	deliveryTimeout = 10 * time.Second
+	queueSize       = 1000
+	workers         = 4
+	// clickFlushInterval is how often clicks are sent, one event per clicked link with the number of clicks,
+	// so a popular link results in a steady trickle of events rather than one per visitor.
+	clickFlushInterval = 10 * time.Second
+)
+
+// retryBackoff is the wait before each retry, a delivery is attempted len(retryBackoff)+1 times.
+var retryBackoff = []time.Duration{2 * time.Second, 10 * time.Second}
+
+// Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type LinkData struct {
+	Link linkPayload `json:"link"`
+}
+
+type ClicksData struct {
+	Link linkPayload `json:"link"`
+	// Clicks is the number of clicks between From and To
+	Clicks int64     `json:"clicks"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+type LockoutData struct {
+	IPAddress string `json:"ip_address"`
+	// Username is the one of the failed login that locked the client out
+	Username string `json:"username"`
+	Failures int    `json:"failures"`
+	// Until is when the client may try again
+	Until time.Time `json:"until"`
+}
+
+type linkPayload struct {
+	ID        int64     `json:"id"`
+	Slug      string    `json:"slug"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newLinkPayload(link *internal.Link) linkPayload {
+	return linkPayload{
+		ID:        link.ID,
+		Slug:      link.Slug,
+		URL:       link.URL,
+		Title:     link.Title,
+		CreatedAt: link.CreatedAt,
+	}
+}
+
+type clickBatch struct {
+	link   *internal.Link
+	clicks int64
+	from   time.Time
+	to     time.Time
+}
+
+// Dispatcher delivers events to subscribed webhooks in the background.
+// Publishing never blocks: events are dropped with a warning when the queue is full.
+type Dispatcher struct {
+	repo   *repo.WebhooksRepo
+	client *http.Client
+	queue  chan Payload
+	// health learns about the outcome of every delivery
+	health *health.Dependency
+
+	mu     sync.Mutex
+	clicks map[int64]*clickBatch
+}
+
+func NewDispatcher(webhooksRepo *repo.WebhooksRepo, dependency *health.Dependency) *Dispatcher {
+	return &Dispatcher{
+		repo:   webhooksRepo,
+		health: dependency,
+		// Receivers must answer directly, a redirect is treated as a failed delivery
+		client: safehttp.NewClient(deliveryTimeout, 0),
+		queue:  make(chan Payload, queueSize),
+		clicks: make(map[int64]*clickBatch),
+	}
+}
+
+// Run delivers queued events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case p := <-d.queue:
+					d.dispatch(ctx, p)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			d.flushClicks()
+		}
+	}
+}
+
+func (d *Dispatcher) LinkCreated(link *internal.Link) {
+	d.publish(internal.EventLinkCreated, LinkData{Link: newLinkPayload(link)})
+}
+
+func (d *Dispatcher) LinkDeleted(link *internal.Link) {
+	d.publish(internal.EventLinkDeleted, LinkData{Link: newLinkPayload(link)})
+}
+
+func (d *Dispatcher) LoginLockedOut(data LockoutData) {
+	d.publish(internal.EventLoginLockedOut, data)
+}
+
+// LinkClicked counts a click, clicks are sent in batches every clickFlushInterval.
+func (d *Dispatcher) LinkClicked(link *internal.Link) {
+	now := time.Now().UTC()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch, ok := d.clicks[link.ID]
+	if !ok {
+		batch = &clickBatch{link: link, from: now}
+		d.clicks[link.ID] = batch
+	}
+	batch.clicks++
+	batch.to = now
+}
+
+func (d *Dispatcher) flushClicks() {
+	d.mu.Lock()
+	batches := d.clicks
+	d.clicks = make(map[int64]*clickBatch, len(batches))
+	d.mu.Unlock()
+
+	for _, batch := range batches {
+		d.publish(internal.EventLinkClicked, ClicksData{
+			Link:   newLinkPayload(batch.link),
+			Clicks: batch.clicks,
+			From:   batch.from,
+			To:     batch.to,
+		})
+	}
+}
+
+func (d *Dispatcher) publish(event string, data any) {
+	p := Payload{
+		ID:        crand.Text(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	select {
+	case d.queue <- p:
+	default:
+		log.Warn().Str("event", event).Msg("webhook queue is full, dropping event")
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, p Payload) {
+	webhooks, err := d.repo.ListForEvent(ctx, p.Event)
+	if err != nil {
+		log.Error().Err(err).Str("event", p.Event).Msg("failed to list webhooks")
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Error().Err(err).Str("event", p.Event).Msg("failed to encode webhook payload")
+		return
+	}
+
+	for _, w := range webhooks {
+		d.deliver(ctx, w, p, body)
+	}
+}
+
+// deliver sends the payload to a webhook, retrying with backoff, and logs every attempt.
+func (d *Dispatcher) deliver(ctx context.Context, w *internal.Webhook, p Payload, body []byte) {
+	for attempt := 1; ; attempt++ {
+		delivery, retry := d.attempt(ctx, w, p, body)
+		delivery.Attempt = attempt
+
+		if err := d.repo.CreateDelivery(ctx, delivery); err != nil {
+			if errors.Is(err, internal.ErrWebhookNotFound) {
+				// Deleted in the meantime, nothing left to deliver to
+				return
+			}
+			log.Error().Err(err).Int64("webhook_id", w.ID).Msg("failed to record webhook delivery")
+		}
+
+		if delivery.Error == "" {
+			d.health.Succeeded()
+			return
+		}
+		if attempt > len(retryBackoff) || !retry {
+			d.health.Failed(fmt.Errorf("webhook %d: %s", w.ID, delivery.Error))
+			log.Warn().
+				Int64("webhook_id", w.ID).
+				Str("event", p.Event).
+				Str("error", delivery.Error).
+				Msg("webhook delivery failed")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryBackoff[attempt-1]):
+		}
+	}
+}
+
+// attempt makes a single delivery and reports whether it's worth retrying if it failed.
+func (d *Dispatcher) attempt(ctx context.Context, w *internal.Webhook, p Payload, body []byte) (*internal.WebhookDelivery, bool) {
+	delivery := &internal.WebhookDelivery{
+		WebhookID:   w.ID,
+		Event:       p.Event,
+		DeliveredAt: time.Now().UTC(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "linked-webhooks")
+	req.Header.Set(EventHeader, p.Event)
+	req.Header.Set(DeliveryHeader, p.ID)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(delivery.DeliveredAt).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		permanent := errors.Is(err, safehttp.ErrBlockedAddress) || errors.Is(err, safehttp.ErrTooManyRedirects)
+		return delivery, !permanent
+	}
+	defer resp.Body.Close()
+	// Drain a bit of the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		delivery.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return delivery, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// Sign returns the signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
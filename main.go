@@ -7,19 +7,25 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/abdusco/linked/internal/auth"
+	"github.com/abdusco/linked/internal/buildinfo"
 	"github.com/abdusco/linked/internal/db"
+	"github.com/abdusco/linked/internal/destpolicy"
 	"github.com/abdusco/linked/internal/handler"
-	"github.com/abdusco/linked/internal/repo"
-	"github.com/abdusco/linked/web"
+	"github.com/abdusco/linked/internal/secheaders"
+	"github.com/abdusco/linked/internal/server"
+	"github.com/abdusco/linked/internal/settings"
+	"github.com/abdusco/linked/internal/slugs"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -29,45 +35,349 @@ var (
 	buildTime = "unknown"
 )
 
-type Config struct {
-	Host       string
-	Port       string
-	DBPath     string
-	AdminCreds string
-	JWTSecret  string
-	LogLevel   string
-	Debug      bool
-}
-
-func newConfigFromEnv() (Config, error) {
-	cfg := Config{
+func newConfigFromEnv() (server.Config, error) {
+	cfg := server.Config{
 		Host:       cmp.Or(os.Getenv("HOST"), "localhost"),
 		Port:       cmp.Or(os.Getenv("PORT"), "8080"),
-		DBPath:     cmp.Or(os.Getenv("DB_PATH"), "linked.db"),
+		DBDriver:   cmp.Or(os.Getenv("DB_DRIVER"), db.DriverSQLite),
 		AdminCreds: os.Getenv("ADMIN_CREDENTIALS"),
 		JWTSecret:  os.Getenv("JWT_SECRET"),
 		LogLevel:   cmp.Or(os.Getenv("LOG_LEVEL"), "info"),
 		Debug:      os.Getenv("DEBUG") == "1",
 	}
 
+	cfg.PublicStatsOrigins = splitList(cmp.Or(os.Getenv("PUBLIC_STATS_ORIGINS"), "*"))
+	cfg.SnapshotsEnabled = os.Getenv("SNAPSHOTS_ENABLED") == "1"
+	cfg.DirectoryEnabled = os.Getenv("DIRECTORY_ENABLED") == "1"
+	cfg.SlackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	cfg.ExcludeBotClicks = os.Getenv("EXCLUDE_BOT_CLICKS") == "1"
+	cfg.SlugCaseInsensitive = os.Getenv("SLUG_CASE_INSENSITIVE") == "1"
+	cfg.SlugChecksum = os.Getenv("SLUG_CHECKSUM") == "1"
+	cfg.Settings.ComingSoonPage = os.Getenv("COMING_SOON_PAGE") == "1"
+	cfg.Settings.NotFoundURL = os.Getenv("NOT_FOUND_URL")
+	cfg.GeoIPDBPath = os.Getenv("GEOIP_DB_PATH")
+	cfg.ClickSink = os.Getenv("CLICK_SINK")
+	cfg.FaviconPath = os.Getenv("FAVICON_PATH")
+	cfg.RobotsPolicy = cmp.Or(os.Getenv("ROBOTS_POLICY"), "disallow")
+	cfg.CriticalIntegrations = splitList(os.Getenv("CRITICAL_INTEGRATIONS"))
+	cfg.AllowedURLSchemes = splitList(strings.ToLower(cmp.Or(os.Getenv("URL_SCHEMES"), "http,https")))
+
+	var err error
+	if cfg.CORSAllowedOrigins, err = envOrigins("CORS_ALLOWED_ORIGINS"); err != nil {
+		return server.Config{}, err
+	}
+	cfg.CORSAllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "1"
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		return server.Config{}, errors.New("CORS_ALLOW_CREDENTIALS=1 requires CORS_ALLOWED_ORIGINS to list origins, not *")
+	}
+	if cfg.CORSAllowedMethods, err = envMethods("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE"); err != nil {
+		return server.Config{}, err
+	}
+	// A customized frontend may need a looser policy, or none at all
+	switch policy := strings.TrimSpace(os.Getenv("CONTENT_SECURITY_POLICY")); policy {
+	case "":
+		cfg.SecurityHeaders.Policy = secheaders.DefaultPolicy
+	case "off":
+	default:
+		cfg.SecurityHeaders.Policy = policy
+	}
+	cfg.SecurityHeaders.ReportURI = os.Getenv("CSP_REPORT_URI")
+	cfg.SecurityHeaders.ReportOnly = os.Getenv("CSP_REPORT_ONLY") == "1"
+	if cfg.SecurityHeaders.FrameAncestors, err = envOrigins("FRAME_ANCESTORS"); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.SnapshotMaxPerLink, err = envInt("SNAPSHOT_MAX_PER_LINK", 5); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.SnapshotMaxTotalMB, err = envInt("SNAPSHOT_MAX_TOTAL_MB", 100); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", 15*time.Second); err != nil {
+		return server.Config{}, err
+	}
+	dedupSeconds, err := envNonNegativeInt("CLICK_DEDUP_SECONDS", 0)
+	if err != nil {
+		return server.Config{}, err
+	}
+	cfg.ClickDedupWindow = time.Duration(dedupSeconds) * time.Second
+	if cfg.ClickWritesPerSecond, err = envNonNegativeInt("CLICK_WRITES_PER_SECOND", 0); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.Settings.ClickRetentionDays, err = envNonNegativeInt("CLICK_RETENTION_DAYS", 0); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.Settings.RedirectStatus, err = envInt("REDIRECT_STATUS", http.StatusPermanentRedirect); err != nil {
+		return server.Config{}, err
+	}
+	var settingErrs settings.FieldErrors
+	if err := cfg.Settings.Validate(); errors.As(err, &settingErrs) {
+		// Each setting is named like its env var
+		for name, msg := range settingErrs {
+			return server.Config{}, fmt.Errorf("%s %s", strings.ToUpper(name), msg)
+		}
+	}
+	if cfg.LinkCheckInterval, err = envDuration("LINK_CHECK_INTERVAL", 0); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.LinkCheckConcurrency, err = envInt("LINK_CHECK_CONCURRENCY", 4); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.LinkCheckHostDelay, err = envDuration("LINK_CHECK_HOST_DELAY", time.Second); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.VacuumInterval, err = envDuration("VACUUM_INTERVAL", 0); err != nil {
+		return server.Config{}, err
+	}
+	cfg.BackupDir = os.Getenv("BACKUP_DIR")
+	if cfg.BackupInterval, err = envDuration("BACKUP_INTERVAL", 24*time.Hour); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.BackupKeep, err = envInt("BACKUP_KEEP", 7); err != nil {
+		return server.Config{}, err
+	}
+	deleteConfirmClicks, err := envNonNegativeInt("DELETE_CONFIRM_CLICKS", 0)
+	if err != nil {
+		return server.Config{}, err
+	}
+	cfg.DeleteConfirmClicks = int64(deleteConfirmClicks)
+	cfg.DeleteForceEnabled = os.Getenv("DELETE_FORCE_ENABLED") == "1"
+	if cfg.ScanBudget, err = envInt("SCAN_BUDGET", 30); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.LoginLockout.MaxFailures, err = envNonNegativeInt("LOGIN_MAX_FAILURES", 10); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.LoginLockout.Window, err = envDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.TrustedProxies, err = envCIDRs("TRUSTED_PROXIES"); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.ScanTarpitDelay, err = envDuration("SCAN_TARPIT_DELAY", 2*time.Second); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.ScanTarpitDelay >= server.RedirectTimeout {
+		return server.Config{}, fmt.Errorf("SCAN_TARPIT_DELAY must be shorter than the %s redirect timeout", server.RedirectTimeout)
+	}
+
+	switch cfg.DBDriver {
+	case db.DriverSQLite:
+		cfg.DBDSN = cmp.Or(os.Getenv("DB_PATH"), "linked.db")
+	case db.DriverPostgres:
+		cfg.DBDSN = os.Getenv("DB_DSN")
+		if cfg.DBDSN == "" {
+			return server.Config{}, errors.New("DB_DSN is required when DB_DRIVER is postgres")
+		}
+	default:
+		return server.Config{}, fmt.Errorf("DB_DRIVER must be %s or %s, got %q", db.DriverSQLite, db.DriverPostgres, cfg.DBDriver)
+	}
+	if cfg.BackupDir != "" && cfg.DBDriver != db.DriverSQLite {
+		return server.Config{}, errors.New("BACKUP_DIR only works with the sqlite driver, back up postgres with its own tools")
+	}
+
+	cfg.DestinationPolicy, err = destpolicy.New(splitList(os.Getenv("DEST_ALLOWLIST")), splitList(os.Getenv("DEST_BLOCKLIST")))
+	if err != nil {
+		return server.Config{}, fmt.Errorf("DEST_ALLOWLIST or DEST_BLOCKLIST: %w", err)
+	}
+
+	if cfg.Domains, err = envDomains("DOMAINS"); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.SlugMinLength, err = envInt("SLUG_MIN_LENGTH", 5); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.SlugMaxLength, err = envInt("SLUG_MAX_LENGTH", 64); err != nil {
+		return server.Config{}, err
+	}
+	if cfg.SlugMaxLength < cfg.SlugMinLength {
+		return server.Config{}, errors.New("SLUG_MAX_LENGTH must be at least SLUG_MIN_LENGTH")
+	}
+	if cfg.SlugCharset, err = slugs.ParseCharset(cmp.Or(os.Getenv("SLUG_CHARSET"), "safe")); err != nil {
+		return server.Config{}, fmt.Errorf("SLUG_CHARSET: %w", err)
+	}
+	cfg.SelfRedirectPolicy, err = handler.ParseSelfRedirectPolicy(cmp.Or(os.Getenv("SELF_REDIRECT_POLICY"), "reject"))
+	if err != nil {
+		return server.Config{}, err
+	}
+	cfg.UTMCheckPolicy, err = handler.ParseUTMCheckPolicy(cmp.Or(os.Getenv("UTM_CHECK_POLICY"), "warn"))
+	if err != nil {
+		return server.Config{}, err
+	}
+	cfg.SlugNormalization, err = handler.ParseSlugNormalization(cmp.Or(os.Getenv("SLUG_NORMALIZATION"), "canonical"))
+	if err != nil {
+		return server.Config{}, fmt.Errorf("SLUG_NORMALIZATION: %w", err)
+	}
+
+	cfg.CookieSecure, err = auth.ParseSecureMode(cmp.Or(os.Getenv("COOKIE_SECURE"), "auto"))
+	if err != nil {
+		return server.Config{}, fmt.Errorf("COOKIE_SECURE: %w", err)
+	}
+	cfg.CookieSameSite, err = auth.ParseSameSite(cmp.Or(os.Getenv("COOKIE_SAMESITE"), "lax"))
+	if err != nil {
+		return server.Config{}, fmt.Errorf("COOKIE_SAMESITE: %w", err)
+	}
+	if cfg.CookieSameSite == http.SameSiteNoneMode && cfg.CookieSecure == auth.SecureNever {
+		return server.Config{}, errors.New("COOKIE_SAMESITE=none requires a Secure cookie, COOKIE_SECURE cannot be false")
+	}
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	cfg.AutoTLSDomains = splitList(strings.ToLower(os.Getenv("AUTO_TLS_DOMAIN")))
+	cfg.AutoTLSCacheDir = cmp.Or(os.Getenv("AUTO_TLS_CACHE_DIR"), "autocert")
+	cfg.HTTPRedirectPort = os.Getenv("HTTP_REDIRECT_PORT")
+	if err := validateTLSConfig(cfg); err != nil {
+		return server.Config{}, err
+	}
+
 	return cfg, nil
 }
 
+// envInt reads a positive integer from the environment, falling back to def when unset.
+func envInt(key string, def int) (int, error) {
+	s := os.Getenv(key)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, s)
+	}
+	return n, nil
+}
+
+func envNonNegativeInt(key string, def int) (int, error) {
+	s := os.Getenv(key)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", key, s)
+	}
+	return n, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	s := os.Getenv(key)
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 15s, got %q", key, s)
+	}
+	return d, nil
+}
+
+// envOrigins parses a comma-separated list of origins like https://example.com, or *.
+func envOrigins(key string) ([]string, error) {
+	origins := splitList(os.Getenv(key))
+	for i, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("%s must list origins like https://example.com, got %q", key, origin)
+		}
+		// Browsers send the origin without a trailing slash and with a lowercase host
+		origins[i] = u.Scheme + "://" + strings.ToLower(u.Host)
+	}
+	return origins, nil
+}
+
+// envDomains parses a comma-separated list of host names like go.example.com, without schemes or ports.
+func envDomains(key string) ([]string, error) {
+	domains := splitList(strings.ToLower(os.Getenv(key)))
+	for _, domain := range domains {
+		if u, err := url.Parse("http://" + domain); err != nil || u.Host != domain || u.Port() != "" || strings.Trim(domain, ".") != domain {
+			return nil, fmt.Errorf("%s must list host names like go.example.com, got %q", key, domain)
+		}
+	}
+	return domains, nil
+}
+
+// envMethods parses a comma-separated list of HTTP methods.
+func envMethods(key, def string) ([]string, error) {
+	known := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	methods := splitList(strings.ToUpper(cmp.Or(os.Getenv(key), def)))
+	for _, method := range methods {
+		if !slices.Contains(known, method) {
+			return nil, fmt.Errorf("%s must list methods out of %s, got %q", key, strings.Join(known, ", "), method)
+		}
+	}
+	return methods, nil
+}
+
+// envCIDRs parses a comma-separated list of IP ranges like 10.0.0.0/8, or single addresses.
+func envCIDRs(key string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, item := range splitList(os.Getenv(key)) {
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s must list IP ranges like 10.0.0.0/8 or addresses, got %q", key, item)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// splitList parses a comma-separated list, ignoring blanks.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	cfg, err := newConfigFromEnv()
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to parse configuration from environment")
 	}
+	cfg.Build = buildinfo.New(version, buildTime)
+
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
 
-	level, err := zerolog.ParseLevel(cfg.LogLevel)
+	logLevel := cfg.LogLevel
+	if cmd != "serve" && os.Getenv("LOG_LEVEL") == "" {
+		// Keep admin commands quiet unless asked otherwise
+		logLevel = "warn"
+	}
+	level, err := zerolog.ParseLevel(logLevel)
 	if err != nil {
-		log.Fatal().Err(err).Str("level", cfg.LogLevel).Msg("failed to parse log level")
+		log.Fatal().Err(err).Str("level", logLevel).Msg("failed to parse log level")
 	}
 	zerolog.SetGlobalLevel(level)
 	if cfg.Debug {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
+	ctx := context.Background()
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cmd != "serve" {
+		code := runCLI(ctx, cfg, cmd, args)
+		cancel()
+		os.Exit(code)
+	}
+
 	if cfg.AdminCreds == "" {
 		cfg.AdminCreds = "admin:admin"
 		log.Warn().Msg("using default admin credentials - set ADMIN_CREDENTIALS for production")
@@ -82,117 +392,89 @@ func main() {
 		Interface("config", cfg).
 		Msg("current configuration")
 
-	ctx := context.Background()
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
-	defer cancel()
-
 	if err := run(ctx, cfg); err != nil {
 		log.Fatal().Err(err).Msg("application error")
 	}
 }
 
-func run(ctx context.Context, cfg Config) error {
+func run(ctx context.Context, cfg server.Config) error {
 	log.Info().
-		Str("version", version).
-		Str("build_time", buildTime).
+		Interface("build", cfg.Build).
 		Msg("starting application")
 
-	credentials, err := auth.NewCredentials(cfg.AdminCreds)
-	if err != nil {
-		return fmt.Errorf("failed to parse admin credentials: %w", err)
-	}
-
-	dbInstance, err := db.Init(ctx, cfg.DBPath)
+	dbInstance, err := db.Init(ctx, cfg.DBDriver, cfg.DBDSN)
 	if err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	defer dbInstance.Close()
 
-	e := echo.New()
-	defer e.Close()
-
-	e.HideBanner = true
-	e.HidePort = true
-	e.HTTPErrorHandler = customErrorHandler
-
-	//e.Use(middleware.RequestLogger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			path := c.Request().URL.Path
-			if strings.HasPrefix(path, "/.well-known/") || path == "/favicon.ico" {
-				return c.NoContent(http.StatusNotFound)
-			}
-			return next(c)
-		}
-	})
-
-	authenticator := auth.NewAuthenticator(credentials, cfg.JWTSecret)
-	authMiddleware := auth.NewAuthMiddleware(authenticator)
-	authHandler := handler.NewAuthHandler(authenticator, web.FS)
-
-	e.GET("/", authHandler.ServeLoginPage)
-	e.POST("/login", authHandler.Login)
-	e.GET("/logout", authHandler.Logout)
-
-	dashboardHandler := handler.NewDashboardHandler(web.FS)
-	e.GET("/dashboard", dashboardHandler.ServeDashboardPage, authMiddleware)
-
-	api := e.Group("/api")
-	api.Use(authMiddleware)
-
-	linksRepo := repo.NewLinksRepo(dbInstance)
-	clicksRepo := repo.NewClicksRepo(dbInstance)
-	linkHandler := handler.NewLinkHandler(linksRepo, clicksRepo)
-	api.POST("/links", linkHandler.CreateLink)
-	api.GET("/links", linkHandler.ListLinks)
-	api.DELETE("/links/:id", linkHandler.DeleteLink)
-
-	if cfg.Debug {
-		log.Info().Msg("serving static files from disk")
-		e.Static("/static", "web")
-	} else {
-		log.Info().Msg("serving static files from embedded filesystem")
-		e.StaticFS("/static", web.FS)
+	if err := db.SetSlugCaseInsensitive(ctx, dbInstance, cfg.SlugCaseInsensitive); err != nil {
+		return err
 	}
 
-	e.GET("/health", func(c echo.Context) error {
-		return c.JSON(200, map[string]string{"status": "ok"})
-	})
-
-	// Parameterized route (must be last)
-	e.GET("/:slug", linkHandler.Redirect)
-
-	addr := net.JoinHostPort(cfg.Host, cfg.Port)
-	log.Info().Str("address", "http://"+addr).Msg("server starting")
+	srv, err := server.New(ctx, cfg, dbInstance)
+	if err != nil {
+		return err
+	}
+	// Writes the queued clicks before the database is closed
+	defer srv.Close()
 
-	runServer(ctx, e, addr)
+	if len(cfg.AutoTLSDomains) > 0 {
+		configureAutoTLS(srv.Echo, cfg)
+	}
+	runServer(ctx, srv.Echo, cfg)
 
 	return nil
 }
 
-func runServer(ctx context.Context, e *echo.Echo, addr string) {
+func runServer(ctx context.Context, e *echo.Echo, cfg server.Config) {
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	log.Info().Str("address", scheme+"://"+addr).Msg("server starting")
+
 	serverErr := make(chan error, 1)
 	go func() {
-		serverErr <- e.Start(addr)
+		serverErr <- startServer(e, cfg, addr)
 	}()
 
+	var redirectServer *http.Server
+	redirectErr := make(chan error, 1)
+	if cfg.HTTPRedirectPort != "" {
+		redirectServer = newHTTPSRedirectServer(e, cfg)
+		log.Info().Str("address", "http://"+redirectServer.Addr).Msg("redirecting HTTP to HTTPS")
+		go func() {
+			redirectErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
 	// Wait for either a startup error or context cancellation
 	select {
 	case err := <-serverErr:
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Error().Err(err).Msg("server error")
 		}
+		if redirectServer != nil {
+			_ = redirectServer.Close()
+		}
 		return
+	case err := <-redirectErr:
+		// Without it HTTP visitors get nowhere, better to fail loudly than to run half reachable
+		log.Error().Err(err).Msg("HTTP redirect server error, shutting down...")
 	case <-ctx.Done():
+		log.Info().Msg("shutdown signal received, gracefully shutting down...")
 	}
 
-	log.Info().Msg("shutdown signal received, gracefully shutting down...")
-
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("error during graceful shutdown of HTTP redirect server")
+		}
+	}
 	if err := e.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("error during graceful shutdown")
 	}
@@ -203,39 +485,3 @@ func runServer(ctx context.Context, e *echo.Echo, addr string) {
 
 	log.Info().Msg("server stopped")
 }
-
-func customErrorHandler(err error, c echo.Context) {
-	code := http.StatusInternalServerError
-	message := "internal server error"
-	isAPICall := strings.HasPrefix(c.Path(), "/api/")
-
-	var httpErr *echo.HTTPError
-	if errors.As(err, &httpErr) {
-		code = httpErr.Code
-		if msg, ok := httpErr.Message.(string); ok {
-			message = msg
-		}
-	}
-
-	if !isAPICall && code == http.StatusUnauthorized {
-		c.Redirect(http.StatusTemporaryRedirect, "/")
-		return
-	}
-
-	if code >= 500 {
-		log.Error().
-			Int("code", code).
-			Str("method", c.Request().Method).
-			Str("path", c.Request().URL.Path).
-			Err(err).
-			Msg("error while handling request")
-	}
-
-	if c.Response().Committed {
-		return
-	}
-
-	c.JSON(code, map[string]any{
-		"error": message,
-	})
-}
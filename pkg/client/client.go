@@ -0,0 +1,297 @@
+// Package client is a Go client for the linked API.
+//
+//	c := client.New("https://go.example.com", client.WithBasicAuth("admin", "secret"))
+//	resp, err := c.CreateLink(ctx, client.CreateLinkRequest{URL: "https://example.com"})
+//	if errors.Is(err, client.ErrSlugExists) {
+//		// pick another slug
+//	}
+//
+// The API is described in full by the OpenAPI document served at /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBodySize bounds how much of an error response is read for its message
+const maxErrorBodySize = 64 << 10
+
+var (
+	ErrSlugExists   = errors.New("slug already exists")
+	ErrLinkNotFound = errors.New("link not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	// ErrLockedOut is returned after too many failed logins from the same IP, until the lockout is over
+	ErrLockedOut = errors.New("too many failed logins")
+	// ErrConfirmationRequired is returned when deleting a link with a lot of clicks, the ConfirmToken
+	// of the Error confirms the deletion with DeleteLinkConfirmed
+	ErrConfirmationRequired = errors.New("confirmation required")
+	errNoCookieJar          = errors.New("client: Login needs an http.Client with a cookie jar")
+)
+
+// Error is returned for responses with an error status. It matches ErrSlugExists,
+// ErrLinkNotFound and ErrConfirmationRequired with errors.Is by its code, and ErrUnauthorized,
+// ErrForbidden and ErrLockedOut by its status code.
+type Error struct {
+	StatusCode int
+	// Code tells errors apart, like slug_conflict or validation_failed, and doesn't change with the message
+	Code    string
+	Message string
+	// Details tells which fields of the request are invalid, with validation_failed
+	Details []FieldError
+	// ConfirmToken is set with ErrConfirmationRequired
+	ConfirmToken string
+}
+
+// FieldError tells why a field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("linked: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrSlugExists:
+		return e.Code == "slug_conflict"
+	case ErrConfirmationRequired:
+		return e.Code == "confirmation_required"
+	case ErrLinkNotFound:
+		return e.Code == "link_not_found"
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrLockedOut:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	username   string
+	password   string
+}
+
+type Option func(*Client)
+
+// WithBasicAuth sends the credentials with every request.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithHTTPClient replaces the default client, which has a 30 second timeout and a cookie jar.
+// Login only works when the client has a cookie jar.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New returns a client for the instance at baseURL, like https://go.example.com.
+// Authenticate with WithBasicAuth, or call Login to use the auth cookie instead.
+func New(baseURL string, opts ...Option) *Client {
+	jar, _ := cookiejar.New(nil)
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second, Jar: jar},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Login exchanges the credentials for the auth cookie, which is sent with later requests.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	if c.httpClient.Jar == nil {
+		return errNoCookieJar
+	}
+	return c.do(ctx, http.MethodPost, "/login", Credentials{Username: username, Password: password}, nil)
+}
+
+// CreateLink creates a link. It returns ErrSlugExists when the slug is taken by a link to
+// another destination, a link to the same one is returned instead.
+func (c *Client) CreateLink(ctx context.Context, req CreateLinkRequest) (*CreateLinkResponse, error) {
+	var resp CreateLinkResponse
+	if err := c.do(ctx, http.MethodPost, "/api/links", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateAndVerifyLink creates a link like CreateLink, then follows it like its first visitor would.
+// The link is created even when verification fails, see the Verification of the response.
+func (c *Client) CreateAndVerifyLink(ctx context.Context, req CreateLinkRequest) (*CreateLinkResponse, error) {
+	var resp CreateLinkResponse
+	if err := c.do(ctx, http.MethodPost, "/api/links?verify=true", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListLinks lists the links, newest first.
+func (c *Client) ListLinks(ctx context.Context, opts ListLinksOptions) ([]Link, error) {
+	query := url.Values{}
+	if opts.URL != "" {
+		query.Set("url", opts.URL)
+	}
+	for _, tag := range opts.Tags {
+		query.Add("tag", tag)
+	}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+	if opts.CampaignID != nil {
+		query.Set("campaign_id", strconv.FormatInt(*opts.CampaignID, 10))
+	}
+	if opts.Domain != nil {
+		query.Set("domain", *opts.Domain)
+	}
+	if opts.Health != "" {
+		query.Set("health", opts.Health)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Ascending {
+		query.Set("order", "asc")
+	}
+	path := "/api/links"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var resp struct {
+		Links []Link `json:"links"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Links, nil
+}
+
+// GetLink returns a link with its click stats, or ErrLinkNotFound.
+func (c *Client) GetLink(ctx context.Context, id int64) (*Link, error) {
+	var link Link
+	if err := c.do(ctx, http.MethodGet, linkPath(id), nil, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// CheckLink checks the destination of a link now and returns the outcome, or ErrLinkNotFound.
+func (c *Client) CheckLink(ctx context.Context, id int64) (*LinkHealth, error) {
+	var resp struct {
+		Health *LinkHealth `json:"health"`
+	}
+	if err := c.do(ctx, http.MethodPost, linkPath(id)+"/check", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Health, nil
+}
+
+// DeleteLink deletes a link and its clicks, or returns ErrLinkNotFound.
+func (c *Client) DeleteLink(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, linkPath(id), nil, nil)
+}
+
+// DeleteLinkConfirmed deletes a link with the ConfirmToken of the ErrConfirmationRequired
+// that DeleteLink returned for it.
+func (c *Client) DeleteLinkConfirmed(ctx context.Context, id int64, confirmToken string) error {
+	path := linkPath(id) + "?" + url.Values{"confirm": {confirmToken}}.Encode()
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// DeleteLinkBySlug deletes the link with the current slug and its clicks, or returns ErrLinkNotFound.
+func (c *Client) DeleteLinkBySlug(ctx context.Context, slug string) error {
+	return c.do(ctx, http.MethodDelete, "/api/links/slug/"+url.PathEscape(slug), nil, nil)
+}
+
+// DeleteLinkOnDomain is DeleteLinkBySlug for a link scoped to a domain.
+func (c *Client) DeleteLinkOnDomain(ctx context.Context, domain, slug string) error {
+	path := "/api/links/slug/" + url.PathEscape(slug) + "?" + url.Values{"domain": {domain}}.Encode()
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func linkPath(id int64) string {
+	return "/api/links/" + strconv.FormatInt(id, 10)
+}
+
+// do sends body as JSON and decodes the response into out, unless either is nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func newError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+	var body struct {
+		Code         string       `json:"code"`
+		Error        string       `json:"error"`
+		Details      []FieldError `json:"details"`
+		ConfirmToken string       `json:"confirm_token"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, maxErrorBodySize)).Decode(&body) == nil && body.Error != "" {
+		apiErr.Code = body.Code
+		apiErr.Message = body.Error
+		apiErr.Details = body.Details
+		apiErr.ConfirmToken = body.ConfirmToken
+	}
+	return apiErr
+}
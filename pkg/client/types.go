@@ -0,0 +1,136 @@
+package client
+
+import "time"
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type CreateLinkRequest struct {
+	URL string `json:"url"`
+	// Slug is generated when empty
+	Slug string `json:"slug,omitempty"`
+	// Domain scopes the slug to one of the domains of the instance, without it the link works on all of them
+	Domain string `json:"domain,omitempty"`
+	Title  string `json:"title,omitempty"`
+	// Description is a private note on why the link exists
+	Description string   `json:"description,omitempty"`
+	Snapshot    bool     `json:"snapshot,omitempty"`
+	Preview     bool     `json:"preview,omitempty"`
+	Listed      bool     `json:"listed,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// OpenGraph fields are shown to bots unfurling the short link
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+	// ActivatesAt and ExpiresAt bound when the link redirects
+	ActivatesAt *time.Time `json:"activates_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Rules send visitors on some devices or with some languages elsewhere, the first matching one wins
+	Rules []DestinationRule `json:"rules,omitempty"`
+	// CampaignID adds the link to a campaign
+	CampaignID *int64 `json:"campaign_id,omitempty"`
+	// ReuseExisting returns an existing link to the same destination instead of creating another one
+	ReuseExisting bool `json:"reuse_existing,omitempty"`
+}
+
+// DestinationRule matches when all of its conditions do, at least one is required.
+type DestinationRule struct {
+	// Device is ios, android or desktop
+	Device string `json:"device,omitempty"`
+	// Language is matched against the preferred language of the visitor, en matches en-GB too
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+type CreateLinkResponse struct {
+	Link Link `json:"link"`
+	// Warning says why the destination looks broken, it's only checked when asked for
+	Warning string `json:"warning,omitempty"`
+	// Verification is how following the new link went, only when asked for
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+type Verification struct {
+	Passed bool                `json:"passed"`
+	Checks []VerificationCheck `json:"checks"`
+}
+
+type VerificationCheck struct {
+	// Name is resolves, active or reachable
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ListLinksOptions struct {
+	// URL only keeps links pointing at this destination
+	URL string
+	// Tags only keeps links that have all of the tags
+	Tags []string
+	// Query only keeps links with the text in their slug, URL, title or description
+	Query string
+	// CampaignID only keeps links of the campaign
+	CampaignID *int64
+	// Domain only keeps links on the domain, an empty one those on any domain
+	Domain *string
+	// Health only keeps links whose destination is broken, ok or unchecked
+	Health string
+	// Sort orders the links by created_at, clicks or last_click, newest first when empty
+	Sort string
+	// Ascending reverses the order of Sort
+	Ascending bool
+}
+
+type Link struct {
+	ID             int64             `json:"id"`
+	Slug           string            `json:"slug"`
+	Domain         string            `json:"domain,omitempty"`
+	URL            string            `json:"url"`
+	Title          string            `json:"title"`
+	Description    string            `json:"description"`
+	ShortURL       string            `json:"short_url"`
+	PublicStatsURL string            `json:"public_stats_url,omitempty"`
+	Snapshot       bool              `json:"snapshot"`
+	Preview        bool              `json:"preview"`
+	Listed         bool              `json:"listed"`
+	Tags           []string          `json:"tags"`
+	OGTitle        string            `json:"og_title,omitempty"`
+	OGDescription  string            `json:"og_description,omitempty"`
+	OGImage        string            `json:"og_image,omitempty"`
+	ActivatesAt    *time.Time        `json:"activates_at,omitempty"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+	Rules          []DestinationRule `json:"rules"`
+	// Status is scheduled, active or expired
+	Status string `json:"status"`
+	// OwnerID is the user who created the link, if known
+	OwnerID *int64 `json:"owner_id,omitempty"`
+	// CampaignID is the campaign the link belongs to, if any
+	CampaignID *int64      `json:"campaign_id,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Stats      *LinkStats  `json:"stats,omitempty"`
+	Health     *LinkHealth `json:"health,omitempty"`
+}
+
+// LinkHealth is the outcome of the last check of the destination of a link, missing when it wasn't checked.
+type LinkHealth struct {
+	// Result is ok, or why the destination is broken: http_error, timeout, tls_error, too_many_redirects or unreachable
+	Result string `json:"result"`
+	// StatusCode is what the destination answered with, zero when it didn't
+	StatusCode int       `json:"status_code,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Broken reports whether the destination failed the check.
+func (h *LinkHealth) Broken() bool {
+	return h.Result != "ok"
+}
+
+// LinkStats is only included by GetLink.
+type LinkStats struct {
+	Clicks int64 `json:"clicks"`
+	// UniqueClicks counts each visitor once a day
+	UniqueClicks  int64      `json:"unique_clicks"`
+	LastClickedAt *time.Time `json:"last_clicked_at"`
+}
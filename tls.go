@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/abdusco/linked/internal/server"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// validateTLSConfig fails on TLS settings the server couldn't start with, instead of at the first request.
+func validateTLSConfig(cfg server.Config) error {
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && len(cfg.AutoTLSDomains) > 0 {
+		return errors.New("TLS_CERT_FILE and AUTO_TLS_DOMAIN can't be used together")
+	}
+	if cfg.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return fmt.Errorf("failed to load TLS_CERT_FILE and TLS_KEY_FILE: %w", err)
+		}
+	}
+	if len(cfg.AutoTLSDomains) > 0 {
+		if err := os.MkdirAll(cfg.AutoTLSCacheDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create AUTO_TLS_CACHE_DIR: %w", err)
+		}
+	}
+	if cfg.HTTPRedirectPort != "" {
+		if !cfg.TLSEnabled() {
+			return errors.New("HTTP_REDIRECT_PORT requires TLS_CERT_FILE or AUTO_TLS_DOMAIN")
+		}
+		if cfg.HTTPRedirectPort == cfg.Port {
+			return errors.New("HTTP_REDIRECT_PORT must differ from PORT")
+		}
+	}
+	return nil
+}
+
+// configureAutoTLS lets echo get certificates for the configured domains from Let's Encrypt.
+func configureAutoTLS(e *echo.Echo, cfg server.Config) {
+	e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.AutoTLSDomains...)
+	// Kept across restarts, Let's Encrypt rate limits issuing the same certificate again
+	e.AutoTLSManager.Cache = autocert.DirCache(cfg.AutoTLSCacheDir)
+}
+
+// startServer starts e on addr, over TLS when it's configured.
+func startServer(e *echo.Echo, cfg server.Config, addr string) error {
+	switch {
+	case cfg.TLSCertFile != "":
+		return e.StartTLS(addr, cfg.TLSCertFile, cfg.TLSKeyFile)
+	case len(cfg.AutoTLSDomains) > 0:
+		return e.StartAutoTLS(addr)
+	default:
+		return e.Start(addr)
+	}
+}
+
+// newHTTPSRedirectServer sends plain HTTP requests to the same URL over HTTPS on httpsPort.
+// With autocert it also answers the HTTP challenges of Let's Encrypt.
+func newHTTPSRedirectServer(e *echo.Echo, cfg server.Config) *http.Server {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if cfg.Port != "443" {
+			host = net.JoinHostPort(host, cfg.Port)
+		}
+		// 308 keeps the method and body, unlike 301
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	if len(cfg.AutoTLSDomains) > 0 {
+		h = e.AutoTLSManager.HTTPHandler(h)
+	}
+	return &http.Server{
+		Addr:              net.JoinHostPort(cfg.Host, cfg.HTTPRedirectPort),
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
@@ -51,19 +51,63 @@ function loginApp() {
 function app() {
 	return {
 		links: [],
+		// sort:order of the list, see GET /api/links
+		sort: 'created_at:desc',
 		loading: true,
 		creating: false,
 		message: { text: '', type: '' },
 		messageTimeout: null,
+		// Only loaded for admins, null hides them
+		loginAttempts: null,
+		// Build of the server, see GET /api/version
+		version: null,
 
 		init() {
 			this.loadLinks();
+			this.loadLoginAttempts();
+			this.loadVersion();
+		},
+
+		async loadVersion() {
+			try {
+				this.version = await fetchJSON('/api/version');
+			} catch (error) {
+				// The footer is only informative, it stays hidden
+				console.error('Failed to load version:', error);
+			}
+		},
+
+		versionText() {
+			const v = this.version;
+			if (!v) {
+				return '';
+			}
+			const parts = [`linked ${v.version}`];
+			if (v.revision) {
+				parts.push(v.revision.slice(0, 7) + (v.dirty ? '-dirty' : ''));
+			}
+			parts.push(`schema ${v.schema_version}`, v.go_version);
+			return parts.join(' · ');
+		},
+
+		async loadLoginAttempts() {
+			try {
+				const me = await fetchJSON('/api/auth/me');
+				if (me?.role !== 'admin') {
+					return;
+				}
+				const response = await fetchJSON('/api/admin/login-attempts?window=7d&limit=20');
+				this.loginAttempts = response?.attempts || [];
+			} catch (error) {
+				this.handleError(error);
+			}
 		},
 
 		async loadLinks() {
 			this.loading = true;
 			try {
-				const response = await fetchJSON('/api/links');
+				const [sort, order] = this.sort.split(':');
+				const response = await fetchJSON(`/api/links?${new URLSearchParams({ sort, order })}`);
 				this.links = response?.links || [];
 			} catch (error) {
 				this.handleError(error);
@@ -83,13 +127,19 @@ function app() {
 
 			this.creating = true;
 			try {
-				const response = await fetchJSON('/api/links', {
+				// The server follows the new link, so it doesn't have to be clicked to check it works
+				const response = await fetchJSON('/api/links?verify=true', {
 					method: 'POST',
 					body: { url, slug: slug || undefined }
 				});
 
 				if (response) {
-					this.showMessage('Link created successfully!', 'success');
+					const failed = (response.verification?.checks || []).filter((check) => !check.passed);
+					if (failed.length) {
+						this.showError(`Link created, but ${failed.map((check) => check.detail).join('; ')}`);
+					} else {
+						this.showMessage('Link created and verified!', 'success');
+					}
 					document.getElementById('url').value = '';
 					document.getElementById('slug').value = '';
 					await this.loadLinks();
@@ -108,9 +158,23 @@ function app() {
 
 			this.loading = true;
 			try {
-				await fetchJSON(`/api/links/${id}`, {
-					method: 'DELETE'
-				});
+				try {
+					await fetchJSON(`/api/links/${id}`, {
+						method: 'DELETE'
+					});
+				} catch (error) {
+					// Links with a lot of clicks are only deleted once confirmed again
+					if (error.data?.code !== 'confirmation_required') {
+						throw error;
+					}
+					const token = error.data.confirm_token;
+					if (!confirm(`The link "${slug}" has ${error.data.clicks} clicks, which will be lost. Delete it anyway?`)) {
+						return;
+					}
+					await fetchJSON(`/api/links/${id}?confirm=${encodeURIComponent(token)}`, {
+						method: 'DELETE'
+					});
+				}
 
 				this.showMessage('Link deleted successfully!', 'success');
 				await this.loadLinks();
@@ -242,7 +306,10 @@ async function fetchJSON(url, options) {
 
 	if (response.status >= 400) {
 		const errorMessage = data?.error || data?.message || `HTTP error! status: ${response.status}`;
-		throw new Error(errorMessage);
+		const error = new Error(errorMessage);
+		// Some errors carry more than the message, like the token confirming a deletion
+		error.data = data;
+		throw error;
 	}
 
 	return data;